@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Logs each request with its status, response size, duration and source
+// IP, as key=value pairs.
+func (svc *Service) accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw, ok := w.(*statusWriter)
+		if !ok {
+			sw = &statusWriter{ResponseWriter: w}
+		}
+		next.ServeHTTP(sw, r)
+		svc.log("access method=%s path=%q status=%d bytes=%d duration=%s ip=%s",
+			r.Method, r.URL.Path, sw.status, sw.bytes, time.Since(start), svc.clientIP(r))
+	})
+}
+
+// Returns the client's IP. If a trusted proxy header is configured and the
+// request comes from a trusted proxy, the last address in the header is
+// used, being the one seen by the proxy. A header from any other client is
+// ignored, as it could be spoofed.
+func (svc *Service) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if svc.proxyHeader != "" && svc.trustedProxy(host) {
+		if v := r.Header.Get(svc.proxyHeader); v != "" {
+			addrs := strings.Split(v, ",")
+			return strings.TrimSpace(addrs[len(addrs)-1])
+		}
+	}
+	return host
+}
+
+// Reports whether host is one of the trusted proxies, or a loopback address
+// if none are configured.
+func (svc *Service) trustedProxy(host string) bool {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	if len(svc.proxies) == 0 {
+		return addr.IsLoopback()
+	}
+	for _, p := range svc.proxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxied := &Service{proxyHeader: "X-Forwarded-For", proxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	local := &Service{proxyHeader: "X-Forwarded-For"}
+	for _, tc := range []struct {
+		name   string
+		svc    *Service
+		remote string
+		header string
+		want   string
+	}{
+		{"no header configured", &Service{}, "192.0.2.1:1234", "198.51.100.7", "192.0.2.1"},
+		{"trusted proxy", proxied, "10.1.2.3:1234", "203.0.113.9, 198.51.100.7", "198.51.100.7"},
+		{"trusted proxy, no header", proxied, "10.1.2.3:1234", "", "10.1.2.3"},
+		{"spoofed by a client", proxied, "192.0.2.1:1234", "10.0.0.1", "192.0.2.1"},
+		{"loopback proxy by default", local, "127.0.0.1:1234", "198.51.100.7", "198.51.100.7"},
+		{"spoofed without proxies", local, "192.0.2.1:1234", "127.0.0.1", "192.0.2.1"},
+	} {
+		r := httptest.NewRequest("GET", "/status", nil)
+		r.RemoteAddr = tc.remote
+		if tc.header != "" {
+			r.Header.Set("X-Forwarded-For", tc.header)
+		}
+		if got := tc.svc.clientIP(r); got != tc.want {
+			t.Errorf("%s: clientIP = %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
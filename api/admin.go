@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Guards /admin/ where authGuard does not, without tenants or client
+// certificates: the client must send a token of the config with admin
+// scope, or connect from loopback, as must the client named by the proxy
+// header if one is trusted.
+func (svc *Service) adminGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if svc.tenants != nil || svc.clientCerts() { // authGuard requires admin scope
+			next.ServeHTTP(w, r)
+			return
+		}
+		secret, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if secret != "" && svc.wsTokenScope(secret) == "admin" || svc.fromLoopback(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("admin token required, or connect from loopback"))
+	})
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/intob/daved/audit"
+	"github.com/intob/daved/tenant"
+)
+
+// Records requests that change the node, being any but GET, HEAD and
+// OPTIONS, to the audit log as action.
+func (svc *Service) audited(action string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if svc.audit == nil || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body := &hashReader{r: r.Body, h: sha256.New()}
+		r.Body = body
+		sw, ok := w.(*statusWriter)
+		if !ok {
+			sw = &statusWriter{ResponseWriter: w}
+		}
+		next.ServeHTTP(sw, r)
+		io.Copy(io.Discard, body) // Hash what the handler didn't read
+		body.r.Close()
+		err := svc.audit.Append(&audit.Entry{
+			Time:     time.Now(),
+			Action:   action,
+			Method:   r.Method,
+			Path:     r.URL.Path,
+			Identity: svc.identity(r),
+			IP:       svc.clientIP(r),
+			Payload:  hex.EncodeToString(body.h.Sum(nil)),
+			Status:   sw.status,
+		})
+		if err != nil {
+			svc.log("failed to write audit log: %s", err)
+		}
+	})
+}
+
+// Records a put made over the websocket, gRPC or Redis protocol, which
+// audited doesn't see, with the hash of its payload as sent.
+func (svc *Service) auditPut(action, method, path, identity, ip string, payload []byte, status int) {
+	if svc.audit == nil {
+		return
+	}
+	sum := sha256.Sum256(payload)
+	err := svc.audit.Append(&audit.Entry{
+		Time:     time.Now(),
+		Action:   action,
+		Method:   method,
+		Path:     path,
+		Identity: identity,
+		IP:       ip,
+		Payload:  hex.EncodeToString(sum[:]),
+		Status:   status,
+	})
+	if err != nil {
+		svc.log("failed to write audit log: %s", err)
+	}
+}
+
+// AuditSet records a SET of the Redis protocol server, from the client at
+// addr whose connection authenticated with token, if any. The path is the
+// key, and the payload the value.
+func (svc *Service) AuditSet(token, addr, key string, val []byte, err error) {
+	if svc.audit == nil {
+		return
+	}
+	var identity string
+	if token != "" && svc.authenticate(token) != nil {
+		identity = tenant.ID(token)
+	}
+	ip, _, splitErr := net.SplitHostPort(addr)
+	if splitErr != nil {
+		ip = addr
+	}
+	status := http.StatusOK
+	if errors.Is(err, tenant.ErrQuota) || errors.Is(err, errRateLimit) {
+		status = http.StatusTooManyRequests
+	} else if err != nil {
+		status = http.StatusBadRequest
+	}
+	svc.auditPut("resp.set", "SET", key, identity, ip, val, status)
+}
+
+// Returns the id of the token the request was authenticated with, or of
+// the known token in its Authorization header. The id is a prefix of the
+// token's hash, so the token itself is never recorded.
+func (svc *Service) identity(r *http.Request) string {
+	if tok := requestToken(r); tok != nil {
+		return tok.ID
+	}
+	secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || svc.authenticate(secret) == nil {
+		return ""
+	}
+	return tenant.ID(secret)
+}
+
+// Returns audit log entries, oldest first, e.g. ?n=50&action=admin.pubkeys.
+func (svc *Service) handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	if svc.audit == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("audit log is not enabled"))
+		return
+	}
+	query := r.URL.Query()
+	q := audit.Query{Limit: 100, Action: query.Get("action"), Identity: query.Get("identity")}
+	if s := query.Get("n"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid n"))
+			return
+		}
+		q.Limit = n
+	}
+	if s := query.Get("since"); s != "" {
+		since, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid since, expected RFC 3339"))
+			return
+		}
+		q.Since = since
+	}
+	entries, err := svc.audit.Read(q)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	svc.writeResult(w, r, entries)
+}
+
+type hashReader struct {
+	r io.ReadCloser
+	h hash.Hash
+}
+
+func (h *hashReader) Read(b []byte) (int, error) {
+	n, err := h.r.Read(b)
+	h.h.Write(b[:n])
+	return n, err
+}
+
+// Closed by the middleware, once the rest of the body is hashed.
+func (h *hashReader) Close() error {
+	return nil
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+)
+
+// Capabilities are the optional subsystems enabled on a node. Unlike the
+// features of /v1/meta, which every node of an API version has, they vary
+// with config and the godave build.
+type Capabilities struct {
+	Gateway       bool     `json:"gateway"`        // /site/, with api_gateway
+	Uploads       bool     `json:"uploads"`        // /uploads, with api_uploads
+	Signing       bool     `json:"signing"`        // Signing dats for clients, not yet implemented
+	Subscriptions bool     `json:"subscriptions"`  // GET /events
+	Bridge        bool     `json:"bridge"`         // Keys registered over GET /ws relay their dats
+	Auth          AuthCaps `json:"auth"`           // How clients authenticate, if they must
+	History       bool     `json:"history"`        // GET /history, with history_pubkeys
+	GraphQL       bool     `json:"graphql"`        // /graphql, and subscriptions over GET /ws
+	Audit         bool     `json:"audit"`          // GET /admin/audit
+	Logs          bool     `json:"logs"`           // GET /logs
+	LogLevels     bool     `json:"log_levels"`     // /admin/loglevel
+	StatusHistory bool     `json:"status_history"` // GET /status/history
+	ReadCache     bool     `json:"read_cache"`     // GET /dat answers from memory
+	Debug         bool     `json:"debug"`          // /debug/ to loopback clients
+	ReadOnly      bool     `json:"readonly"`       // Writes are refused
+}
+
+type AuthCaps struct {
+	Required    bool `json:"required"`
+	Tokens      bool `json:"tokens"`       // Websocket tokens of the config
+	Tenants     bool `json:"tenants"`      // Tokens of /admin/tokens
+	ClientCerts bool `json:"client_certs"` // TLS client certificates
+}
+
+func (svc *Service) capabilities() *Capabilities {
+	return &Capabilities{
+		Subscriptions: svc.events != nil,
+		Bridge:        svc.ws.Bridge != nil,
+		Auth: AuthCaps{
+			Required:    svc.tenants != nil || svc.clientCerts(),
+			Tokens:      len(svc.ws.Tokens) > 0,
+			Tenants:     svc.tenants != nil,
+			ClientCerts: svc.clientCerts(),
+		},
+		History:       svc.history != nil,
+		GraphQL:       svc.graphql != nil,
+		Gateway:       svc.gateway,
+		Uploads:       svc.uploads != nil,
+		Audit:         svc.audit != nil,
+		Logs:          svc.logTail != nil,
+		LogLevels:     svc.logLevels != nil,
+		StatusHistory: svc.statusHistory != nil,
+		ReadCache:     svc.readCache != nil,
+		Debug:         svc.debug,
+		ReadOnly:      svc.readOnly,
+	}
+}
+
+func (svc *Service) handleGetCapabilities(w http.ResponseWriter, r *http.Request) {
+	svc.writeResult(w, r, svc.capabilities())
+}
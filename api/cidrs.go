@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+	"net/netip"
+)
+
+// Rejects requests from clients outside the allowed prefixes, or inside a
+// denied prefix. Denied prefixes take precedence.
+func (svc *Service) cidrGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, err := netip.ParseAddr(svc.clientIP(r))
+		if err != nil || !svc.permitAddr(addr.Unmap()) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("forbidden"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (svc *Service) permitAddr(addr netip.Addr) bool {
+	for _, p := range svc.deniedCidrs {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	if len(svc.allowedCidrs) == 0 {
+		return true
+	}
+	for _, p := range svc.allowedCidrs {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
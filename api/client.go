@@ -0,0 +1,358 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/intob/daved/audit"
+	"github.com/intob/daved/dats"
+	"github.com/intob/daved/status"
+	"github.com/intob/daved/tenant"
+)
+
+// Client talks to the HTTP API of a running daemon, for CLI commands that
+// operate on the node's store rather than spinning up their own node.
+type Client struct {
+	addr   string
+	http   *http.Client
+	token  string
+	scheme string
+	stream *http.Client // Without timeout, for event streams
+}
+
+func NewClient(addr string) *Client {
+	return &Client{
+		addr:   addr,
+		http:   &http.Client{Timeout: 5 * time.Minute},
+		scheme: "http",
+		stream: http.DefaultClient,
+	}
+}
+
+// WithTLS connects over HTTPS, presenting the config's client certificate
+// if it has one.
+func (c *Client) WithTLS(tlsCfg *tls.Config) *Client {
+	transport := &http.Transport{TLSClientConfig: tlsCfg}
+	c.scheme = "https"
+	c.http = &http.Client{Timeout: 5 * time.Minute, Transport: transport}
+	c.stream = &http.Client{Transport: transport}
+	return c
+}
+
+// WithToken sets the token sent with each request, for nodes with
+// tenants.
+func (c *Client) WithToken(token string) *Client {
+	c.token = token
+	return c
+}
+
+func (c *Client) authorize(req *http.Request) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+}
+
+func (c *Client) get(path string, query url.Values) (*http.Response, error) {
+	return c.do(http.MethodGet, apiPrefix+path, query)
+}
+
+func (c *Client) delete(path string, query url.Values) (*http.Response, error) {
+	return c.do(http.MethodDelete, apiPrefix+path, query)
+}
+
+func (c *Client) do(method, path string, query url.Values) (*http.Response, error) {
+	u := url.URL{Scheme: c.scheme, Host: c.addr, Path: path, RawQuery: query.Encode()}
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(req)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	return resp, nil
+}
+
+// History returns the versions of a dat kept by the daemon, newest first.
+func (c *Client) History(pubKey, key string) ([]*dats.Record, error) {
+	resp, err := c.get("/history", url.Values{"pubkey": {pubKey}, "key": {key}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	records := make([]*dats.Record, 0)
+	err = json.NewDecoder(resp.Body).Decode(&records)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return records, nil
+}
+
+// Status returns the daemon's status.
+func (c *Client) Status() (*Status, error) {
+	resp, err := c.get("/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	stat := &Status{}
+	err = json.NewDecoder(resp.Body).Decode(stat)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return stat, nil
+}
+
+// Capabilities returns the optional subsystems enabled on the daemon.
+func (c *Client) Capabilities() (*Capabilities, error) {
+	resp, err := c.get("/capabilities", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	caps := &Capabilities{}
+	err = json.NewDecoder(resp.Body).Decode(caps)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return caps, nil
+}
+
+// Profile writes a pprof profile of the daemon to w. For cpu and trace,
+// the profile is recorded for the given number of seconds. Requires
+// api_debug on the daemon, and a loopback address.
+func (c *Client) Profile(w io.Writer, kind string, seconds int) (int64, error) {
+	query := url.Values{}
+	if seconds > 0 {
+		query.Set("seconds", strconv.Itoa(seconds))
+	}
+	path := "/debug/pprof/" + kind
+	if kind == "cpu" {
+		path = "/debug/pprof/profile"
+	}
+	resp, err := c.do(http.MethodGet, path, query) // Not versioned
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return io.Copy(w, resp.Body)
+}
+
+// StatusHistory returns the daemon's status samples within window, oldest
+// first.
+func (c *Client) StatusHistory(window time.Duration) ([]status.Sample, error) {
+	resp, err := c.get("/status/history", url.Values{"window": {window.String()}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	samples := make([]status.Sample, 0)
+	err = json.NewDecoder(resp.Body).Decode(&samples)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return samples, nil
+}
+
+// Logs returns up to n of the daemon's most recent log lines.
+func (c *Client) Logs(n int) ([]string, error) {
+	resp, err := c.get("/logs", url.Values{"n": {strconv.Itoa(n)}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	lines := make([]string, 0)
+	err = json.NewDecoder(resp.Body).Decode(&lines)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return lines, nil
+}
+
+// Audit returns the n most recent audit log entries, oldest first, of the
+// action if it is not empty.
+func (c *Client) Audit(n int, action string) ([]audit.Entry, error) {
+	query := url.Values{"n": {strconv.Itoa(n)}}
+	if action != "" {
+		query.Set("action", action)
+	}
+	resp, err := c.get("/admin/audit", query)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	entries := make([]audit.Entry, 0)
+	err = json.NewDecoder(resp.Body).Decode(&entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return entries, nil
+}
+
+// Tokens returns the daemon's tenant tokens with their usage.
+func (c *Client) Tokens() ([]*tenant.Token, error) {
+	resp, err := c.get("/admin/tokens", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	tokens := make([]*tenant.Token, 0)
+	err = json.NewDecoder(resp.Body).Decode(&tokens)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return tokens, nil
+}
+
+// CreateToken creates a tenant token, returned only this once.
+func (c *Client) CreateToken(req *TokenReq) (*TokenResp, error) {
+	resp, err := c.post("/admin/tokens", req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	created := &TokenResp{}
+	err = json.NewDecoder(resp.Body).Decode(created)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return created, nil
+}
+
+// RevokeToken revokes a tenant token by id.
+func (c *Client) RevokeToken(id string) error {
+	resp, err := c.delete("/admin/tokens", url.Values{"id": {id}})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *Client) post(path string, body any) (*http.Response, error) {
+	return c.send(http.MethodPost, path, body)
+}
+
+func (c *Client) put(path string, body any) (*http.Response, error) {
+	return c.send(http.MethodPut, path, body)
+}
+
+func (c *Client) send(method, path string, body any) (*http.Response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	u := url.URL{Scheme: c.scheme, Host: c.addr, Path: apiPrefix + path}
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	c.authorize(req)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	return resp, nil
+}
+
+// LogLevels returns the daemon's log levels.
+func (c *Client) LogLevels() (*LogLevels, error) {
+	resp, err := c.get("/admin/loglevel", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	levels := &LogLevels{}
+	err = json.NewDecoder(resp.Body).Decode(levels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return levels, nil
+}
+
+// SetLogLevels changes the daemon's log levels until it restarts, returning
+// the levels after the change.
+func (c *Client) SetLogLevels(change *LogLevels) (*LogLevels, error) {
+	resp, err := c.put("/admin/loglevel", change)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	levels := &LogLevels{}
+	err = json.NewDecoder(resp.Body).Decode(levels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return levels, nil
+}
+
+// StreamedEvent is an event read from the daemon's event stream, with the
+// data left for the caller to decode by type.
+type StreamedEvent struct {
+	Type string          `json:"type"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Events streams the daemon's events of the given types, or all if none are
+// given, calling fn for each until ctx is cancelled or the stream ends.
+func (c *Client) Events(ctx context.Context, fn func(*StreamedEvent), types ...string) error {
+	query := url.Values{}
+	if len(types) > 0 {
+		query.Set("type", strings.Join(types, ","))
+	}
+	u := url.URL{Scheme: c.scheme, Host: c.addr, Path: apiPrefix + "/events", RawQuery: query.Encode()}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	c.authorize(req)
+	resp, err := c.stream.Do(req) // No timeout, the stream is long-lived
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		e := &StreamedEvent{}
+		if err := json.Unmarshal([]byte(data), e); err != nil {
+			return fmt.Errorf("failed to decode event: %w", err)
+		}
+		fn(e)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,21 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/intob/daved/codec"
+)
+
+// Writes v as JSON, CBOR or MessagePack, as negotiated by the Accept header.
+func (svc *Service) writeResult(w http.ResponseWriter, r *http.Request, v any) {
+	contentType := codec.Negotiate(r.Header.Get("Accept"))
+	resp, err := codec.Marshal(contentType, v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Add("Vary", "Accept")
+	w.Write(resp)
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+	identity    bool // Status or headers rule out a compressed body
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if bodyless(status) || w.Header().Get("Content-Range") != "" {
+			w.identity = true
+		} else {
+			w.Header().Del("Content-Length")
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.identity {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.gz.Write(b)
+}
+
+// Flushes compressed data so far, for streaming responses.
+func (w *gzipResponseWriter) Flush() {
+	if !w.identity {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Compresses responses with gzip if the client accepts it. Websocket
+// upgrades and range requests are passed through, as are responses that have
+// no body or carry a Content-Range, whose offsets are of the identity body.
+func compressMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Header.Get("Upgrade") != "" || r.Header.Get("Range") != "" ||
+			!acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gz := gzipWriters.Get().(*gzip.Writer)
+		gz.Reset(w)
+		gw := &gzipResponseWriter{ResponseWriter: w, gz: gz}
+		defer func() {
+			if gw.wroteHeader && !gw.identity {
+				gz.Close()
+			}
+			gzipWriters.Put(gz)
+		}()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		enc, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.TrimSpace(enc) == "gzip" && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// Statuses that never have a body.
+func bodyless(status int) bool {
+	return status == http.StatusNoContent || status == http.StatusNotModified
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCompressMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		rng      string
+		status   int
+		header   string
+		wantGzip bool
+	}{
+		{"ok", "", http.StatusOK, "", true},
+		{"no content", "", http.StatusNoContent, "", false},
+		{"not modified", "", http.StatusNotModified, "", false},
+		{"range request", "bytes=0-1", http.StatusOK, "", false},
+		{"content range", "", http.StatusPartialContent, "bytes 0-1/4", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := compressMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.header != "" {
+					w.Header().Set("Content-Range", tt.header)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte("da"))
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			if tt.rng != "" {
+				r.Header.Set("Range", tt.rng)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if got := w.Header().Get("Content-Encoding") == "gzip"; got != tt.wantGzip {
+				t.Errorf("gzip = %v, want %v", got, tt.wantGzip)
+			}
+			if !tt.wantGzip && tt.status != http.StatusNoContent && tt.status != http.StatusNotModified && w.Body.String() != "da" {
+				t.Errorf("body = %q, want identity", w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/intob/daved/dats"
+	"github.com/intob/daved/events"
+	"github.com/intob/godave/dat"
+	"github.com/intob/godave/types"
+)
+
+// Sends d to the network, publishing it as put through this node.
+func (svc *Service) put(d *dat.Dat) error {
+	if err := svc.dave.Put(*d); err != nil {
+		return err
+	}
+	svc.events.Publish(events.DAT_PUT, events.NewDatEvent(d))
+	return nil
+}
+
+// Returns key within namespace ns, if set, as put with -ns.
+func namespacedKey(ns, key string) (string, error) {
+	if key == "" {
+		return "", errors.New("missing key")
+	}
+	return dats.NamespacedKey(ns, key)
+}
+
+// Returns the newest version of a dat. The ETag is derived from the dat's
+// signature, so polling clients sending If-None-Match get 304 Not Modified
+// until the dat changes.
+func (svc *Service) handleGetDat(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	pubKey, err := base64.RawURLEncoding.DecodeString(q.Get("pubkey"))
+	if err != nil || len(pubKey) != ed25519.PublicKeySize {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid pubkey"))
+		return
+	}
+	key, err := namespacedKey(q.Get("ns"), q.Get("key"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	d, err := svc.getDat(r.Context(), pubKey, key)
+	if err != nil {
+		w.WriteHeader(http.StatusGatewayTimeout)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if d == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("not found"))
+		return
+	}
+	if q.Get("follow") == "true" {
+		d, err = svc.followLinks(r.Context(), d)
+		if errors.Is(err, dats.ErrLinkDepth) || errors.Is(err, dats.ErrLinkCycle) {
+			w.WriteHeader(http.StatusLoopDetected)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusGatewayTimeout)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if d == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("link target not found"))
+			return
+		}
+	}
+	etag := `"` + base64.RawURLEncoding.EncodeToString(d.Sig[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	if q.Get("raw") == "true" {
+		// ServeContent handles Range, If-Range and If-None-Match
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "", d.Time, bytes.NewReader(d.Val))
+		return
+	}
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	svc.writeResult(w, r, dats.FromDat(d))
+}
+
+// Returns the newest version of a dat from the read cache or the network,
+// or nil if it was not found.
+func (svc *Service) getDat(ctx context.Context, pubKey ed25519.PublicKey, key string) (*dat.Dat, error) {
+	if d, ok := svc.readCache.Get(pubKey, key); ok {
+		return d, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	entry, err := svc.dave.Get(ctx, &types.Get{PublicKey: pubKey, DatKey: key})
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	svc.readCache.Put(&entry.Dat)
+	return &entry.Dat, nil
+}
+
+// Follows links from d, up to the link depth, returning the dat at the end,
+// or nil if a target was not found.
+func (svc *Service) followLinks(ctx context.Context, d *dat.Dat) (*dat.Dat, error) {
+	depth := svc.linkDepth
+	if depth == 0 {
+		depth = 8
+	}
+	return dats.FollowLinks(ctx, svc.getDat, d, depth)
+}
+
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == etag || t == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"expvar"
+	"net"
+	"net/http"
+	_ "net/http/pprof" // Registers /debug/pprof on the default mux
+	"strings"
+)
+
+// Hides /debug/pprof and /debug/vars unless debug is enabled, and then only
+// serves them to loopback clients.
+func (svc *Service) debugGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/debug/") && (!svc.debug || !svc.fromLoopback(r)) {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Names are global, so with several nodes in a process only the first
+// publishes its metrics.
+func (svc *Service) publishDebugVars() {
+	if expvar.Get("daved") != nil {
+		return
+	}
+	expvar.Publish("daved", expvar.Func(func() any {
+		return svc.metrics.Snapshot()
+	}))
+}
+
+// Whether the client connects from loopback, as does the client named by the
+// proxy header if one is trusted, so a local proxy doesn't make every client
+// look local.
+func (svc *Service) fromLoopback(r *http.Request) bool {
+	return isLoopback(r.RemoteAddr) && (svc.proxyHeader == "" || net.ParseIP(svc.clientIP(r)).IsLoopback())
+}
+
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/intob/daved/events"
+)
+
+// Streams events as server-sent events. Optionally filtered with a
+// comma-separated list of types, e.g. ?type=backup.written,capacity.threshold,
+// and dat events by the parameters of a dat filter, e.g. ?prefix=chat/.
+func (svc *Service) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("streaming is not supported"))
+		return
+	}
+	var types []string
+	if t := r.URL.Query().Get("type"); t != "" {
+		types = strings.Split(t, ",")
+		for _, typ := range types {
+			if !events.Known(typ) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(fmt.Sprintf("unknown event type %q", typ)))
+				return
+			}
+		}
+	}
+	filter, err := parseDatFilter(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	ch, cancel := svc.events.SubscribeMatching(100, datEventMatcher(filter), types...)
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-ch:
+			b, err := json.Marshal(e)
+			if err != nil {
+				svc.log("failed to marshal event: %s", err)
+				continue
+			}
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, b)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
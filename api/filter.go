@@ -0,0 +1,91 @@
+package api
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/intob/daved/dats"
+	"github.com/intob/daved/events"
+)
+
+// Query parameters of a dat filter.
+var filterParams = []string{"pubkey", "ns", "prefix", "min_work", "min_size", "max_size"}
+
+// Parses a dat filter from query parameters, e.g.
+// ?pubkey=...&prefix=chat/&min_work=20&max_size=512. Nil if none are set.
+func parseDatFilter(q url.Values) (*dats.Filter, error) {
+	f := &dats.Filter{Prefix: q.Get("prefix")}
+	if p := q.Get("pubkey"); p != "" {
+		b, err := base64.RawURLEncoding.DecodeString(p)
+		if err != nil || len(b) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid pubkey")
+		}
+		f.PubKey = b
+	}
+	if ns := q.Get("ns"); ns != "" {
+		if err := dats.ValidateNamespace(ns); err != nil {
+			return nil, err
+		}
+		f.Prefix = ns + dats.NamespaceSeparator + f.Prefix
+	}
+	for name, dst := range map[string]*int{"min_work": &f.MinWork, "min_size": &f.MinSize, "max_size": &f.MaxSize} {
+		s := q.Get(name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %s", name)
+		}
+		*dst = n
+	}
+	if f.MaxSize > 0 && f.MaxSize < f.MinSize {
+		return nil, errors.New("max_size is less than min_size")
+	}
+	if f.IsZero() {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// Returns a matcher of events passing dat events only if they match f,
+// and other events as they are. Nil if f is.
+func datEventMatcher(f *dats.Filter) func(e *events.Event) bool {
+	if f == nil {
+		return nil
+	}
+	return func(e *events.Event) bool {
+		de, ok := e.Data.(*events.DatEvent)
+		return !ok || (de.Dat != nil && f.Match(de.Dat))
+	}
+}
+
+// Decodes the filter of a SUB frame. An empty payload matches any dat,
+// otherwise it is:
+//
+//	pubkey (32, zeros for any) | min work (1) | min size (2) | max size (2, zero for any) | prefix length (1) | prefix
+func decodeBinaryFilter(payload []byte) (*dats.Filter, error) {
+	if len(payload) == 0 {
+		return nil, nil
+	}
+	const fixed = ed25519.PublicKeySize + 1 + 2 + 2 + 1
+	if len(payload) < fixed || len(payload) != fixed+int(payload[fixed-1]) {
+		return nil, errors.New("invalid filter")
+	}
+	f := &dats.Filter{
+		MinWork: int(payload[ed25519.PublicKeySize]),
+		MinSize: int(binary.BigEndian.Uint16(payload[ed25519.PublicKeySize+1:])),
+		MaxSize: int(binary.BigEndian.Uint16(payload[ed25519.PublicKeySize+3:])),
+		Prefix:  string(payload[fixed:]),
+	}
+	if pubKey := payload[:ed25519.PublicKeySize]; !bytes.Equal(pubKey, make([]byte, ed25519.PublicKeySize)) {
+		f.PubKey = append(ed25519.PublicKey(nil), pubKey...)
+	}
+	return f, nil
+}
@@ -0,0 +1,295 @@
+package api
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/intob/daved/dats"
+	"github.com/intob/daved/events"
+	"github.com/intob/daved/graphql"
+)
+
+// Subprotocol of GraphQL subscriptions over the websocket, as spoken by
+// graphql-ws clients.
+const graphqlWsProtocol = "graphql-transport-ws"
+
+// Returns the schema of /graphql. Fields and arguments have the names of
+// the JSON API, and the types of its responses.
+func (svc *Service) graphqlSchema() *graphql.Schema {
+	return &graphql.Schema{
+		Query: map[string]graphql.Resolver{
+			"status": func(ctx context.Context, args graphql.Args) (any, error) {
+				return svc.Status(), nil
+			},
+			"capabilities": func(ctx context.Context, args graphql.Args) (any, error) {
+				return svc.capabilities(), nil
+			},
+			"dat": func(ctx context.Context, args graphql.Args) (any, error) {
+				pubKey, err := base64.RawURLEncoding.DecodeString(args.String("pubkey"))
+				if err != nil || len(pubKey) != ed25519.PublicKeySize {
+					return nil, errors.New("invalid pubkey")
+				}
+				key, err := namespacedKey(args.String("ns"), args.String("key"))
+				if err != nil {
+					return nil, err
+				}
+				d, err := svc.getDat(ctx, pubKey, key)
+				if err == nil && d != nil && args.Bool("follow") {
+					d, err = svc.followLinks(ctx, d)
+				}
+				if err != nil || d == nil {
+					return nil, err
+				}
+				return dats.FromDat(d), nil
+			},
+		},
+		Subscription: map[string]graphql.Subscriber{
+			// Dats put through the node from now on, matching the arguments of a dat filter
+			"dats": func(ctx context.Context, args graphql.Args) (<-chan any, error) {
+				filter, err := parseDatFilter(argValues(args))
+				if err != nil {
+					return nil, err
+				}
+				return svc.graphqlStream(ctx, datEventMatcher(filter), func(e *events.Event) any { return e.Data }, events.DAT_PUT)
+			},
+			// Events of the given types, or all, as GET /events
+			"events": func(ctx context.Context, args graphql.Args) (<-chan any, error) {
+				types := args.Strings("types")
+				for _, typ := range types {
+					if !events.Known(typ) {
+						return nil, fmt.Errorf("unknown event type %q", typ)
+					}
+				}
+				filter, err := parseDatFilter(argValues(args))
+				if err != nil {
+					return nil, err
+				}
+				return svc.graphqlStream(ctx, datEventMatcher(filter), func(e *events.Event) any { return e }, types...)
+			},
+		},
+	}
+}
+
+// Returns scalar arguments as query parameters, to share parsing with the
+// JSON API.
+func argValues(args graphql.Args) url.Values {
+	q := make(url.Values, len(args))
+	for name, v := range args {
+		switch v.(type) {
+		case nil, []any, map[string]any:
+		default:
+			q.Set(name, fmt.Sprint(v))
+		}
+	}
+	return q
+}
+
+// Sends the value of each matching event until ctx is cancelled.
+func (svc *Service) graphqlStream(ctx context.Context, match func(e *events.Event) bool, value func(e *events.Event) any, types ...string) (<-chan any, error) {
+	if svc.events == nil {
+		return nil, errors.New("subscriptions are not enabled")
+	}
+	ch, cancel := svc.events.SubscribeMatching(100, match, types...)
+	out := make(chan any)
+	go func() {
+		defer close(out)
+		defer cancel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-ch:
+				select {
+				case out <- value(e):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// Executes a query, sent as the query parameters or JSON body of the
+// GraphQL over HTTP convention. Errors of the query are in the response.
+func (svc *Service) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	if svc.graphql == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("graphql is not enabled, set api_graphql"))
+		return
+	}
+	req := &graphql.Request{}
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("invalid variables"))
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid request body"))
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	b, err := json.Marshal(svc.graphql.Execute(r.Context(), req))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// A connection speaking graphql-transport-ws. Queries and subscriptions are
+// both sent as subscribe messages.
+type graphqlSession struct {
+	svc     *Service
+	conn    *websocket.Conn
+	writeMu *sync.Mutex // Shared with pings
+	acked   bool
+	mu      sync.Mutex
+	subs    map[string]context.CancelFunc
+}
+
+type graphqlMessage struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+func (svc *Service) newGraphQLSession(conn *websocket.Conn, writeMu *sync.Mutex) *graphqlSession {
+	return &graphqlSession{svc: svc, conn: conn, writeMu: writeMu, subs: make(map[string]context.CancelFunc)}
+}
+
+// Handles a message, returning an error if the connection must be closed.
+func (s *graphqlSession) handle(ctx context.Context, messageType int, msg []byte) error {
+	var m graphqlMessage
+	if messageType != websocket.TextMessage || json.Unmarshal(msg, &m) != nil {
+		return s.closeWith(4400, "invalid message")
+	}
+	switch m.Type {
+	case "connection_init":
+		if s.acked {
+			return s.closeWith(4429, "too many initialisation requests")
+		}
+		s.acked = true
+		return s.write(&graphqlMessage{Type: "connection_ack"})
+	case "ping":
+		return s.write(&graphqlMessage{Type: "pong"})
+	case "pong":
+		return nil
+	case "subscribe":
+		if !s.acked {
+			return s.closeWith(4401, "unauthorized")
+		}
+		return s.subscribe(ctx, m.ID, m.Payload)
+	case "complete":
+		s.mu.Lock()
+		if cancel, ok := s.subs[m.ID]; ok {
+			cancel()
+			delete(s.subs, m.ID)
+		}
+		s.mu.Unlock()
+		return nil
+	default:
+		return s.closeWith(4400, fmt.Sprintf("unknown message type %q", m.Type))
+	}
+}
+
+func (s *graphqlSession) subscribe(ctx context.Context, id string, payload json.RawMessage) error {
+	req := &graphql.Request{}
+	if id == "" || json.Unmarshal(payload, req) != nil {
+		return s.closeWith(4400, "invalid subscribe message")
+	}
+	s.mu.Lock()
+	_, exists := s.subs[id]
+	n := len(s.subs)
+	s.mu.Unlock()
+	if exists {
+		return s.closeWith(4409, fmt.Sprintf("subscriber for %s already exists", id))
+	}
+	if n >= wsMaxSubs {
+		return s.writeErrors(id, fmt.Sprintf("at most %d subscriptions per connection", wsMaxSubs))
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	ch, err := s.svc.graphql.Subscribe(ctx, req)
+	if err != nil {
+		cancel()
+		return s.writeErrors(id, err.Error())
+	}
+	s.mu.Lock()
+	s.subs[id] = cancel
+	s.mu.Unlock()
+	go func() {
+		for resp := range ch {
+			payload, err := json.Marshal(resp)
+			if err == nil {
+				err = s.write(&graphqlMessage{Type: "next", ID: id, Payload: payload})
+			}
+			if err != nil {
+				cancel()
+			}
+		}
+		if ctx.Err() == nil {
+			s.write(&graphqlMessage{Type: "complete", ID: id})
+		}
+		s.mu.Lock()
+		delete(s.subs, id)
+		s.mu.Unlock()
+		cancel()
+	}()
+	return nil
+}
+
+// Ends the session's subscriptions.
+func (s *graphqlSession) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, cancel := range s.subs {
+		cancel()
+		delete(s.subs, id)
+	}
+}
+
+func (s *graphqlSession) write(m *graphqlMessage) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.conn.WriteMessage(websocket.TextMessage, b)
+}
+
+func (s *graphqlSession) writeErrors(id, msg string) error {
+	payload, _ := json.Marshal([]graphql.Error{{Message: msg}})
+	return s.write(&graphqlMessage{Type: "error", ID: id, Payload: payload})
+}
+
+// Closes the connection with a code of the protocol, returning an error to
+// end the read loop.
+func (s *graphqlSession) closeWith(code int, reason string) error {
+	s.writeMu.Lock()
+	s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	s.writeMu.Unlock()
+	return errors.New(reason)
+}
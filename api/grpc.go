@@ -0,0 +1,42 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ErrNoGRPC is returned by Start if a gRPC address is set, but daved was
+// built without the grpc tag.
+var ErrNoGRPC = errors.New("daved was built without grpc, build with -tags grpc")
+
+type grpcServer interface {
+	Serve(net.Listener) error
+	Stop()
+}
+
+// Set by grpcserver.go, which is only built with the grpc tag, as
+// google.golang.org/grpc is a large dependency few nodes need.
+var newGRPCServer func(svc *Service) (grpcServer, error)
+
+// Serve returns nil once stopped.
+func (svc *Service) startGRPC() error {
+	server, err := newGRPCServer(svc)
+	if err != nil {
+		svc.server.Close()
+		return err
+	}
+	listener, err := net.Listen("tcp", svc.grpcAddr)
+	if err != nil {
+		svc.server.Close()
+		return fmt.Errorf("failed to listen for grpc: %w", err)
+	}
+	svc.grpc = server
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			svc.log("grpc server stopped: %s", err)
+		}
+	}()
+	svc.log("started grpc server on %s", listener.Addr())
+	return nil
+}
@@ -0,0 +1,538 @@
+//go:build grpc
+
+package api
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/intob/daved/dats"
+	"github.com/intob/daved/events"
+	"github.com/intob/daved/tenant"
+	"github.com/intob/godave/dat"
+	"github.com/intob/godave/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func init() {
+	newGRPCServer = func(svc *Service) (grpcServer, error) {
+		opts := []grpc.ServerOption{grpc.ForceServerCodec(grpcCodec{})}
+		if svc.tls != nil {
+			tlsCfg, err := svc.tlsConfig()
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+		}
+		server := grpc.NewServer(opts...)
+		server.RegisterService(&grpcServiceDesc, svc)
+		return server, nil
+	}
+}
+
+// The service of proto/daved.proto. Messages are encoded by hand rather
+// than generated, so keep them in sync with the file.
+var grpcServiceDesc = grpc.ServiceDesc{
+	ServiceName: "daved.v1.Dave",
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Put", Handler: grpcUnary(func(svc *Service, ctx context.Context, req *grpcDat) (grpcMessage, error) {
+			if err := svc.grpcPut(ctx, req); err != nil {
+				return nil, err
+			}
+			return &grpcPutResponse{Keys: []string{req.Key}}, nil
+		})},
+		{MethodName: "Get", Handler: grpcUnary((*Service).grpcGet)},
+		{MethodName: "Status", Handler: grpcUnary((*Service).grpcStatus)},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "WatchKeys", Handler: grpcWatchKeys, ServerStreams: true},
+		{StreamName: "StreamPut", Handler: grpcStreamPut, ClientStreams: true},
+	},
+	Metadata: "proto/daved.proto",
+}
+
+// Adapts a method to a grpc handler, decoding its request as Req.
+func grpcUnary[Req any, PReq interface {
+	*Req
+	grpcMessage
+}](fn func(svc *Service, ctx context.Context, req PReq) (grpcMessage, error)) func(any, context.Context, func(any) error, grpc.UnaryServerInterceptor) (any, error) {
+	return func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		req := PReq(new(Req))
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		svc := srv.(*Service)
+		if interceptor == nil {
+			return fn(svc, ctx, req)
+		}
+		method, _ := grpc.Method(ctx)
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: method}
+		return interceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+			return fn(svc, ctx, req.(PReq))
+		})
+	}
+}
+
+// Applies the rules of the HTTP API: api_allowed_cidrs and api_denied_cidrs,
+// and with tokens or client certificates, a client certificate or a token
+// sent as authorization metadata, of write scope for puts and read scope
+// otherwise. Returns the token, if one was needed.
+func (svc *Service) grpcAuth(ctx context.Context, scope string) (*tenant.Token, error) {
+	p, ok := peer.FromContext(ctx)
+	if len(svc.allowedCidrs) > 0 || len(svc.deniedCidrs) > 0 {
+		if !ok {
+			return nil, status.Error(codes.PermissionDenied, "forbidden")
+		}
+		addr, err := netip.ParseAddrPort(p.Addr.String())
+		if err != nil || !svc.permitAddr(addr.Addr().Unmap()) {
+			return nil, status.Error(codes.PermissionDenied, "forbidden")
+		}
+	}
+	if !svc.HasTokens() && !svc.clientCerts() {
+		return nil, nil
+	}
+	var tok *tenant.Token
+	if ok {
+		if info, isTLS := p.AuthInfo.(credentials.TLSInfo); isTLS {
+			tok = svc.connCertToken(&info.State)
+		}
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if vals := md.Get("authorization"); tok == nil && len(vals) > 0 {
+		if secret, _ := strings.CutPrefix(vals[0], "Bearer "); secret != "" {
+			tok = svc.authenticate(secret)
+		}
+	}
+	if tok == nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token or certificate")
+	}
+	if !wsPermits(tok.Scope, scope) {
+		return nil, status.Errorf(codes.PermissionDenied, "%s scope is required", scope)
+	}
+	if svc.tenants != nil && !svc.tenants.Allow(tok) {
+		return nil, status.Error(codes.ResourceExhausted, errRateLimit.Error())
+	}
+	return tok, nil
+}
+
+// Puts a dat that is signed and has work, as a PUT over the websocket,
+// recording it to the audit log whether or not it is refused.
+func (svc *Service) grpcPut(ctx context.Context, req *grpcDat) (err error) {
+	tok, err := svc.grpcAuth(ctx, "write")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		var identity string
+		if tok != nil {
+			identity = tok.ID
+		}
+		method, _ := grpc.Method(ctx)
+		svc.auditPut("grpc.put", "PUT", method, identity, grpcPeerIP(ctx), req.marshal(), grpcHTTPStatus(err))
+	}()
+	if svc.readOnly {
+		return status.Error(codes.PermissionDenied, "node is in readonly mode")
+	}
+	d := req.dat()
+	if err := dats.Verify(d); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := svc.schemas.Validate(d.Key, d.Val); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if tok != nil && svc.tenants != nil {
+		// Tokens of the config are not tenants, so have no quotas
+		err := svc.tenants.Charge(tok.ID, int64(len(d.Key)+len(d.Val)))
+		if err != nil && !errors.Is(err, tenant.ErrNotFound) {
+			return status.Error(codes.ResourceExhausted, err.Error())
+		}
+	}
+	if err := svc.put(d); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// Returns the address of the client, without its port.
+func grpcPeerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	addr, err := netip.ParseAddrPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return addr.Addr().Unmap().String()
+}
+
+// Returns the HTTP status matching the code of err, as the audit log
+// records for puts over HTTP.
+func grpcHTTPStatus(err error) int {
+	switch status.Code(err) {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (svc *Service) grpcGet(ctx context.Context, req *grpcGetRequest) (grpcMessage, error) {
+	if _, err := svc.grpcAuth(ctx, "read"); err != nil {
+		return nil, err
+	}
+	if len(req.PubKey) != ed25519.PublicKeySize {
+		return nil, status.Error(codes.InvalidArgument, "invalid pubkey")
+	}
+	d, ok := svc.readCache.Get(req.PubKey, req.Key)
+	if !ok {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		entry, err := svc.dave.Get(ctx, &types.Get{PublicKey: req.PubKey, DatKey: req.Key})
+		if err != nil {
+			return nil, status.Error(codes.DeadlineExceeded, err.Error())
+		}
+		if entry == nil {
+			return nil, status.Error(codes.NotFound, "not found")
+		}
+		d = &entry.Dat
+		svc.readCache.Put(d)
+	}
+	return newGRPCDat(d), nil
+}
+
+func (svc *Service) grpcStatus(ctx context.Context, _ *grpcStatusRequest) (grpcMessage, error) {
+	if _, err := svc.grpcAuth(ctx, "read"); err != nil {
+		return nil, err
+	}
+	s := svc.Status()
+	return &grpcStatusResponse{
+		ActivePeers:      int32(s.ActivePeers),
+		UsedSpace:        s.UsedSpace,
+		Capacity:         s.Capacity,
+		NetworkUsedSpace: s.Network.UsedSpace,
+		NetworkCapacity:  s.Network.Capacity,
+		ReadOnly:         s.ReadOnly,
+	}, nil
+}
+
+func grpcWatchKeys(srv any, stream grpc.ServerStream) error {
+	svc := srv.(*Service)
+	if _, err := svc.grpcAuth(stream.Context(), "read"); err != nil {
+		return err
+	}
+	if svc.events == nil {
+		return status.Error(codes.Unimplemented, "subscriptions are not enabled")
+	}
+	req := &grpcWatchRequest{}
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	filter := &dats.Filter{PubKey: req.PubKey, Prefix: req.KeyPrefix}
+	ch, cancel := svc.events.SubscribeMatching(100, datEventMatcher(filter), events.DAT_PUT)
+	defer cancel()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			de, ok := e.Data.(*events.DatEvent)
+			if !ok || de.Dat == nil {
+				continue
+			}
+			if err := stream.SendMsg(newGRPCDat(de.Dat)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Puts each dat received, stopping at the first that fails.
+func grpcStreamPut(srv any, stream grpc.ServerStream) error {
+	svc := srv.(*Service)
+	res := &grpcPutResponse{Keys: make([]string, 0)}
+	for {
+		req := &grpcDat{}
+		err := stream.RecvMsg(req)
+		if err == io.EOF {
+			return stream.SendMsg(res)
+		}
+		if err != nil {
+			return err
+		}
+		if err := svc.grpcPut(stream.Context(), req); err != nil {
+			return err
+		}
+		res.Keys = append(res.Keys, req.Key)
+	}
+}
+
+type grpcMessage interface {
+	marshal() []byte
+	unmarshal(b []byte) error
+}
+
+// Encodes grpcMessages in the protobuf wire format, under the name of the
+// default codec, so generated clients work unchanged.
+type grpcCodec struct{}
+
+func (grpcCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(grpcMessage)
+	if !ok {
+		return nil, fmt.Errorf("cannot marshal %T", v)
+	}
+	return m.marshal(), nil
+}
+
+func (grpcCodec) Unmarshal(b []byte, v any) error {
+	m, ok := v.(grpcMessage)
+	if !ok {
+		return fmt.Errorf("cannot unmarshal into %T", v)
+	}
+	return m.unmarshal(b)
+}
+
+func (grpcCodec) Name() string {
+	return "proto"
+}
+
+// Calls fn with each field of a message, with its value as bytes or a
+// varint by its type. Fields of other types are skipped.
+func consumeFields(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte, x uint64)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var v []byte
+		var x uint64
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			x, n = protowire.ConsumeVarint(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		fn(num, typ, v, x)
+	}
+	return nil
+}
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendVarint(b []byte, num protowire.Number, x uint64) []byte {
+	if x == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, x)
+}
+
+type grpcDat struct {
+	Key           string
+	Val           []byte
+	TimeUnixMilli int64
+	Salt          []byte
+	Work          []byte
+	Sig           []byte
+	PubKey        []byte
+}
+
+func newGRPCDat(d *dat.Dat) *grpcDat {
+	return &grpcDat{
+		Key:           d.Key,
+		Val:           d.Val,
+		TimeUnixMilli: d.Time.UnixMilli(),
+		Salt:          d.Salt[:],
+		Work:          d.Work[:],
+		Sig:           d.Sig[:],
+		PubKey:        d.PubKey,
+	}
+}
+
+func (m *grpcDat) dat() *dat.Dat {
+	d := &dat.Dat{Key: m.Key, Val: m.Val, Time: time.UnixMilli(m.TimeUnixMilli), PubKey: ed25519.PublicKey(m.PubKey)}
+	copy(d.Salt[:], m.Salt)
+	copy(d.Work[:], m.Work)
+	copy(d.Sig[:], m.Sig)
+	return d
+}
+
+func (m *grpcDat) marshal() []byte {
+	b := appendBytes(nil, 1, []byte(m.Key))
+	b = appendBytes(b, 2, m.Val)
+	b = appendVarint(b, 3, uint64(m.TimeUnixMilli))
+	b = appendBytes(b, 4, m.Salt)
+	b = appendBytes(b, 5, m.Work)
+	b = appendBytes(b, 6, m.Sig)
+	return appendBytes(b, 7, m.PubKey)
+}
+
+func (m *grpcDat) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte, x uint64) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			m.Key = string(v)
+		case num == 2 && typ == protowire.BytesType:
+			m.Val = append([]byte(nil), v...)
+		case num == 3 && typ == protowire.VarintType:
+			m.TimeUnixMilli = int64(x)
+		case num == 4 && typ == protowire.BytesType:
+			m.Salt = append([]byte(nil), v...)
+		case num == 5 && typ == protowire.BytesType:
+			m.Work = append([]byte(nil), v...)
+		case num == 6 && typ == protowire.BytesType:
+			m.Sig = append([]byte(nil), v...)
+		case num == 7 && typ == protowire.BytesType:
+			m.PubKey = append([]byte(nil), v...)
+		}
+	})
+}
+
+type grpcPutResponse struct {
+	Keys []string
+}
+
+func (m *grpcPutResponse) marshal() []byte {
+	var b []byte
+	for _, k := range m.Keys {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, k)
+	}
+	return b
+}
+
+func (m *grpcPutResponse) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) {
+		if num == 1 && typ == protowire.BytesType {
+			m.Keys = append(m.Keys, string(v))
+		}
+	})
+}
+
+type grpcGetRequest struct {
+	PubKey []byte
+	Key    string
+}
+
+func (m *grpcGetRequest) marshal() []byte {
+	b := appendBytes(nil, 1, m.PubKey)
+	return appendBytes(b, 2, []byte(m.Key))
+}
+
+func (m *grpcGetRequest) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			m.PubKey = append([]byte(nil), v...)
+		case num == 2 && typ == protowire.BytesType:
+			m.Key = string(v)
+		}
+	})
+}
+
+type grpcStatusRequest struct{}
+
+func (m *grpcStatusRequest) marshal() []byte {
+	return nil
+}
+
+func (m *grpcStatusRequest) unmarshal(b []byte) error {
+	return consumeFields(b, func(protowire.Number, protowire.Type, []byte, uint64) {})
+}
+
+type grpcStatusResponse struct {
+	ActivePeers      int32
+	UsedSpace        int64
+	Capacity         int64
+	NetworkUsedSpace uint64
+	NetworkCapacity  uint64
+	ReadOnly         bool
+}
+
+func (m *grpcStatusResponse) marshal() []byte {
+	b := appendVarint(nil, 1, uint64(int64(m.ActivePeers)))
+	b = appendVarint(b, 2, uint64(m.UsedSpace))
+	b = appendVarint(b, 3, uint64(m.Capacity))
+	b = appendVarint(b, 4, m.NetworkUsedSpace)
+	b = appendVarint(b, 5, m.NetworkCapacity)
+	return appendVarint(b, 6, protowire.EncodeBool(m.ReadOnly))
+}
+
+func (m *grpcStatusResponse) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, _ []byte, x uint64) {
+		if typ != protowire.VarintType {
+			return
+		}
+		switch num {
+		case 1:
+			m.ActivePeers = int32(x)
+		case 2:
+			m.UsedSpace = int64(x)
+		case 3:
+			m.Capacity = int64(x)
+		case 4:
+			m.NetworkUsedSpace = x
+		case 5:
+			m.NetworkCapacity = x
+		case 6:
+			m.ReadOnly = protowire.DecodeBool(x)
+		}
+	})
+}
+
+type grpcWatchRequest struct {
+	PubKey    []byte
+	KeyPrefix string
+}
+
+func (m *grpcWatchRequest) marshal() []byte {
+	b := appendBytes(nil, 1, m.PubKey)
+	return appendBytes(b, 2, []byte(m.KeyPrefix))
+}
+
+func (m *grpcWatchRequest) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			m.PubKey = append([]byte(nil), v...)
+		case num == 2 && typ == protowire.BytesType:
+			m.KeyPrefix = string(v)
+		}
+	})
+}
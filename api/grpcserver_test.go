@@ -0,0 +1,67 @@
+//go:build grpc
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func grpcCtx(token string) context.Context {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5000}})
+	if token == "" {
+		return ctx
+	}
+	return metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+token))
+}
+
+func TestGRPCAuth(t *testing.T) {
+	open := &Service{ws: &WebsocketCfg{}}
+	if _, err := open.grpcAuth(grpcCtx(""), "write"); err != nil {
+		t.Errorf("without tokens, a call was refused: %s", err)
+	}
+	svc := &Service{ws: &WebsocketCfg{Tokens: map[string]string{"r": "read", "w": "write"}}}
+	for _, tc := range []struct {
+		token, scope string
+		want         codes.Code
+	}{
+		{"", "read", codes.Unauthenticated},
+		{"x", "read", codes.Unauthenticated},
+		{"r", "read", codes.OK},
+		{"r", "write", codes.PermissionDenied},
+		{"w", "write", codes.OK},
+	} {
+		_, err := svc.grpcAuth(grpcCtx(tc.token), tc.scope)
+		if got := status.Code(err); got != tc.want {
+			t.Errorf("token %q for %s scope: got %s, want %s", tc.token, tc.scope, got, tc.want)
+		}
+	}
+}
+
+func TestGRPCDatRoundTrip(t *testing.T) {
+	m := &grpcDat{
+		Key:           "k",
+		Val:           []byte("v"),
+		TimeUnixMilli: 1700000000000,
+		Salt:          bytes.Repeat([]byte{1}, 16),
+		Work:          bytes.Repeat([]byte{2}, 32),
+		Sig:           bytes.Repeat([]byte{3}, 64),
+		PubKey:        bytes.Repeat([]byte{4}, 32),
+	}
+	got := &grpcDat{}
+	if err := got.unmarshal(m.marshal()); err != nil {
+		t.Fatal(err)
+	}
+	if got.Key != m.Key || !bytes.Equal(got.Val, m.Val) || got.TimeUnixMilli != m.TimeUnixMilli ||
+		!bytes.Equal(got.Salt, m.Salt) || !bytes.Equal(got.Work, m.Work) ||
+		!bytes.Equal(got.Sig, m.Sig) || !bytes.Equal(got.PubKey, m.PubKey) {
+		t.Errorf("round trip gave %+v, want %+v", got, m)
+	}
+}
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/intob/daved/watchdog"
+)
+
+// Responds 200 if the watchdog finds the node healthy, otherwise 503, with
+// the watchdog's state as JSON either way, for load balancers and
+// orchestrators.
+func (svc *Service) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	state := &watchdog.State{Healthy: true}
+	if svc.watchdog != nil {
+		state = svc.watchdog.State()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !state.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(state)
+}
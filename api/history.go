@@ -0,0 +1,37 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/intob/daved/dats"
+)
+
+// Returns known versions of a dat, newest first.
+func (svc *Service) handleGetHistory(w http.ResponseWriter, r *http.Request) {
+	if svc.history == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("history is not enabled, configure history_pubkeys"))
+		return
+	}
+	q := r.URL.Query()
+	pubKey, err := base64.RawURLEncoding.DecodeString(q.Get("pubkey"))
+	if err != nil || len(pubKey) != ed25519.PublicKeySize {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid pubkey"))
+		return
+	}
+	key, err := namespacedKey(q.Get("ns"), q.Get("key"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	versions := svc.history.Versions(pubKey, key)
+	records := make([]*dats.Record, 0, len(versions))
+	for _, v := range versions {
+		records = append(records, dats.FromDat(v))
+	}
+	svc.writeResult(w, r, records)
+}
@@ -1,37 +1,119 @@
 package api
 
 import (
+	"crypto/ed25519"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/gorilla/websocket"
+	"github.com/intob/daved/audit"
+	"github.com/intob/daved/events"
+	"github.com/intob/daved/graphql"
+	"github.com/intob/daved/history"
+	"github.com/intob/daved/logbuf"
+	"github.com/intob/daved/loglevel"
+	"github.com/intob/daved/logtail"
+	"github.com/intob/daved/metrics"
+	"github.com/intob/daved/readcache"
+	"github.com/intob/daved/schema"
+	"github.com/intob/daved/seal"
+	"github.com/intob/daved/status"
+	"github.com/intob/daved/tenant"
+	"github.com/intob/daved/trace"
+	"github.com/intob/daved/upload"
+	"github.com/intob/daved/watchdog"
 	"github.com/intob/godave"
 	"github.com/intob/godave/dat"
 )
 
 type Service struct {
-	listenAddr string
-	logs       chan<- string
-	dave       *godave.Dave
+	listenAddr    string
+	server        *http.Server
+	mux           *http.ServeMux
+	logs          chan<- string
+	dave          *godave.Dave
+	history       *history.History
+	readOnly      bool
+	metrics       *metrics.Registry
+	events        *events.Bus
+	debug         bool
+	statusHistory *status.Recorder
+	accessLogs    bool
+	proxyHeader   string
+	proxies       []netip.Prefix
+	allowedCidrs  []netip.Prefix
+	deniedCidrs   []netip.Prefix
+	ws            *WebsocketCfg
+	wsConns       *wsConns
+	wsBuffers     *sync.Pool // Write buffers shared between websocket connections
+	readCache     *readcache.Cache
+	logTail       *logtail.Tail
+	logLevels     *loglevel.Levels
+	watchdog      *watchdog.Watchdog
+	audit         *audit.Log
+	tenants       *tenant.Tenants
+	tls           *TLSCfg
+	version       string
+	bridgeKeys    atomic.Int64
+	schemas       *schema.Set
+	graphql       *graphql.Schema // Nil if disabled
+	gateway       bool
+	uploads       *upload.Uploads // Nil if disabled
+	linkDepth     int
+	bridgePuts    *metrics.Counter
+	grpcAddr      string
+	grpc          grpcServer // Nil unless serving gRPC
 }
 
 type ServiceCfg struct {
-	ListenAddr string
-	Logs       chan<- string
-	Dave       *godave.Dave
+	ListenAddr    string
+	Logs          chan<- string
+	Dave          *godave.Dave
+	History       *history.History // Optional
+	ReadOnly      bool             // Reject requests that prepare or make writes
+	Metrics       *metrics.Registry
+	Events        *events.Bus
+	Debug         bool             // Serve /debug/pprof and /debug/vars to loopback clients
+	StatusHistory *status.Recorder // Optional
+	AccessLog     bool
+	ProxyHeader   string         // Trusted header with the client IP, e.g. X-Forwarded-For
+	Proxies       []netip.Prefix // Whose ProxyHeader is believed, loopback if empty
+	AllowedCidrs  []netip.Prefix // If set, only clients in these are served
+	DeniedCidrs   []netip.Prefix
+	Websocket     *WebsocketCfg
+	ReadCache     *readcache.Cache   // Optional
+	LogTail       *logtail.Tail      // Optional, serves /logs
+	LogLevels     *loglevel.Levels   // Optional, serves /admin/loglevel
+	Watchdog      *watchdog.Watchdog // Optional, /healthz is always healthy without
+	Audit         *audit.Log         // Optional, records mutating calls and serves /admin/audit
+	Tenants       *tenant.Tenants    // Optional, requires tokens and serves /admin/tokens
+	TLS           *TLSCfg            // Optional, serves HTTPS
+	Version       string             // Commit the daemon was built from, served at /v1/meta
+	Schemas       *schema.Set        // Optional, values put over /ws must match
+	GraphQL       bool               // Serve /graphql, and subscriptions over /ws
+	Gateway       bool               // Serve static sites at /site/
+	Uploads       *upload.Uploads    // Optional, serves /uploads
+	LinkDepth     int                // Links followed by GET /dat?follow=true
+	GRPCAddr      string             // Optional, serves proto/daved.proto, needs the grpc build tag
 }
 
-type status struct {
+type Status struct {
 	ActivePeers int            `json:"peers"`
 	UsedSpace   int64          `json:"used_space"`
 	Capacity    int64          `json:"capacity"`
-	Network     *networkStatus `json:"network"`
+	Network     *NetworkStatus `json:"network"`
+	ReadOnly    bool           `json:"readonly"`
 }
 
-type networkStatus struct {
+type NetworkStatus struct {
 	UsedSpace uint64 `json:"used_space"`
 	Capacity  uint64 `json:"capacity"`
 }
@@ -46,6 +128,15 @@ type datWorkResp struct {
 	Salt string `json:"salt"`
 }
 
+type sealReq struct {
+	Recipient string `json:"recipient"` // Base64 ed25519 public key
+	Val       string `json:"val"`
+}
+
+type sealResp struct {
+	Val string `json:"val"` // Base64 sealed value
+}
+
 /*
 type datEntry struct {
 	Key    string `json:"key"`
@@ -60,19 +151,82 @@ type datEntry struct {
 
 func NewService(cfg *ServiceCfg) *Service {
 	svc := &Service{
-		listenAddr: cfg.ListenAddr,
-		logs:       cfg.Logs,
-		dave:       cfg.Dave,
-	}
-	http.Handle("/", corsMiddleware(http.HandlerFunc(svc.handleGetStatus)))
-	http.Handle("/status", corsMiddleware(http.HandlerFunc(svc.handleGetStatus)))
-	http.Handle("/work", corsMiddleware(http.HandlerFunc(svc.handleDoWork)))
-	//http.Handle("/put", corsMiddleware(http.HandlerFunc(svc.handlePostPut)))
-	http.Handle("/ws", corsMiddleware(http.HandlerFunc(svc.handleWebsocketConnection)))
+		listenAddr:    cfg.ListenAddr,
+		logs:          cfg.Logs,
+		dave:          cfg.Dave,
+		history:       cfg.History,
+		schemas:       cfg.Schemas,
+		readOnly:      cfg.ReadOnly,
+		metrics:       cfg.Metrics,
+		events:        cfg.Events,
+		debug:         cfg.Debug,
+		statusHistory: cfg.StatusHistory,
+		accessLogs:    cfg.AccessLog,
+		proxyHeader:   cfg.ProxyHeader,
+		proxies:       cfg.Proxies,
+		allowedCidrs:  cfg.AllowedCidrs,
+		deniedCidrs:   cfg.DeniedCidrs,
+		ws:            cfg.Websocket,
+		wsConns:       &wsConns{perIP: make(map[string]int), live: make(map[*websocket.Conn]struct{})},
+		wsBuffers:     &sync.Pool{},
+		mux:           http.NewServeMux(),
+		readCache:     cfg.ReadCache,
+		logTail:       cfg.LogTail,
+		logLevels:     cfg.LogLevels,
+		watchdog:      cfg.Watchdog,
+		audit:         cfg.Audit,
+		tenants:       cfg.Tenants,
+		tls:           cfg.TLS,
+		version:       cfg.Version,
+		gateway:       cfg.Gateway,
+		uploads:       cfg.Uploads,
+		linkDepth:     cfg.LinkDepth,
+		grpcAddr:      cfg.GRPCAddr,
+	}
+	if svc.ws == nil {
+		svc.ws = &WebsocketCfg{PingInterval: 30 * time.Second, IdleTimeout: 90 * time.Second, MaxConnsPerIP: 16}
+	}
+	svc.registerStatusMetrics()
+	if svc.ws.Bridge != nil {
+		svc.registerBridgeMetrics()
+	}
+	if svc.debug {
+		svc.publishDebugVars()
+	}
+	if cfg.GraphQL {
+		svc.graphql = svc.graphqlSchema()
+	}
+	svc.mux.Handle("/", corsMiddleware(svc.deprecated("/status", http.HandlerFunc(svc.handleGetStatus))))
+	svc.handleStable("/openapi.json", http.HandlerFunc(svc.handleGetOpenAPI))
+	svc.mux.Handle(apiPrefix+"/meta", corsMiddleware(http.HandlerFunc(svc.handleGetMeta)))
+	svc.handle("/capabilities", http.HandlerFunc(svc.handleGetCapabilities))
+	svc.handle("/status", http.HandlerFunc(svc.handleGetStatus))
+	svc.handle("/status/history", http.HandlerFunc(svc.handleGetStatusHistory))
+	svc.handle("/work", svc.writeGuard(http.HandlerFunc(svc.handleDoWork)))
+	svc.handle("/seal", svc.writeGuard(http.HandlerFunc(svc.handleSeal)))
+	svc.handle("/dat", http.HandlerFunc(svc.handleGetDat))
+	svc.handle("/history", http.HandlerFunc(svc.handleGetHistory))
+	svc.handle("/graphql", http.HandlerFunc(svc.handleGraphQL))
+	svc.handle("/site/", http.HandlerFunc(svc.handleSite))
+	svc.handle("/uploads", svc.writeGuard(svc.audited("uploads", http.HandlerFunc(svc.handleUploads))))
+	svc.handle("/uploads/", svc.writeGuard(svc.audited("uploads", http.HandlerFunc(svc.handleUploads))))
+	svc.handleStable("/healthz", http.HandlerFunc(svc.handleHealthz))
+	svc.handle("/logs", http.HandlerFunc(svc.handleGetLogs))
+	svc.handle("/events", http.HandlerFunc(svc.handleEvents))
+	svc.handleStable("/metrics", http.HandlerFunc(svc.handleGetMetrics))
+	svc.handleAdmin("/admin/loglevel", svc.audited("admin.loglevel", http.HandlerFunc(svc.handleAdminLogLevel)))
+	svc.handleAdmin("/admin/audit", http.HandlerFunc(svc.handleAdminAudit))
+	svc.handleAdmin("/admin/tokens", svc.audited("admin.tokens", http.HandlerFunc(svc.handleAdminTokens)))
+	//svc.mux.Handle("/put", corsMiddleware(http.HandlerFunc(svc.handlePostPut)))
+	svc.handle("/ws", http.HandlerFunc(svc.handleWebsocketConnection))
+	svc.mux.Handle("/debug/", http.DefaultServeMux) // pprof and expvar
 	return svc
 }
 
 func (svc *Service) Start() error {
+	if svc.grpcAddr != "" && newGRPCServer == nil {
+		return ErrNoGRPC
+	}
 	errChan := make(chan error, 1)
 	addrChan := make(chan string, 1)
 	go func() {
@@ -84,8 +238,25 @@ func (svc *Service) Start() error {
 				return
 			}
 		}
+		if svc.tls != nil {
+			tlsCfg, err := svc.tlsConfig()
+			if err != nil {
+				listener.Close()
+				errChan <- err
+				return
+			}
+			listener = tls.NewListener(listener, tlsCfg)
+		}
+		handler := compressMiddleware(svc.debugGuard(svc.authGuard(svc.mux)))
+		if len(svc.allowedCidrs) > 0 || len(svc.deniedCidrs) > 0 {
+			handler = svc.cidrGuard(handler)
+		}
+		if svc.accessLogs {
+			handler = svc.accessLog(handler)
+		}
+		svc.server = &http.Server{Handler: traceMiddleware(handler)}
 		addrChan <- listener.Addr().String()
-		if err := http.Serve(listener, nil); err != nil {
+		if err := svc.server.Serve(listener); err != nil && err != http.ErrServerClosed {
 			errChan <- err
 		}
 	}()
@@ -94,13 +265,38 @@ func (svc *Service) Start() error {
 		return err
 	case addr := <-addrChan:
 		svc.listenAddr = addr
-		svc.log("started http server on http://%s", addr)
+		scheme := "http"
+		if svc.tls != nil {
+			scheme = "https"
+		}
+		svc.log("started http server on %s://%s", scheme, addr)
+		if svc.grpcAddr != "" {
+			return svc.startGRPC()
+		}
 		return nil
 	case <-time.After(50 * time.Millisecond):
 		return fmt.Errorf("timeout waiting for server to start")
 	}
 }
 
+// Addr returns the address the server listens on, once started.
+func (svc *Service) Addr() string {
+	return svc.listenAddr
+}
+
+// Close stops the server, closing its connections, and websockets with
+// CloseGoingAway.
+func (svc *Service) Close() error {
+	svc.wsConns.closeAll()
+	if svc.grpc != nil {
+		svc.grpc.Stop()
+	}
+	if svc.server == nil {
+		return nil
+	}
+	return svc.server.Close()
+}
+
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -109,6 +305,18 @@ func corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// Rejects requests when the node is in readonly mode.
+func (svc *Service) writeGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if svc.readOnly {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("node is in readonly mode"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (svc *Service) handleDoWork(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	dec := json.NewDecoder(r.Body)
@@ -128,7 +336,10 @@ func (svc *Service) handleDoWork(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("invalid signature"))
 	}
+	_, span := trace.Start(r.Context(), "work")
+	span.SetAttr("difficulty", int(req.Difficulty))
 	work, salt := dat.DoWork(dat.Signature(sig), req.Difficulty)
+	span.End()
 	resp := &datWorkResp{
 		Work: base64.RawURLEncoding.EncodeToString(work[:]),
 		Salt: base64.RawURLEncoding.EncodeToString(salt[:]),
@@ -142,6 +353,39 @@ func (svc *Service) handleDoWork(w http.ResponseWriter, r *http.Request) {
 	w.Write(respJson)
 }
 
+func (svc *Service) handleSeal(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	dec := json.NewDecoder(r.Body)
+	req := &sealReq{}
+	err := dec.Decode(req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("failed to decode request body: %s", err)))
+		return
+	}
+	recipient, err := base64.RawURLEncoding.DecodeString(req.Recipient)
+	if err != nil || len(recipient) != ed25519.PublicKeySize {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid recipient public key"))
+		return
+	}
+	sealed, err := seal.Seal(ed25519.PublicKey(recipient), []byte(req.Val))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("failed to seal value: %s", err)))
+		return
+	}
+	respJson, err := json.MarshalIndent(&sealResp{
+		Val: base64.RawURLEncoding.EncodeToString(sealed),
+	}, "", "  ")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to marshal response json: %s", err)))
+		return
+	}
+	w.Write(respJson)
+}
+
 /*
 	func (svc *Service) handlePostPut(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "POST" {
@@ -198,22 +442,24 @@ func (svc *Service) handleDoWork(w http.ResponseWriter, r *http.Request) {
 */
 
 func (svc *Service) handleGetStatus(w http.ResponseWriter, r *http.Request) {
+	svc.writeResult(w, r, svc.Status())
+}
+
+func (svc *Service) Status() *Status {
 	networkUsed, networkCap := svc.dave.NetworkUsedSpaceAndCapacity()
-	stat := &status{
+	return &Status{
 		ActivePeers: svc.dave.ActivePeerCount(),
 		UsedSpace:   svc.dave.UsedSpace(),
 		Capacity:    svc.dave.Capacity(),
-		Network:     &networkStatus{UsedSpace: networkUsed, Capacity: networkCap},
-	}
-	resp, err := json.MarshalIndent(stat, "", "  ")
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
-		return
+		Network:     &NetworkStatus{UsedSpace: networkUsed, Capacity: networkCap},
+		ReadOnly:    svc.readOnly,
 	}
-	w.Write(resp)
 }
 
 func (svc *Service) log(msg string, args ...any) {
-	svc.logs <- fmt.Sprintf("/api "+msg, args...)
+	logbuf.For(svc.logs, "api").Printf(msg, args...)
+}
+
+func (svc *Service) logDebug(msg string, args ...any) {
+	logbuf.For(svc.logs, "api").Debugf(msg, args...)
 }
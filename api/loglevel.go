@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/intob/daved/loglevel"
+)
+
+// LogLevels is the node's log level and the levels of subsystems that
+// differ. In a change, an empty level leaves the level unchanged, and an
+// empty subsystem level returns the subsystem to the node's level.
+type LogLevels struct {
+	Level      string            `json:"level"`
+	Subsystems map[string]string `json:"subsystems"`
+}
+
+// GET returns the log levels, PUT changes them until the node restarts.
+func (svc *Service) handleAdminLogLevel(w http.ResponseWriter, r *http.Request) {
+	if svc.logLevels == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		w.Write([]byte("log levels are not available"))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		defer r.Body.Close()
+		req := &LogLevels{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("failed to decode request body: %s", err)))
+			return
+		}
+		// Parse everything first, so an invalid level changes nothing
+		var level loglevel.Level
+		if req.Level != "" {
+			level, err = loglevel.Parse(req.Level)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+		}
+		subsystems := make(map[string]loglevel.Level, len(req.Subsystems))
+		for name, l := range req.Subsystems {
+			if l == "" {
+				continue
+			}
+			subsystems[strings.TrimPrefix(name, "/")], err = loglevel.Parse(l)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(fmt.Sprintf("%s: %s", name, err)))
+				return
+			}
+		}
+		if req.Level != "" {
+			svc.logLevels.SetLevel(level)
+		}
+		for name, l := range req.Subsystems {
+			name = strings.TrimPrefix(name, "/")
+			if l == "" {
+				svc.logLevels.ClearSubsystem(name)
+			} else {
+				svc.logLevels.SetSubsystem(name, subsystems[name])
+			}
+		}
+		svc.log("admin log level %s %v", req.Level, req.Subsystems)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	svc.writeResult(w, r, currentLogLevels(svc.logLevels))
+}
+
+func currentLogLevels(levels *loglevel.Levels) *LogLevels {
+	level, subsystems := levels.Get()
+	out := &LogLevels{Level: level.String(), Subsystems: make(map[string]string, len(subsystems))}
+	for name, l := range subsystems {
+		out.Subsystems[name] = l.String()
+	}
+	return out
+}
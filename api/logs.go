@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Returns the node's most recent log lines, oldest first, e.g. ?n=50.
+func (svc *Service) handleGetLogs(w http.ResponseWriter, r *http.Request) {
+	if svc.logTail == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		w.Write([]byte("log tail is not enabled"))
+		return
+	}
+	n := 100
+	if s := r.URL.Query().Get("n"); s != "" {
+		var err error
+		n, err = strconv.Atoi(s)
+		if err != nil || n < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid n"))
+			return
+		}
+	}
+	svc.writeResult(w, r, svc.logTail.Lines(n))
+}
@@ -0,0 +1,24 @@
+package api
+
+import (
+	"net/http"
+)
+
+func (svc *Service) registerStatusMetrics() {
+	svc.metrics.GaugeFunc("daved_peers", "Active peers.", func() float64 {
+		return float64(svc.dave.ActivePeerCount())
+	})
+	svc.metrics.GaugeFunc("daved_used_space_bytes", "Space used by the local store.", func() float64 {
+		return float64(svc.dave.UsedSpace())
+	})
+	svc.metrics.GaugeFunc("daved_capacity_bytes", "Capacity of the local store.", func() float64 {
+		return float64(svc.dave.Capacity())
+	})
+}
+
+func (svc *Service) handleGetMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := svc.metrics.WritePrometheus(w); err != nil {
+		svc.log("failed to write metrics: %s", err)
+	}
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/intob/daved/watchdog"
+)
+
+// NodeStatus is the status of one of several nodes run in a process.
+type NodeStatus struct {
+	Name    string          `json:"name"`
+	ApiAddr string          `json:"api_addr"`
+	Status  *Status         `json:"status"`
+	Health  *watchdog.State `json:"health"`
+}
+
+// MultiHandler serves the combined status of the nodes of a process at
+// /v1/status, and the same at /v1/healthz, responding 503 unless every node
+// is healthy. Both are served without the version too.
+func MultiHandler(nodes func() []NodeStatus) http.Handler {
+	mux := http.NewServeMux()
+	status := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(nodes())
+	}
+	mux.HandleFunc(apiPrefix+"/status", status)
+	mux.HandleFunc("/status", status)
+	healthz := func(w http.ResponseWriter, r *http.Request) {
+		statuses := nodes()
+		w.Header().Set("Content-Type", "application/json")
+		for _, s := range statuses {
+			if !s.Health.Healthy {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				break
+			}
+		}
+		json.NewEncoder(w).Encode(statuses)
+	}
+	mux.HandleFunc(apiPrefix+"/healthz", healthz)
+	mux.HandleFunc("/healthz", healthz)
+	return corsMiddleware(mux)
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/intob/daved/audit"
+	"github.com/intob/daved/dats"
+	"github.com/intob/daved/graphql"
+	"github.com/intob/daved/status"
+	"github.com/intob/daved/tenant"
+	"github.com/intob/daved/upload"
+	"github.com/intob/daved/watchdog"
+)
+
+// Describes a route for the OpenAPI document. Schemas are derived from the
+// request and response types, so the structs stay the source of truth.
+type apiRoute struct {
+	Path     string
+	Method   string
+	Summary  string
+	Query    []string // Optional string query parameters
+	Request  any      // Zero value of the JSON body type, or nil
+	Response any      // Zero value of the JSON response type, or nil
+	Content  string   // Response content type, if not JSON
+}
+
+var apiRoutes = []apiRoute{
+	{Path: "/meta", Method: "get", Summary: "Daemon, API and protocol versions, and the features of the API", Response: Meta{}},
+	{Path: "/capabilities", Method: "get", Summary: "Optional subsystems enabled on this node", Response: Capabilities{}},
+	{Path: "/status", Method: "get", Summary: "Node status", Response: Status{}},
+	{Path: "/healthz", Method: "get", Summary: "Watchdog state, 503 if unhealthy", Response: watchdog.State{}},
+	{Path: "/status/history", Method: "get", Summary: "Status samples within a window, oldest first", Query: []string{"window"}, Response: []status.Sample{}},
+	{Path: "/work", Method: "post", Summary: "Compute proof of work for a signature", Request: datWorkReq{}, Response: datWorkResp{}},
+	{Path: "/seal", Method: "post", Summary: "Encrypt a value for a recipient public key", Request: sealReq{}, Response: sealResp{}},
+	{Path: "/dat", Method: "get", Summary: "Newest version of a dat, with an ETag for conditional requests", Query: []string{"pubkey", "ns", "key", "raw", "follow"}, Response: dats.Record{}},
+	{Path: "/logs", Method: "get", Summary: "Most recent log lines, oldest first", Query: []string{"n"}, Response: []string{}},
+	{Path: "/history", Method: "get", Summary: "Versions of a dat, newest first", Query: []string{"pubkey", "ns", "key"}, Response: []*dats.Record{}},
+	{Path: "/graphql", Method: "post", Summary: "GraphQL query of status, capabilities and dats, with api_graphql; subscriptions are served over /ws", Request: graphql.Request{}, Response: graphql.Response{}},
+	{Path: "/site/{pubkey}/{name}/{path}", Method: "get", Summary: "File of a static site, the index.html of a directory or the site's 404.html, with api_gateway", Content: "text/html"},
+	{Path: "/uploads", Method: "post", Summary: "Create a resumable upload of Upload-Length bytes, to be put as the file at path of site key, with api_uploads; its URL is in Location", Query: []string{"key", "path"}},
+	{Path: "/uploads/{id}", Method: "get", Summary: "Offset and state of an upload, also in Upload-Offset", Response: upload.Status{}},
+	{Path: "/uploads/{id}", Method: "patch", Summary: "Append the body, of type application/offset+octet-stream, to an upload at Upload-Offset, the last append publishing it"},
+	{Path: "/uploads/{id}", Method: "delete", Summary: "Cancel an upload"},
+	{Path: "/events", Method: "get", Summary: "Server-sent event stream, with dat events filtered by the dat filter", Query: append([]string{"type"}, filterParams...), Content: "text/event-stream"},
+	{Path: "/metrics", Method: "get", Summary: "Metrics in the Prometheus text format", Content: "text/plain"},
+	{Path: "/admin/loglevel", Method: "get", Summary: "Log level and levels by subsystem", Response: LogLevels{}},
+	{Path: "/admin/loglevel", Method: "put", Summary: "Change the log level or levels by subsystem, an empty subsystem level clears it", Request: LogLevels{}, Response: LogLevels{}},
+	{Path: "/admin/audit", Method: "get", Summary: "Audit log of mutating calls, oldest first, with audit_filename", Query: []string{"n", "since", "action", "identity"}, Response: []audit.Entry{}},
+	{Path: "/admin/tokens", Method: "get", Summary: "Tenant tokens with their usage, with tokens_filename", Response: []tenant.Token{}},
+	{Path: "/admin/tokens", Method: "post", Summary: "Create a tenant token, returned only in this response", Request: TokenReq{}, Response: TokenResp{}},
+	{Path: "/admin/tokens", Method: "delete", Summary: "Revoke a tenant token", Query: []string{"id"}},
+}
+
+func (svc *Service) handleGetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	resp, err := json.MarshalIndent(openAPIDocument(), "", "  ")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Write(resp)
+}
+
+func openAPIDocument() map[string]any {
+	paths := make(map[string]map[string]any)
+	for _, rt := range apiRoutes {
+		op := map[string]any{"summary": rt.Summary}
+		if len(rt.Query) > 0 {
+			params := make([]map[string]any, 0, len(rt.Query))
+			for _, q := range rt.Query {
+				params = append(params, map[string]any{
+					"name": q, "in": "query", "schema": map[string]any{"type": "string"},
+				})
+			}
+			op["parameters"] = params
+		}
+		if rt.Request != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": schemaOf(reflect.TypeOf(rt.Request))}},
+			}
+		}
+		ok := map[string]any{"description": "OK"}
+		content := rt.Content
+		if content == "" {
+			content = "application/json"
+		}
+		if rt.Response != nil {
+			ok["content"] = map[string]any{content: map[string]any{"schema": schemaOf(reflect.TypeOf(rt.Response))}}
+		} else {
+			ok["content"] = map[string]any{content: map[string]any{}}
+		}
+		op["responses"] = map[string]any{"200": ok}
+		path := apiPrefix + rt.Path
+		if paths[path] == nil {
+			paths[path] = make(map[string]any)
+		}
+		paths[path][rt.Method] = op
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info":    map[string]any{"title": "daved", "version": strings.TrimPrefix(APIVersion, "v")},
+		"paths":   paths,
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Returns a JSON schema for t following encoding/json rules.
+func schemaOf(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Struct:
+		props := make(map[string]any)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name := f.Name
+			if tag, ok := f.Tag.Lookup("json"); ok {
+				if tag == "-" {
+					continue
+				}
+				if n, _, _ := strings.Cut(tag, ","); n != "" {
+					name = n
+				}
+			}
+			props[name] = schemaOf(f.Type)
+		}
+		return map[string]any{"type": "object", "properties": props}
+	}
+	return map[string]any{}
+}
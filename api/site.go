@@ -0,0 +1,128 @@
+package api
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/intob/daved/site"
+)
+
+// Serves the files of static sites published with site publish, at
+// /site/<pubkey>/<name>/<path>. A directory serves its index.html, and a
+// missing path the site's 404.html. Pages run in a sandbox with an opaque
+// origin, so their scripts cannot call the API with the visitor's
+// credentials.
+func (svc *Service) handleSite(w http.ResponseWriter, r *http.Request) {
+	if !svc.gateway {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("gateway is not enabled, set api_gateway"))
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	// The escaped path keeps a name's namespace separator apart from the
+	// path within the site
+	parts := strings.SplitN(strings.TrimPrefix(unversioned(r.URL.EscapedPath()), "/site/"), "/", 3)
+	pubKey, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(pubKey) != ed25519.PublicKeySize {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid pubkey"))
+		return
+	}
+	if len(parts) < 2 || parts[1] == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("missing site name"))
+		return
+	}
+	name, err := url.PathUnescape(parts[1])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid site name"))
+		return
+	}
+	if len(parts) < 3 { // Relative links resolve against the trailing slash
+		http.Redirect(w, r, r.URL.EscapedPath()+"/", http.StatusMovedPermanently)
+		return
+	}
+	p, err := url.PathUnescape("/" + parts[2])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid path"))
+		return
+	}
+	m, err := site.ReadManifest(r.Context(), svc.getDat, pubKey, name)
+	if errors.Is(err, site.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	status := http.StatusOK
+	filePath, ok := m.Lookup(p)
+	if !ok && !strings.HasSuffix(p, "/") {
+		if _, ok := m.Lookup(p + "/"); ok {
+			http.Redirect(w, r, r.URL.EscapedPath()+"/", http.StatusMovedPermanently)
+			return
+		}
+	}
+	if !ok {
+		if m.NotFound == "" || m.Files[m.NotFound] == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("not found"))
+			return
+		}
+		status, filePath = http.StatusNotFound, m.NotFound
+	}
+	f := m.Files[filePath]
+	if status == http.StatusOK && r.Header.Get("Range") != "" {
+		svc.serveSiteRange(w, r, pubKey, name, f)
+		return
+	}
+	body, err := site.ReadFile(r.Context(), svc.getDat, pubKey, name, f)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	setSiteHeaders(w, f)
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		w.Write(body)
+		return
+	}
+	// Files are addressed by their hash, so it is a strong ETag
+	w.Header().Set("ETag", `"`+f.SHA256[:32]+`"`)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+}
+
+// Serves the ranges of f a request asks for, fetching only their chunks.
+// ServeContent falls back to the whole file if If-Range does not match.
+func (svc *Service) serveSiteRange(w http.ResponseWriter, r *http.Request, pubKey ed25519.PublicKey, name string, f *site.File) {
+	fr, err := site.NewFileReader(r.Context(), svc.getDat, pubKey, name, f)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	setSiteHeaders(w, f)
+	w.Header().Set("ETag", `"`+f.SHA256[:32]+`"`)
+	http.ServeContent(w, r, "", time.Time{}, fr)
+}
+
+func setSiteHeaders(w http.ResponseWriter, f *site.File) {
+	w.Header().Set("Content-Type", f.Type)
+	w.Header().Set("Content-Security-Policy", "sandbox allow-scripts allow-forms allow-popups allow-downloads")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
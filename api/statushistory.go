@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// Returns status samples within window, default 1h, oldest first.
+func (svc *Service) handleGetStatusHistory(w http.ResponseWriter, r *http.Request) {
+	if svc.statusHistory == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("status history is not available"))
+		return
+	}
+	window := time.Hour
+	if q := r.URL.Query().Get("window"); q != "" {
+		d, err := time.ParseDuration(q)
+		if err != nil || d <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid window"))
+			return
+		}
+		window = d
+	}
+	svc.writeResult(w, r, svc.statusHistory.Window(window))
+}
@@ -0,0 +1,203 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/intob/daved/tenant"
+)
+
+type tokenKey struct{}
+
+var errRateLimit = errors.New("token rate limit exceeded")
+
+type TokenReq struct {
+	Name   string        `json:"name"`
+	Scope  string        `json:"scope"` // read, write or admin
+	Limits tenant.Limits `json:"limits"`
+}
+
+type TokenResp struct {
+	Secret string `json:"token"` // Shown only once
+	*tenant.Token
+}
+
+// Routes served without a token, so health checks and clients discovering
+// the API need none. The websocket authenticates its own connections.
+var openPaths = []string{"/healthz", "/openapi.json", "/meta", "/capabilities", "/ws"}
+
+// Requires a client certificate or token with the scope of the request,
+// when the node has tenants or client certificates: admin for /admin/,
+// write for other requests that change the node or do work, else read.
+// Tenant tokens are held to their rate.
+func (svc *Service) authGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (svc.tenants == nil && !svc.clientCerts()) || slices.Contains(openPaths, unversioned(r.URL.Path)) || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tok := svc.certToken(r)
+		if tok == nil {
+			secret, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if secret != "" {
+				tok = svc.authenticate(secret)
+			}
+		}
+		if tok == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("invalid token or certificate"))
+			return
+		}
+		if required := requiredScope(r); !wsPermits(tok.Scope, required) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(fmt.Sprintf("%s scope is required", required)))
+			return
+		}
+		if svc.tenants != nil && !svc.tenants.Allow(tok) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(errRateLimit.Error()))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenKey{}, tok)))
+	})
+}
+
+// Returns the token of a secret, from the websocket tokens of the config,
+// which have no limits, or the tenants. Nil if it is not known.
+func (svc *Service) authenticate(secret string) *tenant.Token {
+	if scope := svc.wsTokenScope(secret); scope != "" {
+		return &tenant.Token{ID: tenant.ID(secret), Name: "config", Scope: scope}
+	}
+	if svc.tenants == nil {
+		return nil
+	}
+	return svc.tenants.Authenticate(secret)
+}
+
+// Returns the scope of a token, or an empty string if it is not known.
+func (svc *Service) tokenScope(secret string) string {
+	if tok := svc.authenticate(secret); tok != nil {
+		return tok.Scope
+	}
+	return ""
+}
+
+// Permits reports whether token is a tenant or websocket token granting
+// scope, for servers beside the API that share its tokens.
+func (svc *Service) Permits(token, scope string) bool {
+	granted := svc.tokenScope(token)
+	return granted != "" && wsPermits(granted, scope)
+}
+
+// ChargeSet holds a tenant token to its rate, and counts a put of size
+// bytes against its quotas, for SETs of the Redis protocol server as for
+// puts over the websocket. Tokens of the config are not tenants, so are
+// not limited.
+func (svc *Service) ChargeSet(token string, size int64) error {
+	if svc.tenants == nil || token == "" {
+		return nil
+	}
+	tok := svc.tenants.Authenticate(token)
+	if tok == nil {
+		return nil
+	}
+	return svc.chargeTenant(tok, size)
+}
+
+// Holds a tenant token to its rate, and counts a put of size bytes against
+// its quotas.
+func (svc *Service) chargeTenant(tok *tenant.Token, size int64) error {
+	if !svc.tenants.Allow(tok) {
+		return errRateLimit
+	}
+	return svc.tenants.Charge(tok.ID, size)
+}
+
+// HasTokens reports whether the API has any tenant or websocket tokens.
+func (svc *Service) HasTokens() bool {
+	return svc.tenants != nil || len(svc.ws.Tokens) > 0
+}
+
+func requiredScope(r *http.Request) string {
+	switch {
+	case strings.HasPrefix(unversioned(r.URL.Path), "/admin/"):
+		return "admin"
+	case unversioned(r.URL.Path) == "/graphql": // Queries are posted, there are no mutations
+		return "read"
+	case r.Method != http.MethodGet && r.Method != http.MethodHead:
+		return "write"
+	default:
+		return "read"
+	}
+}
+
+// Returns the token the request was authenticated with, or nil.
+func requestToken(r *http.Request) *tenant.Token {
+	tok, _ := r.Context().Value(tokenKey{}).(*tenant.Token)
+	return tok
+}
+
+// GET lists tokens with their usage, POST creates one, DELETE ?id= revokes
+// one.
+func (svc *Service) handleAdminTokens(w http.ResponseWriter, r *http.Request) {
+	if svc.tenants == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("tenants are not enabled"))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		svc.writeResult(w, r, svc.tenants.List())
+	case http.MethodPost:
+		defer r.Body.Close()
+		req := &TokenReq{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("failed to decode request body: %s", err)))
+			return
+		}
+		if !slices.Contains(wsScopes, req.Scope) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("scope must be read, write or admin"))
+			return
+		}
+		l := req.Limits
+		if l.Rate < 0 || l.Burst < 0 || l.MaxPuts < 0 || l.MaxBytes < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("limits must not be negative"))
+			return
+		}
+		secret, tok, err := svc.tenants.Create(req.Name, req.Scope, l)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		svc.log("admin created token %s (%s) with %s scope", tok.ID, tok.Name, tok.Scope)
+		svc.writeResult(w, r, &TokenResp{Secret: secret, Token: tok})
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		err := svc.tenants.Revoke(id)
+		if errors.Is(err, tenant.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		svc.log("admin revoked token %s", id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
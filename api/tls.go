@@ -0,0 +1,78 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/intob/daved/tenant"
+)
+
+type TLSCfg struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string            // If set, clients may authenticate by certificate
+	ClientScopes map[string]string // Certificate name to scope
+}
+
+// Returns the config of the listener. Client certificates signed by the CA
+// are required, unless bearer tokens are accepted as well.
+func (svc *Service) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(svc.tls.CertFile, svc.tls.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	c := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{cert}}
+	if svc.tls.ClientCAFile == "" {
+		return c, nil
+	}
+	pem, err := os.ReadFile(svc.tls.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client ca file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in client ca file")
+	}
+	c.ClientCAs = pool
+	c.ClientAuth = tls.RequireAndVerifyClientCert
+	if svc.tenants != nil || len(svc.ws.Tokens) > 0 {
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return c, nil
+}
+
+// Reports whether clients may authenticate by certificate.
+func (svc *Service) clientCerts() bool {
+	return svc.tls != nil && svc.tls.ClientCAFile != ""
+}
+
+// Returns the token of the request's verified client certificate, named by
+// the first of its common name, DNS, URI and email names that has a scope.
+// Nil if there is no certificate or none of its names has a scope.
+func (svc *Service) certToken(r *http.Request) *tenant.Token {
+	return svc.connCertToken(r.TLS)
+}
+
+// Returns the token of a connection's verified client certificate, as
+// certToken does for requests.
+func (svc *Service) connCertToken(state *tls.ConnectionState) *tenant.Token {
+	if !svc.clientCerts() || state == nil || len(state.VerifiedChains) == 0 {
+		return nil
+	}
+	leaf := state.VerifiedChains[0][0]
+	names := append([]string{leaf.Subject.CommonName}, leaf.DNSNames...)
+	for _, u := range leaf.URIs {
+		names = append(names, u.String())
+	}
+	names = append(names, leaf.EmailAddresses...)
+	for _, name := range names {
+		if scope, ok := svc.tls.ClientScopes[name]; ok && name != "" {
+			return &tenant.Token{ID: "cert:" + name, Name: name, Scope: scope}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/intob/daved/upload"
+)
+
+// Serves resumable uploads of files, put as sites under the node's key:
+// POST /uploads?key=&path= with Upload-Length creates one, PATCH
+// /uploads/<id> with Upload-Offset appends to it, GET or HEAD returns its
+// offset and state, and DELETE cancels it. Without tokens or client
+// certificates, only loopback clients may upload, as the node signs.
+func (svc *Service) handleUploads(w http.ResponseWriter, r *http.Request) {
+	if svc.uploads == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("uploads are not enabled, set api_uploads"))
+		return
+	}
+	if !svc.HasTokens() && !svc.clientCerts() && !svc.fromLoopback(r) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("uploads require a token, or connect from loopback"))
+		return
+	}
+	owner := ""
+	tok := requestToken(r)
+	if tok != nil {
+		owner = tok.ID
+	}
+	id := strings.TrimPrefix(unversioned(r.URL.Path), "/uploads")
+	id = strings.TrimPrefix(id, "/")
+	if id == "" {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid Upload-Length"))
+			return
+		}
+		var charge func(int64) error
+		if svc.tenants != nil && tok != nil && svc.tenants.Get(tok.ID) != nil {
+			charge = func(size int64) error { return svc.tenants.Charge(tok.ID, size) }
+		}
+		q := r.URL.Query()
+		stat, err := svc.uploads.Create(q.Get("key"), q.Get("path"), size, owner, charge)
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		w.Header().Set("Location", apiPrefix+"/uploads/"+stat.ID)
+		w.Header().Set("Upload-Offset", "0")
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		stat, err := svc.uploads.Get(id, owner)
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		setUploadHeaders(w, stat)
+		svc.writeResult(w, r, stat)
+	case http.MethodPatch:
+		if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			w.Write([]byte("Content-Type must be application/offset+octet-stream"))
+			return
+		}
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid Upload-Offset"))
+			return
+		}
+		defer r.Body.Close()
+		stat, err := svc.uploads.Append(id, owner, offset, r.Body)
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		setUploadHeaders(w, stat)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := svc.uploads.Delete(id, owner); err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func setUploadHeaders(w http.ResponseWriter, stat *upload.Status) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(stat.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(stat.Size, 10))
+	w.Header().Set("Upload-Expires", stat.Expires.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+func writeUploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, upload.ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, upload.ErrOffset), errors.Is(err, upload.ErrBusy), errors.Is(err, upload.ErrComplete):
+		w.WriteHeader(http.StatusConflict)
+	case errors.Is(err, upload.ErrTooLong):
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	case errors.Is(err, upload.ErrTooMany):
+		w.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	w.Write([]byte(err.Error()))
+}
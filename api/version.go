@@ -0,0 +1,105 @@
+package api
+
+import (
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// APIVersion is the version of the HTTP API, and the prefix of its paths.
+// Paths without it are served too, with deprecation headers, until the
+// next version.
+const APIVersion = "v1"
+
+const apiPrefix = "/" + APIVersion
+
+// Features of this version of the API, for clients to check before relying
+// on them.
+var apiFeatures = []string{
+	"cbor",         // Accept: application/cbor
+	"msgpack",      // Accept: application/msgpack
+	"gzip",         // Accept-Encoding: gzip
+	"etag",         // GET /dat answers If-None-Match
+	"range",        // GET /dat?raw=true answers Range
+	"sse",          // GET /events
+	"websocket",    // GET /ws
+	"ws_binary",    // GET /ws with the dave.v1 subprotocol
+	"bearer_token", // Authorization: Bearer
+}
+
+type Meta struct {
+	Version  string   `json:"version"`            // Commit the daemon was built from
+	API      string   `json:"api"`                // Version of the HTTP API
+	Protocol string   `json:"protocol,omitempty"` // Version of godave, which defines the wire protocol
+	Features []string `json:"features"`
+}
+
+// Registers a route under the API version, and without it as deprecated.
+func (svc *Service) handle(path string, h http.Handler) {
+	svc.mux.Handle(apiPrefix+path, corsMiddleware(h))
+	svc.mux.Handle(path, corsMiddleware(svc.deprecated(path, h)))
+}
+
+// Registers an /admin/ route like handle, guarded by adminGuard and without
+// CORS headers, so browsers don't let other origins call it.
+func (svc *Service) handleAdmin(path string, h http.Handler) {
+	h = svc.adminGuard(h)
+	svc.mux.Handle(apiPrefix+path, h)
+	svc.mux.Handle(path, svc.deprecated(path, h))
+}
+
+// Registers a route under the API version, and without it for tools that
+// expect the conventional path, such as Prometheus and load balancers.
+func (svc *Service) handleStable(path string, h http.Handler) {
+	svc.mux.Handle(apiPrefix+path, corsMiddleware(h))
+	svc.mux.Handle(path, corsMiddleware(h))
+}
+
+// Marks responses to an unversioned path as deprecated, pointing to the
+// versioned one, and counts them, so operators can tell when no client
+// still uses them.
+func (svc *Service) deprecated(path string, next http.Handler) http.Handler {
+	link := "<" + apiPrefix + path + `>; rel="successor-version"`
+	count := svc.metrics.Counter("daved_api_deprecated_requests_total", "Requests to paths without the API version.")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count.Inc()
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", link)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Returns a path without the API version.
+func unversioned(path string) string {
+	if rest, ok := strings.CutPrefix(path, apiPrefix); ok && strings.HasPrefix(rest, "/") {
+		return rest
+	}
+	return path
+}
+
+func (svc *Service) handleGetMeta(w http.ResponseWriter, r *http.Request) {
+	svc.writeResult(w, r, &Meta{
+		Version:  strings.TrimSpace(svc.version),
+		API:      APIVersion,
+		Protocol: godaveVersion(),
+		Features: apiFeatures,
+	})
+}
+
+// Returns the version of godave the binary was built with, or an empty
+// string if it is not known, such as in a build from a workspace.
+func godaveVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/intob/godave" {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return ""
+}
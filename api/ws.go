@@ -1,43 +1,240 @@
 package api
 
 import (
+	"context"
 	"net/http"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/intob/daved/tenant"
+	"github.com/intob/daved/trace"
 	"github.com/intob/godave/network"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  network.MAX_MSG_LEN,
-	WriteBufferSize: network.MAX_MSG_LEN,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Accepting all requests
-	},
+type WebsocketCfg struct {
+	PingInterval   time.Duration
+	IdleTimeout    time.Duration // Closed if no message or pong within this
+	MaxConnsPerIP  int
+	MaxConns       int               // Zero is unlimited
+	SharedBuffers  bool              // Pool write buffers between connections, to save memory
+	AllowedOrigins []string          // Empty allows any
+	Tokens         map[string]string // Token to scope, if set connections must authenticate
+	Bridge         *BridgeCfg        // Optional
+}
+
+// A protocol spoken over a connection, chosen by subprotocol. Without one,
+// messages are echoed.
+type wsProtocol interface {
+	// Handles a message, returning an error if the connection must close.
+	handle(ctx context.Context, messageType int, msg []byte) error
+	close()
+}
+
+// Counts open websocket connections per client IP.
+type wsConns struct {
+	mu     sync.Mutex
+	perIP  map[string]int
+	total  int
+	live   map[*websocket.Conn]struct{} // Hijacked, so not closed by the http server
+	closed bool
+}
+
+func (c *wsConns) acquire(ip string, max, maxTotal int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.perIP[ip] >= max || (maxTotal > 0 && c.total >= maxTotal) {
+		return false
+	}
+	c.perIP[ip]++
+	c.total++
+	return true
+}
+
+func (c *wsConns) release(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.perIP[ip]--
+	c.total--
+	if c.perIP[ip] <= 0 {
+		delete(c.perIP, ip)
+	}
+}
+
+// Tracks conn until untracked, returning false if the service is closed.
+func (c *wsConns) track(conn *websocket.Conn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	c.live[conn] = struct{}{}
+	return true
+}
+
+func (c *wsConns) untrack(conn *websocket.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.live, conn)
+}
+
+// Tells each live conn the server is going away, and closes it, ending
+// its reads. WriteControl and Close are safe alongside the conn's writer.
+func (c *wsConns) closeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for conn := range c.live {
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+			time.Now().Add(time.Second))
+		conn.Close()
+	}
+}
+
+func (svc *Service) upgrader() *websocket.Upgrader {
+	u := &websocket.Upgrader{
+		ReadBufferSize:  network.MAX_MSG_LEN,
+		WriteBufferSize: network.MAX_MSG_LEN,
+		Subprotocols:    []string{wsBinaryProtocol},
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if len(svc.ws.AllowedOrigins) == 0 || origin == "" {
+				return true
+			}
+			return slices.Contains(svc.ws.AllowedOrigins, origin)
+		},
+	}
+	if svc.graphql != nil {
+		u.Subprotocols = append(u.Subprotocols, graphqlWsProtocol)
+	}
+	if svc.ws.SharedBuffers {
+		u.WriteBufferPool = svc.wsBuffers
+	}
+	return u
 }
 
 func (svc *Service) handleWebsocketConnection(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	ip := svc.clientIP(r)
+	scope, tokenID := "admin", ""
+	if tok := svc.certToken(r); tok != nil {
+		scope, tokenID = tok.Scope, tok.ID
+	} else if len(svc.ws.Tokens) > 0 || svc.tenants != nil || svc.clientCerts() {
+		scope = ""
+		if token := r.URL.Query().Get("token"); token != "" {
+			scope, tokenID = svc.tokenScope(token), tenant.ID(token)
+			if scope == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("invalid token"))
+				return
+			}
+		}
+	}
+	if !svc.wsConns.acquire(ip, svc.ws.MaxConnsPerIP, svc.ws.MaxConns) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("too many websocket connections"))
+		return
+	}
+	defer svc.wsConns.release(ip)
+	conn, err := svc.upgrader().Upgrade(w, r, nil)
 	if err != nil {
 		svc.log("ws error upgrading connection: %v", err)
 		return
 	}
 	defer conn.Close()
+	if !svc.wsConns.track(conn) {
+		return
+	}
+	defer svc.wsConns.untrack(conn)
+
+	conn.SetReadLimit(network.MAX_MSG_LEN)
+	conn.SetReadDeadline(time.Now().Add(svc.ws.IdleTimeout))
+	if scope == "" {
+		var token string
+		scope, token = svc.wsAuthFirstMessage(conn, wsContentType(r))
+		if scope == "" {
+			return
+		}
+		tokenID = tenant.ID(token)
+	}
 
-	svc.log("ws client connected")
+	svc.log("ws client connected with %s scope", scope)
+
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(svc.ws.IdleTimeout))
+	})
+	done := make(chan struct{})
+	defer close(done)
+	var writeMu sync.Mutex // Pings are written concurrently with replies
+	var session wsProtocol
+	switch conn.Subprotocol() {
+	case wsBinaryProtocol:
+		s := svc.newWsSession(conn, &writeMu, scope, tokenID, svc.clientIP(r))
+		defer s.close()
+		if err := s.hello(); err != nil {
+			svc.log("ws write error: %s", err)
+			return
+		}
+		session = s
+	case graphqlWsProtocol:
+		s := svc.newGraphQLSession(conn, &writeMu)
+		defer s.close()
+		session = s
+	}
+	go func() {
+		tick := time.NewTicker(svc.ws.PingInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+				writeMu.Lock()
+				err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(svc.ws.PingInterval))
+				writeMu.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}
+	}()
 
 	for {
 		messageType, message, err := conn.ReadMessage()
 		if err != nil {
-			svc.log("ws read error:", err)
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				svc.log("ws read error: %s", err)
+			}
 			break
 		}
+		conn.SetReadDeadline(time.Now().Add(svc.ws.IdleTimeout))
 
-		svc.log("ws received: %s", string(message))
-
-		// Echo the message back to client
-		if err := conn.WriteMessage(messageType, message); err != nil {
-			svc.log("ws write error:", err)
+		if !wsPermits(scope, "read") {
 			break
 		}
+
+		_, span := trace.Start(r.Context(), "ws.message")
+		span.SetAttr("size", len(message))
+		if session != nil {
+			err = session.handle(r.Context(), messageType, message)
+		} else {
+			svc.logDebug("ws received: %s", string(message))
+
+			// Echo the message back to client
+			writeMu.Lock()
+			err = conn.WriteMessage(messageType, message)
+			writeMu.Unlock()
+		}
+		span.SetError(err)
+		span.End()
+		if err != nil {
+			svc.log("ws write error: %s", err)
+			return
+		}
 	}
+	writeMu.Lock()
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	writeMu.Unlock()
 }
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/intob/daved/codec"
+)
+
+// Websocket scopes, each including the ones before it.
+var wsScopes = []string{"read", "write", "admin"}
+
+type wsAuthMsg struct {
+	Token string `json:"token"`
+}
+
+type wsAuthResp struct {
+	OK    bool   `json:"ok"`
+	Scope string `json:"scope,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Returns the scope of token, or an empty string if it is not known.
+func (svc *Service) wsTokenScope(token string) string {
+	scope := ""
+	for t, s := range svc.ws.Tokens { // Compare all, in constant time
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			scope = s
+		}
+	}
+	return scope
+}
+
+// Returns the content type of the replies the node writes on a connection
+// without a subprotocol: ?format=cbor or msgpack, as browsers cannot set
+// the Accept header of a websocket, else as negotiated by Accept.
+func wsContentType(r *http.Request) string {
+	switch r.URL.Query().Get("format") {
+	case "json":
+		return codec.JSON
+	case "cbor":
+		return codec.CBOR
+	case "msgpack":
+		return codec.MSGPACK
+	}
+	return codec.Negotiate(r.Header.Get("Accept"))
+}
+
+// Writes v as a text message of JSON, or a binary message of CBOR or
+// MessagePack.
+func wsWrite(conn *websocket.Conn, contentType string, v any) error {
+	if contentType == codec.JSON {
+		return conn.WriteJSON(v)
+	}
+	b, err := codec.Marshal(contentType, v)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.BinaryMessage, b)
+}
+
+// Authenticates a connection that did not send a token in the query, by
+// reading {"token": "..."} as the first message, always JSON, and replying
+// in contentType. Returns the scope and token, or an empty scope after
+// closing the connection.
+func (svc *Service) wsAuthFirstMessage(conn *websocket.Conn, contentType string) (string, string) {
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		return "", ""
+	}
+	auth := &wsAuthMsg{}
+	scope := ""
+	if json.Unmarshal(msg, auth) == nil {
+		scope = svc.tokenScope(auth.Token)
+	}
+	if scope == "" {
+		wsWrite(conn, contentType, &wsAuthResp{Error: "invalid token"})
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "invalid token"), time.Now().Add(time.Second))
+		return "", ""
+	}
+	wsWrite(conn, contentType, &wsAuthResp{OK: true, Scope: scope})
+	return scope, auth.Token
+}
+
+// Reports whether scope grants required. Unknown scopes grant nothing.
+func wsPermits(scope, required string) bool {
+	have, need := slices.Index(wsScopes, scope), slices.Index(wsScopes, required)
+	return have >= 0 && need >= 0 && have >= need
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWsPermits(t *testing.T) {
+	for _, tc := range []struct {
+		scope, required string
+		want            bool
+	}{
+		{"read", "read", true},
+		{"read", "write", false},
+		{"read", "admin", false},
+		{"write", "read", true},
+		{"write", "write", true},
+		{"write", "admin", false},
+		{"admin", "read", true},
+		{"admin", "write", true},
+		{"admin", "admin", true},
+		{"", "read", false},
+		{"root", "read", false},
+		{"read", "", false},
+		{"admin", "root", false},
+	} {
+		if got := wsPermits(tc.scope, tc.required); got != tc.want {
+			t.Errorf("wsPermits(%q, %q) = %v, want %v", tc.scope, tc.required, got, tc.want)
+		}
+	}
+}
+
+func TestRequiredScope(t *testing.T) {
+	for _, tc := range []struct {
+		method, path string
+		want         string
+	}{
+		{"GET", "/dat", "read"},
+		{"HEAD", "/v1/dat", "read"},
+		{"POST", "/work", "write"},
+		{"PUT", "/v1/work", "write"},
+		{"POST", "/graphql", "read"},
+		{"POST", "/v1/graphql", "read"},
+		{"GET", "/admin/audit", "admin"},
+		{"GET", "/v1/admin/tokens", "admin"},
+		{"POST", "/admin/loglevel", "admin"},
+		{"GET", "/administrator", "read"},
+	} {
+		r := httptest.NewRequest(tc.method, tc.path, nil)
+		if got := requiredScope(r); got != tc.want {
+			t.Errorf("%s %s requires %q, want %q", tc.method, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestPermits(t *testing.T) {
+	svc := &Service{ws: &WebsocketCfg{Tokens: map[string]string{"r": "read", "w": "write", "a": "admin"}}}
+	for _, tc := range []struct {
+		token, scope string
+		want         bool
+	}{
+		{"r", "read", true},
+		{"r", "write", false},
+		{"w", "write", true},
+		{"w", "admin", false},
+		{"a", "write", true},
+		{"a", "admin", true},
+		{"x", "read", false},
+		{"", "read", false},
+	} {
+		if got := svc.Permits(tc.token, tc.scope); got != tc.want {
+			t.Errorf("Permits(%q, %q) = %v, want %v", tc.token, tc.scope, got, tc.want)
+		}
+	}
+}
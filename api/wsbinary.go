@@ -0,0 +1,249 @@
+package api
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/intob/daved/dats"
+	"github.com/intob/daved/events"
+	"github.com/intob/godave/types"
+)
+
+// Subprotocol of the binary websocket protocol. Each message is one frame:
+// an op (1 byte), an id chosen by the client to match replies (4 bytes,
+// big-endian), then the op's payload. Dats are in the binary encoding of
+// package dats, so a frame is no larger than a message between peers.
+//
+//	GET    0x01  pubkey (32) | key length (1) | key
+//	PUT    0x02  dat
+//	SUB    0x03  filter, dats put through the node from now on that match are sent as EVENT frames with this id
+//	UNSUB  0x04  empty, ends the subscription or registration with this id
+//	REG    0x05  pubkey (32) | signature (64) of the bridge challenge
+//	OK     0x80  empty
+//	DAT    0x81  dat
+//	ERR    0x82  HTTP status (2) | message
+//	EVENT  0x83  dat
+//	HELLO  0x84  nonce (32), sent first if the bridge is enabled
+const wsBinaryProtocol = "dave.v1"
+
+const (
+	wsOpGet   byte = 0x01
+	wsOpPut   byte = 0x02
+	wsOpSub   byte = 0x03
+	wsOpUnsub byte = 0x04
+	wsOpReg   byte = 0x05
+	wsOpOK    byte = 0x80
+	wsOpDat   byte = 0x81
+	wsOpErr   byte = 0x82
+	wsOpEvent byte = 0x83
+	wsOpHello byte = 0x84
+)
+
+const wsMaxSubs = 16 // Per connection
+
+// A connection speaking the binary protocol.
+type wsSession struct {
+	svc     *Service
+	conn    *websocket.Conn
+	writeMu *sync.Mutex // Shared with pings
+	scope   string
+	tokenID string            // Of the token used, for the audit log, empty if none
+	ip      string            // Of the client, for the audit log
+	subs    map[uint32]func() // Id to cancel, only used by the read loop
+	nonce   [32]byte          // Signed to register keys with the bridge
+	keys    map[string]uint32 // Registered key to id
+	tokens  float64           // Bridge rate limit bucket
+	last    time.Time
+	puts    int64
+	bytes   int64
+}
+
+func (svc *Service) newWsSession(conn *websocket.Conn, writeMu *sync.Mutex, scope, tokenID, ip string) *wsSession {
+	return &wsSession{svc: svc, conn: conn, writeMu: writeMu, scope: scope, tokenID: tokenID, ip: ip, subs: make(map[uint32]func()), keys: make(map[string]uint32)}
+}
+
+// Handles a message, returning an error only if the reply failed.
+func (s *wsSession) handle(ctx context.Context, messageType int, msg []byte) error {
+	if messageType != websocket.BinaryMessage || len(msg) < 5 {
+		return s.writeErr(0, http.StatusBadRequest, "expected a binary frame")
+	}
+	op, id, payload := msg[0], binary.BigEndian.Uint32(msg[1:]), msg[5:]
+	switch op {
+	case wsOpGet:
+		return s.get(ctx, id, payload)
+	case wsOpPut:
+		return s.put(id, payload)
+	case wsOpSub:
+		return s.subscribe(id, payload)
+	case wsOpUnsub:
+		s.unsubscribe(id)
+		return s.write(wsOpOK, id, nil)
+	case wsOpReg:
+		return s.register(id, payload)
+	default:
+		return s.writeErr(id, http.StatusBadRequest, fmt.Sprintf("unknown op 0x%02x", op))
+	}
+}
+
+func (s *wsSession) get(ctx context.Context, id uint32, payload []byte) error {
+	if len(payload) < ed25519.PublicKeySize+1 || len(payload) != ed25519.PublicKeySize+1+int(payload[ed25519.PublicKeySize]) {
+		return s.writeErr(id, http.StatusBadRequest, "invalid get")
+	}
+	pubKey := ed25519.PublicKey(payload[:ed25519.PublicKeySize])
+	key := string(payload[ed25519.PublicKeySize+1:])
+	d, ok := s.svc.readCache.Get(pubKey, key)
+	if !ok {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		entry, err := s.svc.dave.Get(ctx, &types.Get{PublicKey: pubKey, DatKey: key})
+		if err != nil {
+			return s.writeErr(id, http.StatusGatewayTimeout, err.Error())
+		}
+		if entry == nil {
+			return s.writeErr(id, http.StatusNotFound, "not found")
+		}
+		d = &entry.Dat
+		s.svc.readCache.Put(d)
+	}
+	b, err := dats.AppendBinary(nil, d)
+	if err != nil {
+		return s.writeErr(id, http.StatusInternalServerError, err.Error())
+	}
+	return s.write(wsOpDat, id, b)
+}
+
+// Puts a dat, recording it to the audit log whether or not it is refused.
+func (s *wsSession) put(id uint32, payload []byte) error {
+	status, err := s.putDat(payload)
+	s.svc.auditPut("ws.put", "PUT", "/ws", s.tokenID, s.ip, payload, status)
+	if err != nil {
+		return s.writeErr(id, status, err.Error())
+	}
+	return s.write(wsOpOK, id, nil)
+}
+
+// Puts a dat, which needs write scope unless it is signed by a key
+// registered with the bridge. Returns the status to reply with, and the
+// error if the put was refused.
+func (s *wsSession) putDat(payload []byte) (int, error) {
+	if s.svc.readOnly {
+		return http.StatusForbidden, errors.New("node is in readonly mode")
+	}
+	d, n, err := dats.DecodeBinary(payload)
+	if err != nil || n != len(payload) {
+		return http.StatusBadRequest, dats.ErrMalformed
+	}
+	_, bridged := s.keys[string(d.PubKey)]
+	if !bridged && !wsPermits(s.scope, "write") {
+		return http.StatusForbidden, errors.New("token scope does not permit writes")
+	}
+	if err := dats.Verify(d); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := s.svc.schemas.Validate(d.Key, d.Val); err != nil {
+		return http.StatusUnprocessableEntity, err
+	}
+	if bridged {
+		if err := s.charge(int64(len(d.Key) + len(d.Val))); err != nil {
+			return http.StatusTooManyRequests, err
+		}
+	}
+	if s.tokenID != "" && s.svc.tenants != nil {
+		// Tokens of the config are not tenants, so have no limits
+		if tok := s.svc.tenants.Get(s.tokenID); tok != nil {
+			if err := s.svc.chargeTenant(tok, int64(len(d.Key)+len(d.Val))); err != nil {
+				return http.StatusTooManyRequests, err
+			}
+		}
+	}
+	if err := s.svc.put(d); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if bridged {
+		s.svc.bridgePuts.Inc()
+	}
+	return http.StatusOK, nil
+}
+
+func (s *wsSession) subscribe(id uint32, payload []byte) error {
+	if s.svc.events == nil {
+		return s.writeErr(id, http.StatusNotImplemented, "subscriptions are not enabled")
+	}
+	if _, ok := s.subs[id]; ok {
+		return s.writeErr(id, http.StatusBadRequest, "id is already subscribed")
+	}
+	if len(s.subs) >= wsMaxSubs {
+		return s.writeErr(id, http.StatusTooManyRequests, fmt.Sprintf("at most %d subscriptions per connection", wsMaxSubs))
+	}
+	filter, err := decodeBinaryFilter(payload)
+	if err != nil {
+		return s.writeErr(id, http.StatusBadRequest, err.Error())
+	}
+	s.subs[id] = s.forward(id, filter)
+	return s.write(wsOpOK, id, nil)
+}
+
+// Sends dats put through the node that match filter as EVENT frames with
+// id, until cancelled.
+func (s *wsSession) forward(id uint32, filter *dats.Filter) (cancel func()) {
+	ch, cancel := s.svc.events.SubscribeMatching(100, datEventMatcher(filter), events.DAT_PUT)
+	go func() {
+		for e := range ch {
+			de, ok := e.Data.(*events.DatEvent)
+			if !ok || de.Dat == nil {
+				continue
+			}
+			b, err := dats.AppendBinary(nil, de.Dat)
+			if err != nil {
+				continue
+			}
+			if s.write(wsOpEvent, id, b) != nil {
+				return
+			}
+		}
+	}()
+	return cancel
+}
+
+// Ends the subscription or registration with id.
+func (s *wsSession) unsubscribe(id uint32) {
+	cancel, ok := s.subs[id]
+	if !ok {
+		return
+	}
+	cancel()
+	delete(s.subs, id)
+	for key, keyID := range s.keys {
+		if keyID == id {
+			delete(s.keys, key)
+			s.svc.bridgeKeys.Add(-1)
+		}
+	}
+}
+
+// Ends the session's subscriptions and registrations.
+func (s *wsSession) close() {
+	for id := range s.subs {
+		s.unsubscribe(id)
+	}
+}
+
+func (s *wsSession) write(op byte, id uint32, payload []byte) error {
+	frame := make([]byte, 5, 5+len(payload))
+	frame[0] = op
+	binary.BigEndian.PutUint32(frame[1:], id)
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.conn.WriteMessage(websocket.BinaryMessage, append(frame, payload...))
+}
+
+func (s *wsSession) writeErr(id uint32, status int, msg string) error {
+	return s.write(wsOpErr, id, append(binary.BigEndian.AppendUint16(nil, uint16(status)), msg...))
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/intob/daved/dats"
+)
+
+// BridgeCfg lets clients of the binary protocol register ephemeral keys,
+// then put dats signed by them without write scope, as a relay for apps
+// that run entirely in the browser.
+type BridgeCfg struct {
+	MaxKeys  int     // Per connection
+	Rate     float64 // Puts per second per connection, zero is unlimited
+	Burst    int
+	MaxPuts  int64 // Per connection, zero is unlimited
+	MaxBytes int64 // Of keys and values per connection, zero is unlimited
+}
+
+// Prefix of the challenge signed to register a key, followed by the nonce
+// of the HELLO frame.
+const bridgeChallenge = "dave.v1 bridge "
+
+var errQuota = errors.New("quota exceeded")
+
+func (svc *Service) registerBridgeMetrics() {
+	svc.metrics.GaugeFunc("daved_bridge_keys", "Keys registered by bridge clients.", func() float64 {
+		return float64(svc.bridgeKeys.Load())
+	})
+	svc.bridgePuts = svc.metrics.Counter("daved_bridge_puts_total", "Dats relayed for bridge clients.")
+}
+
+// Sends the nonce a client signs to register keys.
+func (s *wsSession) hello() error {
+	if s.svc.ws.Bridge == nil {
+		return nil
+	}
+	if _, err := rand.Read(s.nonce[:]); err != nil {
+		return err
+	}
+	return s.write(wsOpHello, 0, s.nonce[:])
+}
+
+// Registers a key the client proved it holds by signing the challenge.
+// Dats put under it through the node, by this client or another, are sent
+// back as EVENT frames with the id, until UNSUB.
+func (s *wsSession) register(id uint32, payload []byte) error {
+	bridge := s.svc.ws.Bridge
+	if bridge == nil {
+		return s.writeErr(id, http.StatusNotImplemented, "bridge is not enabled")
+	}
+	if len(payload) != ed25519.PublicKeySize+ed25519.SignatureSize {
+		return s.writeErr(id, http.StatusBadRequest, "invalid register")
+	}
+	pubKey := ed25519.PublicKey(payload[:ed25519.PublicKeySize])
+	if !ed25519.Verify(pubKey, append([]byte(bridgeChallenge), s.nonce[:]...), payload[ed25519.PublicKeySize:]) {
+		return s.writeErr(id, http.StatusUnauthorized, "invalid signature")
+	}
+	if _, ok := s.keys[string(pubKey)]; ok {
+		return s.writeErr(id, http.StatusBadRequest, "key is already registered")
+	}
+	if _, ok := s.subs[id]; ok {
+		return s.writeErr(id, http.StatusBadRequest, "id is already subscribed")
+	}
+	if len(s.keys) >= bridge.MaxKeys {
+		return s.writeErr(id, http.StatusTooManyRequests, fmt.Sprintf("at most %d keys per connection", bridge.MaxKeys))
+	}
+	s.subs[id] = func() {}
+	if s.svc.events != nil {
+		s.subs[id] = s.forward(id, &dats.Filter{PubKey: append(ed25519.PublicKey(nil), pubKey...)})
+	}
+	s.keys[string(pubKey)] = id
+	s.svc.bridgeKeys.Add(1)
+	return s.write(wsOpOK, id, nil)
+}
+
+// Counts a put of size bytes against the connection's limits.
+func (s *wsSession) charge(size int64) error {
+	l := s.svc.ws.Bridge
+	if (l.MaxPuts > 0 && s.puts >= l.MaxPuts) || (l.MaxBytes > 0 && s.bytes+size > l.MaxBytes) {
+		return errQuota
+	}
+	if l.Rate > 0 {
+		burst := float64(max(l.Burst, 1))
+		now := time.Now()
+		if s.last.IsZero() {
+			s.tokens = burst
+		}
+		s.tokens = min(burst, s.tokens+now.Sub(s.last).Seconds()*l.Rate)
+		s.last = now
+		if s.tokens < 1 {
+			return errors.New("rate limit exceeded")
+		}
+		s.tokens--
+	}
+	s.puts++
+	s.bytes += size
+	return nil
+}
@@ -0,0 +1,99 @@
+// Package audit records the API calls that change the node, one JSON line
+// each, to a file that is only ever appended to. Payloads are not kept,
+// only their hash, so the log can be shared without the data it describes.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"` // e.g. ws.put or admin.loglevel
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	Identity string    `json:"identity,omitempty"` // Id of the caller's token, empty if it sent none
+	IP       string    `json:"ip"`
+	Payload  string    `json:"payload_sha256"` // Hex hash of the request body
+	Status   int       `json:"status"`
+}
+
+// Query selects entries. Zero fields select all.
+type Query struct {
+	Since    time.Time
+	Action   string
+	Identity string
+	Limit    int // Most recent entries returned
+}
+
+type Log struct {
+	mu       sync.Mutex
+	filename string
+	f        *os.File
+}
+
+// Open opens the log for appending, creating it if needed.
+func Open(filename string) (*Log, error) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{filename: filename, f: f}, nil
+}
+
+// Append writes an entry, synced to disk before returning.
+func (l *Log) Append(e *Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+// Read returns the entries matching q, oldest first. Lines that fail to
+// decode, such as one cut short by a crash, are skipped.
+func (l *Log) Read(q Query) ([]Entry, error) {
+	l.mu.Lock() // Not reading a line half written
+	defer l.mu.Unlock()
+	f, err := os.Open(l.filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	entries := make([]Entry, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		if e.Time.Before(q.Since) ||
+			(q.Action != "" && e.Action != q.Action) ||
+			(q.Identity != "" && e.Identity != q.Identity) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[len(entries)-q.Limit:]
+	}
+	return entries, nil
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/intob/daved/dats"
+	"github.com/intob/daved/errs"
+	"github.com/intob/daved/schema"
+	"github.com/intob/daved/signer"
+	"github.com/intob/godave"
+	"github.com/intob/godave/dat"
+)
+
+type bundleResult struct {
+	Filename string `json:"filename"`
+	Dats     int64  `json:"dats"`
+	Invalid  int    `json:"invalid,omitempty"`
+	TookMs   int64  `json:"took_ms"`
+}
+
+// Signs and does work for each record of an import file, writing the dats
+// to a bundle file. Needs no network, so it can run on an air-gapped
+// machine holding the key.
+func bundleCreate(in, out string, s signer.Signer, schemas *schema.Set, opt *cmdOptions) {
+	records, failures, err := readImportFile(in)
+	if err != nil {
+		exit(errs.Usage, "failed to read input file: %s", err)
+	}
+	records, failures = validateRecords(records, failures, schemas)
+	if len(failures) > 0 {
+		exit(errs.Usage, "line %d: %s", failures[0].Line, failures[0].Err)
+	}
+	f, err := dats.Create(out)
+	if err != nil {
+		exit(errs.General, "failed to create bundle: %s", err)
+	}
+	w := dats.NewWriter(f)
+	pubKey := s.PublicKey()
+	cache := openWorkCache(opt)
+	work := make(chan importRecord, runtime.NumCPU())
+	done := make(chan dat.Dat, runtime.NumCPU())
+	wg := sync.WaitGroup{}
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rec := range work {
+				new := dat.Dat{Key: rec.Key, Val: []byte(rec.Val), Time: datTime(opt), PubKey: pubKey}
+				if err := s.Sign(&new); err != nil {
+					exit(errs.Key, "failed to sign %s: %s", rec.Key, err)
+				}
+				var err error
+				new.Work, new.Salt, err = cache.DoWork(new.Sig, opt.Difficulty)
+				if err != nil {
+					exit(errs.General, "failed to cache work: %s", err)
+				}
+				done <- new
+			}
+		}()
+	}
+	go func() {
+		for _, rec := range records {
+			work <- rec
+		}
+		close(work)
+		wg.Wait()
+		close(done)
+	}()
+	start := time.Now()
+	var n int64
+	for d := range done {
+		if err := w.Write(&d); err != nil {
+			exit(errs.General, "failed to write bundle: %s", err)
+		}
+		n++
+		printProgress(int(n), len(records))
+	}
+	fmt.Fprintln(humanOut())
+	if err := f.Close(); err != nil {
+		exit(errs.General, "failed to write bundle: %s", err)
+	}
+	took := time.Since(start)
+	result(&bundleResult{Filename: out, Dats: n, TookMs: took.Milliseconds()},
+		"wrote %d dats to %s in %s", n, out, took)
+}
+
+// Broadcasts the pre-made dats of a bundle. Dats failing verification are
+// skipped.
+func bundleSend(d *godave.Dave, filename string, opt *cmdOptions) {
+	f, err := dats.Open(filename)
+	if err != nil {
+		exit(errs.Usage, "failed to open bundle: %s", err)
+	}
+	defer f.Close()
+	byPubKey := make(map[string][]*dat.Dat)
+	r := dats.NewReader(f)
+	invalid := 0
+	for {
+		dt, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			exit(errs.Usage, "failed to read bundle: %s", err)
+		}
+		if err := dats.Verify(dt); err != nil {
+			info("skipping %s: %s", dt.Key, err)
+			invalid++
+			continue
+		}
+		byPubKey[string(dt.PubKey)] = append(byPubKey[string(dt.PubKey)], dt)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	info("waiting for %d peers...", opt.PeerCount)
+	d.WaitForActivePeers(ctx, opt.PeerCount)
+	start := time.Now()
+	var total int64
+	for _, list := range byPubKey {
+		p, err := newSignedSendPipeline(ctx, d, list[0].PubKey, opt)
+		if err != nil {
+			exit(errs.General, "%s", err)
+		}
+		for _, dt := range list {
+			if p.Submit(dt) != nil {
+				break // reported by Close
+			}
+		}
+		sent, err := p.Close(opt.Timeout)
+		total += sent
+		if err != nil {
+			exit(errs.General, "send failed after %d dats: %s", total, err)
+		}
+	}
+	took := time.Since(start)
+	result(&bundleResult{Filename: filename, Dats: total, Invalid: invalid, TookMs: took.Milliseconds()},
+		"sent %d dats in %s, %d invalid", total, took, invalid)
+	if invalid > 0 {
+		exit(errs.Verification, "%d dats failed verification", invalid)
+	}
+}
@@ -0,0 +1,56 @@
+package cfg
+
+import (
+	"fmt"
+	"time"
+)
+
+// Alerts holds thresholds checked by the node. Zero values disable the
+// corresponding alert.
+type Alerts struct {
+	MinPeers       int
+	MaxUsedPercent float64
+	MaxBackupAge   time.Duration
+	Interval       time.Duration
+}
+
+type AlertsUnparsed struct {
+	MinPeers       *int     `yaml:"min_peers"`
+	MaxUsedPercent *float64 `yaml:"max_used_percent"`
+	MaxBackupAge   *string  `yaml:"max_backup_age"`
+	Interval       *string  `yaml:"interval"` // Default 30s
+}
+
+func mergeAlerts(dst, src AlertsUnparsed) AlertsUnparsed {
+	dst.MinPeers = mergeValue(dst.MinPeers, src.MinPeers)
+	dst.MaxUsedPercent = mergeValue(dst.MaxUsedPercent, src.MaxUsedPercent)
+	dst.MaxBackupAge = mergeValue(dst.MaxBackupAge, src.MaxBackupAge)
+	dst.Interval = mergeValue(dst.Interval, src.Interval)
+	return dst
+}
+
+func parseAlerts(unparsed *AlertsUnparsed) (*Alerts, error) {
+	a := &Alerts{
+		MinPeers:       val(unparsed.MinPeers),
+		MaxUsedPercent: val(unparsed.MaxUsedPercent),
+		Interval:       30 * time.Second,
+	}
+	if a.MinPeers < 0 {
+		return nil, fmt.Errorf("min_peers must not be negative, got %d", a.MinPeers)
+	}
+	if a.MaxUsedPercent < 0 || a.MaxUsedPercent > 100 {
+		return nil, fmt.Errorf("max_used_percent must be between 0 and 100, got %v", a.MaxUsedPercent)
+	}
+	var err error
+	a.MaxBackupAge, err = parseDurationInRange("max_backup_age", val(unparsed.MaxBackupAge), time.Minute, 30*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if interval := val(unparsed.Interval); interval != "" {
+		a.Interval, err = parseDurationInRange("alerts interval", interval, time.Second, time.Hour)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
@@ -0,0 +1,65 @@
+package cfg
+
+import "fmt"
+
+// Bridge lets websocket clients register ephemeral keys and put dats signed
+// by them, within limits per connection, as a relay for browser apps.
+type Bridge struct {
+	MaxKeys  int     // Per connection
+	Rate     float64 // Puts per second, zero is unlimited
+	Burst    int
+	MaxPuts  int64 // Zero is unlimited
+	MaxBytes int64 // Zero is unlimited
+}
+
+type BridgeUnparsed struct {
+	Enabled  string   `yaml:"enabled"`   // Default false
+	MaxKeys  *int     `yaml:"max_keys"`  // Default 4
+	Rate     *float64 `yaml:"rate"`      // Default 1
+	Burst    *int     `yaml:"burst"`     // Default 10
+	MaxPuts  *int64   `yaml:"max_puts"`  // Default 1000
+	MaxBytes *Size    `yaml:"max_bytes"` // Default 1MiB
+}
+
+var defaultBridgeUnparsed = BridgeUnparsed{
+	Enabled:  "false",
+	MaxKeys:  Ptr(4),
+	Rate:     Ptr(1.0),
+	Burst:    Ptr(10),
+	MaxPuts:  Ptr[int64](1000),
+	MaxBytes: Ptr[Size](1024 * 1024), // 1MiB
+}
+
+func mergeBridge(dst, src BridgeUnparsed) BridgeUnparsed {
+	if src.Enabled != "" {
+		dst.Enabled = src.Enabled
+	}
+	dst.MaxKeys = mergeValue(dst.MaxKeys, src.MaxKeys)
+	dst.Rate = mergeValue(dst.Rate, src.Rate)
+	dst.Burst = mergeValue(dst.Burst, src.Burst)
+	dst.MaxPuts = mergeValue(dst.MaxPuts, src.MaxPuts)
+	dst.MaxBytes = mergeValue(dst.MaxBytes, src.MaxBytes)
+	return dst
+}
+
+// Returns nil if the bridge is disabled.
+func parseBridge(unparsed *BridgeUnparsed) (*Bridge, error) {
+	enabled, err := parseBool("bridge.enabled", unparsed.Enabled)
+	if err != nil || !enabled {
+		return nil, err
+	}
+	b := &Bridge{
+		MaxKeys:  val(unparsed.MaxKeys),
+		Rate:     val(unparsed.Rate),
+		Burst:    val(unparsed.Burst),
+		MaxPuts:  val(unparsed.MaxPuts),
+		MaxBytes: int64(val(unparsed.MaxBytes)),
+	}
+	if b.MaxKeys < 1 || b.MaxKeys > 64 {
+		return nil, fmt.Errorf("bridge.max_keys must be between 1 and 64, got %d", b.MaxKeys)
+	}
+	if b.Rate < 0 || b.Burst < 0 || b.MaxPuts < 0 || b.MaxBytes < 0 {
+		return nil, fmt.Errorf("bridge limits must not be negative")
+	}
+	return b, nil
+}
@@ -1,86 +1,392 @@
 package cfg
 
 import (
+	"bytes"
 	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"net"
+	"net/http"
 	"net/netip"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/intob/godave/logger"
+	"github.com/intob/daved/loglevel"
+	"github.com/intob/daved/memlimit"
 	"gopkg.in/yaml.v3"
 )
 
 const DEFAULT_KEY_FILENAME = "key.dave"
 
+// Node modes
+const (
+	MODE_NORMAL   = "normal"
+	MODE_READONLY = "readonly" // Replicates & serves data, rejects local writes
+	MODE_EDGE     = "edge"     // Bootstrap entry point, stores minimal data
+)
+
+// Address family preferences, for hostnames that resolve to both
+const (
+	PREFER_IPV4 = "ipv4" // IPv4 addresses first
+	PREFER_IPV6 = "ipv6" // IPv6 addresses first
+	PREFER_BOTH = "both" // In the order resolved
+)
+
+// Edge mode default, used unless set explicitly
+const EDGE_SHARD_CAPACITY = 256 * 1024 // 64MB total
+
+// Bounds of shard_capacity, in bytes per shard. There are 256 shards.
+const (
+	MIN_SHARD_CAPACITY = 64 * 1024               // 16MB total
+	MAX_SHARD_CAPACITY = 16 * 1024 * 1024 * 1024 // 4TB total
+)
+
 var defaultCfgUnparsed = NodeCfgUnparsed{
-	KeyFilename:   DEFAULT_KEY_FILENAME,
-	UdpListenAddr: "[::]:127",
-	ShardCapacity: 1024 * 1024 * 1024, // 1GB
-	LogLevel:      "ERROR",
+	KeyFilename:         Ptr(DEFAULT_KEY_FILENAME),
+	UdpListenAddr:       Ptr("[::]:127"),
+	ApiListenAddr:       Ptr("127.0.0.1:8080"),
+	ShardCapacity:       Ptr[Size](1024 * 1024 * 1024), // 1GiB
+	LogLevel:            Ptr("ERROR"),
+	Mode:                Ptr(MODE_NORMAL),
+	Prefer:              Ptr(PREFER_IPV4),
+	HistoryDepth:        Ptr(10),
+	LinkDepth:           Ptr(8),
+	CapacityThreshold:   Ptr(0.9),
+	MetricsPushInterval: Ptr("10s"),
+	StatusHistory:       Ptr("24h"),
+	ReadCacheSize:       Ptr[Size](32 * 1024 * 1024), // 32MiB
+	ReadCacheTTL:        Ptr("1m"),
+	Websocket:           defaultWebsocketUnparsed,
+	Watchdog:            defaultWatchdogUnparsed,
+	Clock:               defaultClockUnparsed,
 }
 
 type NodeCfg struct {
-	KeyFilename    string
-	UdpListenAddr  *net.UDPAddr
-	Edges          []netip.AddrPort
-	BackupFilename string
-	ShardCapacity  int64
-	TTL            time.Duration
-	LogLevel       logger.LogLevel
-	LogUnbuffered  bool
+	KeyFilename         string
+	UdpListenAddr       *net.UDPAddr
+	ApiListenAddr       string
+	RespListenAddr      string           // Empty disables
+	GRPCListenAddr      string           // Empty disables
+	Edges               []netip.AddrPort // Every address of each edge
+	Prefer              string
+	BackupFilename      string
+	AuditFilename       string
+	TokensFilename      string
+	ShardCapacity       int64
+	TTL                 time.Duration
+	LogLevel            loglevel.Level
+	LogLevels           map[string]loglevel.Level // By subsystem
+	LogUnbuffered       bool
+	HistoryPubKeys      []ed25519.PublicKey
+	HistoryDepth        int
+	HistoryFilename     string
+	LinkDepth           int // Links followed from a dat, at most
+	Mode                string
+	Webhooks            []Webhook
+	Hooks               []Hook
+	Schemas             []Schema // Applied to values put through the node
+	CapacityThreshold   float64
+	MetricsSink         *MetricsSink
+	MetricsPushInterval time.Duration
+	OtlpEndpoint        string
+	ApiDebug            bool
+	ApiAccessLog        bool
+	ApiGraphQL          bool
+	ApiGateway          bool
+	ApiUploads          bool
+	ApiProxyHeader      string
+	ApiTrustedProxies   []netip.Prefix // Whose proxy header is believed, loopback if empty
+	ApiAllowedCidrs     []netip.Prefix
+	ApiDeniedCidrs      []netip.Prefix
+	StatusHistory       time.Duration
+	Alerts              *Alerts
+	Websocket           *Websocket
+	ApiTLS              *ApiTLS // Nil serves plain HTTP
+	RemoteSigner        *RemoteSigner
+	WorkPool            *WorkPool
+	Watchdog            *Watchdog
+	Clock               *Clock
+	DNS                 *DNS  // Nil if disabled
+	ReadCacheSize       int64 // Zero disables
+	MaxMemory           int64 // From max_memory or the cgroup limit, zero if unlimited
+	ReadCacheTTL        time.Duration
+	CrashDir            string
 }
 
 type NodeCfgUnparsed struct {
-	KeyFilename    string   `yaml:"key_filename"`
-	UdpListenAddr  string   `yaml:"udp_listen_addr"`
-	Edges          []string `yaml:"edges"`
-	BackupFilename string   `yaml:"backup_filename"`
-	ShardCapacity  int64    `yaml:"shard_capacity"`
-	LogLevel       string   `yaml:"log_level"`
-	LogUnbuffered  string   `yaml:"log_unbuffered"`
+	KeyFilename         *string               `yaml:"key_filename"`
+	UdpListenAddr       *string               `yaml:"udp_listen_addr"`
+	ApiListenAddr       *string               `yaml:"api_listen_addr"`
+	RespListenAddr      *string               `yaml:"resp_listen_addr"` // Redis protocol, unset disables
+	GRPCListenAddr      *string               `yaml:"grpc_listen_addr"` // Needs the grpc build tag, unset disables
+	Edges               List[string]          `yaml:"edges"`
+	Prefer              *string               `yaml:"prefer"` // Address family of hostnames
+	BackupFilename      *string               `yaml:"backup_filename"`
+	AuditFilename       *string               `yaml:"audit_filename"`  // Records mutating API calls, set to enable
+	TokensFilename      *string               `yaml:"tokens_filename"` // Tenant tokens, set to require tokens for the API
+	ShardCapacity       *Size                 `yaml:"shard_capacity"`
+	LogLevel            *string               `yaml:"log_level"`
+	LogLevels           map[string]string     `yaml:"log_levels"` // By subsystem, e.g. api: DEBUG
+	LogUnbuffered       *string               `yaml:"log_unbuffered"`
+	HistoryPubKeys      List[string]          `yaml:"history_pubkeys"`
+	HistoryDepth        *int                  `yaml:"history_depth"`
+	HistoryFilename     *string               `yaml:"history_filename"`
+	LinkDepth           *int                  `yaml:"link_depth"`
+	Mode                *string               `yaml:"mode"`
+	Webhooks            List[WebhookUnparsed] `yaml:"webhooks"`
+	Hooks               List[HookUnparsed]    `yaml:"hooks"`
+	Schemas             List[SchemaUnparsed]  `yaml:"schemas"`
+	CapacityThreshold   *float64              `yaml:"capacity_threshold"`
+	MetricsSink         *string               `yaml:"metrics_sink"`
+	MetricsPushInterval *string               `yaml:"metrics_push_interval"`
+	OtlpEndpoint        *string               `yaml:"otlp_endpoint"`
+	ApiDebug            *string               `yaml:"api_debug"`
+	ApiAccessLog        *string               `yaml:"api_access_log"`
+	ApiGraphQL          *string               `yaml:"api_graphql"`
+	ApiGateway          *string               `yaml:"api_gateway"`
+	ApiUploads          *string               `yaml:"api_uploads"`
+	ApiProxyHeader      *string               `yaml:"api_trusted_proxy_header"`
+	ApiTrustedProxies   List[string]          `yaml:"api_trusted_proxies"`
+	ApiAllowedCidrs     List[string]          `yaml:"api_allowed_cidrs"`
+	ApiDeniedCidrs      List[string]          `yaml:"api_denied_cidrs"`
+	StatusHistory       *string               `yaml:"status_history"`
+	Alerts              AlertsUnparsed        `yaml:"alerts"`
+	Websocket           WebsocketUnparsed     `yaml:"websocket"`
+	ApiTLS              ApiTLSUnparsed        `yaml:"api_tls"`
+	RemoteSigner        RemoteSignerUnparsed  `yaml:"remote_signer"`
+	WorkPool            WorkPoolUnparsed      `yaml:"work_pool"`
+	Watchdog            WatchdogUnparsed      `yaml:"watchdog"`
+	Clock               ClockUnparsed         `yaml:"clock"`
+	DNS                 DNSUnparsed           `yaml:"dns"`
+	ReadCacheSize       *Size                 `yaml:"read_cache_size"` // Zero disables
+	MaxMemory           *Size                 `yaml:"max_memory"`      // Unset detects the cgroup limit, zero is unlimited
+	ReadCacheTTL        *string               `yaml:"read_cache_ttl"`
+	CrashDir            *string               `yaml:"crash_dir"` // Default os.TempDir()
+
+	// Named sets of settings merged onto the above, selected with -profile
+	Profiles map[string]NodeCfgUnparsed `yaml:"profiles"`
+	// Files, globs or directories merged over this file in order, resolved
+	// relative to it. Directories contribute their config files in lexical
+	// order, like a conf.d.
+	Include []string `yaml:"include"`
 }
 
+// ReadNodeCfgFile reads a YAML config file, or TOML or JSON for files
+// ending in .toml or .json. Keys are the same in every format. Keys
+// matching no setting are rejected, with the nearest known key suggested.
 func ReadNodeCfgFile(filename string) (*NodeCfgUnparsed, error) {
-	file, err := os.Open(filename)
+	return decodeNodeCfgFile(filename, true)
+}
+
+// With strict set, keys matching no setting are rejected.
+func decodeNodeCfgFile(filename string, strict bool) (*NodeCfgUnparsed, error) {
+	return decodeWithIncludes(filename, strict, make(map[string]bool))
+}
+
+// Decodes filename, then merges its includes over it. Files being decoded
+// are in reading, to detect cycles.
+func decodeWithIncludes(filename string, strict bool, reading map[string]bool) (*NodeCfgUnparsed, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	if reading[abs] {
+		return nil, fmt.Errorf("%s includes itself", filename)
+	}
+	reading[abs] = true
+	defer delete(reading, abs)
+	cfg, err := decodeOne(filename, strict)
+	if err != nil {
+		return nil, err
+	}
+	includes := cfg.Include
+	cfg.Include = nil
+	for _, inc := range includes {
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(filepath.Dir(filename), inc)
+		}
+		files, err := includedFiles(inc)
+		if err != nil {
+			return nil, fmt.Errorf("include %s: %w", inc, err)
+		}
+		for _, f := range files {
+			incCfg, err := decodeWithIncludes(f, strict, reading)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f, err)
+			}
+			cfg = MergeConfigs(*cfg, *incCfg)
+		}
+	}
+	return cfg, nil
+}
+
+// Expands an include to files in lexical order. A glob may match nothing,
+// a plain path must exist.
+func includedFiles(inc string) ([]string, error) {
+	if strings.ContainsAny(inc, "*?[") {
+		return filepath.Glob(inc)
+	}
+	fi, err := os.Stat(inc)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{inc}, nil
+	}
+	entries, err := os.ReadDir(inc) // Sorted by name
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(entries))
+	for _, e := range entries {
+		switch strings.ToLower(filepath.Ext(e.Name())) {
+		case ".yaml", ".yml", ".toml", ".json":
+			if !e.IsDir() {
+				files = append(files, filepath.Join(inc, e.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+func decodeOne(filename string, strict bool) (*NodeCfgUnparsed, error) {
+	b, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %s", err)
 	}
-	dec := yaml.NewDecoder(file)
+	format := "yaml"
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".toml":
+		format = "toml"
+		m, err := parseTOML(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode toml: %s", err)
+		}
+		b, err = yaml.Marshal(m)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode toml: %s", err)
+		}
+	case ".json":
+		// JSON is a subset of YAML, so the yaml decoder reads it as is
+		format = "json"
+		if !json.Valid(b) {
+			return nil, fmt.Errorf("failed to decode json: invalid syntax")
+		}
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(strict)
 	cfg := &NodeCfgUnparsed{}
 	err = dec.Decode(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode yaml: %s", err)
+		var te *yaml.TypeError
+		if strict && errors.As(err, &te) {
+			return nil, explainUnknownKeys(err, format != "toml")
+		}
+		return nil, fmt.Errorf("failed to decode %s: %s", format, err)
 	}
 	return cfg, nil
 }
 
-// Merges src with dst.
-// If a field in src is omitted, the value in dst is left unchanged.
-func MergeConfigs(dst, src NodeCfgUnparsed) *NodeCfgUnparsed {
-	if src.KeyFilename != "" {
-		dst.KeyFilename = src.KeyFilename
-	}
-	if src.UdpListenAddr != "" {
-		dst.UdpListenAddr = src.UdpListenAddr
+// SelectProfile merges the named profile onto the top-level settings of a
+// config file. With no name, the top-level settings are used as they are.
+func SelectProfile(file *NodeCfgUnparsed, name string) (*NodeCfgUnparsed, error) {
+	profiles := file.Profiles
+	base := *file
+	base.Profiles = nil
+	if name == "" {
+		return &base, nil
 	}
-	if len(src.Edges) > 0 {
-		dst.Edges = append(dst.Edges, src.Edges...)
-	}
-	if src.BackupFilename != "" {
-		dst.BackupFilename = src.BackupFilename
+	profile, ok := profiles[name]
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for n := range profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown profile %q, the file has %v", name, names)
 	}
-	if src.ShardCapacity != 0 {
-		dst.ShardCapacity = src.ShardCapacity
+	if len(profile.Profiles) > 0 {
+		return nil, fmt.Errorf("profile %q must not contain profiles", name)
 	}
-	if src.LogLevel != "" {
-		dst.LogLevel = src.LogLevel
+	return MergeConfigs(base, profile), nil
+}
+
+// Merges src with dst.
+// If a field in src is omitted, the value in dst is left unchanged. Lists in
+// src replace those in dst, unless src appends.
+func MergeConfigs(dst, src NodeCfgUnparsed) *NodeCfgUnparsed {
+	dst.KeyFilename = mergeValue(dst.KeyFilename, src.KeyFilename)
+	dst.UdpListenAddr = mergeValue(dst.UdpListenAddr, src.UdpListenAddr)
+	dst.ApiListenAddr = mergeValue(dst.ApiListenAddr, src.ApiListenAddr)
+	dst.RespListenAddr = mergeValue(dst.RespListenAddr, src.RespListenAddr)
+	dst.GRPCListenAddr = mergeValue(dst.GRPCListenAddr, src.GRPCListenAddr)
+	dst.Edges = mergeList(dst.Edges, src.Edges)
+	dst.Prefer = mergeValue(dst.Prefer, src.Prefer)
+	dst.BackupFilename = mergeValue(dst.BackupFilename, src.BackupFilename)
+	dst.AuditFilename = mergeValue(dst.AuditFilename, src.AuditFilename)
+	dst.TokensFilename = mergeValue(dst.TokensFilename, src.TokensFilename)
+	dst.ShardCapacity = mergeValue(dst.ShardCapacity, src.ShardCapacity)
+	dst.LogLevel = mergeValue(dst.LogLevel, src.LogLevel)
+	if len(src.LogLevels) > 0 {
+		levels := maps.Clone(dst.LogLevels)
+		if levels == nil {
+			levels = make(map[string]string, len(src.LogLevels))
+		}
+		maps.Copy(levels, src.LogLevels)
+		dst.LogLevels = levels
 	}
-	if src.LogUnbuffered != "" {
-		dst.LogUnbuffered = src.LogUnbuffered
+	dst.LogUnbuffered = mergeValue(dst.LogUnbuffered, src.LogUnbuffered)
+	dst.HistoryPubKeys = mergeList(dst.HistoryPubKeys, src.HistoryPubKeys)
+	dst.HistoryDepth = mergeValue(dst.HistoryDepth, src.HistoryDepth)
+	dst.HistoryFilename = mergeValue(dst.HistoryFilename, src.HistoryFilename)
+	dst.LinkDepth = mergeValue(dst.LinkDepth, src.LinkDepth)
+	dst.Mode = mergeValue(dst.Mode, src.Mode)
+	dst.Webhooks = mergeList(dst.Webhooks, src.Webhooks)
+	dst.Hooks = mergeList(dst.Hooks, src.Hooks)
+	dst.Schemas = mergeList(dst.Schemas, src.Schemas)
+	dst.CapacityThreshold = mergeValue(dst.CapacityThreshold, src.CapacityThreshold)
+	dst.MetricsSink = mergeValue(dst.MetricsSink, src.MetricsSink)
+	dst.MetricsPushInterval = mergeValue(dst.MetricsPushInterval, src.MetricsPushInterval)
+	dst.OtlpEndpoint = mergeValue(dst.OtlpEndpoint, src.OtlpEndpoint)
+	dst.ApiDebug = mergeValue(dst.ApiDebug, src.ApiDebug)
+	dst.ApiAccessLog = mergeValue(dst.ApiAccessLog, src.ApiAccessLog)
+	dst.ApiGraphQL = mergeValue(dst.ApiGraphQL, src.ApiGraphQL)
+	dst.ApiGateway = mergeValue(dst.ApiGateway, src.ApiGateway)
+	dst.ApiUploads = mergeValue(dst.ApiUploads, src.ApiUploads)
+	dst.ApiProxyHeader = mergeValue(dst.ApiProxyHeader, src.ApiProxyHeader)
+	dst.ApiTrustedProxies = mergeList(dst.ApiTrustedProxies, src.ApiTrustedProxies)
+	dst.ApiAllowedCidrs = mergeList(dst.ApiAllowedCidrs, src.ApiAllowedCidrs)
+	dst.ApiDeniedCidrs = mergeList(dst.ApiDeniedCidrs, src.ApiDeniedCidrs)
+	dst.StatusHistory = mergeValue(dst.StatusHistory, src.StatusHistory)
+	dst.Alerts = mergeAlerts(dst.Alerts, src.Alerts)
+	dst.Websocket = mergeWebsocket(dst.Websocket, src.Websocket)
+	dst.ApiTLS = mergeApiTLS(dst.ApiTLS, src.ApiTLS)
+	dst.RemoteSigner = mergeRemoteSigner(dst.RemoteSigner, src.RemoteSigner)
+	dst.WorkPool = mergeWorkPool(dst.WorkPool, src.WorkPool)
+	dst.Watchdog = mergeWatchdog(dst.Watchdog, src.Watchdog)
+	dst.Clock = mergeClock(dst.Clock, src.Clock)
+	dst.DNS = mergeDNS(dst.DNS, src.DNS)
+	dst.ReadCacheSize = mergeValue(dst.ReadCacheSize, src.ReadCacheSize)
+	dst.MaxMemory = mergeValue(dst.MaxMemory, src.MaxMemory)
+	dst.ReadCacheTTL = mergeValue(dst.ReadCacheTTL, src.ReadCacheTTL)
+	dst.CrashDir = mergeValue(dst.CrashDir, src.CrashDir)
+	if len(src.Profiles) > 0 {
+		profiles := make(map[string]NodeCfgUnparsed, len(dst.Profiles)+len(src.Profiles))
+		for name, p := range dst.Profiles {
+			profiles[name] = p
+		}
+		for name, p := range src.Profiles {
+			profiles[name] = *MergeConfigs(profiles[name], p)
+		}
+		dst.Profiles = profiles
 	}
 	return &dst
 }
@@ -88,39 +394,245 @@ func MergeConfigs(dst, src NodeCfgUnparsed) *NodeCfgUnparsed {
 func ParseNodeCfg(unparsed *NodeCfgUnparsed) (*NodeCfg, error) {
 	withDefaults := MergeConfigs(defaultCfgUnparsed, *unparsed)
 	cfg := &NodeCfg{
-		KeyFilename:    withDefaults.KeyFilename,
-		BackupFilename: withDefaults.BackupFilename,
-		ShardCapacity:  withDefaults.ShardCapacity,
+		KeyFilename:     val(withDefaults.KeyFilename),
+		ApiListenAddr:   val(withDefaults.ApiListenAddr),
+		RespListenAddr:  val(withDefaults.RespListenAddr),
+		GRPCListenAddr:  val(withDefaults.GRPCListenAddr),
+		BackupFilename:  val(withDefaults.BackupFilename),
+		AuditFilename:   val(withDefaults.AuditFilename),
+		HistoryFilename: val(withDefaults.HistoryFilename),
+		TokensFilename:  val(withDefaults.TokensFilename),
+		ShardCapacity:   int64(val(withDefaults.ShardCapacity)),
 	}
 	var err error
-	cfg.UdpListenAddr, err = net.ResolveUDPAddr("udp", withDefaults.UdpListenAddr)
+	cfg.UdpListenAddr, err = net.ResolveUDPAddr("udp", val(withDefaults.UdpListenAddr))
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve UDP listen address: %s", err)
 	}
+	switch strings.ToLower(val(withDefaults.Prefer)) {
+	case PREFER_IPV4, PREFER_IPV6, PREFER_BOTH:
+		cfg.Prefer = strings.ToLower(val(withDefaults.Prefer))
+	default:
+		return nil, fmt.Errorf("invalid prefer %q, expected %s, %s or %s", val(withDefaults.Prefer), PREFER_IPV4, PREFER_IPV6, PREFER_BOTH)
+	}
 	cfg.Edges = make([]netip.AddrPort, 0)
-	for _, e := range withDefaults.Edges {
+	for _, e := range withDefaults.Edges.Items {
 		if e == "" {
 			continue
 		}
-		addrs, err := parseAddrPortOrHostname(e)
+		addrs, err := parseAddrPortOrHostname(e, cfg.Prefer)
 		if err != nil {
 			return nil, fmt.Errorf("failed to resolve address or hostname: %s", err)
 		}
 		cfg.Edges = append(cfg.Edges, addrs...)
 	}
 
-	if strings.ToUpper(withDefaults.LogLevel) == "DEBUG" {
-		cfg.LogLevel = logger.DEBUG
-	} else {
-		cfg.LogLevel = logger.ERROR
+	cfg.LogLevel, err = loglevel.Parse(val(withDefaults.LogLevel))
+	if err != nil {
+		return nil, fmt.Errorf("log_level: %w", err)
 	}
-	if withDefaults.LogUnbuffered != "" {
+	cfg.LogLevels = make(map[string]loglevel.Level, len(withDefaults.LogLevels))
+	for name, level := range withDefaults.LogLevels {
+		cfg.LogLevels[strings.TrimPrefix(name, "/")], err = loglevel.Parse(level)
+		if err != nil {
+			return nil, fmt.Errorf("log_levels %s: %w", name, err)
+		}
+	}
+	if val(withDefaults.LogUnbuffered) != "" {
 		cfg.LogUnbuffered = true
 	}
+	cfg.HistoryPubKeys, err = parsePubKeys(withDefaults.HistoryPubKeys.Items)
+	if err != nil {
+		return nil, fmt.Errorf("invalid history_pubkeys: %w", err)
+	}
+	switch strings.ToLower(val(withDefaults.Mode)) {
+	case MODE_NORMAL, MODE_READONLY, MODE_EDGE:
+		cfg.Mode = strings.ToLower(val(withDefaults.Mode))
+	default:
+		return nil, fmt.Errorf("invalid mode %q, expected %s, %s or %s", val(withDefaults.Mode), MODE_NORMAL, MODE_READONLY, MODE_EDGE)
+	}
+	if cfg.Mode == MODE_EDGE && unparsed.ShardCapacity == nil {
+		cfg.ShardCapacity = EDGE_SHARD_CAPACITY
+	}
+	if cfg.ShardCapacity < MIN_SHARD_CAPACITY || cfg.ShardCapacity > MAX_SHARD_CAPACITY {
+		return nil, fmt.Errorf("shard_capacity must be between %s and %s per shard, got %s; total storage is 256 times this", Size(MIN_SHARD_CAPACITY), Size(MAX_SHARD_CAPACITY), Size(cfg.ShardCapacity))
+	}
+	cfg.Webhooks, err = parseWebhooks(withDefaults.Webhooks.Items)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Hooks, err = parseHooks(withDefaults.Hooks.Items)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Schemas, err = parseSchemas(withDefaults.Schemas.Items)
+	if err != nil {
+		return nil, err
+	}
+	if val(withDefaults.CapacityThreshold) <= 0 || val(withDefaults.CapacityThreshold) > 1 {
+		return nil, fmt.Errorf("capacity threshold must be in (0, 1], got %v", val(withDefaults.CapacityThreshold))
+	}
+	cfg.CapacityThreshold = val(withDefaults.CapacityThreshold)
+	cfg.MetricsSink, err = parseMetricsSink(val(withDefaults.MetricsSink))
+	if err != nil {
+		return nil, err
+	}
+	cfg.MetricsPushInterval, err = parseDurationInRange("metrics_push_interval", val(withDefaults.MetricsPushInterval), time.Second, time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if val(withDefaults.OtlpEndpoint) != "" {
+		u, err := url.Parse(val(withDefaults.OtlpEndpoint))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid otlp_endpoint %q, expected e.g. http://localhost:4318", val(withDefaults.OtlpEndpoint))
+		}
+		cfg.OtlpEndpoint = val(withDefaults.OtlpEndpoint)
+	}
+	cfg.StatusHistory, err = parseDurationInRange("status_history", val(withDefaults.StatusHistory), time.Minute, 7*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Alerts, err = parseAlerts(&withDefaults.Alerts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid alerts: %w", err)
+	}
+	cfg.Websocket, err = parseWebsocket(&withDefaults.Websocket)
+	if err != nil {
+		return nil, fmt.Errorf("invalid websocket: %w", err)
+	}
+	cfg.ApiTLS, err = parseApiTLS(&withDefaults.ApiTLS)
+	if err != nil {
+		return nil, fmt.Errorf("invalid api_tls: %w", err)
+	}
+	cfg.RemoteSigner, err = parseRemoteSigner(&withDefaults.RemoteSigner)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote_signer: %w", err)
+	}
+	cfg.WorkPool, err = parseWorkPool(&withDefaults.WorkPool)
+	if err != nil {
+		return nil, fmt.Errorf("invalid work_pool: %w", err)
+	}
+	cfg.Watchdog, err = parseWatchdog(&withDefaults.Watchdog)
+	if err != nil {
+		return nil, fmt.Errorf("invalid watchdog: %w", err)
+	}
+	cfg.Clock, err = parseClock(&withDefaults.Clock)
+	if err != nil {
+		return nil, fmt.Errorf("invalid clock: %w", err)
+	}
+	cfg.DNS, err = parseDNS(&withDefaults.DNS)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dns: %w", err)
+	}
+	if val(withDefaults.ReadCacheSize) < 0 {
+		return nil, fmt.Errorf("read_cache_size must not be negative, got %s; set 0 to disable the read cache", val(withDefaults.ReadCacheSize))
+	}
+	cfg.ReadCacheSize = int64(val(withDefaults.ReadCacheSize))
+	cfg.ReadCacheTTL, err = parseDurationInRange("read_cache_ttl", val(withDefaults.ReadCacheTTL), time.Second, 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if withDefaults.MaxMemory == nil {
+		cfg.MaxMemory = memlimit.Detect()
+	} else if cfg.MaxMemory = int64(val(withDefaults.MaxMemory)); cfg.MaxMemory < 0 {
+		return nil, fmt.Errorf("max_memory must not be negative, got %s; set 0 for no limit", Size(cfg.MaxMemory))
+	}
+	if cfg.MaxMemory > 0 {
+		// Sizes given explicitly are kept, defaults are shrunk to fit
+		if unparsed.ShardCapacity == nil {
+			cfg.ShardCapacity = max(MIN_SHARD_CAPACITY, min(cfg.ShardCapacity, int64(memlimit.StoreShare*float64(cfg.MaxMemory))/256))
+		}
+		if unparsed.ReadCacheSize == nil {
+			cfg.ReadCacheSize = min(cfg.ReadCacheSize, int64(memlimit.ReadCacheShare*float64(cfg.MaxMemory)))
+		}
+	}
+	cfg.CrashDir = val(withDefaults.CrashDir)
+	cfg.ApiDebug, err = parseBool("api_debug", val(withDefaults.ApiDebug))
+	if err != nil {
+		return nil, err
+	}
+	cfg.ApiAccessLog, err = parseBool("api_access_log", val(withDefaults.ApiAccessLog))
+	if err != nil {
+		return nil, err
+	}
+	cfg.ApiGraphQL, err = parseBool("api_graphql", val(withDefaults.ApiGraphQL))
+	if err != nil {
+		return nil, err
+	}
+	cfg.ApiGateway, err = parseBool("api_gateway", val(withDefaults.ApiGateway))
+	if err != nil {
+		return nil, err
+	}
+	cfg.ApiUploads, err = parseBool("api_uploads", val(withDefaults.ApiUploads))
+	if err != nil {
+		return nil, err
+	}
+	cfg.ApiProxyHeader = http.CanonicalHeaderKey(val(withDefaults.ApiProxyHeader))
+	cfg.ApiTrustedProxies, err = parsePrefixes("api_trusted_proxies", withDefaults.ApiTrustedProxies.Items)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ApiAllowedCidrs, err = parsePrefixes("api_allowed_cidrs", withDefaults.ApiAllowedCidrs.Items)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ApiDeniedCidrs, err = parsePrefixes("api_denied_cidrs", withDefaults.ApiDeniedCidrs.Items)
+	if err != nil {
+		return nil, err
+	}
+	if val(withDefaults.HistoryDepth) < 1 {
+		return nil, fmt.Errorf("history depth must be at least 1, got %d", val(withDefaults.HistoryDepth))
+	}
+	cfg.HistoryDepth = val(withDefaults.HistoryDepth)
+	if val(withDefaults.LinkDepth) < 1 {
+		return nil, fmt.Errorf("link depth must be at least 1, got %d", val(withDefaults.LinkDepth))
+	}
+	cfg.LinkDepth = val(withDefaults.LinkDepth)
 	return cfg, nil
 }
 
-func parseAddrPortOrHostname(edge string) ([]netip.AddrPort, error) {
+func parseDurationInRange(name, value string, min, max time.Duration) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %s", name, err)
+	}
+	if d < min || d > max {
+		return 0, fmt.Errorf("%s must be between %s and %s, got %s", name, min, max, d)
+	}
+	return d, nil
+}
+
+func parseBool(name, value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "", "false", "0", "no":
+		return false, nil
+	case "true", "1", "yes":
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid %s %q, expected true or false", name, value)
+}
+
+func parsePubKeys(encoded []string) ([]ed25519.PublicKey, error) {
+	keys := make([]ed25519.PublicKey, 0, len(encoded))
+	for _, k := range encoded {
+		if k == "" {
+			continue
+		}
+		pubKey, err := base64.RawURLEncoding.DecodeString(k)
+		if err != nil || len(pubKey) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key %q", k)
+		}
+		keys = append(keys, pubKey)
+	}
+	return keys, nil
+}
+
+// Returns every address of a hostname, ordered by the address family
+// preference.
+func parseAddrPortOrHostname(edge, prefer string) ([]netip.AddrPort, error) {
 	addrs := make([]netip.AddrPort, 0)
 	portStart := strings.LastIndex(edge, ":")
 	if portStart < 0 || portStart == len(edge) {
@@ -150,17 +662,29 @@ func parseAddrPortOrHostname(edge string) ([]netip.AddrPort, error) {
 			}
 			addrs = append(addrs, addrPort)
 		}
-		if len(addrs) > 1 {
-			for _, addr := range addrs {
-				if addr.Addr().Is4() || addr.Addr().Is4In6() { // prioritise IPv4
-					return []netip.AddrPort{addr}, nil
-				}
-			}
-		}
+		sortByFamily(addrs, prefer)
 	}
 	return addrs, nil
 }
 
+// Moves addresses of the preferred family first, keeping the resolved order
+// within each family.
+func sortByFamily(addrs []netip.AddrPort, prefer string) {
+	if prefer == PREFER_BOTH {
+		return
+	}
+	slices.SortStableFunc(addrs, func(a, b netip.AddrPort) int {
+		a4, b4 := a.Addr().Unmap().Is4(), b.Addr().Unmap().Is4()
+		if a4 == b4 {
+			return 0
+		}
+		if a4 == (prefer == PREFER_IPV4) {
+			return -1
+		}
+		return 1
+	})
+}
+
 func parseAddrPort(addrport string) (netip.AddrPort, error) {
 	if strings.HasPrefix(addrport, ":") { // infer local machine if no IP
 		addrport = "[::1]" + addrport
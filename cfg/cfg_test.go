@@ -0,0 +1,207 @@
+package cfg
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestIncludes(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		files    map[string]string
+		logLevel string
+		minPeers *int
+		cidrs    []string
+		err      bool
+	}{
+		{
+			name:     "no includes",
+			files:    map[string]string{"main.yaml": "log_level: info\n"},
+			logLevel: "info",
+		},
+		{
+			name: "file overrides",
+			files: map[string]string{
+				"main.yaml":  "log_level: info\ninclude: [local.yaml]\n",
+				"local.yaml": "log_level: debug\n",
+			},
+			logLevel: "debug",
+		},
+		{
+			name: "directory in lexical order",
+			files: map[string]string{
+				"main.yaml":        "log_level: info\ninclude: [conf.d]\n",
+				"conf.d/20-b.yaml": "log_level: warn\n",
+				"conf.d/10-a.toml": "log_level = \"debug\"\n",
+				"conf.d/notes.txt": "log_level: error\n",
+				"conf.d/30-c.json": `{"api_allowed_cidrs": ["10.0.0.0/8"]}`,
+			},
+			logLevel: "warn",
+			cidrs:    []string{"10.0.0.0/8"},
+		},
+		{
+			name: "glob and append",
+			files: map[string]string{
+				"main.yaml":  "api_allowed_cidrs: [127.0.0.0/8]\ninclude: [\"*.inc.yaml\"]\n",
+				"a.inc.yaml": "api_allowed_cidrs: {append: [10.0.0.0/8]}\n",
+				"b.inc.yaml": "api_allowed_cidrs: {append: [192.168.0.0/16]}\n",
+			},
+			cidrs: []string{"127.0.0.0/8", "10.0.0.0/8", "192.168.0.0/16"},
+		},
+		{
+			name:     "glob matching nothing",
+			files:    map[string]string{"main.yaml": "log_level: info\ninclude: [\"none/*.yaml\"]\n"},
+			logLevel: "info",
+		},
+		{
+			name: "nested zero overrides",
+			files: map[string]string{
+				"main.yaml": "alerts: {min_peers: 3}\ninclude: [off.yaml]\n",
+				"off.yaml":  "alerts: {min_peers: 0}\n",
+			},
+			minPeers: Ptr(0),
+		},
+		{
+			name: "nested unset keeps",
+			files: map[string]string{
+				"main.yaml":  "alerts: {min_peers: 3}\ninclude: [other.yaml]\n",
+				"other.yaml": "alerts: {interval: 1m}\n",
+			},
+			minPeers: Ptr(3),
+		},
+		{
+			name:  "missing file",
+			files: map[string]string{"main.yaml": "include: [missing.yaml]\n"},
+			err:   true,
+		},
+		{
+			name: "cycle",
+			files: map[string]string{
+				"main.yaml": "include: [a.yaml]\n",
+				"a.yaml":    "include: [main.yaml]\n",
+			},
+			err: true,
+		},
+		{
+			name: "unknown key in include",
+			files: map[string]string{
+				"main.yaml": "include: [a.yaml]\n",
+				"a.yaml":    "log_levl: debug\n",
+			},
+			err: true,
+		},
+	} {
+		dir := writeFiles(t, tc.files)
+		got, err := ReadNodeCfgFile(filepath.Join(dir, "main.yaml"))
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: want an error", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if val(got.LogLevel) != tc.logLevel {
+			t.Errorf("%s: log_level %q, want %q", tc.name, val(got.LogLevel), tc.logLevel)
+		}
+		if !reflect.DeepEqual(got.Alerts.MinPeers, tc.minPeers) {
+			t.Errorf("%s: alerts.min_peers %v, want %v", tc.name, got.Alerts.MinPeers, tc.minPeers)
+		}
+		if !reflect.DeepEqual(got.ApiAllowedCidrs.Items, tc.cidrs) {
+			t.Errorf("%s: api_allowed_cidrs %v, want %v", tc.name, got.ApiAllowedCidrs.Items, tc.cidrs)
+		}
+		if got.Include != nil {
+			t.Errorf("%s: include %v was not resolved", tc.name, got.Include)
+		}
+	}
+}
+
+func TestSelectProfile(t *testing.T) {
+	file := &NodeCfgUnparsed{
+		LogLevel: Ptr("info"),
+		Mode:     Ptr("edge"),
+		DNS:      DNSUnparsed{ListenAddr: Ptr(":53"), Zone: Ptr("example.org")},
+		Profiles: map[string]NodeCfgUnparsed{
+			"dev":     {LogLevel: Ptr("debug"), DNS: DNSUnparsed{ListenAddr: Ptr("")}},
+			"nested":  {Profiles: map[string]NodeCfgUnparsed{"x": {}}},
+			"nothing": {},
+		},
+	}
+	for _, tc := range []struct {
+		name       string
+		profile    string
+		logLevel   string
+		listenAddr string
+		err        bool
+	}{
+		{name: "none", profile: "", logLevel: "info", listenAddr: ":53"},
+		{name: "overrides", profile: "dev", logLevel: "debug", listenAddr: ""},
+		{name: "empty profile", profile: "nothing", logLevel: "info", listenAddr: ":53"},
+		{name: "unknown", profile: "prod", err: true},
+		{name: "nested profiles", profile: "nested", err: true},
+	} {
+		got, err := SelectProfile(file, tc.profile)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: want an error", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if val(got.LogLevel) != tc.logLevel {
+			t.Errorf("%s: log_level %q, want %q", tc.name, val(got.LogLevel), tc.logLevel)
+		}
+		if val(got.DNS.ListenAddr) != tc.listenAddr {
+			t.Errorf("%s: dns.listen_addr %q, want %q", tc.name, val(got.DNS.ListenAddr), tc.listenAddr)
+		}
+		if val(got.Mode) != "edge" || val(got.DNS.Zone) != "example.org" {
+			t.Errorf("%s: lost settings the profile doesn't set", tc.name)
+		}
+		if got.Profiles != nil {
+			t.Errorf("%s: profiles were kept", tc.name)
+		}
+	}
+}
+
+func TestMergeProfilesAcrossFiles(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.yaml":  "profiles: {dev: {log_level: debug, mode: edge}}\ninclude: [local.yaml]\n",
+		"local.yaml": "profiles: {dev: {log_level: warn}, test: {mode: readonly}}\n",
+	})
+	file, err := ReadNodeCfgFile(filepath.Join(dir, "main.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, err := SelectProfile(file, "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val(dev.LogLevel) != "warn" || val(dev.Mode) != "edge" {
+		t.Errorf("dev profile has log_level %q and mode %q, want warn and edge", val(dev.LogLevel), val(dev.Mode))
+	}
+	if _, err := SelectProfile(file, "test"); err != nil {
+		t.Errorf("test profile of the include: %s", err)
+	}
+}
@@ -0,0 +1,32 @@
+package cfg
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Parses CIDR prefixes. Plain addresses are taken as single-host prefixes.
+func parsePrefixes(name string, values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s entry %q", name, v)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q", name, v)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
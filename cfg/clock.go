@@ -0,0 +1,42 @@
+package cfg
+
+import (
+	"fmt"
+	"time"
+)
+
+// Clock configures the measurement of the local clock's offset at startup,
+// applied to the time of dats put.
+type Clock struct {
+	NTPServers []string // Empty disables
+	MaxSkew    time.Duration
+}
+
+type ClockUnparsed struct {
+	NTPServers List[string] `yaml:"ntp_servers"` // Default pool.ntp.org, empty disables
+	MaxSkew    *string      `yaml:"max_skew"`    // Default 1s
+}
+
+var defaultClockUnparsed = ClockUnparsed{
+	NTPServers: List[string]{Items: []string{"pool.ntp.org"}},
+	MaxSkew:    Ptr("1s"),
+}
+
+func mergeClock(dst, src ClockUnparsed) ClockUnparsed {
+	dst.NTPServers = mergeList(dst.NTPServers, src.NTPServers)
+	dst.MaxSkew = mergeValue(dst.MaxSkew, src.MaxSkew)
+	return dst
+}
+
+func parseClock(unparsed *ClockUnparsed) (*Clock, error) {
+	c := &Clock{NTPServers: unparsed.NTPServers.Items}
+	if len(c.NTPServers) > 16 {
+		return nil, fmt.Errorf("ntp_servers must list at most 16 servers, got %d", len(c.NTPServers))
+	}
+	var err error
+	c.MaxSkew, err = parseDurationInRange("max_skew", val(unparsed.MaxSkew), 10*time.Millisecond, time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,53 @@
+package cfg
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// DNS configures the embedded DNS responder, answering for a zone from
+// dats published under the public keys named in queries.
+type DNS struct {
+	ListenAddr string
+	Zone       string // Lower case, with a trailing dot
+	TTL        time.Duration
+}
+
+type DNSUnparsed struct {
+	ListenAddr *string `yaml:"listen_addr"` // UDP, empty disables
+	Zone       *string `yaml:"zone"`        // e.g. dave.example.org
+	TTL        *string `yaml:"ttl"`         // Default 60s
+}
+
+func mergeDNS(dst, src DNSUnparsed) DNSUnparsed {
+	dst.ListenAddr = mergeValue(dst.ListenAddr, src.ListenAddr)
+	dst.Zone = mergeValue(dst.Zone, src.Zone)
+	dst.TTL = mergeValue(dst.TTL, src.TTL)
+	return dst
+}
+
+// Returns nil if the responder is disabled.
+func parseDNS(unparsed *DNSUnparsed) (*DNS, error) {
+	listenAddr := val(unparsed.ListenAddr)
+	if listenAddr == "" {
+		return nil, nil
+	}
+	if _, err := net.ResolveUDPAddr("udp", listenAddr); err != nil {
+		return nil, fmt.Errorf("invalid listen_addr: %w", err)
+	}
+	zone := strings.ToLower(strings.TrimSuffix(val(unparsed.Zone), ".")) + "."
+	if zone == "." || strings.HasPrefix(zone, ".") || strings.Contains(zone, "..") {
+		return nil, fmt.Errorf("zone must be a domain name, got %q", val(unparsed.Zone))
+	}
+	d := &DNS{ListenAddr: listenAddr, Zone: zone, TTL: time.Minute}
+	if ttl := val(unparsed.TTL); ttl != "" {
+		var err error
+		d.TTL, err = parseDurationInRange("ttl", ttl, 0, 24*time.Hour)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
@@ -0,0 +1,142 @@
+package cfg
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Sources of a setting's value, in increasing precedence.
+const (
+	SOURCE_DEFAULT = "default"
+	SOURCE_FILE    = "file"
+	SOURCE_FLAG    = "flag"
+)
+
+// Setting is one value of the merged config, and where it came from. Lists
+// and maps may combine several sources, so their source may name several.
+type Setting struct {
+	Key    string `json:"key"`
+	Value  any    `json:"value"`
+	Source string `json:"source"`
+}
+
+// Fields holding credentials, shown as redacted.
+var secretKeys = map[string]bool{"secret": true, "token": true}
+
+// Effective returns the settings of the config merged from the defaults,
+// file (may be nil) and flags. Unless all is set, only settings from the
+// file or flags are included.
+func Effective(file, flags *NodeCfgUnparsed, all bool) []Setting {
+	if file == nil {
+		file = &NodeCfgUnparsed{}
+	}
+	merged := MergeConfigs(*MergeConfigs(defaultCfgUnparsed, *file), *flags)
+	layers := []struct {
+		name string
+		cfg  reflect.Value
+	}{
+		{SOURCE_DEFAULT, reflect.ValueOf(defaultCfgUnparsed)},
+		{SOURCE_FILE, reflect.ValueOf(*file)},
+		{SOURCE_FLAG, reflect.ValueOf(*flags)},
+	}
+	settings := make([]Setting, 0)
+	var walk func(prefix string, path []int, t reflect.Type)
+	walk = func(prefix string, path []int, t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			fieldPath := append(append([]int(nil), path...), i)
+			if name == "profiles" || name == "include" {
+				continue // Resolved when the file is read
+			}
+			isList := f.Type.Implements(listType)
+			if f.Type.Kind() == reflect.Struct && !isList {
+				walk(prefix+name+".", fieldPath, f.Type)
+				continue
+			}
+			var sources []string
+			for _, l := range layers {
+				v := l.cfg.FieldByIndex(fieldPath)
+				if isZero(v) {
+					continue
+				}
+				if isList && !v.Interface().(listSetting).appends() {
+					sources = sources[:0] // Replaced
+				}
+				sources = append(sources, l.name)
+			}
+			if !all && (len(sources) == 0 || sources[len(sources)-1] == SOURCE_DEFAULT) {
+				continue
+			}
+			s := Setting{Key: prefix + name, Value: display(reflect.ValueOf(*merged).FieldByIndex(fieldPath))}
+			if isList || f.Type.Kind() == reflect.Slice || f.Type.Kind() == reflect.Map {
+				s.Source = strings.Join(sources, "+")
+			} else if len(sources) > 0 {
+				s.Source = sources[len(sources)-1]
+			}
+			settings = append(settings, s)
+		}
+	}
+	walk("", nil, reflect.TypeOf(*merged))
+	return settings
+}
+
+var listType = reflect.TypeOf((*listSetting)(nil)).Elem()
+
+// Reports whether v is unset. Lists of only empty strings count as unset.
+func isZero(v reflect.Value) bool {
+	if l, ok := v.Interface().(listSetting); ok {
+		return !l.isSet()
+	}
+	if v.Kind() == reflect.Slice {
+		for i := 0; i < v.Len(); i++ {
+			if !v.Index(i).IsZero() {
+				return false
+			}
+		}
+		return true
+	}
+	return v.IsZero()
+}
+
+// Converts structs to maps keyed by yaml name, drops empty list items and
+// redacts credentials.
+func display(v reflect.Value) any {
+	if l, ok := v.Interface().(listSetting); ok {
+		return display(reflect.ValueOf(l.values()))
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return display(v.Elem())
+	case reflect.Slice:
+		out := make([]any, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if !v.Index(i).IsZero() {
+				out = append(out, display(v.Index(i)))
+			}
+		}
+		return out
+	case reflect.Struct:
+		out := make(map[string]any)
+		for i := 0; i < v.NumField(); i++ {
+			name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+			if name == "" || name == "-" || v.Field(i).IsZero() {
+				continue
+			}
+			if secretKeys[name] {
+				out[name] = "<redacted>"
+				continue
+			}
+			out[name] = display(v.Field(i))
+		}
+		return out
+	default:
+		return v.Interface()
+	}
+}
@@ -0,0 +1,117 @@
+package cfg
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Matches the error yaml reports for a key with no field, in strict mode.
+var unknownFieldErr = regexp.MustCompile(`^line (\d+): field (\S+) not found in type (\S+)$`)
+
+// Known keys of each config struct, by Go type name as yaml reports it.
+type keySet struct {
+	section string
+	keys    []string
+}
+
+var knownKeys = func() map[string]keySet {
+	known := make(map[string]keySet)
+	var walk func(section string, t reflect.Type)
+	walk = func(section string, t reflect.Type) {
+		if _, ok := known[t.String()]; ok {
+			return
+		}
+		set := keySet{section: section}
+		known[t.String()] = set
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			set.keys = append(set.keys, name)
+			ft := f.Type
+			if ft.Implements(listType) {
+				ft = ft.Field(0).Type // Items
+			}
+			for ft.Kind() == reflect.Pointer || ft.Kind() == reflect.Slice || ft.Kind() == reflect.Map {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				walk(strings.TrimPrefix(section+"."+name, "."), ft)
+			}
+		}
+		known[t.String()] = set
+	}
+	walk("", reflect.TypeOf(NodeCfgUnparsed{}))
+	return known
+}()
+
+// Rewrites unknown key errors from strict decoding to name the section and
+// suggest the closest known key. Line numbers are only meaningful when the
+// file was decoded as written, so are dropped otherwise.
+func explainUnknownKeys(err error, lines bool) error {
+	var te *yaml.TypeError
+	if !errors.As(err, &te) {
+		return err
+	}
+	errs := make([]error, 0, len(te.Errors))
+	for _, e := range te.Errors {
+		m := unknownFieldErr.FindStringSubmatch(e)
+		if m == nil {
+			errs = append(errs, errors.New(e))
+			continue
+		}
+		line, key, set := m[1], m[2], knownKeys[m[3]]
+		msg := fmt.Sprintf("unknown key %q", key)
+		if set.section != "" {
+			msg += " in " + set.section
+		}
+		if lines {
+			msg += " at line " + line
+		}
+		if s := closest(key, set.keys); s != "" {
+			msg += fmt.Sprintf(", did you mean %q?", s)
+		}
+		errs = append(errs, errors.New(msg))
+	}
+	return errors.Join(errs...)
+}
+
+// Returns the candidate nearest to s by edit distance, if near enough to
+// be a likely typo.
+func closest(s string, candidates []string) string {
+	best, bestDist := "", max(1, len(s)/3)+1
+	for _, c := range candidates {
+		if d := editDistance(s, c); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,81 @@
+package cfg
+
+import (
+	"fmt"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// List is a list setting. A later source replaces the list of earlier
+// sources, unless it appends, written in YAML as {append: [...]} and in
+// flags with a leading +. An empty list that is set clears the setting.
+type List[T any] struct {
+	Items  []T
+	Append bool
+	Set    bool
+}
+
+func (l *List[T]) UnmarshalYAML(n *yaml.Node) error {
+	l.Set = true
+	if n.Kind == yaml.MappingNode {
+		if len(n.Content) != 2 || n.Content[0].Value != "append" {
+			return fmt.Errorf("line %d: expected a list, or {append: [...]} to append to it", n.Line)
+		}
+		var a struct {
+			Append []T `yaml:"append"`
+		}
+		if err := n.Decode(&a); err != nil {
+			return err
+		}
+		l.Items, l.Append = a.Append, true
+		return nil
+	}
+	return n.Decode(&l.Items)
+}
+
+func (l List[T]) values() any { return l.Items }
+
+func (l List[T]) isSet() bool { return l.Set }
+
+func (l List[T]) appends() bool { return l.Append }
+
+// Implemented by List, for reflection over the config.
+type listSetting interface {
+	values() any
+	isSet() bool
+	appends() bool
+}
+
+func mergeList[T any](dst, src List[T]) List[T] {
+	if !src.Set {
+		return dst
+	}
+	if src.Append {
+		return List[T]{Items: append(slices.Clone(dst.Items), src.Items...), Set: true}
+	}
+	return List[T]{Items: src.Items, Set: true}
+}
+
+// Returns src if it is set, scalar settings are pointers so that a source
+// can set the zero value.
+func mergeValue[T any](dst, src *T) *T {
+	if src != nil {
+		return src
+	}
+	return dst
+}
+
+// Ptr returns a pointer to v, for setting scalar settings.
+func Ptr[T any](v T) *T {
+	return &v
+}
+
+// Returns the value of a scalar setting, or the zero value if unset.
+func val[T any](p *T) T {
+	if p == nil {
+		var zero T
+		return zero
+	}
+	return *p
+}
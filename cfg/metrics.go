@@ -0,0 +1,30 @@
+package cfg
+
+import (
+	"fmt"
+	"net/url"
+)
+
+type MetricsSink struct {
+	Protocol string // statsd or graphite
+	Addr     string
+}
+
+// Parses a sink URL such as statsd://127.0.0.1:8125 or graphite://host:2003.
+// Returns nil for an empty string.
+func parseMetricsSink(sink string) (*MetricsSink, error) {
+	if sink == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(sink)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metrics_sink: %s", err)
+	}
+	if u.Scheme != "statsd" && u.Scheme != "graphite" {
+		return nil, fmt.Errorf("invalid metrics_sink %q, expected statsd:// or graphite://", sink)
+	}
+	if u.Port() == "" {
+		return nil, fmt.Errorf("invalid metrics_sink %q, missing port", sink)
+	}
+	return &MetricsSink{Protocol: u.Scheme, Addr: u.Host}, nil
+}
@@ -0,0 +1,36 @@
+package cfg
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Schema applies the JSON Schema in a file to values put under a prefix.
+type Schema struct {
+	Prefix   string
+	Filename string
+}
+
+type SchemaUnparsed struct {
+	Prefix   string `yaml:"prefix"` // Of the full key, with any namespace; the longest matching prefix applies
+	Filename string `yaml:"filename"`
+}
+
+func parseSchemas(unparsed []SchemaUnparsed) ([]Schema, error) {
+	schemas := make([]Schema, 0, len(unparsed))
+	seen := make(map[string]bool)
+	for _, s := range unparsed {
+		if s.Filename == "" {
+			return nil, errors.New("schema needs a filename")
+		}
+		if len(s.Prefix) > 255 {
+			return nil, fmt.Errorf("schema prefix is longer than a key: %.32s...", s.Prefix)
+		}
+		if seen[s.Prefix] {
+			return nil, fmt.Errorf("duplicate schema prefix %q", s.Prefix)
+		}
+		seen[s.Prefix] = true
+		schemas = append(schemas, Schema{Prefix: s.Prefix, Filename: s.Filename})
+	}
+	return schemas, nil
+}
@@ -0,0 +1,68 @@
+package cfg
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+)
+
+type RemoteSigner struct {
+	URL      string
+	PubKey   ed25519.PublicKey
+	CertFile string
+	KeyFile  string
+	CAFile   string
+}
+
+type RemoteSignerUnparsed struct {
+	URL      string `yaml:"url"`
+	PubKey   string `yaml:"pubkey"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	CAFile   string `yaml:"ca_file"`
+}
+
+func mergeRemoteSigner(dst, src RemoteSignerUnparsed) RemoteSignerUnparsed {
+	if src.URL != "" {
+		dst.URL = src.URL
+	}
+	if src.PubKey != "" {
+		dst.PubKey = src.PubKey
+	}
+	if src.CertFile != "" {
+		dst.CertFile = src.CertFile
+	}
+	if src.KeyFile != "" {
+		dst.KeyFile = src.KeyFile
+	}
+	if src.CAFile != "" {
+		dst.CAFile = src.CAFile
+	}
+	return dst
+}
+
+// Returns nil if no remote signer is configured.
+func parseRemoteSigner(unparsed *RemoteSignerUnparsed) (*RemoteSigner, error) {
+	if unparsed.URL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(unparsed.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q, expected https://", unparsed.URL)
+	}
+	pubKey, err := base64.RawURLEncoding.DecodeString(unparsed.PubKey)
+	if err != nil || len(pubKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid pubkey %q", unparsed.PubKey)
+	}
+	if (unparsed.CertFile == "") != (unparsed.KeyFile == "") {
+		return nil, fmt.Errorf("cert_file and key_file must be set together")
+	}
+	return &RemoteSigner{
+		URL:      unparsed.URL,
+		PubKey:   pubKey,
+		CertFile: unparsed.CertFile,
+		KeyFile:  unparsed.KeyFile,
+		CAFile:   unparsed.CAFile,
+	}, nil
+}
@@ -0,0 +1,94 @@
+package cfg
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Size is a number of bytes, written as a plain number or with a unit:
+// KB, MB, GB and TB are powers of 1000, KiB, MiB, GiB and TiB powers of
+// 1024. Units are case-insensitive and may be fractional, as in 1.5GiB.
+// Size implements flag.Value, so flags take the same form.
+type Size int64
+
+var sizeUnits = []struct {
+	suffix string
+	n      int64
+}{
+	{"tib", 1 << 40}, {"gib", 1 << 30}, {"mib", 1 << 20}, {"kib", 1 << 10},
+	{"tb", 1e12}, {"gb", 1e9}, {"mb", 1e6}, {"kb", 1e3},
+	{"b", 1},
+}
+
+// ParseSize parses a size such as 4096, 64MB or 4GiB into bytes. Sizes
+// can't be negative.
+func ParseSize(s string) (int64, error) {
+	num := strings.ToLower(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, u := range sizeUnits {
+		if n, ok := strings.CutSuffix(num, u.suffix); ok {
+			num, mult = strings.TrimSpace(n), u.n
+			break
+		}
+	}
+	if n, err := strconv.ParseInt(num, 10, 64); err == nil {
+		if n < 0 {
+			return 0, fmt.Errorf("size %q is negative", s)
+		}
+		if n > math.MaxInt64/mult {
+			return 0, fmt.Errorf("size %q is too large", s)
+		}
+		return n * mult, nil
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("invalid size %q, expected bytes or a number with a unit such as 64MB or 4GiB", s)
+	}
+	if f < 0 {
+		return 0, fmt.Errorf("size %q is negative", s)
+	}
+	b := f * float64(mult)
+	if b >= math.MaxInt64 {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return int64(b), nil
+}
+
+// String formats s in the unit giving the smallest whole number, so that
+// it parses back to the same size.
+func (s Size) String() string {
+	n, unit := int64(s), ""
+	for _, u := range sizeUnits[:len(sizeUnits)-1] {
+		if s != 0 && int64(s)%u.n == 0 && int64(s)/u.n < n {
+			n, unit = int64(s)/u.n, strings.Replace(strings.ToUpper(u.suffix), "I", "i", 1)
+		}
+	}
+	return strconv.FormatInt(n, 10) + unit
+}
+
+func (s *Size) Set(v string) error {
+	n, err := ParseSize(v)
+	if err != nil {
+		return err
+	}
+	*s = Size(n)
+	return nil
+}
+
+func (s *Size) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: expected a size such as 64MB or 4GiB", n.Line)
+	}
+	if err := s.Set(n.Value); err != nil {
+		return fmt.Errorf("line %d: %w", n.Line, err)
+	}
+	return nil
+}
+
+func (s Size) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
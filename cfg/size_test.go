@@ -0,0 +1,75 @@
+package cfg
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"4096", 4096, true},
+		{" 64MB ", 64e6, true},
+		{"64mb", 64e6, true},
+		{"4GiB", 4 << 30, true},
+		{"1.5GiB", 3 << 29, true},
+		{"10 KiB", 10 << 10, true},
+		{"512b", 512, true},
+		{"1TB", 1e12, true},
+		{"", 0, false},
+		{"MB", 0, false},
+		{"ten", 0, false},
+		{"4XB", 0, false},
+		{"-1", 0, false},
+		{"-1KiB", 0, false},
+		{"-0.5MB", 0, false},
+		{"NaN", 0, false},
+		{"nanMB", 0, false},
+		{"Inf", 0, false},
+		{"+InfGiB", 0, false},
+		{"9223372036854775807", math.MaxInt64, true},
+		{"9223372036854775807KB", 0, false},
+		{"1e30TB", 0, false},
+	} {
+		got, err := ParseSize(tc.in)
+		if tc.ok && err != nil {
+			t.Errorf("ParseSize(%q): %s", tc.in, err)
+			continue
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("ParseSize(%q) = %d, want an error", tc.in, got)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParseSize(%q) = %d, want %d", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestSizeString(t *testing.T) {
+	for _, tc := range []struct {
+		in   Size
+		want string
+	}{
+		{0, "0"},
+		{1000, "1KB"},
+		{1024, "1KiB"},
+		{1536, "1536"},
+		{32 << 20, "32MiB"},
+		{5e9, "5GB"},
+		{1 << 40, "1TiB"},
+	} {
+		got := tc.in.String()
+		if got != tc.want {
+			t.Errorf("Size(%d).String() = %q, want %q", int64(tc.in), got, tc.want)
+		}
+		n, err := ParseSize(got)
+		if err != nil || n != int64(tc.in) {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", got, n, err, int64(tc.in))
+		}
+	}
+}
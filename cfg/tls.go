@@ -0,0 +1,72 @@
+package cfg
+
+import "fmt"
+
+type ApiTLS struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string            // If set, clients are authenticated by certificate
+	ClientScopes map[string]string // Certificate name to scope
+}
+
+type ClientScope struct {
+	Name  string `yaml:"name"`  // Common name, or a DNS or URI subject alternative name
+	Scope string `yaml:"scope"` // read, write or admin
+}
+
+type ApiTLSUnparsed struct {
+	CertFile     string        `yaml:"cert_file"`
+	KeyFile      string        `yaml:"key_file"`
+	ClientCAFile string        `yaml:"client_ca_file"`
+	ClientScopes []ClientScope `yaml:"client_scopes"`
+}
+
+func mergeApiTLS(dst, src ApiTLSUnparsed) ApiTLSUnparsed {
+	if src.CertFile != "" {
+		dst.CertFile = src.CertFile
+	}
+	if src.KeyFile != "" {
+		dst.KeyFile = src.KeyFile
+	}
+	if src.ClientCAFile != "" {
+		dst.ClientCAFile = src.ClientCAFile
+	}
+	if len(src.ClientScopes) > 0 {
+		dst.ClientScopes = src.ClientScopes
+	}
+	return dst
+}
+
+// Returns nil if the API is not served over TLS.
+func parseApiTLS(unparsed *ApiTLSUnparsed) (*ApiTLS, error) {
+	if unparsed.CertFile == "" && unparsed.KeyFile == "" {
+		if unparsed.ClientCAFile != "" || len(unparsed.ClientScopes) > 0 {
+			return nil, fmt.Errorf("client certificates need cert_file and key_file")
+		}
+		return nil, nil
+	}
+	if unparsed.CertFile == "" || unparsed.KeyFile == "" {
+		return nil, fmt.Errorf("cert_file and key_file must be set together")
+	}
+	if len(unparsed.ClientScopes) > 0 && unparsed.ClientCAFile == "" {
+		return nil, fmt.Errorf("client_scopes needs client_ca_file")
+	}
+	t := &ApiTLS{
+		CertFile:     unparsed.CertFile,
+		KeyFile:      unparsed.KeyFile,
+		ClientCAFile: unparsed.ClientCAFile,
+		ClientScopes: make(map[string]string, len(unparsed.ClientScopes)),
+	}
+	for _, c := range unparsed.ClientScopes {
+		if c.Name == "" {
+			return nil, fmt.Errorf("client_scopes entries need a name")
+		}
+		switch c.Scope {
+		case SCOPE_READ, SCOPE_WRITE, SCOPE_ADMIN:
+		default:
+			return nil, fmt.Errorf("invalid scope %q for %s, expected %s, %s or %s", c.Scope, c.Name, SCOPE_READ, SCOPE_WRITE, SCOPE_ADMIN)
+		}
+		t.ClientScopes[c.Name] = c.Scope
+	}
+	return t, nil
+}
@@ -0,0 +1,433 @@
+package cfg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A TOML reader covering what config files need: tables, arrays of tables,
+// dotted and quoted keys, strings, integers, floats, booleans, arrays and
+// inline tables. Dates and times are not supported, no setting takes one.
+// The result is re-encoded as YAML and decoded with the yaml tags, so TOML
+// keys are the same as YAML keys.
+type tomlParser struct {
+	s   string
+	pos int
+}
+
+func parseTOML(s string) (map[string]any, error) {
+	p := &tomlParser{s: s}
+	root := make(map[string]any)
+	current := root
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return root, nil
+		}
+		var err error
+		if p.peek() == '[' {
+			current, err = p.parseHeader(root)
+		} else {
+			err = p.parseKeyValue(current)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", p.line(), err)
+		}
+		if err := p.endOfLine(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", p.line(), err)
+		}
+	}
+}
+
+func (p *tomlParser) eof() bool  { return p.pos >= len(p.s) }
+func (p *tomlParser) peek() byte { return p.s[p.pos] }
+
+func (p *tomlParser) line() int {
+	return strings.Count(p.s[:min(p.pos, len(p.s))], "\n") + 1
+}
+
+// Skips spaces and tabs.
+func (p *tomlParser) skipSpace() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+// Skips whitespace, newlines and comments.
+func (p *tomlParser) skipBlank() {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\r', '\n':
+			p.pos++
+		case '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) endOfLine() error {
+	p.skipSpace()
+	if p.eof() {
+		return nil
+	}
+	switch p.peek() {
+	case '#', '\n':
+		return nil
+	case '\r':
+		if strings.HasPrefix(p.s[p.pos:], "\r\n") {
+			return nil
+		}
+	}
+	return fmt.Errorf("unexpected %q after value", p.peek())
+}
+
+func (p *tomlParser) parseHeader(root map[string]any) (map[string]any, error) {
+	array := strings.HasPrefix(p.s[p.pos:], "[[")
+	if array {
+		p.pos += 2
+	} else {
+		p.pos++
+	}
+	path, err := p.parseKey()
+	if err != nil {
+		return nil, err
+	}
+	closing := "]"
+	if array {
+		closing = "]]"
+	}
+	if !strings.HasPrefix(p.s[p.pos:], closing) {
+		return nil, fmt.Errorf("expected %s", closing)
+	}
+	p.pos += len(closing)
+	parent, err := table(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+	if !array {
+		return table(parent, []string{last})
+	}
+	t := make(map[string]any)
+	switch existing := parent[last].(type) {
+	case nil:
+		parent[last] = []any{t}
+	case []any:
+		parent[last] = append(existing, t)
+	default:
+		return nil, fmt.Errorf("%s is already defined", last)
+	}
+	return t, nil
+}
+
+// Returns the table at path below t, creating missing tables. Within an
+// array of tables, the last table is used.
+func table(t map[string]any, path []string) (map[string]any, error) {
+	for _, k := range path {
+		switch next := t[k].(type) {
+		case nil:
+			m := make(map[string]any)
+			t[k] = m
+			t = m
+		case map[string]any:
+			t = next
+		case []any:
+			m, ok := next[len(next)-1].(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("%s is not a table", k)
+			}
+			t = m
+		default:
+			return nil, fmt.Errorf("%s is not a table", k)
+		}
+	}
+	return t, nil
+}
+
+func (p *tomlParser) parseKeyValue(t map[string]any) error {
+	path, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+	if p.eof() || p.peek() != '=' {
+		return fmt.Errorf("expected = after key")
+	}
+	p.pos++
+	p.skipSpace()
+	v, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+	parent, err := table(t, path[:len(path)-1])
+	if err != nil {
+		return err
+	}
+	last := path[len(path)-1]
+	if _, ok := parent[last]; ok {
+		return fmt.Errorf("%s is already defined", last)
+	}
+	parent[last] = v
+	return nil
+}
+
+// Parses a possibly dotted key, consuming surrounding spaces.
+func (p *tomlParser) parseKey() ([]string, error) {
+	var path []string
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return nil, fmt.Errorf("expected key")
+		}
+		var k string
+		switch p.peek() {
+		case '"':
+			s, err := p.parseBasicString()
+			if err != nil {
+				return nil, err
+			}
+			k = s
+		case '\'':
+			s, err := p.parseLiteralString()
+			if err != nil {
+				return nil, err
+			}
+			k = s
+		default:
+			start := p.pos
+			for !p.eof() && isBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, fmt.Errorf("unexpected %q in key", p.peek())
+			}
+			k = p.s[start:p.pos]
+		}
+		path = append(path, k)
+		p.skipSpace()
+		if p.eof() || p.peek() != '.' {
+			return path, nil
+		}
+		p.pos++
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) parseValue() (any, error) {
+	if p.eof() {
+		return nil, fmt.Errorf("expected value")
+	}
+	switch c := p.peek(); {
+	case c == '"':
+		return p.parseBasicString()
+	case c == '\'':
+		return p.parseLiteralString()
+	case c == '[':
+		return p.parseArray()
+	case c == '{':
+		return p.parseInlineTable()
+	}
+	start := p.pos
+	for !p.eof() && !strings.ContainsRune(" \t\r\n,]}#", rune(p.peek())) {
+		p.pos++
+	}
+	tok := p.s[start:p.pos]
+	switch tok {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	if i, err := strconv.ParseInt(tok, 0, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(strings.ReplaceAll(tok, "_", ""), 64); err == nil {
+		return f, nil
+	}
+	if strings.ContainsAny(tok, ":") || strings.Count(tok, "-") == 2 {
+		return nil, fmt.Errorf("dates and times are not supported, quote %q as a string", tok)
+	}
+	return nil, fmt.Errorf("invalid value %q", tok)
+}
+
+func (p *tomlParser) parseBasicString() (string, error) {
+	multiline := strings.HasPrefix(p.s[p.pos:], `"""`)
+	if multiline {
+		p.pos += 3
+		p.trimLeadingNewline()
+	} else {
+		p.pos++
+	}
+	var b strings.Builder
+	for {
+		if p.eof() {
+			return "", fmt.Errorf("unterminated string")
+		}
+		if multiline && strings.HasPrefix(p.s[p.pos:], `"""`) {
+			p.pos += 3
+			return b.String(), nil
+		}
+		c := p.peek()
+		switch {
+		case !multiline && c == '"':
+			p.pos++
+			return b.String(), nil
+		case !multiline && c == '\n':
+			return "", fmt.Errorf("newline in string")
+		case c == '\\':
+			p.pos++
+			if p.eof() {
+				return "", fmt.Errorf("unterminated string")
+			}
+			if err := p.parseEscape(&b, multiline); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+func (p *tomlParser) parseEscape(b *strings.Builder, multiline bool) error {
+	c := p.peek()
+	p.pos++
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case '"':
+		b.WriteByte('"')
+	case '\\':
+		b.WriteByte('\\')
+	case 'u', 'U':
+		n := 4
+		if c == 'U' {
+			n = 8
+		}
+		if p.pos+n > len(p.s) {
+			return fmt.Errorf("short unicode escape")
+		}
+		r, err := strconv.ParseUint(p.s[p.pos:p.pos+n], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(r)) {
+			return fmt.Errorf("invalid unicode escape")
+		}
+		b.WriteRune(rune(r))
+		p.pos += n
+	case ' ', '\t', '\r', '\n':
+		if !multiline {
+			return fmt.Errorf("invalid escape")
+		}
+		// Line ending backslash, trims whitespace up to the next content
+		p.pos--
+		for !p.eof() && strings.ContainsRune(" \t\r\n", rune(p.peek())) {
+			p.pos++
+		}
+	default:
+		return fmt.Errorf("invalid escape \\%c", c)
+	}
+	return nil
+}
+
+func (p *tomlParser) parseLiteralString() (string, error) {
+	delim := "'"
+	if strings.HasPrefix(p.s[p.pos:], "'''") {
+		delim = "'''"
+	}
+	p.pos += len(delim)
+	if len(delim) == 3 {
+		p.trimLeadingNewline()
+	}
+	end := strings.Index(p.s[p.pos:], delim)
+	if end < 0 {
+		return "", fmt.Errorf("unterminated string")
+	}
+	s := p.s[p.pos : p.pos+end]
+	if len(delim) == 1 && strings.Contains(s, "\n") {
+		return "", fmt.Errorf("newline in string")
+	}
+	p.pos += end + len(delim)
+	return s, nil
+}
+
+func (p *tomlParser) trimLeadingNewline() {
+	if strings.HasPrefix(p.s[p.pos:], "\r\n") {
+		p.pos += 2
+	} else if strings.HasPrefix(p.s[p.pos:], "\n") {
+		p.pos++
+	}
+}
+
+func (p *tomlParser) parseArray() ([]any, error) {
+	p.pos++
+	arr := make([]any, 0)
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return nil, fmt.Errorf("unterminated array")
+		}
+		if p.peek() == ']' {
+			p.pos++
+			return arr, nil
+		}
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+		p.skipBlank()
+		if p.eof() {
+			return nil, fmt.Errorf("unterminated array")
+		}
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, fmt.Errorf("expected , or ] in array")
+		}
+	}
+}
+
+func (p *tomlParser) parseInlineTable() (map[string]any, error) {
+	p.pos++
+	t := make(map[string]any)
+	p.skipSpace()
+	if !p.eof() && p.peek() == '}' {
+		p.pos++
+		return t, nil
+	}
+	for {
+		if err := p.parseKeyValue(t); err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.eof() {
+			return nil, fmt.Errorf("unterminated inline table")
+		}
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return t, nil
+		default:
+			return nil, fmt.Errorf("expected , or } in inline table")
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package cfg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		want map[string]any
+	}{
+		{"empty", "# nothing\n\n", map[string]any{}},
+		{"scalars", "a = \"x\"\nb = 42\nc = 1.5\nd = true\ne = 'lit\\eral'\nf = 1_000",
+			map[string]any{"a": "x", "b": int64(42), "c": 1.5, "d": true, "e": `lit\eral`, "f": int64(1000)}},
+		{"escapes", `s = "tab\there \"quoted\" é"`, map[string]any{"s": "tab\there \"quoted\" é"}},
+		{"multiline", "s = \"\"\"\nline one\nline two\"\"\"", map[string]any{"s": "line one\nline two"}},
+		{"comment after value", "a = 1 # one", map[string]any{"a": int64(1)}},
+		{"table", "[alerts]\nmin_peers = 3\ninterval = \"1m\"",
+			map[string]any{"alerts": map[string]any{"min_peers": int64(3), "interval": "1m"}}},
+		{"dotted and quoted keys", "dns.zone = \"example.org\"\n\"odd key\" = 1\n[a.'b c']\nd = 2",
+			map[string]any{"dns": map[string]any{"zone": "example.org"}, "odd key": int64(1), "a": map[string]any{"b c": map[string]any{"d": int64(2)}}}},
+		{"arrays", "a = [1, 2,\n  3, # three\n]\nb = []\nc = [[\"x\"], [\"y\"]]",
+			map[string]any{"a": []any{int64(1), int64(2), int64(3)}, "b": []any{}, "c": []any{[]any{"x"}, []any{"y"}}}},
+		{"inline table", "t = { a = 1, b = { c = \"d\" } }\ne = {}",
+			map[string]any{"t": map[string]any{"a": int64(1), "b": map[string]any{"c": "d"}}, "e": map[string]any{}}},
+		{"array of tables", "[[webhooks]]\nurl = \"a\"\n[[webhooks]]\nurl = \"b\"\n[webhooks.headers]\nx = \"y\"",
+			map[string]any{"webhooks": []any{
+				map[string]any{"url": "a"},
+				map[string]any{"url": "b", "headers": map[string]any{"x": "y"}},
+			}}},
+	} {
+		got, err := parseTOML(tc.in)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %#v, want %#v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+	}{
+		{"missing equals", "a 1"},
+		{"missing value", "a ="},
+		{"redefined key", "a = 1\na = 2"},
+		{"redefined table key", "[t]\na = 1\n[t]\na = 2"},
+		{"value is not a table", "a = 1\n[a]"},
+		{"unclosed header", "[a"},
+		{"unclosed array header", "[[a]"},
+		{"unterminated string", `a = "x`},
+		{"newline in string", "a = \"x\ny\""},
+		{"unterminated array", "a = [1, 2"},
+		{"missing comma in array", "a = [1 2]"},
+		{"unterminated inline table", "a = { b = 1"},
+		{"trailing garbage", "a = 1 b"},
+		{"date", "a = 2024-01-01"},
+		{"time", "a = 12:00:00"},
+		{"bare word", "a = yes"},
+	} {
+		if got, err := parseTOML(tc.in); err == nil {
+			t.Errorf("%s: got %#v, want an error", tc.name, got)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package cfg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Validate checks a config file, if given, with the named profile selected
+// and merged with flags: unknown YAML keys, every value ParseNodeCfg checks, and the presence and permission
+// bits of the files it names. All problems found are returned.
+func Validate(filename, profile string, flags *NodeCfgUnparsed) []error {
+	var problems []error
+	merged := flags
+	if filename != "" {
+		file, err := decodeNodeCfgFile(filename, true)
+		if err != nil {
+			problems = append(problems, err)
+			file, err = decodeNodeCfgFile(filename, false) // Check values regardless
+			if err != nil {
+				return problems
+			}
+		}
+		file, err = SelectProfile(file, profile)
+		if err != nil {
+			return append(problems, err)
+		}
+		merged = MergeConfigs(*file, *flags)
+	}
+	parsed, err := ParseNodeCfg(merged)
+	if err != nil {
+		return append(problems, err)
+	}
+	if err := CheckKeyFile(parsed.KeyFilename); err != nil {
+		problems = append(problems, fmt.Errorf("key_filename: %w", err))
+	}
+	if rs := parsed.RemoteSigner; rs != nil {
+		for name, filename := range map[string]string{"cert_file": rs.CertFile, "ca_file": rs.CAFile} {
+			if filename == "" {
+				continue
+			}
+			if _, err := os.Stat(filename); err != nil {
+				problems = append(problems, fmt.Errorf("remote_signer %s: %w", name, err))
+			}
+		}
+		if rs.KeyFile != "" {
+			if err := checkPrivate(rs.KeyFile); err != nil {
+				problems = append(problems, fmt.Errorf("remote_signer key_file: %w", err))
+			}
+		}
+	}
+	if t := parsed.ApiTLS; t != nil {
+		for name, filename := range map[string]string{"cert_file": t.CertFile, "client_ca_file": t.ClientCAFile} {
+			if filename == "" {
+				continue
+			}
+			if _, err := os.Stat(filename); err != nil {
+				problems = append(problems, fmt.Errorf("api_tls %s: %w", name, err))
+			}
+		}
+		if err := checkPrivate(t.KeyFile); err != nil {
+			problems = append(problems, fmt.Errorf("api_tls key_file: %w", err))
+		}
+	}
+	if wp := parsed.WorkPool; wp != nil {
+		if _, err := os.Stat(wp.Records); err != nil {
+			problems = append(problems, fmt.Errorf("work_pool records: %w", err))
+		}
+	}
+	return problems
+}
+
+// CheckKeyFile checks that a private key file exists, holds a key, and is
+// not accessible to group or others.
+func CheckKeyFile(filename string) error {
+	if err := checkPrivate(filename); err != nil {
+		return err
+	}
+	_, err := ReadKeyFile(filename)
+	return err
+}
+
+func checkPrivate(filename string) error {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%s does not exist", filename)
+		}
+		return err
+	}
+	if perm := fi.Mode().Perm(); perm&0077 != 0 {
+		return fmt.Errorf("%s is accessible to group or others (mode %04o), expected 0600", filename, perm)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package cfg
+
+import (
+	"fmt"
+	"time"
+)
+
+// Watchdog configures the checks of the node's health served at /healthz.
+type Watchdog struct {
+	Interval      time.Duration
+	NoPeersAfter  time.Duration // Zero disables
+	MinPeers      int           // Zero disables
+	MinPeersAfter time.Duration
+}
+
+type WatchdogUnparsed struct {
+	Interval      string `yaml:"interval"`        // Default 10s
+	NoPeersAfter  string `yaml:"no_peers_after"`  // Default 2m, 0 disables
+	MinPeers      *int   `yaml:"min_peers"`       // Default 0, disabled
+	MinPeersAfter string `yaml:"min_peers_after"` // Default 5m
+}
+
+var defaultWatchdogUnparsed = WatchdogUnparsed{
+	Interval:      "10s",
+	NoPeersAfter:  "2m",
+	MinPeersAfter: "5m",
+}
+
+func mergeWatchdog(dst, src WatchdogUnparsed) WatchdogUnparsed {
+	if src.Interval != "" {
+		dst.Interval = src.Interval
+	}
+	if src.NoPeersAfter != "" {
+		dst.NoPeersAfter = src.NoPeersAfter
+	}
+	dst.MinPeers = mergeValue(dst.MinPeers, src.MinPeers)
+	if src.MinPeersAfter != "" {
+		dst.MinPeersAfter = src.MinPeersAfter
+	}
+	return dst
+}
+
+func parseWatchdog(unparsed *WatchdogUnparsed) (*Watchdog, error) {
+	w := &Watchdog{}
+	var err error
+	w.Interval, err = parseDurationInRange("interval", unparsed.Interval, time.Second, 10*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if unparsed.NoPeersAfter != "0" {
+		w.NoPeersAfter, err = parseDurationInRange("no_peers_after", unparsed.NoPeersAfter, w.Interval, 24*time.Hour)
+		if err != nil {
+			return nil, err
+		}
+	}
+	w.MinPeers = val(unparsed.MinPeers)
+	if w.MinPeers < 0 || w.MinPeers > 1000 {
+		return nil, fmt.Errorf("min_peers must be between 0 and 1000, got %d", w.MinPeers)
+	}
+	w.MinPeersAfter, err = parseDurationInRange("min_peers_after", unparsed.MinPeersAfter, w.Interval, 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
@@ -0,0 +1,74 @@
+package cfg
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+type Webhook struct {
+	URL     string
+	Events  []string
+	Secret  string
+	Retries int
+}
+
+type WebhookUnparsed struct {
+	URL     string   `yaml:"url"`
+	Events  []string `yaml:"events"`  // Empty for all events
+	Secret  string   `yaml:"secret"`  // Signs bodies with HMAC-SHA256 if set
+	Retries *int     `yaml:"retries"` // Default 3
+}
+
+func parseWebhooks(unparsed []WebhookUnparsed) ([]Webhook, error) {
+	hooks := make([]Webhook, 0, len(unparsed))
+	for _, h := range unparsed {
+		u, err := url.Parse(h.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook url %q", h.URL)
+		}
+		hook := Webhook{URL: h.URL, Events: h.Events, Secret: h.Secret, Retries: 3}
+		if h.Retries != nil {
+			if *h.Retries < 0 || *h.Retries > 10 {
+				return nil, fmt.Errorf("webhook retries must be between 0 and 10, got %d", *h.Retries)
+			}
+			hook.Retries = *h.Retries
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+type Hook struct {
+	Command   []string
+	Events    []string
+	KeyPrefix string
+	Timeout   time.Duration
+}
+
+type HookUnparsed struct {
+	Command   []string `yaml:"command"`    // Program followed by its arguments
+	Events    []string `yaml:"events"`     // Empty for all events
+	KeyPrefix string   `yaml:"key_prefix"` // Only dat events with keys starting with this
+	Timeout   string   `yaml:"timeout"`    // Default 30s
+}
+
+func parseHooks(unparsed []HookUnparsed) ([]Hook, error) {
+	hooks := make([]Hook, 0, len(unparsed))
+	for _, h := range unparsed {
+		if len(h.Command) == 0 || h.Command[0] == "" {
+			return nil, errors.New("hook needs a command")
+		}
+		hook := Hook{Command: h.Command, Events: h.Events, KeyPrefix: h.KeyPrefix, Timeout: 30 * time.Second}
+		if h.Timeout != "" {
+			timeout, err := parseDurationInRange("hook timeout", h.Timeout, time.Second, time.Hour)
+			if err != nil {
+				return nil, err
+			}
+			hook.Timeout = timeout
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
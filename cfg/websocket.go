@@ -0,0 +1,104 @@
+package cfg
+
+import (
+	"fmt"
+	"time"
+)
+
+// Websocket token scopes, each including the ones before it
+const (
+	SCOPE_READ  = "read"
+	SCOPE_WRITE = "write"
+	SCOPE_ADMIN = "admin"
+)
+
+type Websocket struct {
+	PingInterval   time.Duration
+	IdleTimeout    time.Duration
+	MaxConnsPerIP  int
+	AllowedOrigins []string
+	Tokens         map[string]string // Token to scope
+	Bridge         *Bridge           // Nil if disabled
+}
+
+type WebsocketToken struct {
+	Token string `yaml:"token"`
+	Scope string `yaml:"scope"` // read, write or admin
+}
+
+type WebsocketUnparsed struct {
+	PingInterval   string           `yaml:"ping_interval"`    // Default 30s
+	IdleTimeout    string           `yaml:"idle_timeout"`     // Default 90s
+	MaxConnsPerIP  int              `yaml:"max_conns_per_ip"` // Default 16
+	AllowedOrigins []string         `yaml:"allowed_origins"`  // Empty allows any
+	Tokens         []WebsocketToken `yaml:"tokens"`           // If set, connections must authenticate
+	Bridge         BridgeUnparsed   `yaml:"bridge"`
+}
+
+var defaultWebsocketUnparsed = WebsocketUnparsed{
+	PingInterval:  "30s",
+	IdleTimeout:   "90s",
+	MaxConnsPerIP: 16,
+	Bridge:        defaultBridgeUnparsed,
+}
+
+func mergeWebsocket(dst, src WebsocketUnparsed) WebsocketUnparsed {
+	if src.PingInterval != "" {
+		dst.PingInterval = src.PingInterval
+	}
+	if src.IdleTimeout != "" {
+		dst.IdleTimeout = src.IdleTimeout
+	}
+	if src.MaxConnsPerIP != 0 {
+		dst.MaxConnsPerIP = src.MaxConnsPerIP
+	}
+	if len(src.AllowedOrigins) > 0 {
+		dst.AllowedOrigins = append(dst.AllowedOrigins, src.AllowedOrigins...)
+	}
+	if len(src.Tokens) > 0 {
+		dst.Tokens = append(dst.Tokens, src.Tokens...)
+	}
+	dst.Bridge = mergeBridge(dst.Bridge, src.Bridge)
+	return dst
+}
+
+func parseWebsocket(unparsed *WebsocketUnparsed) (*Websocket, error) {
+	ws := &Websocket{
+		MaxConnsPerIP:  unparsed.MaxConnsPerIP,
+		AllowedOrigins: unparsed.AllowedOrigins,
+	}
+	var err error
+	ws.PingInterval, err = parseDurationInRange("ping_interval", unparsed.PingInterval, time.Second, 10*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	ws.IdleTimeout, err = parseDurationInRange("idle_timeout", unparsed.IdleTimeout, 2*time.Second, time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if ws.IdleTimeout <= ws.PingInterval {
+		return nil, fmt.Errorf("idle_timeout (%s) must be greater than ping_interval (%s)", ws.IdleTimeout, ws.PingInterval)
+	}
+	if ws.MaxConnsPerIP < 1 {
+		return nil, fmt.Errorf("max_conns_per_ip must be at least 1, got %d", ws.MaxConnsPerIP)
+	}
+	if len(unparsed.Tokens) > 0 {
+		ws.Tokens = make(map[string]string, len(unparsed.Tokens))
+	}
+	for _, t := range unparsed.Tokens {
+		if len(t.Token) < 16 {
+			return nil, fmt.Errorf("tokens must be at least 16 characters")
+		}
+		switch t.Scope {
+		case SCOPE_READ, SCOPE_WRITE, SCOPE_ADMIN:
+		default:
+			return nil, fmt.Errorf("invalid token scope %q, expected %s, %s or %s", t.Scope, SCOPE_READ, SCOPE_WRITE, SCOPE_ADMIN)
+		}
+		ws.Tokens[t.Token] = t.Scope
+	}
+	ws.Bridge, err = parseBridge(&unparsed.Bridge)
+	if err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
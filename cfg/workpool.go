@@ -0,0 +1,80 @@
+package cfg
+
+import (
+	"fmt"
+	"time"
+)
+
+// WorkPool configures the background miner that precomputes work for the
+// node's own records at future slot times.
+type WorkPool struct {
+	Records   string // Import file of records to prepare
+	WorkCache string
+	Slot      time.Duration
+	Ahead     int
+	Workers   int
+}
+
+type WorkPoolUnparsed struct {
+	Records   string `yaml:"records"`
+	WorkCache string `yaml:"work_cache"`
+	Slot      string `yaml:"slot"`    // Default 1h
+	Ahead     int    `yaml:"ahead"`   // Default 24 slots
+	Workers   int    `yaml:"workers"` // Default 1
+}
+
+func mergeWorkPool(dst, src WorkPoolUnparsed) WorkPoolUnparsed {
+	if src.Records != "" {
+		dst.Records = src.Records
+	}
+	if src.WorkCache != "" {
+		dst.WorkCache = src.WorkCache
+	}
+	if src.Slot != "" {
+		dst.Slot = src.Slot
+	}
+	if src.Ahead != 0 {
+		dst.Ahead = src.Ahead
+	}
+	if src.Workers != 0 {
+		dst.Workers = src.Workers
+	}
+	return dst
+}
+
+// Returns nil if no records are configured.
+func parseWorkPool(unparsed *WorkPoolUnparsed) (*WorkPool, error) {
+	if unparsed.Records == "" {
+		return nil, nil
+	}
+	if unparsed.WorkCache == "" {
+		return nil, fmt.Errorf("work_cache is required")
+	}
+	p := &WorkPool{
+		Records:   unparsed.Records,
+		WorkCache: unparsed.WorkCache,
+		Slot:      time.Hour,
+		Ahead:     24,
+		Workers:   1,
+	}
+	if unparsed.Slot != "" {
+		var err error
+		p.Slot, err = parseDurationInRange("slot", unparsed.Slot, time.Minute, 7*24*time.Hour)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if unparsed.Ahead != 0 {
+		if unparsed.Ahead < 1 || unparsed.Ahead > 1000 {
+			return nil, fmt.Errorf("ahead must be between 1 and 1000, got %d", unparsed.Ahead)
+		}
+		p.Ahead = unparsed.Ahead
+	}
+	if unparsed.Workers != 0 {
+		if unparsed.Workers < 1 || unparsed.Workers > 256 {
+			return nil, fmt.Errorf("workers must be between 1 and 256, got %d", unparsed.Workers)
+		}
+		p.Workers = unparsed.Workers
+	}
+	return p, nil
+}
@@ -0,0 +1,149 @@
+// Package clock measures the offset of the local clock from NTP servers,
+// so dats are timestamped with the servers' time rather than a local clock
+// that may have drifted. Peers refuse dats from the future, and order
+// versions by time, so a fast clock loses writes and a slow one is
+// overwritten.
+package clock
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ntpPort  = "123"
+	ntpEpoch = 2208988800 // Seconds from 1900 to 1970
+	// Margin before the clock is measured, as it may be ahead
+	defaultMargin = 100 * time.Millisecond
+)
+
+// Estimate is the offset of the local clock from a server's.
+type Estimate struct {
+	Server string
+	Offset time.Duration // Added to local time gives the server's
+	RTT    time.Duration // Round trip, less the server's processing
+}
+
+// Uncertainty is the most the offset can be wrong by, half the round trip.
+func (e *Estimate) Uncertainty() time.Duration {
+	return e.RTT / 2
+}
+
+var current atomic.Pointer[Estimate]
+
+// Set makes Now correct time by e.
+func Set(e *Estimate) {
+	current.Store(e)
+}
+
+// Current returns the estimate set, or nil.
+func Current() *Estimate {
+	return current.Load()
+}
+
+// Now returns the time to put on a dat: local time corrected by the offset
+// set, less its uncertainty so the dat is not ahead of a peer's clock.
+// Without an estimate it is local time less 100ms.
+func Now() time.Time {
+	e := current.Load()
+	if e == nil {
+		return time.Now().Add(-defaultMargin)
+	}
+	return time.Now().Add(e.Offset - e.Uncertainty())
+}
+
+// Measure queries the servers concurrently, returning the estimate of
+// median offset, so one wrong server does not skew it.
+func Measure(ctx context.Context, servers []string) (*Estimate, error) {
+	var mu sync.Mutex
+	estimates := make([]*Estimate, 0, len(servers))
+	errs := make([]error, 0)
+	wg := sync.WaitGroup{}
+	for _, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e, err := Query(ctx, server)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			estimates = append(estimates, e)
+		}()
+	}
+	wg.Wait()
+	if len(estimates) == 0 {
+		return nil, fmt.Errorf("no server answered: %w", errors.Join(errs...))
+	}
+	sort.Slice(estimates, func(i, j int) bool { return estimates[i].Offset < estimates[j].Offset })
+	return estimates[len(estimates)/2], nil
+}
+
+// Query asks server, a host with an optional port, for the time over SNTP
+// (RFC 4330).
+func Query(ctx context.Context, server string) (*Estimate, error) {
+	addr := server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		addr = net.JoinHostPort(server, ntpPort)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", server, err)
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	req := make([]byte, 48)
+	req[0] = 0<<6 | 4<<3 | 3 // No leap warning, version 4, client
+	t1 := time.Now()
+	binary.BigEndian.PutUint64(req[40:], toNTP(t1)) // Echoed as the origin
+	if _, err := conn.Write(req); err != nil {
+		return nil, fmt.Errorf("%s: %w", server, err)
+	}
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	t4 := time.Now()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", server, err)
+	}
+	switch {
+	case n < 48:
+		return nil, fmt.Errorf("%s: short response", server)
+	case resp[0]&0x7 != 4:
+		return nil, fmt.Errorf("%s: not a server response", server)
+	case resp[0]>>6 == 3 || resp[1] == 0 || resp[1] > 15:
+		return nil, fmt.Errorf("%s: server is not synchronised", server)
+	case binary.BigEndian.Uint64(resp[24:]) != binary.BigEndian.Uint64(req[40:]):
+		return nil, fmt.Errorf("%s: response does not match request", server)
+	}
+	t2 := fromNTP(binary.BigEndian.Uint64(resp[32:]))
+	t3 := fromNTP(binary.BigEndian.Uint64(resp[40:]))
+	return &Estimate{
+		Server: server,
+		Offset: (t2.Sub(t1) + t3.Sub(t4)) / 2,
+		RTT:    max(t4.Sub(t1)-t3.Sub(t2), 0),
+	}, nil
+}
+
+func toNTP(t time.Time) uint64 {
+	sec := uint64(t.Unix() + ntpEpoch)
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return sec<<32 | frac
+}
+
+func fromNTP(v uint64) time.Time {
+	sec := int64(v>>32) - ntpEpoch
+	nsec := int64((v & 0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(sec, nsec)
+}
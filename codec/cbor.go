@@ -0,0 +1,68 @@
+package codec
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Encodes CBOR, RFC 8949, with definite lengths.
+type cborEncoder struct {
+	buf []byte
+}
+
+func (e *cborEncoder) head(major byte, n uint64) {
+	switch {
+	case n < 24:
+		e.buf = append(e.buf, major<<5|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, major<<5|24, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, major<<5|25)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	case n <= math.MaxUint32:
+		e.buf = append(e.buf, major<<5|26)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	default:
+		e.buf = append(e.buf, major<<5|27)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, n)
+	}
+}
+
+func (e *cborEncoder) null() { e.buf = append(e.buf, 0xf6) }
+
+func (e *cborEncoder) bool(b bool) {
+	if b {
+		e.buf = append(e.buf, 0xf5)
+	} else {
+		e.buf = append(e.buf, 0xf4)
+	}
+}
+
+func (e *cborEncoder) int(i int64) {
+	if i < 0 {
+		e.head(1, uint64(-1-i))
+		return
+	}
+	e.head(0, uint64(i))
+}
+
+func (e *cborEncoder) uint(u uint64) { e.head(0, u) }
+
+func (e *cborEncoder) float(f float64) {
+	e.buf = append(e.buf, 0xfb)
+	e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(f))
+}
+
+func (e *cborEncoder) str(s string) {
+	e.head(3, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *cborEncoder) bin(b []byte) {
+	e.head(2, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *cborEncoder) array(n int)     { e.head(4, uint64(n)) }
+func (e *cborEncoder) mapHeader(n int) { e.head(5, uint64(n)) }
+func (e *cborEncoder) bytes() []byte   { return e.buf }
@@ -0,0 +1,282 @@
+// Package codec encodes API responses as JSON, CBOR or MessagePack.
+//
+// Binary formats follow encoding/json field names and omitempty. String
+// fields tagged `binary:"base64"` hold base64url data in JSON, and are sent
+// as raw bytes by the binary formats, roughly halving their size.
+package codec
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	JSON    = "application/json"
+	CBOR    = "application/cbor"
+	MSGPACK = "application/msgpack"
+)
+
+// Negotiate returns the content type to respond with for an Accept header,
+// the supported one of highest q, the first of those if several share it.
+// Types with q=0 are refused. Defaults to JSON.
+func Negotiate(accept string) string {
+	best, bestQ := JSON, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(v, 64)
+			if err != nil || q <= 0 {
+				continue
+			}
+		}
+		var contentType string
+		switch mt {
+		case CBOR:
+			contentType = CBOR
+		case MSGPACK, "application/x-msgpack", "application/vnd.msgpack":
+			contentType = MSGPACK
+		case JSON:
+			contentType = JSON
+		}
+		if contentType != "" && q > bestQ {
+			best, bestQ = contentType, q
+		}
+	}
+	return best
+}
+
+// Marshal encodes v as contentType.
+func Marshal(contentType string, v any) ([]byte, error) {
+	var enc encoder
+	switch contentType {
+	case JSON:
+		return json.MarshalIndent(v, "", "  ")
+	case CBOR:
+		enc = &cborEncoder{}
+	case MSGPACK:
+		enc = &msgpackEncoder{}
+	default:
+		return nil, fmt.Errorf("unsupported content type %q", contentType)
+	}
+	err := encode(enc, reflect.ValueOf(v))
+	if err != nil {
+		return nil, err
+	}
+	return enc.bytes(), nil
+}
+
+// Primitive writers implemented by each binary format.
+type encoder interface {
+	null()
+	bool(b bool)
+	int(i int64)
+	uint(u uint64)
+	float(f float64)
+	str(s string)
+	bin(b []byte)
+	array(n int)
+	mapHeader(n int)
+	bytes() []byte
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type field struct {
+	name      string
+	index     []int // Through embedded structs
+	tagged    bool  // Named by its json tag
+	omitEmpty bool
+	base64    bool
+}
+
+// Returns the fields of struct type t as encoding/json would encode them:
+// fields of embedded structs without a json name are promoted, and of
+// several fields with the same name, the least nested wins, then the one
+// named by its tag, else none of them.
+func fields(t reflect.Type) []field {
+	var all []field
+	collectFields(t, nil, map[reflect.Type]bool{}, &all)
+	depth := make(map[string]int)
+	for _, f := range all {
+		if d, ok := depth[f.name]; !ok || len(f.index) < d {
+			depth[f.name] = len(f.index)
+		}
+	}
+	fs := make([]field, 0, len(all))
+	for _, f := range all {
+		if len(f.index) != depth[f.name] {
+			continue
+		}
+		var rivals, tagged int
+		for _, g := range all {
+			if g.name == f.name && len(g.index) == len(f.index) {
+				rivals++
+				if g.tagged {
+					tagged++
+				}
+			}
+		}
+		if rivals == 1 || (tagged == 1 && f.tagged) {
+			fs = append(fs, f)
+		}
+	}
+	return fs
+}
+
+// Appends the fields of t, reached through index, skipping structs already
+// on the path, as a struct embedding a pointer to itself.
+func collectFields(t reflect.Type, index []int, path map[reflect.Type]bool, all *[]field) {
+	if path[t] {
+		return
+	}
+	path[t] = true
+	defer delete(path, t)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if f.Anonymous {
+			if !f.IsExported() && ft.Kind() != reflect.Struct {
+				continue
+			}
+		} else if !f.IsExported() {
+			continue
+		}
+		fd := field{name: f.Name, index: append(slices.Clone(index), i), base64: f.Tag.Get("binary") == "base64"}
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if name != "" {
+				fd.name, fd.tagged = name, true
+			}
+			fd.omitEmpty = strings.Contains(opts, "omitempty")
+		}
+		if f.Anonymous && !fd.tagged && ft.Kind() == reflect.Struct {
+			collectFields(ft, fd.index, path, all)
+			continue
+		}
+		*all = append(*all, fd)
+	}
+}
+
+// Returns the field of struct v at index, or false if it is within a nil
+// embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func encode(e encoder, v reflect.Value) error {
+	if !v.IsValid() {
+		e.null()
+		return nil
+	}
+	if v.Type() == timeType {
+		e.str(v.Interface().(time.Time).Format(time.RFC3339Nano))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			e.null()
+			return nil
+		}
+		return encode(e, v.Elem())
+	case reflect.Bool:
+		e.bool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.int(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		e.uint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		e.float(v.Float())
+	case reflect.String:
+		e.str(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			e.null()
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			e.bin(b)
+			return nil
+		}
+		e.array(v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if err := encode(e, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			e.null()
+			return nil
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("unsupported map key type %s", v.Type().Key())
+		}
+		e.mapHeader(v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			e.str(iter.Key().String())
+			if err := encode(e, iter.Value()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fs := fields(v.Type())
+		present := make([]field, 0, len(fs))
+		values := make([]reflect.Value, 0, len(fs))
+		for _, f := range fs {
+			fv, ok := fieldByIndex(v, f.index)
+			if !ok || (f.omitEmpty && fv.IsZero()) {
+				continue
+			}
+			present = append(present, f)
+			values = append(values, fv)
+		}
+		e.mapHeader(len(present))
+		for i, f := range present {
+			e.str(f.name)
+			fv := values[i]
+			if f.base64 && fv.Kind() == reflect.String {
+				b, err := base64.RawURLEncoding.DecodeString(fv.String())
+				if err == nil {
+					e.bin(b)
+					continue
+				}
+			}
+			if err := encode(e, fv); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+type inner struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+type Meta struct {
+	Version int `json:"version"`
+}
+
+type withEmbedded struct {
+	Secret string `json:"token"`
+	*inner
+	Meta
+}
+
+type shadowed struct {
+	Name string `json:"name"`
+	inner
+}
+
+type ambiguousA struct{ X int }
+type ambiguousB struct{ X int }
+
+type ambiguous struct {
+	ambiguousA
+	ambiguousB
+	Y int
+}
+
+type nested struct {
+	A struct {
+		B int `json:"b"`
+	} `json:"a"`
+}
+
+type keyed struct {
+	PubKey string `json:"pubkey" binary:"base64"`
+	Skip   string `json:"-"`
+	hidden int
+}
+
+func TestMarshal(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		v             any
+		cbor, msgpack string // Hex
+	}{
+		{"null", nil, "f6", "c0"},
+		{"true", true, "f5", "c3"},
+		{"small int", 10, "0a", "0a"},
+		{"negative int", -500, "3901f3", "d1fe0c"},
+		{"uint16", uint16(1000), "1903e8", "cd03e8"},
+		{"float", 1.5, "fb3ff8000000000000", "cb3ff8000000000000"},
+		{"string", "dave", "6464617665", "a464617665"},
+		{"byte string", []byte{1, 2, 3}, "43010203", "c403010203"},
+		{"nil slice", []string(nil), "f6", "c0"},
+		{"array", []int{1, -1}, "820120", "9201ff"},
+		{"map", map[string]int{"a": 1}, "a1616101", "81a16101"},
+		{"time", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			"74" + hex.EncodeToString([]byte("2024-01-02T03:04:05Z")),
+			"b4" + hex.EncodeToString([]byte("2024-01-02T03:04:05Z"))},
+		{"nested struct", nested{A: struct {
+			B int `json:"b"`
+		}{B: 1}}, "a16161a1616201", "81a16181a16201"},
+		{"base64 field", keyed{PubKey: "AQID", Skip: "x", hidden: 1},
+			"a166" + hex.EncodeToString([]byte("pubkey")) + "43010203",
+			"81a6" + hex.EncodeToString([]byte("pubkey")) + "c403010203"},
+		{"embedded", withEmbedded{Secret: "s", inner: &inner{ID: "x"}, Meta: Meta{Version: 2}},
+			"a365" + hex.EncodeToString([]byte("token")) + "6173" + "626964" + "6178" + "67" + hex.EncodeToString([]byte("version")) + "02",
+			"83a5" + hex.EncodeToString([]byte("token")) + "a173" + "a26964" + "a178" + "a7" + hex.EncodeToString([]byte("version")) + "02"},
+		{"nil embedded pointer", withEmbedded{Secret: "s"},
+			"a265" + hex.EncodeToString([]byte("token")) + "6173" + "67" + hex.EncodeToString([]byte("version")) + "00",
+			"82a5" + hex.EncodeToString([]byte("token")) + "a173" + "a7" + hex.EncodeToString([]byte("version")) + "00"},
+		{"outer field shadows", shadowed{Name: "outer", inner: inner{ID: "x", Name: "inner"}},
+			"a2646e616d65656f75746572626964" + "6178",
+			"82a46e616d65a56f75746572a26964" + "a178"},
+		{"ambiguous fields dropped", ambiguous{Y: 1}, "a1615901", "81a15901"},
+	} {
+		for _, enc := range []struct {
+			contentType, want string
+		}{{CBOR, tc.cbor}, {MSGPACK, tc.msgpack}} {
+			want, err := hex.DecodeString(enc.want)
+			if err != nil {
+				t.Fatalf("%s: %s", tc.name, err)
+			}
+			got, err := Marshal(enc.contentType, tc.v)
+			if err != nil {
+				t.Errorf("%s as %s: %s", tc.name, enc.contentType, err)
+				continue
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s as %s: got %x, want %x", tc.name, enc.contentType, got, want)
+			}
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	for _, tc := range []struct {
+		accept, want string
+	}{
+		{"", JSON},
+		{"*/*", JSON},
+		{"application/cbor", CBOR},
+		{"application/x-msgpack", MSGPACK},
+		{"text/html, application/cbor", CBOR},
+		{"application/cbor, application/json", CBOR},
+		{"application/cbor;q=0, application/json", JSON},
+		{"application/cbor;q=0", JSON},
+		{"application/json;q=0.5, application/msgpack", MSGPACK},
+		{"application/json;q=0.9, application/cbor;q=0.9", JSON},
+		{"application/cbor;q=x, application/msgpack;q=0.1", MSGPACK},
+	} {
+		if got := Negotiate(tc.accept); got != tc.want {
+			t.Errorf("Negotiate(%q) = %s, want %s", tc.accept, got, tc.want)
+		}
+	}
+}
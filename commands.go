@@ -0,0 +1,202 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/intob/daved/errs"
+)
+
+type command struct {
+	Name    string
+	Args    string
+	Summary string
+	Sub     []string // Completed as the first argument
+	Files   bool     // Arguments are filenames
+}
+
+// Command definitions, used for completion scripts and the man page. Keep in
+// sync with the switch in main.
+var commands = []command{
+	{Name: "version", Summary: "Print the build commit."},
+	{Name: "keygen", Args: "[FILENAME]", Summary: "Generate a data key pair, printing the public key.", Files: true},
+	{Name: "put", Args: "<KEY> <VAL>", Summary: "Sign, do work and send a dat."},
+	{Name: "get", Args: "<KEY>", Summary: "Fetch a dat of the data key from the network."},
+	{Name: "publish", Args: "<TOPIC> <MSG>", Summary: "Put a message on a topic, under a key of its own."},
+	{Name: "link", Args: "set <KEY> [->] <TARGET_KEY>", Summary: "Put a dat referring to another, of -pubkey or the data key, for get -follow_links.", Sub: []string{"set"}},
+	{Name: "dns-name", Args: "<PUBKEY> [HOST]", Summary: "Print the name of a host published under a public key, in the dns zone."},
+	{Name: "import", Args: "<FILE.jsonl|FILE.csv>", Summary: "Put every record of a file.", Files: true},
+	{Name: "bundle", Args: "<create|send> <FILE>...", Summary: "Prepare dats offline, or send a prepared bundle.", Sub: []string{"create", "send"}, Files: true},
+	{Name: "site", Args: "publish <DIR> [--key NAME]", Summary: "Put the files of a directory as a static site, served by the gateway.", Sub: []string{"publish"}, Files: true},
+	{Name: "history", Args: "<PUBKEY> <KEY>", Summary: "Show superseded versions of a dat."},
+	{Name: "status", Args: "[history [WINDOW]]", Summary: "Show node status, or trends over a window.", Sub: []string{"history"}},
+	{Name: "top", Summary: "Full-screen monitor of status, recent puts and logs."},
+	{Name: "loglevel", Args: "[LEVEL] [SUBSYSTEM=LEVEL ...]", Summary: "Show or change the running node's log levels until it restarts.", Sub: []string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR"}},
+	{Name: "multi", Summary: "Run a node for each config file in -cfg_dir, with a combined status API."},
+	{Name: "load", Summary: "Put dats at -rate for -duration, reporting acceptance and errors."},
+	{Name: "profile", Args: "<heap|goroutine|allocs|block|mutex>", Summary: "Record a profile of the running node, or a CPU profile with -cpu.", Sub: []string{"heap", "goroutine", "allocs", "block", "mutex"}},
+	{Name: "tokens", Args: "[create <NAME> <SCOPE> [LIMIT=VALUE ...]|revoke <ID>]", Summary: "List, create or revoke the running node's tenant tokens, with tokens_filename.", Sub: []string{"create", "revoke"}},
+	{Name: "audit", Args: "[ACTION]", Summary: "Show the running node's most recent mutating API calls, with audit_filename."},
+	{Name: "diff", Args: "<A> <B>", Summary: "Compare the dats of two bundle or history files.", Files: true},
+	{Name: "inspect", Args: "<DAT_FILE>", Summary: "Summarise a bundle or history file.", Files: true},
+	{Name: "verify", Args: "<PUBKEY> <KEY>", Summary: "Fetch a dat and check its signature and work."},
+	{Name: "decode", Args: "<FILE.pcap|HEXDUMP_FILE>", Summary: "Print captured UDP messages as hex dumps.", Files: true},
+	{Name: "config", Args: "<validate|show [--effective]>", Summary: "Check the config, or print it merged with the source of each value.", Sub: []string{"validate", "show"}},
+	{Name: "completion", Args: "<bash|zsh|fish>", Summary: "Print a shell completion script.", Sub: []string{"bash", "zsh", "fish"}},
+	{Name: "man", Summary: "Print the man page in roff format."},
+}
+
+// Flags taking a filename, completed with files.
+var fileFlags = map[string]bool{
+	"cfg":               true,
+	"data_key_filename": true,
+	"key_filename":      true,
+	"backup_filename":   true,
+	"audit_filename":    true,
+	"tokens_filename":   true,
+	"work_cache":        true,
+	"template":          true,
+	"cfg_dir":           true,
+}
+
+func flagNames() []string {
+	names := make([]string, 0)
+	flag.VisitAll(func(f *flag.Flag) { names = append(names, "-"+f.Name) })
+	return names
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for _, c := range commands {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+func writeCompletion(w io.Writer, shell string) error {
+	switch shell {
+	case "bash":
+		writeBashCompletion(w)
+	case "zsh":
+		// zsh runs the bash script through its compatibility layer
+		fmt.Fprintln(w, "#compdef daved")
+		fmt.Fprintln(w, "autoload -U +X bashcompinit && bashcompinit")
+		writeBashCompletion(w)
+	case "fish":
+		writeFishCompletion(w)
+	default:
+		return fmt.Errorf("unsupported shell %q, expected bash, zsh or fish", shell)
+	}
+	return nil
+}
+
+func writeBashCompletion(w io.Writer) {
+	fileFlagNames := make([]string, 0, len(fileFlags))
+	for name := range fileFlags {
+		fileFlagNames = append(fileFlagNames, "-"+name)
+	}
+	sort.Strings(fileFlagNames)
+	fmt.Fprintln(w, "_daved() {")
+	fmt.Fprintln(w, `	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}" cmd="" i`)
+	fmt.Fprintf(w, "\tcase \"$prev\" in\n\t\t%s)\n\t\t\tCOMPREPLY=($(compgen -f -- \"$cur\")); return;;\n\tesac\n", strings.Join(fileFlagNames, "|"))
+	fmt.Fprintln(w, `	if [[ "$cur" == -* ]]; then`)
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\")); return\n", strings.Join(flagNames(), " "))
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, `	for ((i = 1; i < COMP_CWORD; i++)); do`)
+	fmt.Fprintln(w, `		case " `+strings.Join(commandNames(), " ")+` " in *" ${COMP_WORDS[i]} "*) cmd="${COMP_WORDS[i]}"; break;; esac`)
+	fmt.Fprintln(w, "\tdone")
+	fmt.Fprintln(w, `	case "$cmd" in`)
+	fmt.Fprintf(w, "\t\t\"\") COMPREPLY=($(compgen -W %q -- \"$cur\"));;\n", strings.Join(commandNames(), " "))
+	for _, c := range commands {
+		switch {
+		case len(c.Sub) > 0 && c.Files:
+			fmt.Fprintf(w, "\t\t%s) if [[ \"$prev\" == %s ]]; then COMPREPLY=($(compgen -W %q -- \"$cur\")); else COMPREPLY=($(compgen -f -- \"$cur\")); fi;;\n", c.Name, c.Name, strings.Join(c.Sub, " "))
+		case len(c.Sub) > 0:
+			fmt.Fprintf(w, "\t\t%s) [[ \"$prev\" == %s ]] && COMPREPLY=($(compgen -W %q -- \"$cur\"));;\n", c.Name, c.Name, strings.Join(c.Sub, " "))
+		case c.Files:
+			fmt.Fprintf(w, "\t\t%s) COMPREPLY=($(compgen -f -- \"$cur\"));;\n", c.Name)
+		}
+	}
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -o filenames -F _daved daved")
+}
+
+func writeFishCompletion(w io.Writer) {
+	for _, c := range commands {
+		fmt.Fprintf(w, "complete -c daved -f -n __fish_use_subcommand -a %s -d %s\n", c.Name, fishQuote(c.Summary))
+		if len(c.Sub) > 0 {
+			fmt.Fprintf(w, "complete -c daved -f -n '__fish_seen_subcommand_from %s' -a %s\n", c.Name, fishQuote(strings.Join(c.Sub, " ")))
+		}
+		if c.Files {
+			fmt.Fprintf(w, "complete -c daved -F -n '__fish_seen_subcommand_from %s'\n", c.Name)
+		}
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		if fileFlags[f.Name] {
+			fmt.Fprintf(w, "complete -c daved -r -F -o %s -d %s\n", f.Name, fishQuote(f.Usage))
+		} else {
+			fmt.Fprintf(w, "complete -c daved -o %s -d %s\n", f.Name, fishQuote(f.Usage))
+		}
+	})
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), "'", `\'`) + "'"
+}
+
+func writeManPage(w io.Writer) {
+	fmt.Fprintln(w, `.TH DAVED 1 "" "daved `+strings.TrimSpace(commit)+`" "User Commands"`)
+	fmt.Fprintln(w, ".SH NAME")
+	fmt.Fprintln(w, `daved \- node and client for the dave distributed key-value store`)
+	fmt.Fprintln(w, ".SH SYNOPSIS")
+	fmt.Fprintln(w, `.B daved
+[\fIOPTIONS\fR] [\fICOMMAND\fR [\fIARGS\fR]]`)
+	fmt.Fprintln(w, ".SH DESCRIPTION")
+	fmt.Fprintln(w, "Without a command, daved runs a node until interrupted. With a command, it runs the command and exits. Commands reading the node's store use the HTTP API of the node at \\fB\\-api_listen_addr\\fR.")
+	fmt.Fprintln(w, ".SH COMMANDS")
+	for _, c := range commands {
+		fmt.Fprintf(w, ".TP\n.B %s\n%s\n", roffEscape(strings.TrimSpace(c.Name+" "+c.Args)), roffEscape(c.Summary))
+	}
+	fmt.Fprintln(w, ".SH OPTIONS")
+	flag.VisitAll(func(f *flag.Flag) {
+		name, usage := flag.UnquoteUsage(f)
+		fmt.Fprintf(w, ".TP\n.B \\-%s", roffEscape(f.Name))
+		if name != "" {
+			fmt.Fprintf(w, " \\fI%s\\fR", roffEscape(name))
+		}
+		fmt.Fprintf(w, "\n%s", roffEscape(usage))
+		if f.DefValue != "" && f.DefValue != "0" && f.DefValue != "false" {
+			fmt.Fprintf(w, " Default %s.", roffEscape(f.DefValue))
+		}
+		fmt.Fprintln(w)
+	})
+	fmt.Fprintln(w, ".SH EXIT STATUS")
+	for _, s := range []struct {
+		code int
+		desc string
+	}{
+		{errs.OK, "Success."},
+		{errs.General, "Unclassified failure."},
+		{errs.Usage, "Bad command line arguments."},
+		{errs.Config, "Config file or flags invalid."},
+		{errs.Key, "Key file missing or invalid."},
+		{errs.Timeout, "Network operation timed out."},
+		{errs.NotFound, "Requested dat was not found."},
+		{errs.Verification, "Signature or proof-of-work is invalid."},
+	} {
+		fmt.Fprintf(w, ".TP\n.B %d\n%s\n", s.code, s.desc)
+	}
+}
+
+func roffEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\e`)
+	s = strings.ReplaceAll(s, "-", `\-`)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/intob/daved/cfg"
+	"github.com/intob/daved/errs"
+)
+
+// Runs config subcommands. Called before the config is parsed, so that
+// validate can report every problem of an invalid config.
+func configCommand(opt *cmdOptions, flags *cfg.NodeCfgUnparsed, filename string) {
+	switch flag.Arg(1) {
+	case "validate":
+		problems := cfg.Validate(filename, opt.Profile, flags)
+		if opt.DataKeyFilename != "" {
+			if err := cfg.CheckKeyFile(opt.DataKeyFilename); err != nil {
+				problems = append(problems, fmt.Errorf("data_key_filename: %w", err))
+			}
+		}
+		msgs := make([]string, 0, len(problems))
+		for _, p := range problems {
+			msgs = append(msgs, p.Error())
+		}
+		if jsonOutput {
+			printJSON(map[string]any{"valid": len(problems) == 0, "problems": msgs})
+		} else if len(problems) == 0 {
+			fmt.Println("config is valid")
+		} else {
+			for _, m := range msgs {
+				fmt.Println(m)
+			}
+		}
+		if len(problems) > 0 {
+			if jsonOutput {
+				os.Exit(errs.Config)
+			}
+			exit(errs.Config, "problems found: %d", len(problems))
+		}
+	case "show":
+		var file *cfg.NodeCfgUnparsed
+		if filename != "" {
+			var err error
+			file, err = cfg.ReadNodeCfgFile(filename)
+			if err != nil {
+				exit(errs.Config, "failed to read config file: %s", err)
+			}
+			file, err = cfg.SelectProfile(file, opt.Profile)
+			if err != nil {
+				exit(errs.Config, "failed to select profile: %s", err)
+			}
+		}
+		effective := flag.Arg(2) == "--effective" || flag.Arg(2) == "-effective"
+		settings := cfg.Effective(file, flags, effective)
+		if jsonOutput {
+			printJSON(settings)
+			break
+		}
+		for _, s := range settings {
+			source := s.Source
+			if source == "" {
+				source = "unset"
+			}
+			fmt.Printf("%s: %v  # %s\n", s.Key, s.Value, source)
+		}
+	default:
+		exit(errs.Usage, "correct usage is config <validate|show [--effective]>")
+	}
+}
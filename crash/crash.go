@@ -0,0 +1,118 @@
+// Package crash writes a report when the process panics, with the stacks of
+// all goroutines, the config with secrets redacted and the recent log, so
+// that something useful can be attached to a bug report.
+package crash
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/intob/daved/cfg"
+	"github.com/intob/daved/logtail"
+)
+
+// Log lines included in a report.
+const reportLogLines = 200
+
+type Cfg struct {
+	Dir      string               // Default os.TempDir()
+	Commit   string               // Build commit
+	Settings func() []cfg.Setting // Optional, called when reporting
+	Logs     *logtail.Tail        // Optional
+}
+
+var (
+	mu       sync.Mutex
+	current  = &Cfg{}
+	reported bool
+)
+
+// Init sets what reports contain and where they are written. Panics before
+// Init are reported without config or logs.
+func Init(c *Cfg) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = c
+}
+
+// Recover must be deferred directly. On panic it writes a report, then
+// panics again so the process exits as it would have.
+func Recover() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	filename, err := write(r, debug.Stack())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write crash report: %s\n", err)
+	} else if filename != "" {
+		fmt.Fprintf(os.Stderr, "crash report written to %s\n", filename)
+	}
+	panic(r)
+}
+
+// Go runs f in a new goroutine that reports panics.
+func Go(f func()) {
+	go func() {
+		defer Recover()
+		f()
+	}()
+}
+
+// Writes a report, unless one has been written already, returning its
+// filename.
+func write(r any, stack []byte) (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if reported {
+		return "", nil
+	}
+	reported = true
+	dir := current.Dir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	now := time.Now().UTC()
+	filename := filepath.Join(dir, fmt.Sprintf("daved-crash-%s.txt", now.Format("20060102T150405Z")))
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	writeReport(f, current, now, r, stack)
+	return filename, f.Close()
+}
+
+func writeReport(w io.Writer, c *Cfg, now time.Time, r any, stack []byte) {
+	fmt.Fprintf(w, "daved crash report\n\ntime: %s\ncommit: %s\ngo: %s %s/%s\n", now.Format(time.RFC3339), c.Commit, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(w, "\npanic: %v\n\n%s", r, stack)
+	fmt.Fprintf(w, "\nconfig (set by file or flags):\n")
+	if c.Settings != nil {
+		for _, s := range c.Settings() {
+			fmt.Fprintf(w, "%s: %v  # %s\n", s.Key, s.Value, s.Source)
+		}
+	}
+	fmt.Fprintf(w, "\nrecent log:\n")
+	if c.Logs != nil {
+		for _, line := range c.Logs.Lines(reportLogLines) {
+			fmt.Fprintln(w, line)
+		}
+	}
+	fmt.Fprintf(w, "\nall goroutines:\n%s", allStacks())
+}
+
+func allStacks() []byte {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= 64<<20 {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
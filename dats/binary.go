@@ -0,0 +1,73 @@
+package dats
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/intob/godave/dat"
+	"github.com/intob/godave/network"
+)
+
+// Binary encoding of a dat, a frame of daved's own rather than the format
+// godave sends between peers. Lengths and time, in unix milliseconds, are
+// big-endian:
+//
+//	key length (1) | key | val length (2) | val | time (8) | salt (16) | work (32) | sig (64) | pubkey (32)
+//
+// Dats that would encode larger than network.MAX_MSG_LEN are refused.
+const binaryFixedLen = 1 + 2 + 8 + len(dat.Salt{}) + len(dat.Work{}) + len(dat.Signature{}) + ed25519.PublicKeySize
+
+var ErrMalformed = errors.New("malformed binary dat")
+
+// AppendBinary appends the binary encoding of d to b.
+func AppendBinary(b []byte, d *dat.Dat) ([]byte, error) {
+	if len(d.Key) > 0xff {
+		return nil, fmt.Errorf("key is longer than 255 bytes")
+	}
+	if binaryFixedLen+len(d.Key)+len(d.Val) > network.MAX_MSG_LEN {
+		return nil, fmt.Errorf("dat is larger than %d bytes", network.MAX_MSG_LEN)
+	}
+	if len(d.PubKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid pubkey")
+	}
+	b = append(b, byte(len(d.Key)))
+	b = append(b, d.Key...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(d.Val)))
+	b = append(b, d.Val...)
+	b = binary.BigEndian.AppendUint64(b, uint64(d.Time.UnixMilli()))
+	b = append(b, d.Salt[:]...)
+	b = append(b, d.Work[:]...)
+	b = append(b, d.Sig[:]...)
+	return append(b, d.PubKey...), nil
+}
+
+// DecodeBinary decodes a dat from the start of b, returning it and the
+// number of bytes read.
+func DecodeBinary(b []byte) (*dat.Dat, int, error) {
+	if len(b) < binaryFixedLen {
+		return nil, 0, ErrMalformed
+	}
+	d := &dat.Dat{}
+	n := 1 + int(b[0])
+	if len(b) < n+2 {
+		return nil, 0, ErrMalformed
+	}
+	d.Key = string(b[1:n])
+	valLen := int(binary.BigEndian.Uint16(b[n:]))
+	n += 2
+	if len(b) < n+valLen+binaryFixedLen-3 {
+		return nil, 0, ErrMalformed
+	}
+	d.Val = append([]byte(nil), b[n:n+valLen]...)
+	n += valLen
+	d.Time = time.UnixMilli(int64(binary.BigEndian.Uint64(b[n:])))
+	n += 8
+	n += copy(d.Salt[:], b[n:])
+	n += copy(d.Work[:], b[n:])
+	n += copy(d.Sig[:], b[n:])
+	d.PubKey = append(ed25519.PublicKey(nil), b[n:n+ed25519.PublicKeySize]...)
+	return d, n + ed25519.PublicKeySize, nil
+}
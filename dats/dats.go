@@ -0,0 +1,216 @@
+// Package dats defines the JSONL format of signed dats outside of a running
+// node, as written to bundles and history files and read by inspect and diff.
+//
+// Each line is one JSON object. Binary fields are base64 (raw URL encoding),
+// time is unix milliseconds:
+//
+//	{"key":"k","val":"dg","time":1700000000000,"salt":"...","work":"...","pubkey":"...","sig":"..."}
+package dats
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/intob/godave/dat"
+)
+
+type Record struct {
+	Key    string `json:"key"`
+	Val    string `json:"val" binary:"base64"`
+	Time   int64  `json:"time"`
+	Salt   string `json:"salt" binary:"base64"`
+	Work   string `json:"work" binary:"base64"`
+	PubKey string `json:"pubkey" binary:"base64"`
+	Sig    string `json:"sig" binary:"base64"`
+}
+
+func FromDat(d *dat.Dat) *Record {
+	return &Record{
+		Key:    d.Key,
+		Val:    base64.RawURLEncoding.EncodeToString(d.Val),
+		Time:   d.Time.UnixMilli(),
+		Salt:   base64.RawURLEncoding.EncodeToString(d.Salt[:]),
+		Work:   base64.RawURLEncoding.EncodeToString(d.Work[:]),
+		PubKey: base64.RawURLEncoding.EncodeToString(d.PubKey),
+		Sig:    base64.RawURLEncoding.EncodeToString(d.Sig[:]),
+	}
+}
+
+func (r *Record) Dat() (*dat.Dat, error) {
+	val, err := base64.RawURLEncoding.DecodeString(r.Val)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode val: %w", err)
+	}
+	salt, err := base64.RawURLEncoding.DecodeString(r.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode salt: %w", err)
+	}
+	work, err := base64.RawURLEncoding.DecodeString(r.Work)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode work: %w", err)
+	}
+	pubKey, err := decodeFixed(r.PubKey, ed25519.PublicKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode pubkey: %w", err)
+	}
+	sig, err := decodeFixed(r.Sig, ed25519.SignatureSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode sig: %w", err)
+	}
+	return &dat.Dat{
+		Key:    r.Key,
+		Val:    val,
+		Time:   time.UnixMilli(r.Time),
+		Salt:   dat.Salt(salt),
+		Work:   dat.Work(work),
+		PubKey: ed25519.PublicKey(pubKey),
+		Sig:    dat.Signature(sig),
+	}, nil
+}
+
+func decodeFixed(s string, size int) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != size {
+		return nil, fmt.Errorf("expected %d bytes, got %d", size, len(b))
+	}
+	return b, nil
+}
+
+type Writer struct {
+	enc *json.Encoder
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{enc: json.NewEncoder(w)}
+}
+
+func (w *Writer) Write(d *dat.Dat) error {
+	return w.enc.Encode(FromDat(d))
+}
+
+type Reader struct {
+	scanner *bufio.Scanner
+	line    int
+	err     error // Returned by every Read after the first read error
+}
+
+// DecodeError is a record that could not be decoded. Reading may continue
+// after it, unlike after other errors.
+type DecodeError struct {
+	Line int
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &Reader{scanner: scanner}
+}
+
+// Read returns the next dat, or io.EOF when there are no more records.
+// Records that fail to decode return a *DecodeError, and reading may
+// continue after them. Other errors, such as a line too long or a truncated
+// file, are returned by every later Read.
+func (r *Reader) Read() (*dat.Dat, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	for r.scanner.Scan() {
+		r.line++
+		text := strings.TrimSpace(r.scanner.Text())
+		if text == "" {
+			continue
+		}
+		rec := &Record{}
+		err := json.Unmarshal([]byte(text), rec)
+		if err != nil {
+			return nil, &DecodeError{Line: r.line, Err: err}
+		}
+		d, err := rec.Dat()
+		if err != nil {
+			return nil, &DecodeError{Line: r.line, Err: err}
+		}
+		return d, nil
+	}
+	r.err = r.scanner.Err()
+	if r.err == nil {
+		r.err = io.EOF
+	}
+	return nil, r.err
+}
+
+// Create opens filename for writing, compressing with gzip if the name
+// ends in .gz.
+func Create(filename string) (io.WriteCloser, error) {
+	if strings.HasSuffix(filename, ".zst") {
+		return nil, errors.New("zstd is not supported, use .gz")
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(filename, ".gz") {
+		return &gzipFile{Writer: gzip.NewWriter(f), f: f}, nil
+	}
+	return f, nil
+}
+
+// Open opens filename for reading, decompressing if the name ends in .gz.
+func Open(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(filename, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &gzipReader{Reader: zr, f: f}, nil
+	}
+	return f, nil
+}
+
+type gzipFile struct {
+	*gzip.Writer
+	f *os.File
+}
+
+func (g *gzipFile) Close() error {
+	if err := g.Writer.Close(); err != nil {
+		g.f.Close()
+		return err
+	}
+	return g.f.Close()
+}
+
+type gzipReader struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g *gzipReader) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
@@ -0,0 +1,42 @@
+package dats
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"strings"
+
+	"github.com/intob/godave/dat"
+)
+
+// Filter selects dats by owner, key prefix, proof-of-work and value size,
+// for subscribers that want only part of what the node stores. Zero fields
+// match any dat.
+type Filter struct {
+	PubKey  ed25519.PublicKey
+	Prefix  string
+	MinWork int // Leading zero bits
+	MinSize int // Bytes of value
+	MaxSize int // Zero is unlimited
+}
+
+// Match reports whether d passes every condition of the filter.
+func (f *Filter) Match(d *dat.Dat) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.PubKey) > 0 && !bytes.Equal(d.PubKey, f.PubKey) {
+		return false
+	}
+	if !strings.HasPrefix(d.Key, f.Prefix) {
+		return false
+	}
+	if f.MinWork > 0 && WorkBits(d.Work[:]) < f.MinWork {
+		return false
+	}
+	return len(d.Val) >= f.MinSize && (f.MaxSize == 0 || len(d.Val) <= f.MaxSize)
+}
+
+// IsZero reports whether the filter matches every dat.
+func (f *Filter) IsZero() bool {
+	return f == nil || (len(f.PubKey) == 0 && f.Prefix == "" && f.MinWork == 0 && f.MinSize == 0 && f.MaxSize == 0)
+}
@@ -0,0 +1,74 @@
+package dats
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/intob/godave/dat"
+)
+
+// A link is a dat whose value refers to another dat, as
+// dave://<pubkey>/<key>, so a mutable name such as "latest" can point at
+// content that is never rewritten.
+const LinkScheme = "dave://"
+
+var (
+	ErrLinkDepth = errors.New("too many links")
+	ErrLinkCycle = errors.New("link cycle")
+)
+
+type Link struct {
+	PubKey ed25519.PublicKey
+	Key    string
+}
+
+func (l *Link) String() string {
+	return LinkScheme + base64.RawURLEncoding.EncodeToString(l.PubKey) + "/" + l.Key
+}
+
+// ParseLink parses the value of a link. Values that are not links return
+// an error.
+func ParseLink(val []byte) (*Link, error) {
+	rest, ok := bytes.CutPrefix(val, []byte(LinkScheme))
+	if !ok {
+		return nil, fmt.Errorf("value does not start with %s", LinkScheme)
+	}
+	pubKey, key, ok := strings.Cut(string(rest), "/")
+	if !ok || key == "" {
+		return nil, errors.New("link has no key")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(pubKey)
+	if err != nil || len(b) != ed25519.PublicKeySize {
+		return nil, errors.New("link has an invalid pubkey")
+	}
+	return &Link{PubKey: b, Key: key}, nil
+}
+
+// FollowLinks follows links from d through at most depth dats, returning
+// the first that is not a link, or nil if a link's target was not found.
+// get returns the newest version of a dat, or nil.
+func FollowLinks(ctx context.Context, get func(context.Context, ed25519.PublicKey, string) (*dat.Dat, error), d *dat.Dat, depth int) (*dat.Dat, error) {
+	seen := map[string]bool{string(d.PubKey) + d.Key: true}
+	for i := 0; ; i++ {
+		l, err := ParseLink(d.Val)
+		if err != nil {
+			return d, nil
+		}
+		if i == depth {
+			return nil, fmt.Errorf("%w: more than %d", ErrLinkDepth, depth)
+		}
+		if seen[string(l.PubKey)+l.Key] {
+			return nil, fmt.Errorf("%w at %s", ErrLinkCycle, l)
+		}
+		seen[string(l.PubKey)+l.Key] = true
+		d, err = get(ctx, l.PubKey, l.Key)
+		if err != nil || d == nil {
+			return nil, err
+		}
+	}
+}
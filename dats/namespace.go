@@ -0,0 +1,39 @@
+package dats
+
+import "fmt"
+
+const (
+	NamespaceSeparator = "/"
+	maxNamespaceLen    = 64
+)
+
+// ValidateNamespace checks that ns is a single path segment made of
+// letters, digits, '-', '_' or '.'.
+func ValidateNamespace(ns string) error {
+	if ns == "" {
+		return fmt.Errorf("namespace is empty")
+	}
+	if len(ns) > maxNamespaceLen {
+		return fmt.Errorf("namespace is longer than %d characters", maxNamespaceLen)
+	}
+	for _, c := range ns {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			return fmt.Errorf("namespace contains invalid character %q", c)
+		}
+	}
+	return nil
+}
+
+// NamespacedKey returns key prefixed with ns, or key unchanged if ns is empty.
+func NamespacedKey(ns, key string) (string, error) {
+	if ns == "" {
+		return key, nil
+	}
+	if err := ValidateNamespace(ns); err != nil {
+		return "", err
+	}
+	return ns + NamespaceSeparator + key, nil
+}
@@ -0,0 +1,60 @@
+package dats
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+
+	"github.com/intob/daved/errs"
+	"github.com/intob/godave/dat"
+	"github.com/intob/godave/network"
+	"lukechampine.com/blake3"
+)
+
+// Verify checks the signature and proof-of-work of d, as peers do before
+// storing a dat. The work must meet network.MIN_WORK. Failures are wrapped
+// with errs.ErrVerification.
+func Verify(d *dat.Dat) error {
+	return errs.Wrap(errs.ErrVerification, verify(d))
+}
+
+func verify(d *dat.Dat) error {
+	if len(d.PubKey) != ed25519.PublicKeySize {
+		return errors.New("invalid public key length")
+	}
+	if !ed25519.Verify(d.PubKey, signingDigest(d), d.Sig[:]) {
+		return errors.New("invalid signature")
+	}
+	if blake3.Sum256(append(d.Sig[:], d.Salt[:]...)) != d.Work {
+		return errors.New("work does not match signature and salt")
+	}
+	if n := WorkBits(d.Work[:]); n < int(network.MIN_WORK) {
+		return fmt.Errorf("work has %d leading zero bits, less than %d", n, network.MIN_WORK)
+	}
+	return nil
+}
+
+// The message godave's (*dat.Dat).Sign signs: the BLAKE3 hash of the key,
+// value and time in unix milliseconds (8 bytes, big-endian).
+func signingDigest(d *dat.Dat) []byte {
+	h := blake3.New(32, nil)
+	h.Write([]byte(d.Key))
+	h.Write(d.Val)
+	h.Write(binary.BigEndian.AppendUint64(nil, uint64(d.Time.UnixMilli())))
+	return h.Sum(nil)
+}
+
+// WorkBits returns the number of leading zero bits of work, the difficulty
+// actually achieved.
+func WorkBits(work []byte) int {
+	n := 0
+	for _, b := range work {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
@@ -0,0 +1,70 @@
+package dats
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/intob/daved/errs"
+	"github.com/intob/godave/dat"
+	"github.com/intob/godave/network"
+)
+
+func signedDat(t *testing.T) *dat.Dat {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &dat.Dat{Key: "test", Val: []byte("hello"), Time: time.UnixMilli(1700000000000), PubKey: pub}
+	d.Sign(priv)
+	d.Work, d.Salt = dat.DoWork(d.Sig, network.MIN_WORK)
+	return d
+}
+
+func TestVerify(t *testing.T) {
+	d := signedDat(t)
+	if err := Verify(d); err != nil {
+		t.Fatalf("verify a dat signed by godave: %s", err)
+	}
+	tests := []struct {
+		name   string
+		tamper func(d *dat.Dat)
+	}{
+		{"key", func(d *dat.Dat) { d.Key += "x" }},
+		{"val", func(d *dat.Dat) { d.Val = []byte("hellO") }},
+		{"time", func(d *dat.Dat) { d.Time = d.Time.Add(time.Millisecond) }},
+		{"sig", func(d *dat.Dat) { d.Sig[0] ^= 1 }},
+		{"salt", func(d *dat.Dat) { d.Salt[0] ^= 1 }},
+		{"work", func(d *dat.Dat) { d.Work[31] ^= 1 }},
+		{"pubkey", func(d *dat.Dat) { d.PubKey = d.PubKey[:16] }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := signedDat(t)
+			tt.tamper(d)
+			err := Verify(d)
+			if !errors.Is(err, errs.ErrVerification) {
+				t.Errorf("verify with tampered %s: got %v, want a verification error", tt.name, err)
+			}
+		})
+	}
+}
+
+func TestWorkBits(t *testing.T) {
+	tests := []struct {
+		work []byte
+		want int
+	}{
+		{[]byte{0x80}, 0},
+		{[]byte{0x01}, 7},
+		{[]byte{0x00, 0x40}, 9},
+		{[]byte{0x00, 0x00}, 16},
+	}
+	for _, tt := range tests {
+		if got := WorkBits(tt.work); got != tt.want {
+			t.Errorf("WorkBits(%x) = %d, want %d", tt.work, got, tt.want)
+		}
+	}
+}
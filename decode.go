@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/intob/daved/wire"
+)
+
+// Prints each message in a pcap capture, or a file of hex lines.
+func decodeCapture(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == ".pcap" || ext == ".cap" {
+		r, err := wire.NewPcapReader(f)
+		if err != nil {
+			return err
+		}
+		for {
+			pkt, err := r.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			wire.Print(os.Stdout, pkt)
+			fmt.Println()
+		}
+	}
+	packets, err := wire.ReadHex(f)
+	if err != nil {
+		return err
+	}
+	for _, pkt := range packets {
+		wire.Print(os.Stdout, pkt)
+		fmt.Println()
+	}
+	return nil
+}
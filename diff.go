@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/intob/daved/dats"
+	"github.com/intob/daved/errs"
+)
+
+// A dat missing from one side, or held in different versions.
+type datDifference struct {
+	PubKey string `json:"pubkey"`
+	Key    string `json:"key"`
+	A      int64  `json:"a,omitempty"` // Unix milli time on side A, zero if missing
+	B      int64  `json:"b,omitempty"`
+}
+
+type diffReport struct {
+	A           int             `json:"a"` // Dats on each side
+	B           int             `json:"b"`
+	OnlyA       int             `json:"only_a"`
+	OnlyB       int             `json:"only_b"`
+	Mismatched  int             `json:"mismatched"` // Held by both in different versions
+	Differences []datDifference `json:"differences"`
+}
+
+// Reads a bundle or history file, returning the unix milli time of the
+// newest version of each dat by public key and key.
+func readDatTimes(filename string) (map[[2]string]int64, error) {
+	f, err := dats.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	reader := dats.NewReader(f)
+	times := make(map[[2]string]int64)
+	for i := 1; ; i++ {
+		d, err := reader.Read()
+		if err == io.EOF {
+			return times, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+		id := [2]string{base64.RawURLEncoding.EncodeToString(d.PubKey), d.Key}
+		if t := d.Time.UnixMilli(); t > times[id] {
+			times[id] = t
+		}
+	}
+}
+
+// Compares the dats of two bundle or history files, exiting with
+// errs.General if they differ.
+func diffCommand(a, b string) {
+	ta, err := readDatTimes(a)
+	if err != nil {
+		exit(errs.Usage, "failed to read %s: %s", a, err)
+	}
+	tb, err := readDatTimes(b)
+	if err != nil {
+		exit(errs.Usage, "failed to read %s: %s", b, err)
+	}
+	report := diffDatTimes(ta, tb)
+	if jsonOutput {
+		printJSON(report)
+	} else {
+		for _, d := range report.Differences {
+			switch {
+			case d.B == 0:
+				fmt.Printf("< %s %s %s\n", d.PubKey, d.Key, formatMilli(d.A))
+			case d.A == 0:
+				fmt.Printf("> %s %s %s\n", d.PubKey, d.Key, formatMilli(d.B))
+			default:
+				fmt.Printf("! %s %s %s %s\n", d.PubKey, d.Key, formatMilli(d.A), formatMilli(d.B))
+			}
+		}
+		info("%s: %d dats, %s: %d dats; %d only in %s, %d only in %s, %d in different versions",
+			a, report.A, b, report.B, report.OnlyA, a, report.OnlyB, b, report.Mismatched)
+	}
+	if len(report.Differences) > 0 {
+		os.Exit(errs.General)
+	}
+}
+
+// Reports differences ordered by public key and key.
+func diffDatTimes(a, b map[[2]string]int64) *diffReport {
+	r := &diffReport{A: len(a), B: len(b), Differences: make([]datDifference, 0)}
+	for id, ta := range a {
+		tb := b[id]
+		switch {
+		case ta == tb:
+			continue
+		case tb == 0:
+			r.OnlyA++
+		default:
+			r.Mismatched++
+		}
+		r.Differences = append(r.Differences, datDifference{PubKey: id[0], Key: id[1], A: ta, B: tb})
+	}
+	for id, tb := range b {
+		if _, ok := a[id]; !ok {
+			r.OnlyB++
+			r.Differences = append(r.Differences, datDifference{PubKey: id[0], Key: id[1], B: tb})
+		}
+	}
+	sort.Slice(r.Differences, func(i, j int) bool {
+		if r.Differences[i].PubKey != r.Differences[j].PubKey {
+			return r.Differences[i].PubKey < r.Differences[j].PubKey
+		}
+		return r.Differences[i].Key < r.Differences[j].Key
+	})
+	return r
+}
+
+func formatMilli(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,196 @@
+// Package dns answers DNS queries for a zone from dats, so dave can back
+// naming experiments without a registrar. A name's last label below the
+// zone is the lower case, unpadded base32 of a public key, and records are
+// read from dats under that key:
+//
+//	_dave.<key>.<pubkey>.<zone>  TXT with the value of dat <key>
+//	<host>.<pubkey>.<zone>       records in the value of dat dns/<host>, or dns/@ at <pubkey>.<zone>
+//
+// A host's value holds one record per line, as TYPE DATA, e.g. "A 192.0.2.1",
+// "AAAA 2001:db8::1" or "TXT hello". Only UDP is served, without EDNS.
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base32"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intob/daved/logbuf"
+	"github.com/intob/godave/dat"
+)
+
+const (
+	maxQueries = 256 // In flight, more are dropped so clients retry
+	timeout    = 2 * time.Second
+	hostPrefix = "dns/"
+)
+
+var labelEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Store is the node, which reads through its cache.
+type Store interface {
+	Get(ctx context.Context, pubKey ed25519.PublicKey, key string) (*dat.Dat, error)
+}
+
+type ServerCfg struct {
+	Addr  string
+	Zone  string // Lower case, with a trailing dot
+	TTL   time.Duration
+	Store Store
+	Logs  chan<- string
+}
+
+type Server struct {
+	cfg     *ServerCfg
+	conn    net.PacketConn
+	queries chan struct{} // Semaphore
+	log     logbuf.Logger
+}
+
+// Label returns the label of pubKey in names.
+func Label(pubKey ed25519.PublicKey) string {
+	return strings.ToLower(labelEncoding.EncodeToString(pubKey))
+}
+
+// NewServer listens on cfg.Addr, so the address is in use once it returns.
+func NewServer(cfg *ServerCfg) (*Server, error) {
+	conn, err := net.ListenPacket("udp", cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	return &Server{
+		cfg:     cfg,
+		conn:    conn,
+		queries: make(chan struct{}, maxQueries),
+		log:     logbuf.For(cfg.Logs, "dns"),
+	}, nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+// Run answers queries until ctx is cancelled.
+func (s *Server) Run(ctx context.Context) {
+	s.log.Printf("listening on %s for %s", s.Addr(), s.cfg.Zone)
+	stop := context.AfterFunc(ctx, func() { s.conn.Close() })
+	defer stop()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.log.Printf("failed to read: %s", err)
+			}
+			return
+		}
+		q, err := parseQuery(buf[:n])
+		if err != nil {
+			s.log.Debugf("%s from %s", err, addr)
+			continue
+		}
+		select {
+		case s.queries <- struct{}{}:
+		default:
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-s.queries }()
+			rcode, answers := s.answer(ctx, q)
+			_, err := s.conn.WriteTo(q.response(rcode, uint32(s.cfg.TTL/time.Second), answers), addr)
+			if err != nil && ctx.Err() == nil {
+				s.log.Debugf("failed to reply to %s: %s", addr, err)
+			}
+		}()
+	}
+}
+
+// Returns the response code and answers of q.
+func (s *Server) answer(ctx context.Context, q *query) (int, []record) {
+	if q.flags&maskOpcode != 0 {
+		return RCODE_NOTIMP, nil
+	}
+	if q.qclass != CLASS_IN && q.qclass != CLASS_ANY {
+		return RCODE_REFUSED, nil
+	}
+	if q.name == s.cfg.Zone {
+		return RCODE_OK, nil
+	}
+	sub, ok := strings.CutSuffix(q.name, "."+s.cfg.Zone)
+	if !ok {
+		return RCODE_REFUSED, nil
+	}
+	labels := strings.Split(sub, ".")
+	pubKey, err := labelEncoding.DecodeString(strings.ToUpper(labels[len(labels)-1]))
+	if err != nil || len(pubKey) != ed25519.PublicKeySize {
+		return RCODE_NXDOMAIN, nil
+	}
+	labels = labels[:len(labels)-1]
+	var key string
+	if len(labels) > 1 && labels[0] == "_dave" {
+		key = strings.Join(labels[1:], ".")
+	} else if len(labels) == 0 {
+		key = hostPrefix + "@"
+	} else {
+		key = hostPrefix + strings.Join(labels, ".")
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	d, err := s.cfg.Store.Get(ctx, pubKey, key)
+	if err != nil {
+		s.log.Debugf("failed to get %s: %s", key, err)
+		return RCODE_SERVFAIL, nil
+	}
+	if d == nil {
+		return RCODE_NXDOMAIN, nil
+	}
+	var records []record
+	if strings.HasPrefix(key, hostPrefix) {
+		records = parseRecords(d.Val)
+	} else {
+		records = []record{{rtype: TYPE_TXT, data: txtData(d.Val)}}
+	}
+	answers := records[:0]
+	for _, rr := range records {
+		if q.qtype == TYPE_ANY || rr.rtype == q.qtype {
+			answers = append(answers, rr)
+		}
+	}
+	return RCODE_OK, answers
+}
+
+// Parses the records of a host, skipping lines that are not records.
+func parseRecords(val []byte) []record {
+	var records []record
+	sc := bufio.NewScanner(bytes.NewReader(val))
+	for sc.Scan() {
+		rtype, data, _ := strings.Cut(strings.TrimSpace(sc.Text()), " ")
+		data = strings.TrimSpace(data)
+		switch strings.ToUpper(rtype) {
+		case "A":
+			if addr, err := netip.ParseAddr(data); err == nil && addr.Is4() {
+				records = append(records, record{rtype: TYPE_A, data: addr.AsSlice()})
+			}
+		case "AAAA":
+			if addr, err := netip.ParseAddr(data); err == nil && addr.Is6() && !addr.Is4In6() {
+				records = append(records, record{rtype: TYPE_AAAA, data: addr.AsSlice()})
+			}
+		case "TXT":
+			records = append(records, record{rtype: TYPE_TXT, data: txtData([]byte(data))})
+		}
+	}
+	return records
+}
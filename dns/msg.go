@@ -0,0 +1,136 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// Record types and classes
+const (
+	TYPE_A    = 1
+	TYPE_TXT  = 16
+	TYPE_AAAA = 28
+	TYPE_ANY  = 255
+	CLASS_IN  = 1
+	CLASS_ANY = 255
+)
+
+// Response codes
+const (
+	RCODE_OK       = 0
+	RCODE_FORMERR  = 1
+	RCODE_SERVFAIL = 2
+	RCODE_NXDOMAIN = 3
+	RCODE_NOTIMP   = 4
+	RCODE_REFUSED  = 5
+)
+
+const (
+	headerLen  = 12
+	maxUDPLen  = 512 // Without EDNS, longer responses are truncated
+	flagQR     = 0x8000
+	flagAA     = 0x0400
+	flagTC     = 0x0200
+	flagRD     = 0x0100
+	maskOpcode = 0x7800
+)
+
+var errFormat = errors.New("malformed query")
+
+type query struct {
+	id       uint16
+	flags    uint16
+	name     string // Lower case, with a trailing dot
+	qtype    uint16
+	qclass   uint16
+	question []byte // As sent, echoed in the response
+}
+
+type record struct {
+	rtype uint16
+	data  []byte
+}
+
+// Parses a query with one question. Responses are refused, so two servers
+// can't answer each other in a loop, as are compressed names, as no client
+// compresses the only name of a query.
+func parseQuery(b []byte) (*query, error) {
+	if len(b) < headerLen || binary.BigEndian.Uint16(b[4:]) != 1 {
+		return nil, errFormat
+	}
+	q := &query{id: binary.BigEndian.Uint16(b), flags: binary.BigEndian.Uint16(b[2:])}
+	if q.flags&flagQR != 0 {
+		return nil, errFormat
+	}
+	var name strings.Builder
+	i := headerLen
+	for {
+		if i >= len(b) {
+			return nil, errFormat
+		}
+		n := int(b[i])
+		i++
+		if n == 0 {
+			break
+		}
+		if n > 63 || i+n > len(b) || name.Len()+n+1 > 254 { // 255 on the wire, with the root label
+			return nil, errFormat
+		}
+		name.WriteString(strings.ToLower(string(b[i : i+n])))
+		name.WriteByte('.')
+		i += n
+	}
+	if i+4 > len(b) {
+		return nil, errFormat
+	}
+	q.name = name.String()
+	if q.name == "" {
+		q.name = "."
+	}
+	q.qtype = binary.BigEndian.Uint16(b[i:])
+	q.qclass = binary.BigEndian.Uint16(b[i+2:])
+	q.question = bytes.Clone(b[headerLen : i+4]) // b is reused for the next packet
+	return q, nil
+}
+
+// Builds the response to q, answering with records for its name. If the
+// response is too long for UDP, it is truncated so the client retries.
+func (q *query) response(rcode int, ttl uint32, answers []record) []byte {
+	flags := flagQR | flagAA | q.flags&(maskOpcode|flagRD) | uint16(rcode)
+	b := make([]byte, headerLen, maxUDPLen)
+	binary.BigEndian.PutUint16(b, q.id)
+	binary.BigEndian.PutUint16(b[4:], 1)
+	binary.BigEndian.PutUint16(b[6:], uint16(len(answers)))
+	b = append(b, q.question...)
+	for _, rr := range answers {
+		b = append(b, 0xc0, headerLen) // Pointer to the question's name
+		b = binary.BigEndian.AppendUint16(b, rr.rtype)
+		b = binary.BigEndian.AppendUint16(b, CLASS_IN)
+		b = binary.BigEndian.AppendUint32(b, ttl)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(rr.data)))
+		b = append(b, rr.data...)
+	}
+	if len(b) > maxUDPLen {
+		b = append(b[:headerLen], q.question...)
+		binary.BigEndian.PutUint16(b[6:], 0)
+		flags |= flagTC
+	}
+	binary.BigEndian.PutUint16(b[2:], flags)
+	return b
+}
+
+// Returns the data of a TXT record, split into strings of 255 bytes.
+func txtData(s []byte) []byte {
+	b := make([]byte, 0, len(s)+len(s)/255+1)
+	for {
+		n := min(len(s), 255)
+		b = append(b, byte(n))
+		b = append(b, s[:n]...)
+		s = s[n:]
+		if len(s) == 0 {
+			return b
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// Builds a query for name, given as labels.
+func buildQuery(id, flags uint16, qdcount uint16, labels []string, qtype, qclass uint16) []byte {
+	b := binary.BigEndian.AppendUint16(nil, id)
+	b = binary.BigEndian.AppendUint16(b, flags)
+	b = binary.BigEndian.AppendUint16(b, qdcount)
+	b = append(b, 0, 0, 0, 0, 0, 0)
+	for _, l := range labels {
+		b = append(b, byte(len(l)))
+		b = append(b, l...)
+	}
+	b = append(b, 0)
+	b = binary.BigEndian.AppendUint16(b, qtype)
+	return binary.BigEndian.AppendUint16(b, qclass)
+}
+
+func TestParseQuery(t *testing.T) {
+	long := strings.Repeat("a", 63)
+	for _, tc := range []struct {
+		name string
+		in   []byte
+		want string // Name parsed, empty if the query is malformed
+	}{
+		{"lower cased", buildQuery(1, flagRD, 1, []string{"Key", "PubKey", "Dave", "Example", "org"}, TYPE_TXT, CLASS_IN), "key.pubkey.dave.example.org."},
+		{"root", buildQuery(1, 0, 1, nil, TYPE_A, CLASS_IN), "."},
+		{"longest label", buildQuery(1, 0, 1, []string{long, "org"}, TYPE_A, CLASS_IN), long + ".org."},
+		{"longest name", buildQuery(1, 0, 1, []string{long, long, long, strings.Repeat("b", 61)}, TYPE_A, CLASS_IN), long + "." + long + "." + long + "." + strings.Repeat("b", 61) + "."},
+		{"name too long", buildQuery(1, 0, 1, []string{long, long, long, strings.Repeat("b", 62)}, TYPE_A, CLASS_IN), ""},
+		{"label too long", buildQuery(1, 0, 1, []string{long + "a"}, TYPE_A, CLASS_IN), ""},
+		{"response", buildQuery(1, flagQR, 1, []string{"a"}, TYPE_A, CLASS_IN), ""},
+		{"no question", buildQuery(1, 0, 0, []string{"a"}, TYPE_A, CLASS_IN), ""},
+		{"two questions", buildQuery(1, 0, 2, []string{"a"}, TYPE_A, CLASS_IN), ""},
+		{"short header", []byte{0, 1, 0, 0, 0, 1}, ""},
+		{"truncated name", buildQuery(1, 0, 1, []string{"abc"}, TYPE_A, CLASS_IN)[:headerLen+3], ""},
+		{"no terminator", buildQuery(1, 0, 1, []string{"abc"}, TYPE_A, CLASS_IN)[:headerLen+4], ""},
+		{"truncated type", buildQuery(1, 0, 1, []string{"a"}, TYPE_A, CLASS_IN)[:headerLen+5], ""},
+		{"compressed", append(buildQuery(1, 0, 1, nil, TYPE_A, CLASS_IN)[:headerLen], 0xc0, headerLen, 0, 1, 0, 1), ""},
+	} {
+		q, err := parseQuery(tc.in)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("%s: parsed %q, want an error", tc.name, q.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if q.name != tc.want {
+			t.Errorf("%s: name %q, want %q", tc.name, q.name, tc.want)
+		}
+	}
+}
+
+func TestParseQueryFields(t *testing.T) {
+	b := buildQuery(0xbeef, flagRD|0x0800, 1, []string{"a", "org"}, TYPE_AAAA, CLASS_ANY)
+	q, err := parseQuery(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.id != 0xbeef || q.flags != flagRD|0x0800 || q.qtype != TYPE_AAAA || q.qclass != CLASS_ANY {
+		t.Errorf("got id %x, flags %x, type %d, class %d", q.id, q.flags, q.qtype, q.qclass)
+	}
+	if !bytes.Equal(q.question, b[headerLen:]) {
+		t.Errorf("question %x, want %x", q.question, b[headerLen:])
+	}
+	b[headerLen+1] = 'z'
+	if q.question[1] != 'a' {
+		t.Error("question shares the packet buffer")
+	}
+}
+
+func TestResponse(t *testing.T) {
+	q, err := parseQuery(buildQuery(7, flagRD, 1, []string{"a", "org"}, TYPE_TXT, CLASS_IN))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name    string
+		rcode   int
+		answers []record
+		ancount int
+		tc      bool
+	}{
+		{"nxdomain", RCODE_NXDOMAIN, nil, 0, false},
+		{"one answer", RCODE_OK, []record{{TYPE_TXT, txtData([]byte("v"))}}, 1, false},
+		{"two answers", RCODE_OK, []record{{TYPE_A, []byte{127, 0, 0, 1}}, {TYPE_A, []byte{10, 0, 0, 1}}}, 2, false},
+		{"truncated", RCODE_OK, []record{{TYPE_TXT, txtData(bytes.Repeat([]byte("x"), 600))}}, 0, true},
+	} {
+		b := q.response(tc.rcode, 60, tc.answers)
+		flags := binary.BigEndian.Uint16(b[2:])
+		if binary.BigEndian.Uint16(b) != 7 {
+			t.Errorf("%s: id %d, want 7", tc.name, binary.BigEndian.Uint16(b))
+		}
+		if flags&flagQR == 0 || flags&flagAA == 0 || flags&flagRD == 0 {
+			t.Errorf("%s: flags %x lack QR, AA or RD", tc.name, flags)
+		}
+		if int(flags&0xf) != tc.rcode {
+			t.Errorf("%s: rcode %d, want %d", tc.name, flags&0xf, tc.rcode)
+		}
+		if (flags&flagTC != 0) != tc.tc {
+			t.Errorf("%s: truncated %v, want %v", tc.name, flags&flagTC != 0, tc.tc)
+		}
+		if n := int(binary.BigEndian.Uint16(b[6:])); n != tc.ancount {
+			t.Errorf("%s: %d answers, want %d", tc.name, n, tc.ancount)
+		}
+		if len(b) > maxUDPLen {
+			t.Errorf("%s: %d bytes, more than %d", tc.name, len(b), maxUDPLen)
+		}
+		if !bytes.Equal(b[headerLen:headerLen+len(q.question)], q.question) {
+			t.Errorf("%s: question not echoed", tc.name)
+		}
+		if _, err := parseQuery(b); err == nil {
+			t.Errorf("%s: the response parsed as a query", tc.name)
+		}
+	}
+}
+
+func TestTxtData(t *testing.T) {
+	for _, tc := range []struct {
+		n    int
+		want []int // Lengths of the strings
+	}{
+		{0, []int{0}},
+		{1, []int{1}},
+		{255, []int{255}},
+		{256, []int{255, 1}},
+		{600, []int{255, 255, 90}},
+	} {
+		b := txtData(bytes.Repeat([]byte("x"), tc.n))
+		var got []int
+		for len(b) > 0 {
+			n := int(b[0])
+			got = append(got, n)
+			b = b[1+n:]
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%d bytes split into %v, want %v", tc.n, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%d bytes split into %v, want %v", tc.n, got, tc.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Package errs defines the process exit codes of daved, and the error kinds
+// that map onto them, so wrapper scripts can tell why a command failed.
+package errs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Exit codes
+const (
+	OK           = 0
+	General      = 1 // Unclassified failure
+	Usage        = 2 // Bad command line arguments
+	Config       = 3 // Config file or flags invalid
+	Key          = 4 // Key file missing or invalid
+	Timeout      = 5 // Network operation timed out
+	NotFound     = 6 // Requested dat was not found
+	Verification = 7 // Signature or proof-of-work is invalid
+)
+
+var (
+	ErrUsage        = errors.New("usage error")
+	ErrConfig       = errors.New("config error")
+	ErrKey          = errors.New("key error")
+	ErrTimeout      = errors.New("timeout")
+	ErrNotFound     = errors.New("not found")
+	ErrVerification = errors.New("verification failed")
+)
+
+// Wrap annotates err with kind, so that Code(err) returns the matching code.
+func Wrap(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", kind, err)
+}
+
+// Code returns the exit code for err.
+func Code(err error) int {
+	switch {
+	case err == nil:
+		return OK
+	case errors.Is(err, ErrUsage):
+		return Usage
+	case errors.Is(err, ErrConfig):
+		return Config
+	case errors.Is(err, ErrKey):
+		return Key
+	case errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return Timeout
+	case errors.Is(err, ErrNotFound):
+		return NotFound
+	case errors.Is(err, ErrVerification):
+		return Verification
+	default:
+		return General
+	}
+}
@@ -0,0 +1,83 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/intob/daved/logbuf"
+	"github.com/intob/godave"
+)
+
+// Alert names
+const (
+	ALERT_MIN_PEERS  = "min_peers"
+	ALERT_USED_SPACE = "max_used_percent"
+	ALERT_BACKUP_AGE = "max_backup_age"
+)
+
+type Alert struct {
+	Name      string  `json:"name"`
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	Message   string  `json:"message"`
+}
+
+type AlerterCfg struct {
+	Bus            *Bus
+	Dave           *godave.Dave
+	BackupFilename string
+	MinPeers       int           // Zero disables
+	MaxUsedPercent float64       // Zero disables
+	MaxBackupAge   time.Duration // Zero disables
+	Interval       time.Duration
+	Logs           chan<- string
+}
+
+// Monitor checks thresholds on each interval until ctx is cancelled. An
+// alert.firing event is published when a threshold is crossed, and
+// alert.resolved when it recovers, so webhooks and hooks can notify.
+func Monitor(ctx context.Context, cfg *AlerterCfg) {
+	firing := make(map[string]bool)
+	check := func(name string, bad bool, value, threshold float64, msg string, args ...any) {
+		if bad == firing[name] {
+			return
+		}
+		firing[name] = bad
+		a := &Alert{Name: name, Value: value, Threshold: threshold, Message: fmt.Sprintf(msg, args...)}
+		typ := ALERT_RESOLVED
+		if bad {
+			typ = ALERT_FIRING
+		}
+		logbuf.For(cfg.Logs, "alerts").Printf("%s %s: %s", typ, name, a.Message)
+		cfg.Bus.Publish(typ, a)
+	}
+	tick := time.NewTicker(cfg.Interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		if cfg.MinPeers > 0 {
+			n := cfg.Dave.ActivePeerCount()
+			check(ALERT_MIN_PEERS, n < cfg.MinPeers, float64(n), float64(cfg.MinPeers),
+				"%d active peers, minimum %d", n, cfg.MinPeers)
+		}
+		if cfg.MaxUsedPercent > 0 && cfg.Dave.Capacity() > 0 {
+			pct := 100 * float64(cfg.Dave.UsedSpace()) / float64(cfg.Dave.Capacity())
+			check(ALERT_USED_SPACE, pct > cfg.MaxUsedPercent, pct, cfg.MaxUsedPercent,
+				"%.1f%% of capacity used, maximum %.1f%%", pct, cfg.MaxUsedPercent)
+		}
+		if cfg.MaxBackupAge > 0 && cfg.BackupFilename != "" {
+			age := cfg.MaxBackupAge + 1 // Missing backup counts as too old
+			if fi, err := os.Stat(cfg.BackupFilename); err == nil {
+				age = time.Since(fi.ModTime())
+			}
+			check(ALERT_BACKUP_AGE, age > cfg.MaxBackupAge, age.Seconds(), cfg.MaxBackupAge.Seconds(),
+				"backup is %s old, maximum %s", age.Round(time.Second), cfg.MaxBackupAge)
+		}
+	}
+}
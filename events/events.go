@@ -0,0 +1,97 @@
+// Package events distributes notable node events, such as the backup file
+// being written, to subscribers like webhooks and the /events stream.
+package events
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	DAT_PUT            = "dat.put" // Put through this node
+	BACKUP_WRITTEN     = "backup.written"
+	CAPACITY_THRESHOLD = "capacity.threshold"
+	ALERT_FIRING       = "alert.firing"
+	ALERT_RESOLVED     = "alert.resolved"
+	WATCHDOG_UNHEALTHY = "watchdog.unhealthy"
+	WATCHDOG_RECOVERED = "watchdog.recovered"
+	WATCHDOG_ACTION    = "watchdog.action"
+)
+
+var Types = []string{DAT_PUT, BACKUP_WRITTEN, CAPACITY_THRESHOLD, ALERT_FIRING, ALERT_RESOLVED, WATCHDOG_UNHEALTHY, WATCHDOG_RECOVERED, WATCHDOG_ACTION}
+
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data,omitempty"`
+}
+
+// Bus fans out published events to subscribers. Publishing never blocks,
+// a subscriber that falls behind misses events.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[*subscription]struct{}
+}
+
+type subscription struct {
+	ch    chan *Event
+	types []string
+	match func(e *Event) bool // Nil matches any
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*subscription]struct{})}
+}
+
+// Subscribe returns a channel receiving events of the given types, or all
+// events if none are given. Call cancel to unsubscribe.
+func (b *Bus) Subscribe(buf int, types ...string) (events <-chan *Event, cancel func()) {
+	return b.SubscribeMatching(buf, nil, types...)
+}
+
+// SubscribeMatching is Subscribe, receiving only events for which match
+// returns true. Events are matched as they are published, so those that
+// don't match never take space in the buffer.
+func (b *Bus) SubscribeMatching(buf int, match func(e *Event) bool, types ...string) (events <-chan *Event, cancel func()) {
+	sub := &subscription{ch: make(chan *Event, buf), types: types, match: match}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, sub)
+			b.mu.Unlock()
+			close(sub.ch)
+		})
+	}
+}
+
+func (b *Bus) Publish(typ string, data any) {
+	if b == nil {
+		return
+	}
+	e := &Event{Type: typ, Time: time.Now(), Data: data}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subs {
+		if len(sub.types) > 0 && !slices.Contains(sub.types, typ) {
+			continue
+		}
+		if sub.match != nil && !sub.match(e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+		}
+	}
+}
+
+// Known reports whether typ is a valid event type.
+func Known(typ string) bool {
+	return slices.Contains(Types, typ)
+}
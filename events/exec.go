@@ -0,0 +1,93 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/intob/daved/logbuf"
+)
+
+type Hook struct {
+	Command   []string
+	Events    []string // Empty for all events
+	KeyPrefix string   // Only dat events with keys starting with this
+	Timeout   time.Duration
+}
+
+// Exec runs hook's command for each matching event until ctx is cancelled.
+// Commands run one at a time, with the event in environment variables:
+// DAVE_EVENT, DAVE_TIME, DAVE_EVENT_JSON, and each field of the event data
+// as DAVE_<FIELD>, e.g. DAVE_PUBKEY and DAVE_KEY.
+func Exec(ctx context.Context, bus *Bus, hook *Hook, logs chan<- string) error {
+	for _, typ := range hook.Events {
+		if !Known(typ) {
+			return fmt.Errorf("unknown event type %q", typ)
+		}
+	}
+	ch, cancel := bus.Subscribe(100, hook.Events...)
+	go func() {
+		defer cancel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-ch:
+				if hook.KeyPrefix != "" {
+					de, ok := e.Data.(*DatEvent)
+					if !ok || !strings.HasPrefix(de.Key, hook.KeyPrefix) {
+						continue
+					}
+				}
+				out, err := run(ctx, hook, e)
+				if err != nil {
+					logbuf.For(logs, "events").Printf("hook %s failed: %s: %s", hook.Command[0], err, out)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func run(ctx context.Context, hook *Hook, e *Event) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, hook.Timeout)
+	defer cancel()
+	env, err := eventEnv(e)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	return cmd.CombinedOutput()
+}
+
+func eventEnv(e *Event) ([]string, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	env := []string{
+		"DAVE_EVENT=" + e.Type,
+		"DAVE_TIME=" + e.Time.Format(time.RFC3339Nano),
+		"DAVE_EVENT_JSON=" + string(b),
+	}
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]any)
+	if json.Unmarshal(data, &fields) == nil {
+		for k, v := range fields {
+			s, ok := v.(string)
+			if !ok {
+				s = fmt.Sprint(v)
+			}
+			env = append(env, "DAVE_"+strings.ToUpper(k)+"="+s)
+		}
+	}
+	return env, nil
+}
@@ -0,0 +1,92 @@
+package events
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"time"
+
+	"github.com/intob/godave"
+	"github.com/intob/godave/dat"
+)
+
+type DatEvent struct {
+	PubKey string    `json:"pubkey"`
+	Key    string    `json:"key"`
+	Time   time.Time `json:"time"`
+	Size   int       `json:"size"`
+	Dat    *dat.Dat  `json:"-"` // For subscribers that forward the dat itself
+}
+
+type BackupEvent struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+type CapacityEvent struct {
+	UsedSpace int64   `json:"used_space"`
+	Capacity  int64   `json:"capacity"`
+	Threshold float64 `json:"threshold"`
+	Above     bool    `json:"above"` // False when usage fell back below
+}
+
+type WatcherCfg struct {
+	Bus               *Bus
+	Dave              *godave.Dave
+	BackupFilename    string
+	CapacityThreshold float64 // Fraction of capacity, zero disables
+	Interval          time.Duration
+}
+
+// Watch publishes events derived from the node until ctx is cancelled.
+// Capacity and the backup file are polled on each interval.
+func Watch(ctx context.Context, cfg *WatcherCfg) {
+	var above bool
+	var backupMod time.Time
+	if cfg.BackupFilename != "" {
+		if fi, err := os.Stat(cfg.BackupFilename); err == nil {
+			backupMod = fi.ModTime()
+		}
+	}
+	tick := time.NewTicker(cfg.Interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		if cfg.CapacityThreshold > 0 {
+			used, capacity := cfg.Dave.UsedSpace(), cfg.Dave.Capacity()
+			nowAbove := capacity > 0 && float64(used) >= cfg.CapacityThreshold*float64(capacity)
+			if nowAbove != above {
+				above = nowAbove
+				cfg.Bus.Publish(CAPACITY_THRESHOLD, &CapacityEvent{
+					UsedSpace: used,
+					Capacity:  capacity,
+					Threshold: cfg.CapacityThreshold,
+					Above:     above,
+				})
+			}
+		}
+		if cfg.BackupFilename != "" {
+			fi, err := os.Stat(cfg.BackupFilename)
+			if err == nil && fi.ModTime().After(backupMod) {
+				backupMod = fi.ModTime()
+				cfg.Bus.Publish(BACKUP_WRITTEN, &BackupEvent{Filename: cfg.BackupFilename, Size: fi.Size()})
+			}
+		}
+	}
+}
+
+// NewDatEvent describes d for the dat events, with a copy of it.
+func NewDatEvent(d *dat.Dat) *DatEvent {
+	cpy := *d
+	return &DatEvent{
+		PubKey: base64.RawURLEncoding.EncodeToString(d.PubKey),
+		Key:    d.Key,
+		Time:   d.Time,
+		Size:   len(d.Val),
+		Dat:    &cpy,
+	}
+}
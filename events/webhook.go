@@ -0,0 +1,95 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/intob/daved/logbuf"
+)
+
+// Header carrying the hex HMAC-SHA256 of the request body, keyed with the
+// webhook's secret, as "sha256=<hex>".
+const SIGNATURE_HEADER = "X-Dave-Signature"
+
+type Webhook struct {
+	URL     string
+	Events  []string // Empty for all events
+	Secret  string   // Optional, enables signing
+	Retries int
+}
+
+// Deliver POSTs each event matching hook to its URL until ctx is cancelled.
+// Failed deliveries are retried with exponential backoff.
+func Deliver(ctx context.Context, bus *Bus, hook *Webhook, logs chan<- string) error {
+	for _, typ := range hook.Events {
+		if !Known(typ) {
+			return fmt.Errorf("unknown event type %q", typ)
+		}
+	}
+	ch, cancel := bus.Subscribe(100, hook.Events...)
+	go func() {
+		defer cancel()
+		client := &http.Client{Timeout: 10 * time.Second}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-ch:
+				err := post(ctx, client, hook, e)
+				if err != nil {
+					logbuf.For(logs, "events").Printf("webhook %s failed: %s", hook.URL, err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func post(ctx context.Context, client *http.Client, hook *Webhook, e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err = send(ctx, client, hook, body)
+		if err == nil || attempt >= hook.Retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func send(ctx context.Context, client *http.Client, hook *Webhook, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		mac.Write(body)
+		req.Header.Set(SIGNATURE_HEADER, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,440 @@
+// Package graphql executes GraphQL queries and subscriptions without a
+// schema language: root fields are resolvers, and the values they return are
+// projected onto selections by their JSON field names, so the API's types
+// stay the source of truth. Objects selected without fields are returned
+// whole. There are no mutations, and no introspection beyond __typename.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Resolver resolves a root query field.
+type Resolver func(ctx context.Context, args Args) (any, error)
+
+// Subscriber starts the source stream of a root subscription field. The
+// channel must be closed once ctx is cancelled.
+type Subscriber func(ctx context.Context, args Args) (<-chan any, error)
+
+type Schema struct {
+	Query        map[string]Resolver
+	Subscription map[string]Subscriber
+}
+
+type Request struct {
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables,omitempty"`
+	OperationName string         `json:"operationName,omitempty"`
+}
+
+type Response struct {
+	Data   any     `json:"data"`
+	Errors []Error `json:"errors,omitempty"`
+}
+
+type Error struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+// Args are the arguments of a field, with variables resolved.
+type Args map[string]any
+
+// String returns the string argument name, or "" if it is not a string.
+func (a Args) String(name string) string {
+	switch v := a[name].(type) {
+	case string:
+		return v
+	case enum:
+		return string(v)
+	}
+	return ""
+}
+
+// Int returns the integer argument name, or 0 if it is not a number.
+func (a Args) Int(name string) int {
+	switch v := a[name].(type) {
+	case int:
+		return v
+	case float64: // From JSON variables
+		return int(v)
+	}
+	return 0
+}
+
+// Bool returns the boolean argument name, or false if it is not a boolean.
+func (a Args) Bool(name string) bool {
+	b, _ := a[name].(bool)
+	return b
+}
+
+// Strings returns the list of strings argument name. A single string is a
+// list of one, as in GraphQL input coercion.
+func (a Args) Strings(name string) []string {
+	if s := a.String(name); s != "" {
+		return []string{s}
+	}
+	list, _ := a[name].([]any)
+	strs := make([]string, 0, len(list))
+	for _, v := range list {
+		if s, ok := v.(string); ok {
+			strs = append(strs, s)
+		} else if e, ok := v.(enum); ok {
+			strs = append(strs, string(e))
+		}
+	}
+	return strs
+}
+
+// An object with fields in the order they were selected, as GraphQL
+// requires.
+type object []member
+
+type member struct {
+	key string
+	val any
+}
+
+func (o object) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(m.key)
+		b.Write(k)
+		b.WriteByte(':')
+		v, err := json.Marshal(m.val)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+type execution struct {
+	doc    *document
+	vars   map[string]any
+	errors []Error
+}
+
+// Prepares the operation of req, returning it with variables resolved.
+func prepare(req *Request) (*execution, *operation, error) {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return nil, nil, err
+	}
+	var op *operation
+	for _, o := range doc.ops {
+		if o.name == req.OperationName || (req.OperationName == "" && len(doc.ops) == 1) {
+			op = o
+			break
+		}
+	}
+	if op == nil {
+		if req.OperationName == "" {
+			return nil, nil, errors.New("operationName is required for a document with several operations")
+		}
+		return nil, nil, fmt.Errorf("unknown operation %s", req.OperationName)
+	}
+	e := &execution{doc: doc, vars: make(map[string]any)}
+	for _, v := range op.vars {
+		val, ok := req.Variables[v.name]
+		if !ok {
+			val = v.def
+		}
+		if val == nil && v.nonNull {
+			return nil, nil, fmt.Errorf("variable $%s is required", v.name)
+		}
+		e.vars[v.name] = val
+	}
+	return e, op, nil
+}
+
+// Execute runs a query operation.
+func (s *Schema) Execute(ctx context.Context, req *Request) *Response {
+	e, op, err := prepare(req)
+	if err != nil {
+		return &Response{Errors: []Error{{Message: err.Error()}}}
+	}
+	if op.kind != "query" {
+		return &Response{Errors: []Error{{Message: "subscriptions are served over the websocket"}}}
+	}
+	data := object{}
+	for _, f := range e.collect(op.sel, nil) {
+		key := f.key()
+		if f.name == "__typename" {
+			data = append(data, member{key, "Query"})
+			continue
+		}
+		resolve, ok := s.Query[f.name]
+		if !ok {
+			e.fail([]any{key}, fmt.Sprintf("cannot query field %q on type Query", f.name))
+			data = append(data, member{key, nil})
+			continue
+		}
+		v, err := resolve(ctx, e.args(f))
+		if err != nil {
+			e.fail([]any{key}, err.Error())
+			data = append(data, member{key, nil})
+			continue
+		}
+		data = append(data, member{key, e.project(v, f.sel, []any{key})})
+	}
+	return &Response{Data: data, Errors: e.errors}
+}
+
+// Subscribe runs a subscription operation, sending a response for each
+// event until ctx is cancelled or the source ends, when the channel is
+// closed. A query operation is sent as a single response.
+func (s *Schema) Subscribe(ctx context.Context, req *Request) (<-chan *Response, error) {
+	e, op, err := prepare(req)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan *Response, 1)
+	if op.kind == "query" {
+		out <- s.Execute(ctx, req)
+		close(out)
+		return out, nil
+	}
+	fields := e.collect(op.sel, nil)
+	if len(fields) != 1 {
+		return nil, errors.New("a subscription must select exactly one field")
+	}
+	f := fields[0]
+	subscribe, ok := s.Subscription[f.name]
+	if !ok {
+		return nil, fmt.Errorf("cannot query field %q on type Subscription", f.name)
+	}
+	src, err := subscribe(ctx, e.args(f))
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(out)
+		for v := range src {
+			ev := &execution{doc: e.doc, vars: e.vars}
+			data := object{{f.key(), ev.project(v, f.sel, []any{f.key()})}}
+			select {
+			case out <- &Response{Data: data, Errors: ev.errors}:
+			case <-ctx.Done():
+				for range src { // Until the subscriber closes it
+				}
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (e *execution) fail(path []any, msg string) {
+	e.errors = append(e.errors, Error{Message: msg, Path: path})
+}
+
+func (f *field) key() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+// Flattens fragments and applies @skip and @include. Fields with the same
+// key are merged.
+func (e *execution) collect(sel []*selection, visited map[string]bool) []*field {
+	var fields []*field
+	byKey := make(map[string]*field)
+	var walk func(sel []*selection)
+	walk = func(sel []*selection) {
+		for _, s := range sel {
+			if !e.included(s.directives) {
+				continue
+			}
+			switch {
+			case s.field != nil:
+				if f, ok := byKey[s.field.key()]; ok {
+					f.sel = append(f.sel, s.field.sel...)
+					continue
+				}
+				f := *s.field
+				f.sel = append([]*selection(nil), s.field.sel...)
+				byKey[f.key()] = &f
+				fields = append(fields, &f)
+			case s.spread != "":
+				frag, ok := e.doc.frags[s.spread]
+				if !ok || visited[s.spread] {
+					continue
+				}
+				if visited == nil {
+					visited = make(map[string]bool)
+				}
+				visited[s.spread] = true
+				walk(frag.sel)
+				delete(visited, s.spread)
+			default:
+				walk(s.inline)
+			}
+		}
+	}
+	walk(sel)
+	return fields
+}
+
+func (e *execution) included(directives []*directive) bool {
+	for _, d := range directives {
+		if d.name != "skip" && d.name != "include" {
+			continue
+		}
+		var cond bool
+		for _, a := range d.args {
+			if a.name == "if" {
+				cond, _ = e.resolve(a.val).(bool)
+			}
+		}
+		if cond == (d.name == "skip") {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *execution) args(f *field) Args {
+	args := make(Args, len(f.args))
+	for _, a := range f.args {
+		args[a.name] = e.resolve(a.val)
+	}
+	return args
+}
+
+// Replaces variables in v with their values.
+func (e *execution) resolve(v any) any {
+	switch v := v.(type) {
+	case variable:
+		return e.vars[string(v)]
+	case []any:
+		list := make([]any, len(v))
+		for i, item := range v {
+			list[i] = e.resolve(item)
+		}
+		return list
+	case map[string]any:
+		obj := make(map[string]any, len(v))
+		for k, item := range v {
+			obj[k] = e.resolve(item)
+		}
+		return obj
+	}
+	return v
+}
+
+// Returns the part of v selected by sel.
+func (e *execution) project(v any, sel []*selection, path []any) any {
+	if len(sel) == 0 {
+		return v
+	}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil
+		}
+		list := make([]any, rv.Len())
+		for i := range list {
+			list[i] = e.project(rv.Index(i).Interface(), sel, append(path[:len(path):len(path)], i))
+		}
+		return list
+	case reflect.Struct, reflect.Map:
+	default:
+		e.fail(path, fmt.Sprintf("field %v is a scalar, it has no fields to select", path[len(path)-1]))
+		return nil
+	}
+	obj := object{}
+	for _, f := range e.collect(sel, nil) {
+		key := f.key()
+		fpath := append(path[:len(path):len(path)], key)
+		if f.name == "__typename" {
+			obj = append(obj, member{key, typeName(rv.Type())})
+			continue
+		}
+		fv, ok := fieldByName(rv, f.name)
+		if !ok {
+			e.fail(fpath, fmt.Sprintf("cannot query field %q on type %s", f.name, typeName(rv.Type())))
+			obj = append(obj, member{key, nil})
+			continue
+		}
+		obj = append(obj, member{key, e.project(fv, f.sel, fpath)})
+	}
+	return obj
+}
+
+func typeName(t reflect.Type) string {
+	if t.Name() == "" {
+		return "Object"
+	}
+	return t.Name()
+}
+
+// Returns the field of a struct or map with a JSON name.
+func fieldByName(rv reflect.Value, name string) (any, bool) {
+	if rv.Kind() == reflect.Map {
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		v := rv.MapIndex(reflect.ValueOf(name).Convert(rv.Type().Key()))
+		if !v.IsValid() {
+			return nil, false
+		}
+		return v.Interface(), true
+	}
+	i, ok := jsonFields(rv.Type())[name]
+	if !ok {
+		return nil, false
+	}
+	return rv.Field(i).Interface(), true
+}
+
+var fieldCache sync.Map // reflect.Type to map[string]int
+
+// Returns the indexes of a struct's fields by JSON name.
+func jsonFields(t reflect.Type) map[string]int {
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.(map[string]int)
+	}
+	fields := make(map[string]int)
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			if n, _, _ := strings.Cut(tag, ","); n != "" {
+				name = n
+			}
+		}
+		fields[name] = i
+	}
+	fieldCache.Store(t, fields)
+	return fields
+}
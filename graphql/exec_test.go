@@ -0,0 +1,197 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+type testPeer struct {
+	Addr  string `json:"addr"`
+	Trust int    `json:"trust,omitempty"`
+	skip  int
+}
+
+type testStatus struct {
+	ActivePeers int         `json:"activePeers"`
+	Peers       []*testPeer `json:"peers"`
+	Meta        map[string]string
+}
+
+var testSchema = &Schema{
+	Query: map[string]Resolver{
+		"status": func(ctx context.Context, args Args) (any, error) {
+			return &testStatus{
+				ActivePeers: 2,
+				Peers:       []*testPeer{{Addr: "a", Trust: 1}, {Addr: "b"}},
+				Meta:        map[string]string{"v": "1"},
+			}, nil
+		},
+		"echo": func(ctx context.Context, args Args) (any, error) {
+			return map[string]any{"s": args.String("s"), "n": args.Int("n"), "b": args.Bool("b"), "l": args.Strings("l")}, nil
+		},
+		"fail": func(ctx context.Context, args Args) (any, error) {
+			return nil, errors.New("failed")
+		},
+		"nothing": func(ctx context.Context, args Args) (any, error) {
+			return (*testStatus)(nil), nil
+		},
+	},
+	Subscription: map[string]Subscriber{
+		"ticks": func(ctx context.Context, args Args) (<-chan any, error) {
+			ch := make(chan any)
+			go func() {
+				defer close(ch)
+				for i := range args.Int("n") {
+					select {
+					case ch <- &testPeer{Addr: "t", Trust: i}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+			return ch, nil
+		},
+	},
+}
+
+func TestExecute(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		req  Request
+		want string
+	}{
+		{"projection",
+			Request{Query: "{ status { activePeers peers { addr } } }"},
+			`{"data":{"status":{"activePeers":2,"peers":[{"addr":"a"},{"addr":"b"}]}}}`},
+		{"whole object",
+			Request{Query: "{ status { peers } }"},
+			`{"data":{"status":{"peers":[{"addr":"a","trust":1},{"addr":"b"}]}}}`},
+		{"aliases keep order",
+			Request{Query: "{ b: status { n: activePeers } a: status { __typename } __typename }"},
+			`{"data":{"b":{"n":2},"a":{"__typename":"testStatus"},"__typename":"Query"}}`},
+		{"map fields",
+			Request{Query: "{ status { Meta { v } } }"},
+			`{"data":{"status":{"Meta":{"v":"1"}}}}`},
+		{"fragments merge",
+			Request{Query: "{ status { ...P ... on Status { activePeers } } } fragment P on Status { peers { addr } peers { trust } }"},
+			`{"data":{"status":{"peers":[{"addr":"a","trust":1},{"addr":"b","trust":0}],"activePeers":2}}}`},
+		{"skip and include",
+			Request{Query: "query ($x: Boolean!) { status { activePeers @skip(if: $x) peers @include(if: $x) { addr } } }", Variables: map[string]any{"x": true}},
+			`{"data":{"status":{"peers":[{"addr":"a"},{"addr":"b"}]}}}`},
+		{"arguments and variables",
+			Request{Query: `query ($n: Int = 3) { echo(s: ENUM, n: $n, b: true, l: ["x", Y]) { s n b l } }`},
+			`{"data":{"echo":{"s":"ENUM","n":3,"b":true,"l":["x","Y"]}}}`},
+		{"json variables",
+			Request{Query: `query ($n: Int, $l: [String]) { echo(n: $n, l: $l) { n l } }`, Variables: map[string]any{"n": 4.0, "l": "one"}},
+			`{"data":{"echo":{"n":4,"l":["one"]}}}`},
+		{"named operation",
+			Request{Query: "query A { status { activePeers } } query B { nothing { activePeers } }", OperationName: "B"},
+			`{"data":{"nothing":null}}`},
+		{"resolver error",
+			Request{Query: "{ fail status { activePeers } }"},
+			`{"data":{"fail":null,"status":{"activePeers":2}},"errors":[{"message":"failed","path":["fail"]}]}`},
+		{"unknown root field",
+			Request{Query: "{ missing }"},
+			`{"data":{"missing":null},"errors":[{"message":"cannot query field \"missing\" on type Query","path":["missing"]}]}`},
+		{"unknown field",
+			Request{Query: "{ status { peers { port } } }"},
+			`{"data":{"status":{"peers":[{"port":null},{"port":null}]}},"errors":[{"message":"cannot query field \"port\" on type testPeer","path":["status","peers",0,"port"]},{"message":"cannot query field \"port\" on type testPeer","path":["status","peers",1,"port"]}]}`},
+		{"unexported field",
+			Request{Query: "{ status { peers { skip } } }"},
+			`{"data":{"status":{"peers":[{"skip":null},{"skip":null}]}},"errors":[{"message":"cannot query field \"skip\" on type testPeer","path":["status","peers",0,"skip"]},{"message":"cannot query field \"skip\" on type testPeer","path":["status","peers",1,"skip"]}]}`},
+		{"selection on a scalar",
+			Request{Query: "{ status { activePeers { x } } }"},
+			`{"data":{"status":{"activePeers":null}},"errors":[{"message":"field activePeers is a scalar, it has no fields to select","path":["status","activePeers"]}]}`},
+		{"syntax error",
+			Request{Query: "{ status"},
+			`{"data":null,"errors":[{"message":"syntax error at 8: expected a name, got \"\""}]}`},
+		{"missing variable",
+			Request{Query: "query ($x: Boolean!) { status { activePeers } }"},
+			`{"data":null,"errors":[{"message":"variable $x is required"}]}`},
+		{"ambiguous operation",
+			Request{Query: "query A { a } query B { b }"},
+			`{"data":null,"errors":[{"message":"operationName is required for a document with several operations"}]}`},
+		{"unknown operation",
+			Request{Query: "query A { a }", OperationName: "C"},
+			`{"data":null,"errors":[{"message":"unknown operation C"}]}`},
+		{"subscription",
+			Request{Query: "subscription { ticks { addr } }"},
+			`{"data":null,"errors":[{"message":"subscriptions are served over the websocket"}]}`},
+	} {
+		req := tc.req
+		b, err := json.Marshal(testSchema.Execute(context.Background(), &req))
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if string(b) != tc.want {
+			t.Errorf("%s:\n got %s\nwant %s", tc.name, b, tc.want)
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		req  Request
+		want []string
+		err  bool
+	}{
+		{"events",
+			Request{Query: "subscription ($n: Int) { t: ticks(n: $n) { trust } }", Variables: map[string]any{"n": 2.0}},
+			[]string{`{"data":{"t":{"trust":0}}}`, `{"data":{"t":{"trust":1}}}`}, false},
+		{"query",
+			Request{Query: "{ status { activePeers } }"},
+			[]string{`{"data":{"status":{"activePeers":2}}}`}, false},
+		{"several fields", Request{Query: "subscription { ticks { addr } other { addr } }"}, nil, true},
+		{"unknown field", Request{Query: "subscription { other { addr } }"}, nil, true},
+		{"syntax error", Request{Query: "subscription {"}, nil, true},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		req := tc.req
+		ch, err := testSchema.Subscribe(ctx, &req)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: want an error", tc.name)
+			}
+			cancel()
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			cancel()
+			continue
+		}
+		var got []string
+		for resp := range ch {
+			b, _ := json.Marshal(resp)
+			got = append(got, string(b))
+		}
+		cancel()
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: response %d is %s, want %s", tc.name, i, got[i], tc.want[i])
+			}
+		}
+	}
+}
+
+func TestSubscribeCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req := &Request{Query: "subscription { ticks(n: 1000) { trust } }"}
+	ch, err := testSchema.Subscribe(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-ch
+	cancel()
+	for range ch { // Must be closed once cancelled
+	}
+}
@@ -0,0 +1,498 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxDepth = 32 // Of nested selections and values
+
+type document struct {
+	ops   []*operation
+	frags map[string]*fragment
+}
+
+type operation struct {
+	kind string // query or subscription
+	name string
+	vars []*varDef
+	sel  []*selection
+}
+
+type varDef struct {
+	name    string
+	nonNull bool
+	def     any // Nil if none
+}
+
+type fragment struct {
+	sel []*selection // The type condition is not checked, there is no type system
+}
+
+// A field, a fragment spread or an inline fragment.
+type selection struct {
+	field      *field
+	spread     string
+	inline     []*selection
+	directives []*directive
+}
+
+type field struct {
+	alias string
+	name  string
+	args  []*argument
+	sel   []*selection
+}
+
+type argument struct {
+	name string
+	val  any
+}
+
+type directive struct {
+	name string
+	args []*argument
+}
+
+// A variable in a value, resolved when the operation is executed.
+type variable string
+
+// An enum value, passed to resolvers as a string.
+type enum string
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind tokenKind
+	val  string
+	pos  int
+}
+
+type parser struct {
+	src   string
+	pos   int
+	tok   token
+	depth int
+}
+
+type syntaxError struct {
+	pos int
+	msg string
+}
+
+func (e *syntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %d: %s", e.pos, e.msg)
+}
+
+func parse(src string) (doc *document, err error) {
+	p := &parser{src: src}
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(*syntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = se
+		}
+	}()
+	p.next()
+	doc = &document{frags: make(map[string]*fragment)}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.is("{"):
+			doc.ops = append(doc.ops, &operation{kind: "query", sel: p.selectionSet()})
+		case p.tok.kind == tokName && p.tok.val == "fragment":
+			p.next()
+			name := p.name()
+			if p.name() != "on" {
+				p.fail("expected on")
+			}
+			p.name()
+			p.directives()
+			if _, ok := doc.frags[name]; ok {
+				p.fail(fmt.Sprintf("fragment %s is defined twice", name))
+			}
+			doc.frags[name] = &fragment{sel: p.selectionSet()}
+		case p.tok.kind == tokName:
+			doc.ops = append(doc.ops, p.operation())
+		default:
+			p.fail(fmt.Sprintf("unexpected %q", p.tok.val))
+		}
+	}
+	if len(doc.ops) == 0 {
+		p.fail("no operation")
+	}
+	return doc, nil
+}
+
+func (p *parser) operation() *operation {
+	op := &operation{kind: p.name()}
+	switch op.kind {
+	case "query", "subscription":
+	case "mutation":
+		p.fail("mutations are not supported")
+	default:
+		p.fail(fmt.Sprintf("unknown operation type %s", op.kind))
+	}
+	if p.tok.kind == tokName {
+		op.name = p.name()
+	}
+	if p.skip("(") {
+		for !p.skip(")") {
+			p.expect("$")
+			v := &varDef{name: p.name()}
+			p.expect(":")
+			v.nonNull = p.typeRef()
+			if p.skip("=") {
+				v.def = p.value(true)
+			}
+			p.directives()
+			op.vars = append(op.vars, v)
+		}
+	}
+	p.directives()
+	op.sel = p.selectionSet()
+	return op
+}
+
+// Parses a type, returning whether it is non-null. Types are otherwise not
+// checked.
+func (p *parser) typeRef() bool {
+	if p.skip("[") {
+		p.typeRef()
+		p.expect("]")
+	} else {
+		p.name()
+	}
+	return p.skip("!")
+}
+
+func (p *parser) selectionSet() []*selection {
+	p.enter()
+	defer p.leave()
+	p.expect("{")
+	var sel []*selection
+	for !p.skip("}") {
+		if p.skip("...") {
+			s := &selection{}
+			if p.tok.kind == tokName && p.tok.val != "on" {
+				s.spread = p.name()
+				s.directives = p.directives()
+			} else {
+				if p.tok.kind == tokName {
+					p.next()
+					p.name()
+				}
+				s.directives = p.directives()
+				s.inline = p.selectionSet()
+			}
+			sel = append(sel, s)
+			continue
+		}
+		f := &field{name: p.name()}
+		if p.skip(":") {
+			f.alias, f.name = f.name, p.name()
+		}
+		f.args = p.arguments()
+		s := &selection{field: f, directives: p.directives()}
+		if p.is("{") {
+			f.sel = p.selectionSet()
+		}
+		sel = append(sel, s)
+	}
+	if len(sel) == 0 {
+		p.fail("empty selection set")
+	}
+	return sel
+}
+
+func (p *parser) arguments() []*argument {
+	var args []*argument
+	if !p.skip("(") {
+		return nil
+	}
+	for !p.skip(")") {
+		a := &argument{name: p.name()}
+		p.expect(":")
+		a.val = p.value(false)
+		args = append(args, a)
+	}
+	return args
+}
+
+func (p *parser) directives() []*directive {
+	var ds []*directive
+	for p.skip("@") {
+		ds = append(ds, &directive{name: p.name(), args: p.arguments()})
+	}
+	return ds
+}
+
+// Parses a value. Constant values, as defaults of variables, can't contain
+// variables.
+func (p *parser) value(constant bool) any {
+	p.enter()
+	defer p.leave()
+	t := p.tok
+	switch t.kind {
+	case tokInt:
+		p.next()
+		n, err := strconv.ParseInt(t.val, 10, 64)
+		if err != nil {
+			p.fail("invalid int")
+		}
+		return int(n)
+	case tokFloat:
+		p.next()
+		f, err := strconv.ParseFloat(t.val, 64)
+		if err != nil {
+			p.fail("invalid float")
+		}
+		return f
+	case tokString:
+		p.next()
+		return t.val
+	case tokName:
+		p.next()
+		switch t.val {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return enum(t.val)
+	}
+	switch {
+	case p.skip("$"):
+		if constant {
+			p.fail("unexpected variable")
+		}
+		return variable(p.name())
+	case p.skip("["):
+		list := make([]any, 0)
+		for !p.skip("]") {
+			list = append(list, p.value(constant))
+		}
+		return list
+	case p.skip("{"):
+		obj := make(map[string]any)
+		for !p.skip("}") {
+			name := p.name()
+			p.expect(":")
+			obj[name] = p.value(constant)
+		}
+		return obj
+	}
+	p.fail(fmt.Sprintf("unexpected %q", t.val))
+	return nil
+}
+
+func (p *parser) enter() {
+	p.depth++
+	if p.depth > maxDepth {
+		p.fail("too deeply nested")
+	}
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+func (p *parser) name() string {
+	if p.tok.kind != tokName {
+		p.fail(fmt.Sprintf("expected a name, got %q", p.tok.val))
+	}
+	v := p.tok.val
+	p.next()
+	return v
+}
+
+func (p *parser) is(punct string) bool {
+	return p.tok.kind == tokPunct && p.tok.val == punct
+}
+
+func (p *parser) skip(punct string) bool {
+	if p.is(punct) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(punct string) {
+	if !p.skip(punct) {
+		p.fail(fmt.Sprintf("expected %q, got %q", punct, p.tok.val))
+	}
+}
+
+func (p *parser) fail(msg string) {
+	panic(&syntaxError{pos: p.tok.pos, msg: msg})
+}
+
+// Reads the next token, skipping whitespace, commas and comments.
+func (p *parser) next() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		} else if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			p.pos++
+		} else if strings.HasPrefix(p.src[p.pos:], "\uFEFF") { // Byte order mark
+			p.pos += 3
+		} else {
+			break
+		}
+	}
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return
+	}
+	c := p.src[p.pos]
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.pos += 3
+		p.tok = token{kind: tokPunct, val: "...", pos: start}
+	case strings.IndexByte("!$()[]{}:=@|&", c) >= 0:
+		p.pos++
+		p.tok = token{kind: tokPunct, val: string(c), pos: start}
+	case c == '_' || isLetter(c):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || isLetter(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok = token{kind: tokName, val: p.src[start:p.pos], pos: start}
+	case c == '-' || isDigit(c):
+		p.number()
+	case c == '"':
+		p.string()
+	default:
+		r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+		p.tok = token{kind: tokPunct, val: string(r), pos: start}
+		p.fail(fmt.Sprintf("unexpected character %q", r))
+	}
+}
+
+func (p *parser) number() {
+	start := p.pos
+	kind := tokInt
+	if p.src[p.pos] == '-' {
+		p.pos++
+	}
+	digits := func() {
+		n := p.pos
+		for p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+			p.pos++
+		}
+		if p.pos == n {
+			p.tok.pos = p.pos
+			p.fail("expected a digit")
+		}
+	}
+	digits()
+	if p.pos < len(p.src) && p.src[p.pos] == '.' {
+		kind = tokFloat
+		p.pos++
+		digits()
+	}
+	if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+		kind = tokFloat
+		p.pos++
+		if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+			p.pos++
+		}
+		digits()
+	}
+	p.tok = token{kind: kind, val: p.src[start:p.pos], pos: start}
+}
+
+// Reads a string, or a block string without removing its indentation.
+func (p *parser) string() {
+	start := p.pos
+	if strings.HasPrefix(p.src[p.pos:], `"""`) {
+		end := strings.Index(p.src[p.pos+3:], `"""`)
+		if end < 0 {
+			p.tok.pos = start
+			p.fail("unterminated string")
+		}
+		p.tok = token{kind: tokString, val: strings.TrimSpace(p.src[p.pos+3 : p.pos+3+end]), pos: start}
+		p.pos += end + 6
+		return
+	}
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' {
+			p.tok.pos = start
+			p.fail("unterminated string")
+		}
+		c := p.src[p.pos]
+		if c == '"' {
+			p.pos++
+			break
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			p.pos++
+			continue
+		}
+		if p.pos+1 >= len(p.src) {
+			p.tok.pos = start
+			p.fail("unterminated string")
+		}
+		esc := p.src[p.pos+1]
+		p.pos += 2
+		switch esc {
+		case 'u':
+			if p.pos+4 > len(p.src) {
+				p.tok.pos = start
+				p.fail("invalid escape")
+			}
+			r, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 32)
+			if err != nil {
+				p.tok.pos = start
+				p.fail("invalid escape")
+			}
+			b.WriteRune(rune(r))
+			p.pos += 4
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case '"', '\\', '/':
+			b.WriteByte(esc)
+		default:
+			p.tok.pos = start
+			p.fail("invalid escape")
+		}
+	}
+	p.tok = token{kind: tokString, val: b.String(), pos: start}
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,99 @@
+package graphql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		src   string
+		ops   int
+		frags int
+	}{
+		{"shorthand", "{ status { activePeers } }", 1, 0},
+		{"named with variables", "query Q($key: String!, $n: Int = 10, $keys: [String!]) { dat(key: $key, n: $n) { val } }", 1, 0},
+		{"subscription", "subscription { dats(prefix: \"a\") { key } }", 1, 0},
+		{"several operations", "query A { a } query B { b }", 2, 0},
+		{"fragments", "{ ...F ... on Dat { key } ... @include(if: true) { val } } fragment F on Query { a }", 1, 1},
+		{"aliases and directives", "{ x: a @skip(if: false) y: a(n: 1) }", 1, 0},
+		{"comments, commas and bom", "\uFEFF# c\n{ a, b # d\n }", 1, 0},
+		{"values", `{ a(i: -1, f: 1.5e3, s: "x\u00e9\n", b: """ block """, e: ENUM, n: null, l: [1 2], o: {k: true}) }`, 1, 0},
+	} {
+		doc, err := parse(tc.src)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if len(doc.ops) != tc.ops || len(doc.frags) != tc.frags {
+			t.Errorf("%s: %d operations and %d fragments, want %d and %d", tc.name, len(doc.ops), len(doc.frags), tc.ops, tc.frags)
+		}
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	doc, err := parse(`{ a(i: -1, f: 1.5e3, s: "x\u00e9\n", b: """ block """, e: ENUM, n: null, t: true, l: [1 2], o: {k: $v}) }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]any)
+	for _, a := range doc.ops[0].sel[0].field.args {
+		got[a.name] = a.val
+	}
+	want := map[string]any{
+		"i": -1,
+		"f": 1500.0,
+		"s": "xé\n",
+		"b": "block",
+		"e": enum("ENUM"),
+		"n": nil,
+		"t": true,
+		"l": []any{1, 2},
+		"o": map[string]any{"k": variable("v")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		src  string
+	}{
+		{"empty", ""},
+		{"only a fragment", "fragment F on Q { a }"},
+		{"mutation", "mutation { put }"},
+		{"unknown operation type", "update { a }"},
+		{"empty selection", "{ }"},
+		{"unclosed selection", "{ a { b }"},
+		{"missing argument value", "{ a(n: ) }"},
+		{"variable in a default", "query ($a: Int = $b) { a }"},
+		{"fragment without on", "{ a } fragment F { a }"},
+		{"duplicate fragment", "{ a } fragment F on Q { a } fragment F on Q { b }"},
+		{"unterminated string", `{ a(s: "x) }`},
+		{"newline in string", "{ a(s: \"x\ny\") }"},
+		{"invalid escape", `{ a(s: "\q") }`},
+		{"short unicode escape", `{ a(s: "\u12") }`},
+		{"unterminated block string", `{ a(s: """x) }`},
+		{"bad number", "{ a(n: -) }"},
+		{"bad exponent", "{ a(n: 1e) }"},
+		{"unexpected character", "{ a ? }"},
+		{"too deep", "{ a" + repeat("{ a", maxDepth) + repeat("}", maxDepth+1)},
+	} {
+		if _, err := parse(tc.src); err == nil {
+			t.Errorf("%s: want an error", tc.name)
+		} else if _, ok := err.(*syntaxError); !ok {
+			t.Errorf("%s: got %T, want a syntax error", tc.name, err)
+		}
+	}
+}
+
+func repeat(s string, n int) string {
+	var r string
+	for range n {
+		r += s
+	}
+	return r
+}
@@ -0,0 +1,220 @@
+// Package history keeps superseded versions of dats for selected public
+// keys, so accidental overwrites can be recovered from the local node.
+package history
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/intob/daved/dats"
+	"github.com/intob/daved/events"
+	"github.com/intob/daved/logbuf"
+	"github.com/intob/godave/dat"
+)
+
+type History struct {
+	mu       sync.RWMutex
+	depth    int
+	filename string
+	dirty    bool // Versions changed since the file was written
+	pubKeys  []ed25519.PublicKey
+	entries  map[string]*ring // pubkey + key
+	logs     chan<- string
+}
+
+type HistoryCfg struct {
+	PubKeys  []ed25519.PublicKey
+	Depth    int    // Versions kept per key
+	Filename string // Versions are kept across restarts if set
+	Logs     chan<- string
+}
+
+// NewHistory returns a history holding the versions in cfg.Filename, if it
+// exists.
+func NewHistory(cfg *HistoryCfg) (*History, error) {
+	if cfg.Depth < 1 {
+		return nil, fmt.Errorf("depth must be at least 1, got %d", cfg.Depth)
+	}
+	h := &History{
+		depth:    cfg.Depth,
+		filename: cfg.Filename,
+		pubKeys:  cfg.PubKeys,
+		entries:  make(map[string]*ring),
+		logs:     cfg.Logs,
+	}
+	if h.filename != "" {
+		if err := h.load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("failed to load %s: %w", h.filename, err)
+		}
+	}
+	return h, nil
+}
+
+// Run records dats put through the node until ctx is cancelled. The file is
+// written on each interval if versions changed.
+func (h *History) Run(ctx context.Context, bus *events.Bus, interval time.Duration) {
+	evs, cancel := bus.Subscribe(4096, events.DAT_PUT)
+	defer cancel()
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-evs:
+			if de, ok := e.Data.(*events.DatEvent); ok && de.Dat != nil {
+				h.Observe(de.Dat)
+			}
+		case <-tick.C:
+			if err := h.Flush(); err != nil {
+				h.log("failed to write: %s", err)
+			}
+		}
+	}
+}
+
+// Observe records d if its public key is watched. The current version is
+// kept alongside up to depth superseded versions.
+func (h *History) Observe(d *dat.Dat) {
+	if !h.watched(d.PubKey) {
+		return
+	}
+	id := string(d.PubKey) + d.Key
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, ok := h.entries[id]
+	if !ok {
+		r = newRing(h.depth + 1)
+		h.entries[id] = r
+	}
+	if latest := r.latest(); latest != nil && !d.Time.After(latest.Time) {
+		return
+	}
+	cpy := *d
+	cpy.Val = bytes.Clone(d.Val)
+	r.push(&cpy)
+	h.dirty = true
+}
+
+// Flush writes the file if versions changed since it was last written.
+func (h *History) Flush() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.filename == "" || !h.dirty {
+		return nil
+	}
+	tmp := h.filename + ".tmp"
+	f, err := dats.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	w := dats.NewWriter(f)
+	for _, r := range h.entries {
+		list := r.list()
+		for i := len(list) - 1; i >= 0; i-- { // Oldest first, as they are loaded
+			if err := w.Write(list[i]); err != nil {
+				f.Close()
+				return err
+			}
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, h.filename); err != nil {
+		return err
+	}
+	h.dirty = false
+	return nil
+}
+
+// Loads versions written by Flush.
+func (h *History) load() error {
+	f, err := dats.Open(h.filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := dats.NewReader(f)
+	for {
+		d, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		h.Observe(d)
+	}
+	h.dirty = false
+	return nil
+}
+
+// Versions returns known versions of a dat, newest first. The first element
+// is the current version.
+func (h *History) Versions(pubKey ed25519.PublicKey, key string) []*dat.Dat {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	r, ok := h.entries[string(pubKey)+key]
+	if !ok {
+		return nil
+	}
+	return r.list()
+}
+
+func (h *History) watched(pubKey ed25519.PublicKey) bool {
+	for _, k := range h.pubKeys {
+		if bytes.Equal(k, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *History) log(msg string, args ...any) {
+	if h.logs != nil {
+		logbuf.For(h.logs, "history").Printf(msg, args...)
+	}
+}
+
+type ring struct {
+	buf  []*dat.Dat
+	next int
+	size int
+}
+
+func newRing(capacity int) *ring {
+	return &ring{buf: make([]*dat.Dat, capacity)}
+}
+
+func (r *ring) push(d *dat.Dat) {
+	r.buf[r.next] = d
+	r.next = (r.next + 1) % len(r.buf)
+	if r.size < len(r.buf) {
+		r.size++
+	}
+}
+
+func (r *ring) latest() *dat.Dat {
+	if r.size == 0 {
+		return nil
+	}
+	return r.buf[(r.next-1+len(r.buf))%len(r.buf)]
+}
+
+func (r *ring) list() []*dat.Dat {
+	out := make([]*dat.Dat, 0, r.size)
+	for i := 1; i <= r.size; i++ {
+		out = append(out, r.buf[(r.next-i+len(r.buf))%len(r.buf)])
+	}
+	return out
+}
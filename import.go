@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/intob/daved/errs"
+	"github.com/intob/daved/schema"
+	"github.com/intob/daved/signer"
+	"github.com/intob/godave"
+	"github.com/intob/godave/dat"
+)
+
+type importRecord struct {
+	Key  string `json:"key"`
+	Val  string `json:"val"`
+	Line int    `json:"-"`
+}
+
+type importFailure struct {
+	Line int
+	Key  string
+	Err  error
+}
+
+type importResult struct {
+	Imported int64                 `json:"imported"`
+	Failures []importFailureResult `json:"failures"`
+	TookMs   int64                 `json:"took_ms"`
+}
+
+type importFailureResult struct {
+	Line  int    `json:"line,omitempty"`
+	Error string `json:"error"`
+}
+
+// Reads key/value records from a .jsonl or .csv file one at a time, calling
+// fn for each, and stopping if it returns an error. Malformed lines are
+// passed to fail rather than aborting the whole import.
+func scanImportFile(filename string, fn func(importRecord) error, fail func(importFailure)) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return scanImportCSV(f, fn, fail)
+	case ".jsonl", ".ndjson":
+		return scanImportJSONL(f, fn, fail)
+	default:
+		return fmt.Errorf("unsupported file extension %q, expected .jsonl or .csv", filepath.Ext(filename))
+	}
+}
+
+// Reads all records of a file, for inputs that are needed in memory.
+func readImportFile(filename string) ([]importRecord, []importFailure, error) {
+	records := make([]importRecord, 0)
+	failures := make([]importFailure, 0)
+	err := scanImportFile(filename, func(rec importRecord) error {
+		records = append(records, rec)
+		return nil
+	}, func(f importFailure) {
+		failures = append(failures, f)
+	})
+	return records, failures, err
+}
+
+func scanImportJSONL(r io.Reader, fn func(importRecord) error, fail func(importFailure)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		rec := importRecord{Line: line}
+		err := json.Unmarshal([]byte(text), &rec)
+		if err == nil && rec.Key == "" {
+			err = errors.New("missing key")
+		}
+		if err != nil {
+			fail(importFailure{Line: line, Err: err})
+			continue
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func scanImportCSV(r io.Reader, fn func(importRecord) error, fail func(importFailure)) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	line := 0
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			fail(importFailure{Line: line, Err: err})
+			continue
+		}
+		if line == 1 && len(fields) >= 2 && fields[0] == "key" && fields[1] == "val" {
+			continue // header
+		}
+		if len(fields) != 2 || fields[0] == "" {
+			fail(importFailure{Line: line, Err: errors.New("expected key,val")})
+			continue
+		}
+		if err := fn(importRecord{Key: fields[0], Val: fields[1], Line: line}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Moves records whose values don't match their schema to the failures,
+// before any work is done for them.
+func validateRecords(records []importRecord, failures []importFailure, schemas *schema.Set) ([]importRecord, []importFailure) {
+	valid := records[:0]
+	for _, rec := range records {
+		if err := schemas.Validate(rec.Key, []byte(rec.Val)); err != nil {
+			failures = append(failures, importFailure{Line: rec.Line, Key: rec.Key, Err: err})
+			continue
+		}
+		valid = append(valid, rec)
+	}
+	return valid, failures
+}
+
+// The file is read twice, first to count records for the progress bar, so
+// that records are never all held in memory.
+func importFile(d *godave.Dave, filename string, s signer.Signer, schemas *schema.Set, opt *cmdOptions) {
+	total := 0
+	err := scanImportFile(filename, func(importRecord) error {
+		total++
+		return nil
+	}, func(importFailure) {})
+	if err != nil {
+		exit(errs.Usage, "failed to read import file: %s", err)
+	}
+	info("found %d records, waiting for %d peers...", total, opt.PeerCount)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	d.WaitForActivePeers(ctx, opt.PeerCount)
+	p, err := newSendPipeline(ctx, d, s, opt)
+	if err != nil {
+		exit(errs.General, "%s", err)
+	}
+	failures := make([]importFailure, 0)
+	start := time.Now()
+	progressDone, progressStopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(progressStopped)
+		tick := time.NewTicker(100 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				printProgress(int(p.Sent()), total)
+			case <-progressDone:
+				printProgress(int(p.Sent()), total)
+				fmt.Fprintln(humanOut())
+				return
+			}
+		}
+	}()
+	err = scanImportFile(filename, func(rec importRecord) error {
+		// Checked before any work is done for the record
+		if err := schemas.Validate(rec.Key, []byte(rec.Val)); err != nil {
+			failures = append(failures, importFailure{Line: rec.Line, Key: rec.Key, Err: err})
+			return nil
+		}
+		return p.Submit(&dat.Dat{Key: rec.Key, Val: []byte(rec.Val), Time: datTime(opt), PubKey: s.PublicKey()})
+	}, func(f importFailure) {
+		failures = append(failures, f)
+	})
+	if err != nil && p.ctx.Err() == nil { // Else reported by Close
+		failures = append(failures, importFailure{Err: fmt.Errorf("failed to read import file: %w", err)})
+	}
+	sent, err := p.Close(opt.Timeout)
+	if err != nil {
+		failures = append(failures, importFailure{Err: err})
+	}
+	close(progressDone)
+	<-progressStopped
+	took := time.Since(start)
+	res := &importResult{Imported: sent, Failures: make([]importFailureResult, 0, len(failures)), TookMs: took.Milliseconds()}
+	for _, f := range failures {
+		res.Failures = append(res.Failures, importFailureResult{Line: f.Line, Error: f.Err.Error()})
+	}
+	if jsonOutput {
+		printJSON(res)
+	} else {
+		fmt.Printf("imported %d records in %s, %d failures\n", res.Imported, took, len(failures))
+		for _, f := range res.Failures {
+			if f.Line > 0 {
+				fmt.Printf("  line %d: %s\n", f.Line, f.Error)
+			} else {
+				fmt.Printf("  %s\n", f.Error)
+			}
+		}
+	}
+	if len(failures) > 0 {
+		if jsonOutput { // failures are already in the result
+			os.Exit(errs.General)
+		}
+		exit(errs.General, "import completed with failures")
+	}
+}
+
+func printProgress(n, total int) {
+	const width = 40
+	filled := width
+	if total > 0 {
+		filled = n * width / total
+	}
+	fmt.Fprintf(humanOut(), "\r[%s%s] %d/%d", strings.Repeat("=", filled), strings.Repeat(" ", width-filled), n, total)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/intob/daved/dats"
+	"github.com/intob/daved/errs"
+	"github.com/intob/godave/dat"
+)
+
+type datReport struct {
+	Key      string `json:"key"`
+	PubKey   string `json:"pubkey"`
+	Time     string `json:"time"`
+	Size     int    `json:"size"`
+	Salt     string `json:"salt"`
+	Work     string `json:"work"`
+	WorkBits int    `json:"work_bits"`
+	Sig      string `json:"sig"`
+	Valid    bool   `json:"valid"`
+	Error    string `json:"error,omitempty"`
+}
+
+func newDatReport(d *dat.Dat) *datReport {
+	r := &datReport{
+		Key:      d.Key,
+		PubKey:   base64.RawURLEncoding.EncodeToString(d.PubKey),
+		Time:     d.Time.Format(time.RFC3339Nano),
+		Size:     len(d.Val),
+		Salt:     hex.EncodeToString(d.Salt[:]),
+		Work:     hex.EncodeToString(d.Work[:]),
+		WorkBits: dats.WorkBits(d.Work[:]),
+		Sig:      hex.EncodeToString(d.Sig[:]),
+	}
+	err := dats.Verify(d)
+	r.Valid = err == nil
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+func (r *datReport) print() {
+	fmt.Printf("key:       %s\npubkey:    %s\ntime:      %s\nsize:      %d bytes\nsalt:      %s\nwork:      %s\nwork bits: %d\nsig:       %s\nvalid:     %t\n",
+		r.Key, r.PubKey, r.Time, r.Size, r.Salt, r.Work, r.WorkBits, r.Sig, r.Valid)
+	if r.Error != "" {
+		fmt.Printf("error:     %s\n", r.Error)
+	}
+}
+
+// Prints a report for each dat in a bundle or history file. Returns the number of dats
+// that failed verification. Records that fail to decode are reported and
+// skipped, but a read error ends the file.
+func inspectFile(filename string) int {
+	f, err := dats.Open(filename)
+	if err != nil {
+		exit(errs.Usage, "failed to open file: %s", err)
+	}
+	defer f.Close()
+	reader := dats.NewReader(f)
+	reports := make([]*datReport, 0)
+	invalid := 0
+	for {
+		d, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			invalid++
+			reports = append(reports, &datReport{Error: err.Error()})
+			var decodeErr *dats.DecodeError
+			if errors.As(err, &decodeErr) {
+				continue
+			}
+			break
+		}
+		r := newDatReport(d)
+		if !r.Valid {
+			invalid++
+		}
+		reports = append(reports, r)
+	}
+	if jsonOutput {
+		printJSON(reports)
+		return invalid
+	}
+	for i, r := range reports {
+		if i > 0 {
+			fmt.Println()
+		}
+		r.print()
+	}
+	return invalid
+}
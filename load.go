@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/intob/daved/cfg"
+	"github.com/intob/daved/errs"
+	"github.com/intob/godave/dat"
+)
+
+// Interval between progress lines.
+const loadProgressInterval = 10 * time.Second
+
+type loadResult struct {
+	Duration   time.Duration `json:"duration"`
+	TargetRate float64       `json:"target_rate"`
+	Rate       float64       `json:"rate"` // Accepted per second
+	Sent       int64         `json:"sent"`
+	Accepted   int64         `json:"accepted"`
+	Errors     int64         `json:"errors"`
+	Skipped    int64         `json:"skipped"` // Not sent as every worker was busy
+	ErrorRate  float64       `json:"error_rate"`
+}
+
+// Puts signed dats of -size at -rate for -duration to the network,
+// reporting how many were accepted.
+func loadCommand(opt *cmdOptions, nodeCfg *cfg.NodeCfg) {
+	rate, err := parseRate(opt.LoadRate)
+	if err != nil {
+		exit(errs.Usage, "invalid -rate: %s", err)
+	}
+	ctx := getCtx()
+	requireWritable(nodeCfg)
+	d, err := initNode(nodeCfg)
+	if err != nil {
+		exit(errs.Code(err), "failed to init node: %s", err)
+	}
+	defer d.Kill()
+	s := dataSigner(opt, nodeCfg)
+	info("waiting for %d peers...", opt.PeerCount)
+	d.WaitForActivePeers(ctx, opt.PeerCount)
+	put := func(key string, val []byte) error {
+		dt := dat.Dat{Key: key, Val: val, Time: time.Now(), PubKey: s.PublicKey()}
+		if err := s.Sign(&dt); err != nil {
+			return err
+		}
+		dt.Work, dt.Salt = dat.DoWork(dt.Sig, opt.Difficulty)
+		return d.Put(dt)
+	}
+	res := runLoad(ctx, put, rate, opt)
+	if jsonOutput {
+		printJSON(res)
+		return
+	}
+	fmt.Printf("%s at %.1f/s of %.1f/s target: sent %d, accepted %d, errors %d (%.2f%%), skipped %d\n",
+		res.Duration.Round(time.Second), res.Rate, res.TargetRate, res.Sent, res.Accepted, res.Errors, 100*res.ErrorRate, res.Skipped)
+}
+
+func runLoad(ctx context.Context, put func(key string, val []byte) error, rate float64, opt *cmdOptions) *loadResult {
+	ctx, cancel := context.WithTimeout(ctx, opt.LoadDuration)
+	defer cancel()
+	res := &loadResult{TargetRate: rate}
+	var accepted, failed atomic.Int64
+	jobs := make(chan int64, runtime.NumCPU())
+	var workers sync.WaitGroup
+	prefix := fmt.Sprintf("load/%d/", time.Now().UnixNano())
+	for w := 0; w < runtime.NumCPU(); w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range jobs {
+				val := make([]byte, opt.LoadSize)
+				rand.Read(val)
+				if err := put(prefix+strconv.FormatInt(i, 10), val); err != nil {
+					failed.Add(1)
+					continue
+				}
+				accepted.Add(1)
+			}
+		}()
+	}
+	start := time.Now()
+	tick := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer tick.Stop()
+	progress := time.NewTicker(loadProgressInterval)
+	defer progress.Stop()
+	var next int64
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-progress.C:
+			info("sent %d, accepted %d, errors %d, %.1f/s", next, accepted.Load(), failed.Load(), float64(accepted.Load())/time.Since(start).Seconds())
+		case <-tick.C:
+			select {
+			case jobs <- next:
+				next++
+			default:
+				res.Skipped++
+			}
+		}
+	}
+	close(jobs)
+	workers.Wait()
+	res.Duration = time.Since(start)
+	res.Sent, res.Accepted, res.Errors = next, accepted.Load(), failed.Load()
+	res.Rate = float64(res.Accepted) / res.Duration.Seconds()
+	if res.Sent > 0 {
+		res.ErrorRate = float64(res.Errors) / float64(res.Sent)
+	}
+	return res
+}
+
+// Parses a rate such as 100/s, 500/m or 10/h, or a number per second.
+func parseRate(s string) (float64, error) {
+	num, unit, _ := strings.Cut(s, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("expected a positive number, such as 100/s")
+	}
+	switch unit {
+	case "", "s":
+		return n, nil
+	case "m":
+		return n / 60, nil
+	case "h":
+		return n / 3600, nil
+	default:
+		return 0, fmt.Errorf("unknown unit %q, expected s, m or h", unit)
+	}
+}
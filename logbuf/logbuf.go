@@ -0,0 +1,128 @@
+// Package logbuf carries the node's log lines to their consumer without
+// letting a slow consumer, such as a blocked stdout, stall the subsystems
+// that log. Lines wait in a bounded buffer; when it is full, the oldest are
+// dropped and counted.
+package logbuf
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/intob/daved/loglevel"
+)
+
+// Lines buffered by default before the oldest are dropped.
+const DefaultLines = 10000
+
+type Buffer struct {
+	mu      sync.Mutex
+	lines   []string
+	head    int // Index of the oldest line
+	n       int
+	ready   chan struct{}
+	dropped atomic.Uint64
+}
+
+func New(size int) *Buffer {
+	if size < 1 {
+		size = DefaultLines
+	}
+	return &Buffer{lines: make([]string, size), ready: make(chan struct{}, 1)}
+}
+
+// Pipe returns a channel whose lines are forwarded to out through the
+// buffer. Sends to it do not wait for out.
+func (b *Buffer) Pipe(out chan<- string) chan<- string {
+	in := make(chan string, cap(out))
+	go func() {
+		for line := range in {
+			b.push(line)
+		}
+	}()
+	go b.drain(out)
+	return in
+}
+
+// Dropped returns the number of lines dropped since the buffer was created.
+func (b *Buffer) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+// Len returns the number of lines waiting for the consumer.
+func (b *Buffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.n
+}
+
+func (b *Buffer) push(line string) {
+	b.mu.Lock()
+	if b.n == len(b.lines) {
+		b.lines[b.head] = ""
+		b.head = (b.head + 1) % len(b.lines)
+		b.n--
+		b.dropped.Add(1)
+	}
+	b.lines[(b.head+b.n)%len(b.lines)] = line
+	b.n++
+	b.mu.Unlock()
+	select {
+	case b.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (b *Buffer) pop() (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.n == 0 {
+		return "", false
+	}
+	line := b.lines[b.head]
+	b.lines[b.head] = ""
+	b.head = (b.head + 1) % len(b.lines)
+	b.n--
+	return line, true
+}
+
+// Writes buffered lines to out, reporting lines dropped since the last
+// report once the consumer catches up. Lines are already filtered by level
+// when buffered, so the report is untagged.
+func (b *Buffer) drain(out chan<- string) {
+	var reported uint64
+	for range b.ready {
+		for {
+			line, ok := b.pop()
+			if !ok {
+				break
+			}
+			out <- line
+		}
+		if dropped := b.dropped.Load(); dropped > reported {
+			out <- fmt.Sprintf("/log dropped %d lines, the consumer is too slow", dropped-reported)
+			reported = dropped
+		}
+	}
+}
+
+// Logger writes the lines of one subsystem, prefixed with its name, as
+// "/api started http server".
+type Logger struct {
+	out    chan<- string
+	prefix string
+}
+
+// For returns the logger of a subsystem. Lines are sent to out.
+func For(out chan<- string, subsystem string) Logger {
+	return Logger{out: out, prefix: "/" + subsystem + " "}
+}
+
+func (l Logger) Printf(msg string, args ...any) {
+	l.out <- l.prefix + fmt.Sprintf(msg, args...)
+}
+
+// Debugf logs a line at DEBUG, for loglevel to filter.
+func (l Logger) Debugf(msg string, args ...any) {
+	l.out <- loglevel.Tag(loglevel.DEBUG, l.prefix+fmt.Sprintf(msg, args...))
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/intob/daved/api"
+	"github.com/intob/daved/errs"
+)
+
+// Shows or changes the log levels of the running node. Each argument is a
+// level for the node, or subsystem=LEVEL, where an empty level returns the
+// subsystem to the node's level.
+func logLevelCommand(client *api.Client) {
+	var levels *api.LogLevels
+	var err error
+	if flag.NArg() > 1 {
+		change := &api.LogLevels{Subsystems: make(map[string]string)}
+		for _, arg := range flag.Args()[1:] {
+			if name, level, ok := strings.Cut(arg, "="); ok {
+				change.Subsystems[name] = level
+			} else {
+				change.Level = arg
+			}
+		}
+		levels, err = client.SetLogLevels(change)
+		if err != nil {
+			exit(errs.General, "failed to set log level: %s", err)
+		}
+	} else {
+		levels, err = client.LogLevels()
+		if err != nil {
+			exit(errs.General, "failed to get log level: %s", err)
+		}
+	}
+	if jsonOutput {
+		printJSON(levels)
+		return
+	}
+	fmt.Println(levels.Level)
+	names := make([]string, 0, len(levels.Subsystems))
+	for name := range levels.Subsystems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s=%s\n", name, levels.Subsystems[name])
+	}
+}
@@ -0,0 +1,183 @@
+// Package loglevel filters the node's log lines by level, per subsystem. A
+// line's subsystem is its leading /name, as in "/api started http server".
+package loglevel
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/intob/godave/logger"
+)
+
+type Level int
+
+const (
+	TRACE Level = iota
+	DEBUG
+	INFO
+	WARN
+	ERROR
+)
+
+var names = [...]string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR"}
+
+func (l Level) String() string {
+	if l < TRACE || l > ERROR {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return names[l]
+}
+
+// Parse parses a level name, case-insensitive. WARNING is accepted for WARN.
+func Parse(s string) (Level, error) {
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	if upper == "WARNING" {
+		return WARN, nil
+	}
+	for i, n := range names {
+		if upper == n {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid log level %q, expected TRACE, DEBUG, INFO, WARN or ERROR", s)
+}
+
+// Levels is the level of the node's logs, and of subsystems that differ.
+type Levels struct {
+	mu         sync.RWMutex
+	level      Level
+	subsystems map[string]Level
+}
+
+func New(level Level, subsystems map[string]Level) *Levels {
+	l := &Levels{level: level, subsystems: make(map[string]Level, len(subsystems))}
+	for name, lvl := range subsystems {
+		l.subsystems[name] = lvl
+	}
+	return l
+}
+
+// Enabled reports whether lines of the subsystem at level are logged.
+func (l *Levels) Enabled(subsystem string, level Level) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	threshold, ok := l.subsystems[subsystem]
+	if !ok {
+		threshold = l.level
+	}
+	return level >= threshold
+}
+
+// Set replaces the level and the levels of subsystems.
+func (l *Levels) Set(level Level, subsystems map[string]Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = level
+	l.subsystems = make(map[string]Level, len(subsystems))
+	for name, lvl := range subsystems {
+		l.subsystems[name] = lvl
+	}
+}
+
+// SetLevel sets the level of subsystems without their own.
+func (l *Levels) SetLevel(level Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = level
+}
+
+// SetSubsystem sets the level of a subsystem.
+func (l *Levels) SetSubsystem(name string, level Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.subsystems[name] = level
+}
+
+// ClearSubsystem returns a subsystem to the level of the rest.
+func (l *Levels) ClearSubsystem(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.subsystems, name)
+}
+
+// Get returns the level and a copy of the levels of subsystems.
+func (l *Levels) Get() (Level, map[string]Level) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	subsystems := make(map[string]Level, len(l.subsystems))
+	for name, lvl := range l.subsystems {
+		subsystems[name] = lvl
+	}
+	return l.level, subsystems
+}
+
+// Min returns the lowest level logged by any subsystem.
+func (l *Levels) Min() Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	lowest := l.level
+	for _, lvl := range l.subsystems {
+		lowest = min(lowest, lvl)
+	}
+	return lowest
+}
+
+// Logger wraps a godave logger, dropping messages below the level of their
+// subsystem. The inner logger should log at DEBUG, so that levels can be
+// lowered at runtime. Godave logs only errors and debug messages, so TRACE
+// and DEBUG match its debug messages and the other levels its errors.
+func (l *Levels) Logger(inner logger.Logger) logger.Logger {
+	return &filteredLogger{levels: l, inner: inner}
+}
+
+type filteredLogger struct {
+	levels *Levels
+	inner  logger.Logger
+}
+
+func (f *filteredLogger) Log(level logger.LogLevel, msg string, args ...any) {
+	lvl := ERROR
+	if level == logger.DEBUG {
+		lvl = DEBUG
+	}
+	if f.levels.Enabled(Subsystem(msg), lvl) {
+		f.inner.Log(level, msg, args...)
+	}
+}
+
+// Tag prefixes a line with its level, for Filter. Untagged lines are ERROR,
+// so are always logged.
+func Tag(level Level, line string) string {
+	return level.String() + " " + line
+}
+
+// Filter returns a channel that forwards to out the lines at or above the
+// level of their subsystem, without their tag.
+func (l *Levels) Filter(out chan<- string) chan<- string {
+	in := make(chan string, cap(out))
+	go func() {
+		for line := range in {
+			level := ERROR
+			if name, rest, ok := strings.Cut(line, " "); ok && strings.HasPrefix(rest, "/") {
+				if lvl, err := Parse(name); err == nil {
+					level, line = lvl, rest
+				}
+			}
+			if l.Enabled(Subsystem(line), level) {
+				out <- line
+			}
+		}
+	}()
+	return in
+}
+
+// Subsystem returns the leading /name of a line, or "" if it has none.
+func Subsystem(line string) string {
+	if !strings.HasPrefix(line, "/") {
+		return ""
+	}
+	name, _, _ := strings.Cut(line[1:], " ")
+	name, _, _ = strings.Cut(name, "/")
+	return name
+}
@@ -0,0 +1,56 @@
+// Package logtail keeps the most recent log lines of the node, so they can
+// be served over the API.
+package logtail
+
+import "sync"
+
+type Tail struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+func New(n int) *Tail {
+	return &Tail{lines: make([]string, n)}
+}
+
+// Tee returns a channel that records each line before forwarding it to out.
+func (t *Tail) Tee(out chan<- string) chan<- string {
+	in := make(chan string, cap(out))
+	go func() {
+		for line := range in {
+			t.add(line)
+			out <- line
+		}
+	}()
+	return in
+}
+
+func (t *Tail) add(line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lines[t.next] = line
+	t.next = (t.next + 1) % len(t.lines)
+	if t.next == 0 {
+		t.full = true
+	}
+}
+
+// Lines returns up to n of the most recent lines, oldest first.
+func (t *Tail) Lines(n int) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	count := t.next
+	if t.full {
+		count = len(t.lines)
+	}
+	if n <= 0 || n > count {
+		n = count
+	}
+	out := make([]string, 0, n)
+	for i := n; i > 0; i-- {
+		out = append(out, t.lines[(t.next-i+len(t.lines))%len(t.lines)])
+	}
+	return out
+}
@@ -3,19 +3,36 @@ package main
 import (
 	"context"
 	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
 	_ "embed"
+	"encoding/base64"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
-	"runtime"
+	"strconv"
 	"strings"
-	"sync"
 	"syscall"
 	"time"
 
 	"github.com/intob/daved/api"
 	"github.com/intob/daved/cfg"
+	"github.com/intob/daved/clock"
+	"github.com/intob/daved/crash"
+	"github.com/intob/daved/dats"
+	"github.com/intob/daved/dns"
+	"github.com/intob/daved/errs"
+	"github.com/intob/daved/logbuf"
+	"github.com/intob/daved/loglevel"
+	"github.com/intob/daved/logtail"
+	"github.com/intob/daved/node"
+	"github.com/intob/daved/schema"
+	"github.com/intob/daved/seal"
+	"github.com/intob/daved/signer"
+	"github.com/intob/daved/trace"
+	"github.com/intob/daved/workcache"
+	"github.com/intob/daved/workpool"
 	"github.com/intob/godave"
 	"github.com/intob/godave/dat"
 	"github.com/intob/godave/logger"
@@ -26,165 +43,751 @@ import (
 //go:embed commit
 var commit string
 
+// Recent node log lines, served by the API at /logs.
+var logTail = logtail.New(1000)
+
+// Log levels of the node, changed at runtime by the API at /admin/loglevel.
+var logLevels = loglevel.New(loglevel.ERROR, nil)
+
 type cmdOptions struct {
 	DataKeyFilename string
 	Difficulty      uint8
 	Ntest           int
 	Timeout         time.Duration
 	PeerCount       int
+	EncryptFor      string
+	Template        string
+	Env             bool
+	Yes             bool
+	JSON            bool
+	Namespace       string
+	PubKey          string // Base64, of the dat a link refers to
+	FollowLinks     bool
+	ProfileCPU      time.Duration
+	Time            time.Time
+	WorkCache       string
+	Slot            time.Duration // Work pool slot, put tries the slot time first
+	Profile         string
+	CfgDir          string
+	LoadRate        string
+	LoadSize        cfg.Size
+	LoadDuration    time.Duration
 }
 
 func main() {
+	defer crash.Recover()
 	// Parse & merge configuration
 	opt, cfgFlags, cfgFilename := parseFlags()
+	jsonOutput = opt.JSON
+	if opt.Profile != "" && cfgFilename == "" {
+		exit(errs.Usage, "-profile requires -cfg")
+	}
+	if flag.Arg(0) == "config" {
+		configCommand(opt, cfgFlags, cfgFilename)
+		return
+	}
 	unparsedCfg := cfgFlags
+	var cfgFile *cfg.NodeCfgUnparsed
 	if cfgFilename != "" {
-		cfgFile, err := cfg.ReadNodeCfgFile(cfgFilename)
+		var err error
+		cfgFile, err = cfg.ReadNodeCfgFile(cfgFilename)
+		if err != nil {
+			exit(errs.Config, "failed to read config file: %s", err)
+		}
+		cfgFile, err = cfg.SelectProfile(cfgFile, opt.Profile)
 		if err != nil {
-			exit(1, "failed to read config file: %s", err)
+			exit(errs.Config, "failed to select profile: %s", err)
 		}
 		unparsedCfg = cfg.MergeConfigs(*cfgFile, *cfgFlags) // flags take precedence
 	}
 	nodeCfg, err := cfg.ParseNodeCfg(unparsedCfg)
 	if err != nil {
-		exit(1, "failed to parse config: %s", err)
+		exit(errs.Config, "failed to parse config: %s", err)
+	}
+	crash.Init(&crash.Cfg{
+		Dir:      nodeCfg.CrashDir,
+		Commit:   commit,
+		Settings: func() []cfg.Setting { return cfg.Effective(cfgFile, cfgFlags, false) },
+		Logs:     logTail,
+	})
+	if nodeCfg.OtlpEndpoint != "" {
+		trace.Init(nodeCfg.OtlpEndpoint, "daved")
 	}
 
 	// Execute command or wait for kill sig
 	if flag.NArg() > 0 { // Command mode
 		switch flag.Arg(0) {
 		case "version":
-			fmt.Printf("commit %s\n", commit)
+			result(map[string]string{"commit": commit}, "commit %s", commit)
+		case "completion":
+			if flag.NArg() < 2 {
+				exit(errs.Usage, "correct usage is completion <bash|zsh|fish>")
+			}
+			if err := writeCompletion(os.Stdout, flag.Arg(1)); err != nil {
+				exit(errs.Usage, "%s", err)
+			}
+		case "man":
+			writeManPage(os.Stdout)
 		case "keygen":
 			filename := cfg.DEFAULT_KEY_FILENAME
 			if flag.NArg() < 2 {
-				fmt.Printf("no filename provided, using default: %s\n", filename)
+				info("no filename provided, using default: %s", filename)
 			} else {
 				filename = flag.Arg(1)
 			}
 			_, priv, err := ed25519.GenerateKey(nil)
 			if err != nil {
-				exit(1, "failed to generate key: %s", err)
+				exit(errs.General, "failed to generate key: %s", err)
 			}
 			// TODO: encrypt key with passphrase
 			os.WriteFile(filename, priv, 0600) // W/R by owner only
+			pub := priv.Public().(ed25519.PublicKey)
+			pubB64 := base64.RawURLEncoding.EncodeToString(pub)
+			result(map[string]string{"filename": filename, "public_key": pubB64}, "public key: %s", pubB64)
 		case "put":
-			d, _, err := initNode(nodeCfg)
+			requireWritable(nodeCfg)
+			measureClock(nodeCfg, opt)
+			d, err := initNode(nodeCfg)
 			if err != nil {
-				exit(1, "failed to init node: %s", err)
+				exit(errs.Code(err), "failed to init node: %s", err)
 			}
-			keyFilename := opt.DataKeyFilename
-			if keyFilename == "" { // fallback to node key file
-				keyFilename = nodeCfg.KeyFilename
+			s := dataSigner(opt, nodeCfg)
+			withWorkPool(opt, nodeCfg)
+			if opt.Template == "" && flag.NArg() < 3 {
+				exit(errs.Usage, "missing arguments: put <KEY> <VAL>")
 			}
-			dataPrivateKey, err := cfg.ReadKeyFile(keyFilename)
+			if opt.Template != "" && flag.NArg() != 2 {
+				exit(errs.Usage, "correct usage is -template <FILE> put <KEY>")
+			}
+			key, err := dats.NamespacedKey(opt.Namespace, flag.Arg(1))
 			if err != nil {
-				fmt.Printf("failed to read key file: %s\n", err)
-				return
+				exit(errs.Usage, "invalid key: %s", err)
+			}
+			val := []byte(flag.Arg(2))
+			var used map[string]string
+			if opt.Template != "" {
+				val, used, err = renderTemplate(opt.Template, opt.Env)
+				if err != nil {
+					exit(errs.Usage, "failed to render template: %s", err)
+				}
+			}
+			if err := loadSchemas(nodeCfg).Validate(key, val); err != nil {
+				exit(errs.Usage, "%s", err)
 			}
+			if opt.Template != "" {
+				reviewPut(d, s.PublicKey(), key, val, used, opt)
+			}
+			if opt.EncryptFor != "" {
+				recipient, err := base64.RawURLEncoding.DecodeString(opt.EncryptFor)
+				if err != nil {
+					exit(errs.Usage, "failed to decode recipient public key: %s", err)
+				}
+				val, err = seal.Seal(ed25519.PublicKey(recipient), val)
+				if err != nil {
+					exit(errs.General, "failed to encrypt value: %s", err)
+				}
+			}
+			put(d, key, val, s, opt)
+		case "publish":
+			requireWritable(nodeCfg)
 			if flag.NArg() < 3 {
-				exit(1, "missing arguments: put <KEY> <VAL>")
+				exit(errs.Usage, "correct usage is publish <TOPIC> <MSG>")
 			}
-			put(d, flag.Arg(1), []byte(flag.Arg(2)), dataPrivateKey, opt)
-		case "get":
+			measureClock(nodeCfg, opt)
+			key := publishKey(opt)
+			if err := loadSchemas(nodeCfg).Validate(key, []byte(flag.Arg(2))); err != nil {
+				exit(errs.Usage, "%s", err)
+			}
+			d, err := initNode(nodeCfg)
+			if err != nil {
+				exit(errs.Code(err), "failed to init node: %s", err)
+			}
+			s := dataSigner(opt, nodeCfg)
+			withWorkPool(opt, nodeCfg)
+			put(d, key, []byte(flag.Arg(2)), s, opt)
+		case "link":
+			requireWritable(nodeCfg)
+			args := flag.Args()[1:]
+			if len(args) == 4 && args[2] == "->" {
+				args = []string{args[0], args[1], args[3]}
+			}
+			if len(args) != 3 || args[0] != "set" || args[2] == "" {
+				exit(errs.Usage, "correct usage is link set <KEY> [->] <TARGET_KEY>")
+			}
+			key, err := dats.NamespacedKey(opt.Namespace, args[1])
+			if err != nil {
+				exit(errs.Usage, "invalid key: %s", err)
+			}
+			d, err := initNode(nodeCfg)
+			if err != nil {
+				exit(errs.Code(err), "failed to init node: %s", err)
+			}
+			s := dataSigner(opt, nodeCfg)
+			withWorkPool(opt, nodeCfg)
+			target := &dats.Link{PubKey: s.PublicKey(), Key: args[2]}
+			if opt.PubKey != "" {
+				target.PubKey, err = base64.RawURLEncoding.DecodeString(opt.PubKey)
+				if err != nil || len(target.PubKey) != ed25519.PublicKeySize {
+					exit(errs.Usage, "invalid public key")
+				}
+			}
+			info("%s -> %s", key, target)
+			measureClock(nodeCfg, opt)
+			put(d, key, []byte(target.String()), s, opt)
+		case "dns-name":
 			if flag.NArg() < 2 {
-				exit(1, "correct usage is get <KEY>")
+				exit(errs.Usage, "correct usage is dns-name <PUBKEY> [HOST]")
+			}
+			pubKey, err := base64.RawURLEncoding.DecodeString(flag.Arg(1))
+			if err != nil || len(pubKey) != ed25519.PublicKeySize {
+				exit(errs.Usage, "invalid public key")
+			}
+			name := dns.Label(pubKey)
+			if flag.NArg() > 2 && flag.Arg(2) != "@" {
+				name = flag.Arg(2) + "." + name
+			}
+			if nodeCfg.DNS != nil {
+				name += "." + nodeCfg.DNS.Zone
+			}
+			result(map[string]string{"name": name}, "%s", name)
+		case "import":
+			requireWritable(nodeCfg)
+			if flag.NArg() < 2 {
+				exit(errs.Usage, "correct usage is import <FILE.jsonl|FILE.csv>")
+			}
+			d, err := initNode(nodeCfg)
+			if err != nil {
+				exit(errs.Code(err), "failed to init node: %s", err)
+			}
+			measureClock(nodeCfg, opt)
+			importFile(d, flag.Arg(1), dataSigner(opt, nodeCfg), loadSchemas(nodeCfg), opt)
+		case "bundle":
+			switch flag.Arg(1) {
+			case "create":
+				if flag.NArg() < 4 {
+					exit(errs.Usage, "correct usage is bundle create <FILE.jsonl|FILE.csv> <BUNDLE.jsonl[.gz]>")
+				}
+				measureClock(nodeCfg, opt)
+				bundleCreate(flag.Arg(2), flag.Arg(3), dataSigner(opt, nodeCfg), loadSchemas(nodeCfg), opt)
+			case "send":
+				requireWritable(nodeCfg)
+				if flag.NArg() < 3 {
+					exit(errs.Usage, "correct usage is bundle send <BUNDLE>")
+				}
+				d, err := initNode(nodeCfg)
+				if err != nil {
+					exit(errs.Code(err), "failed to init node: %s", err)
+				}
+				bundleSend(d, flag.Arg(2), opt)
+				d.Kill()
+			default:
+				exit(errs.Usage, "correct usage is bundle <create|send>")
+			}
+		case "site":
+			if flag.Arg(1) != "publish" {
+				exit(errs.Usage, "correct usage is site publish <DIR> [--key NAME]")
 			}
-			d, _, err := initNode(nodeCfg)
+			requireWritable(nodeCfg)
+			dir, name := sitePublishArgs(flag.Args()[2:])
+			measureClock(nodeCfg, opt)
+			d, err := initNode(nodeCfg)
 			if err != nil {
-				exit(1, "failed to init node: %s", err)
+				exit(errs.Code(err), "failed to init node: %s", err)
 			}
-			keyFilename := opt.DataKeyFilename
-			if keyFilename == "" { // fallback to node key file
-				keyFilename = nodeCfg.KeyFilename
+			s := dataSigner(opt, nodeCfg)
+			withWorkPool(opt, nodeCfg)
+			sitePublish(d, dir, name, s, opt, nodeCfg)
+		case "history":
+			if flag.NArg() < 3 {
+				exit(errs.Usage, "correct usage is history <PUBKEY> <KEY>")
 			}
-			dataPrivateKey, err := cfg.ReadKeyFile(keyFilename)
+			records, err := newClient(nodeCfg.ApiListenAddr).History(flag.Arg(1), flag.Arg(2))
 			if err != nil {
-				fmt.Printf("failed to read key file: %s\n", err)
-				return
+				exit(errs.General, "failed to get history: %s", err)
+			}
+			if jsonOutput {
+				printJSON(records)
+				break
+			}
+			for i, r := range records {
+				val, err := base64.RawURLEncoding.DecodeString(r.Val)
+				if err != nil {
+					exit(errs.General, "failed to decode value: %s", err)
+				}
+				fmt.Printf("%d %s %s=%s\n", i, time.UnixMilli(r.Time).Format(time.RFC3339Nano), r.Key, val)
+			}
+		case "status":
+			if flag.Arg(1) == "history" {
+				window := time.Hour
+				if flag.NArg() > 2 {
+					window, err = time.ParseDuration(flag.Arg(2))
+					if err != nil {
+						exit(errs.Usage, "correct usage is status history [WINDOW], e.g. 1h")
+					}
+				}
+				samples, err := newClient(nodeCfg.ApiListenAddr).StatusHistory(window)
+				if err != nil {
+					exit(errs.General, "failed to get status history: %s", err)
+				}
+				if jsonOutput {
+					printJSON(samples)
+					break
+				}
+				printStatusHistory(samples, window)
+				break
+			}
+			stat, err := newClient(nodeCfg.ApiListenAddr).Status()
+			if err != nil {
+				exit(errs.General, "failed to get status: %s", err)
+			}
+			if jsonOutput {
+				printJSON(stat)
+				break
+			}
+			fmt.Printf("peers: %d\nused space: %d/%d bytes\nnetwork: %d/%d bytes\n",
+				stat.ActivePeers, stat.UsedSpace, stat.Capacity, stat.Network.UsedSpace, stat.Network.Capacity)
+		case "top":
+			top(newClient(nodeCfg.ApiListenAddr))
+		case "loglevel":
+			logLevelCommand(newClient(nodeCfg.ApiListenAddr))
+		case "multi":
+			multiCommand(opt, nodeCfg)
+		case "load":
+			loadCommand(opt, nodeCfg)
+		case "profile":
+			kind, seconds := flag.Arg(1), 0
+			if opt.ProfileCPU > 0 {
+				kind, seconds = "cpu", int(opt.ProfileCPU.Seconds())
+			} else if kind == "" {
+				exit(errs.Usage, "correct usage is profile -cpu <DURATION> or profile <heap|goroutine|allocs|block|mutex>")
 			}
+			filename := fmt.Sprintf("%s-%d.pprof", kind, time.Now().Unix())
+			f, err := os.Create(filename)
+			if err != nil {
+				exit(errs.General, "failed to create file: %s", err)
+			}
+			if seconds > 0 {
+				info("recording %s profile for %s...", kind, opt.ProfileCPU)
+			}
+			n, err := newClient(nodeCfg.ApiListenAddr).Profile(f, kind, seconds)
+			f.Close()
+			if err != nil {
+				os.Remove(filename)
+				exit(errs.General, "failed to fetch profile: %s", err)
+			}
+			result(map[string]any{"filename": filename, "bytes": n}, "wrote %s (%d bytes), view with go tool pprof %s", filename, n, filename)
+		case "get":
+			if flag.NArg() < 2 {
+				exit(errs.Usage, "correct usage is get <KEY>")
+			}
+			d, err := initNode(nodeCfg)
+			if err != nil {
+				exit(errs.Code(err), "failed to init node: %s", err)
+			}
+			dataPrivateKey := readDataKey(opt, nodeCfg)
+			key, err := dats.NamespacedKey(opt.Namespace, flag.Arg(1))
+			if err != nil {
+				exit(errs.Usage, "invalid key: %s", err)
+			}
+			traceCtx, span := trace.Start(context.Background(), "get")
+			_, wait := trace.Start(traceCtx, "wait_peers")
 			d.WaitForActivePeers(context.Background(), opt.PeerCount)
-			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			wait.End()
+			ctx, cancel := context.WithTimeout(traceCtx, opt.Timeout)
 			defer cancel()
 			start := time.Now()
-			entry, err := d.Get(ctx, &types.Get{
-				PublicKey: dataPrivateKey.Public().(ed25519.PublicKey),
-				DatKey:    flag.Arg(1)})
+			_, rt := trace.Start(ctx, "network")
+			fetch := func(ctx context.Context, pubKey ed25519.PublicKey, key string) (*dat.Dat, error) {
+				entry, err := d.Get(ctx, &types.Get{PublicKey: pubKey, DatKey: key})
+				if entry == nil {
+					return nil, err
+				}
+				return &entry.Dat, err
+			}
+			got, err := fetch(ctx, dataPrivateKey.Public().(ed25519.PublicKey), key)
+			rt.SetError(err)
+			rt.End()
+			if err == nil && got != nil && opt.FollowLinks {
+				_, ls := trace.Start(traceCtx, "follow_links")
+				got, err = dats.FollowLinks(ctx, fetch, got, nodeCfg.LinkDepth)
+				ls.SetError(err)
+				ls.End()
+				if err == nil && got == nil {
+					exit(errs.NotFound, "target of link %s not found", key)
+				}
+			}
+			span.End()
 			if err != nil {
-				exit(1, err.Error())
+				exit(errs.Code(err), "%s", err)
 			}
-			fmt.Printf("%s=%s (took %s)\n", entry.Dat.Key, string(entry.Dat.Val), time.Since(start))
+			if got == nil {
+				exit(errs.NotFound, "%s not found", key)
+			}
+			val := got.Val
+			if seal.IsSealed(val) {
+				val, err = seal.Open(dataPrivateKey, val)
+				if err != nil {
+					exit(errs.Key, "value is encrypted: %s", err)
+				}
+			}
+			took := time.Since(start)
+			result(&getResult{
+				Key:    got.Key,
+				Val:    string(val),
+				PubKey: base64.RawURLEncoding.EncodeToString(got.PubKey),
+				Time:   got.Time.UnixMilli(),
+				TookMs: took.Milliseconds(),
+			}, "%s=%s (took %s)", got.Key, string(val), took)
 			d.Kill()
+		case "tokens":
+			tokensCommand(newClient(nodeCfg.ApiListenAddr))
+		case "audit":
+			entries, err := newClient(nodeCfg.ApiListenAddr).Audit(100, flag.Arg(1))
+			if err != nil {
+				exit(errs.General, "failed to get audit log: %s", err)
+			}
+			if jsonOutput {
+				printJSON(entries)
+				break
+			}
+			for _, e := range entries {
+				identity := e.Identity
+				if identity == "" {
+					identity = "-"
+				}
+				fmt.Printf("%s %-14s %s %s %d %s %s\n", e.Time.Format(time.RFC3339), e.Action, identity, e.IP, e.Status, e.Method, e.Path)
+			}
+		case "diff":
+			if flag.NArg() < 3 {
+				exit(errs.Usage, "correct usage is diff <DAT_FILE> <DAT_FILE>")
+			}
+			diffCommand(flag.Arg(1), flag.Arg(2))
+		case "inspect":
+			if flag.NArg() < 2 {
+				exit(errs.Usage, "correct usage is inspect <DAT_FILE>")
+			}
+			if invalid := inspectFile(flag.Arg(1)); invalid > 0 {
+				exit(errs.Verification, "%d dats failed verification", invalid)
+			}
+		case "verify":
+			if flag.NArg() < 3 {
+				exit(errs.Usage, "correct usage is verify <PUBKEY> <KEY>")
+			}
+			pubKey, err := base64.RawURLEncoding.DecodeString(flag.Arg(1))
+			if err != nil || len(pubKey) != ed25519.PublicKeySize {
+				exit(errs.Usage, "invalid public key")
+			}
+			d, err := initNode(nodeCfg)
+			if err != nil {
+				exit(errs.Code(err), "failed to init node: %s", err)
+			}
+			d.WaitForActivePeers(context.Background(), opt.PeerCount)
+			ctx, cancel := context.WithTimeout(context.Background(), opt.Timeout)
+			defer cancel()
+			entry, err := d.Get(ctx, &types.Get{PublicKey: pubKey, DatKey: flag.Arg(2)})
+			if err != nil {
+				exit(errs.Code(err), "%s", err)
+			}
+			if entry == nil {
+				exit(errs.NotFound, "%s not found", flag.Arg(2))
+			}
+			r := newDatReport(&entry.Dat)
+			if jsonOutput {
+				printJSON(r)
+			} else {
+				r.print()
+			}
+			d.Kill()
+			if !r.Valid {
+				exit(errs.Verification, "verification failed")
+			}
+		case "decode":
+			if flag.NArg() < 2 {
+				exit(errs.Usage, "correct usage is decode <FILE.pcap|HEXDUMP_FILE>")
+			}
+			err := decodeCapture(flag.Arg(1))
+			if err != nil {
+				exit(errs.General, "failed to decode: %s", err)
+			}
+		default:
+			exit(errs.Usage, "unknown command %q", flag.Arg(0))
 		}
+		flushTraces()
 	} else { // Node mode, wait for kill sig
-		d, logs, err := initNode(nodeCfg)
-		if err != nil {
-			exit(1, "failed to init node: %s", err)
+		records, s := workPool(opt, nodeCfg)
+		if s == nil && (nodeCfg.RespListenAddr != "" || nodeCfg.ApiUploads) {
+			s = dataSigner(opt, nodeCfg)
 		}
-		svc := api.NewService(&api.ServiceCfg{
-			ListenAddr: "127.0.0.1:8080",
-			Logs:       logs,
-			Dave:       d,
+		n, err := node.Run(getCtx(), &node.Cfg{
+			Node:            nodeCfg,
+			LogLevels:       logLevels,
+			LogTail:         logTail,
+			Signer:          s,
+			WorkPoolRecords: records,
+			Version:         commit,
 		})
-		err = svc.Start()
 		if err != nil {
-			exit(1, "failed to start http server: %s", err)
+			exit(errs.Code(err), "failed to start node: %s", err)
 		}
-		<-getCtx().Done()
-		d.Kill()
+		<-n.Done()
+		flushTraces()
 		fmt.Println("shutdown gracefully")
 	}
 }
 
-func initNode(nodeCfg *cfg.NodeCfg) (*godave.Dave, chan<- string, error) {
+// Returns a client of a node's API, sending the token in DAVE_API_TOKEN for
+// nodes with tenants. If DAVE_API_CA or DAVE_API_CERT is set, it connects
+// over HTTPS, trusting the CA and presenting the certificate with the key
+// in DAVE_API_KEY.
+func newClient(addr string) *api.Client {
+	c := api.NewClient(addr).WithToken(os.Getenv("DAVE_API_TOKEN"))
+	caFile, certFile := os.Getenv("DAVE_API_CA"), os.Getenv("DAVE_API_CERT")
+	if caFile == "" && certFile == "" {
+		return c
+	}
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, os.Getenv("DAVE_API_KEY"))
+		if err != nil {
+			exit(errs.Config, "failed to load DAVE_API_CERT: %s", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			exit(errs.Config, "failed to read DAVE_API_CA: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			exit(errs.Config, "no certificates found in DAVE_API_CA")
+		}
+		tlsCfg.RootCAs = pool
+	}
+	return c.WithTLS(tlsCfg)
+}
+
+// Starts the godave instance used by commands, printing its logs only if
+// the log level is DEBUG or lower.
+func initNode(nodeCfg *cfg.NodeCfg) (*godave.Dave, error) {
+	logLevels.Set(nodeCfg.LogLevel, nodeCfg.LogLevels)
 	var logs chan<- string
-	if flag.NArg() == 0 || nodeCfg.LogLevel == logger.DEBUG {
-		// If running as node (not CLI), or log level is debug, print logs
-		logs = logger.StdOut(!nodeCfg.LogUnbuffered)
+	if logLevels.Min() <= loglevel.DEBUG {
+		buf := logbuf.New(logbuf.DefaultLines)
+		logs = logLevels.Filter(buf.Pipe(logTail.Tee(logger.StdOut(!nodeCfg.LogUnbuffered))))
 	} else {
 		logs = logger.DevNull()
 	}
-	key, err := cfg.ReadKeyFile(nodeCfg.KeyFilename)
+	return node.NewDave(nodeCfg, logs, logLevels)
+}
+
+// Reads the records of the node's work pool, and returns the signer for
+// them. Both are nil without a work pool.
+func workPool(opt *cmdOptions, nodeCfg *cfg.NodeCfg) ([]workpool.Record, signer.Signer) {
+	p := nodeCfg.WorkPool
+	if p == nil {
+		return nil, nil
+	}
+	imported, failures, err := readImportFile(p.Records)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to load key file: %s", err)
+		exit(errs.Config, "failed to read work pool records: %s", err)
 	}
-	logger, err := logger.NewDaveLogger(&logger.DaveLoggerCfg{
-		Level:  nodeCfg.LogLevel,
-		Output: logs,
-	})
+	if len(failures) > 0 {
+		exit(errs.Config, "work pool records line %d: %s", failures[0].Line, failures[0].Err)
+	}
+	records := make([]workpool.Record, 0, len(imported))
+	for _, rec := range imported {
+		records = append(records, workpool.Record{Key: rec.Key, Val: []byte(rec.Val)})
+	}
+	return records, dataSigner(opt, nodeCfg)
+}
+
+// Takes the slot of the node's work pool, and its work cache unless
+// -work_cache is set, so work done here is its share of the pool's.
+func withWorkPool(opt *cmdOptions, nodeCfg *cfg.NodeCfg) {
+	p := nodeCfg.WorkPool
+	if p == nil {
+		return
+	}
+	opt.Slot = p.Slot
+	if opt.WorkCache == "" {
+		opt.WorkCache = p.WorkCache
+	}
+}
+
+func requireWritable(nodeCfg *cfg.NodeCfg) {
+	if nodeCfg.Mode == cfg.MODE_READONLY {
+		exit(errs.Config, "node is in %s mode, writes are disabled", nodeCfg.Mode)
+	}
+}
+
+func readDataKey(opt *cmdOptions, nodeCfg *cfg.NodeCfg) ed25519.PrivateKey {
+	keyFilename := opt.DataKeyFilename
+	if keyFilename == "" { // fallback to node key file
+		keyFilename = nodeCfg.KeyFilename
+	}
+	key, err := cfg.ReadKeyFile(keyFilename)
+	if err != nil {
+		exit(errs.Key, "failed to read key file: %s", err)
+	}
+	return key
+}
+
+// Returns the -time flag if set, so an identical dat (and signature) can be
+// rebuilt later. Otherwise now, corrected by the clock offset measured from
+// the NTP servers by measureClock.
+func datTime(opt *cmdOptions) time.Time {
+	if !opt.Time.IsZero() {
+		return opt.Time
+	}
+	return clock.Now()
+}
+
+// Measures the offset of the local clock for datTime, unless -time is set,
+// warning if it is skewed by more than max_skew, as peers would refuse dats
+// from the future, or order them before versions put earlier.
+func measureClock(nodeCfg *cfg.NodeCfg, opt *cmdOptions) {
+	c := nodeCfg.Clock
+	if !opt.Time.IsZero() || len(c.NTPServers) == 0 {
+		return
+	}
+	e, err := clock.Measure(context.Background(), c.NTPServers)
+	if err != nil {
+		info("failed to measure clock offset, dats are timed by the local clock: %s", err)
+		return
+	}
+	clock.Set(e)
+	if e.Offset.Abs() > c.MaxSkew {
+		info("WARNING: local clock is %s off %s, more than max_skew %s; synchronise it, dats are timed by %s until then", e.Offset.Round(time.Millisecond), e.Server, c.MaxSkew, e.Server)
+	}
+}
+
+func parseDatTime(s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(ms)
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create logger: %w", err)
-	}
-	d, err := godave.NewDave(&godave.DaveCfg{
-		UdpListenAddr:  nodeCfg.UdpListenAddr,
-		PrivateKey:     key,
-		Edges:          nodeCfg.Edges,
-		ShardCapacity:  nodeCfg.ShardCapacity,
-		BackupFilename: nodeCfg.BackupFilename,
-		Logger:         logger,
+		exit(errs.Usage, "invalid -time %q, expected RFC3339 or unix milli", s)
+	}
+	return t
+}
+
+// Signs d at the current work pool slot time and reports whether its work
+// was already prepared. If not, d is left for the caller to sign as usual.
+func pooled(d *dat.Dat, s signer.Signer, cache *workcache.Cache, opt *cmdOptions) bool {
+	if cache == nil || opt.Slot == 0 || !opt.Time.IsZero() {
+		return false
+	}
+	slotted := *d
+	slotted.Time = workpool.SlotTime(time.Now(), opt.Slot)
+	if err := s.Sign(&slotted); err != nil {
+		return false
+	}
+	work, salt, ok := cache.Get(slotted.Sig, opt.Difficulty)
+	if !ok {
+		return false
+	}
+	slotted.Work, slotted.Salt = work, salt
+	*d = slotted
+	return true
+}
+
+// Returns nil if no work cache is configured, in which case work is always
+// computed.
+func openWorkCache(opt *cmdOptions) *workcache.Cache {
+	if opt.WorkCache == "" {
+		return nil
+	}
+	c, err := workcache.Open(opt.WorkCache)
+	if err != nil {
+		exit(errs.General, "failed to open work cache: %s", err)
+	}
+	return c
+}
+
+// Returns the schemas of the config, so values are checked before any work
+// is done for them.
+func loadSchemas(nodeCfg *cfg.NodeCfg) *schema.Set {
+	schemas, err := node.LoadSchemas(nodeCfg.Schemas)
+	if err != nil {
+		exit(errs.Config, "%s", err)
+	}
+	return schemas
+}
+
+// Returns the remote signer if configured, otherwise signs with the data key.
+func dataSigner(opt *cmdOptions, nodeCfg *cfg.NodeCfg) signer.Signer {
+	rs := nodeCfg.RemoteSigner
+	if rs == nil {
+		return signer.Local(readDataKey(opt, nodeCfg))
+	}
+	s, err := signer.NewRemote(&signer.RemoteCfg{
+		URL:      rs.URL,
+		PubKey:   rs.PubKey,
+		CertFile: rs.CertFile,
+		KeyFile:  rs.KeyFile,
+		CAFile:   rs.CAFile,
 	})
 	if err != nil {
-		return nil, nil, err
+		exit(errs.Config, "failed to init remote signer: %s", err)
 	}
-	return d, logs, nil
+	return s
 }
 
 func parseFlags() (*cmdOptions, *cfg.NodeCfgUnparsed, string) {
 	cfgFilename := flag.String("cfg", "", "Config filename")
+	profile := flag.String("profile", "", "Named profile of the config file to merge onto its top-level settings.")
+	cfgDir := flag.String("cfg_dir", "", "For multi command. Directory of config files, one per node.")
+	jsonOut := flag.Bool("json", false, "Print command results as JSON to stdout, other text to stderr.")
 	// CLI flags
 	dataKeyFname := flag.String("data_key_filename", "", "Data private key filename")
 	difficulty := flag.Uint("d", network.MIN_WORK, "For set command. Number of leading zero bits.")
 	ntest := flag.Int("ntest", 1, "For put command. Repeat work & send n times. For testing.")
-	timeout := flag.Duration("timeout", 10*time.Second, "Timeout for get command.")
+	timeout := flag.Duration("timeout", 10*time.Second, "Timeout for get & verify commands, and for send confirmation of put, import & bundle send.")
 	npeer := flag.Int("npeer", 1, "Number of peers to wait for.")
+	namespace := flag.String("ns", "", "Key namespace for put and get commands.")
+	pubKey := flag.String("pubkey", "", "For link command. Base64 public key of the dat a link refers to.")
+	followLinks := flag.Bool("follow_links", false, "For get command. If the dat is a link, get the dat it refers to, up to link_depth links.")
+	loadRate := flag.String("rate", "10/s", "For load command. Dats put per second, minute or hour, e.g. 100/s.")
+	loadSize := cfg.Size(512)
+	flag.Var(&loadSize, "size", "For load command. Size of each value, such as 512 or 4KiB.")
+	loadDuration := flag.Duration("duration", time.Minute, "For load command. How long to generate load.")
+	timeFlag := flag.String("time", "", "For put, import & bundle commands. Fixed dat time, RFC3339 or unix milli, so the same dat can be rebuilt.")
+	workCache := flag.String("work_cache", "", "For put, import & bundle commands. File caching proof-of-work by signature.")
+	profileCPU := flag.Duration("cpu", 0, "For profile command. Record a CPU profile for this long.")
+	encryptFor := flag.String("encrypt_for", "", "For put command. Encrypt value for base64 public key.")
+	templateFile := flag.String("template", "", "For put command. Render the value from a Go template file, showing the changes for review first.")
+	env := flag.Bool("env", false, "For put command with -template. Make environment variables available, as {{env \"NAME\"}}.")
+	yes := flag.Bool("yes", false, "For put command with -template. Put after showing the changes, without asking.")
 	// Node flags
 	nodeKeyFname := flag.String("key_filename", "", "Node private key filename")
 	udpLaddr := flag.String("udp_listen_addr", "", "Listen address:port")
+	apiLaddr := flag.String("api_listen_addr", "", "HTTP API listen address:port, also used by remote commands")
+	respLaddr := flag.String("resp_listen_addr", "", "Redis protocol listen address:port, set to enable.")
+	grpcLaddr := flag.String("grpc_listen_addr", "", "gRPC listen address:port, set to enable. Needs a build with the grpc tag.")
 	edges := flag.String("edges", "", "Comma-separated bootstrap address:port")
+	prefer := flag.String("prefer", "", "Address family tried first for edges with a hostname, ipv4, ipv6 or both.")
 	backup := flag.String("backup_filename", "", "Backup file, set to enable.")
-	shardCap := flag.Int64("shard_capacity", 0, "Shard capacity. There are 256 shards.")
-	logLevel := flag.String("log_level", "", "Log level ERROR or DEBUG.")
+	auditFilename := flag.String("audit_filename", "", "Audit log of mutating API calls, set to enable.")
+	tokensFilename := flag.String("tokens_filename", "", "Tenant tokens file, set to require tokens for the API.")
+	var shardCap, maxMemory cfg.Size
+	flag.Var(&shardCap, "shard_capacity", "Shard capacity, such as 256MiB. There are 256 shards.")
+	flag.Var(&maxMemory, "max_memory", "Memory to size the node to, such as 256MiB. Defaults to the cgroup limit, 0 for none.")
+	mode := flag.String("mode", "", "Node mode, normal, readonly or edge.")
+	logLevel := flag.String("log_level", "", "Log level TRACE, DEBUG, INFO, WARN or ERROR.")
+	logLevels := flag.String("log_levels", "", "Comma-separated subsystem=LEVEL, e.g. api=DEBUG,events=ERROR.")
 	logUnbuffered := flag.String("log_unbuffered", "", "Flush log buffer after each write.")
+	historyPubKeys := flag.String("history_pubkeys", "", "Comma-separated base64 public keys to keep version history for.")
+	historyDepth := flag.Int("history_depth", 0, "Superseded versions kept per key.")
+	historyFilename := flag.String("history_filename", "", "File keeping version history across restarts.")
+	linkDepth := flag.Int("link_depth", 0, "Links followed from a dat, at most, by get -follow_links and the API.")
+	otlpEndpoint := flag.String("otlp_endpoint", "", "Export traces via OTLP/HTTP, e.g. http://localhost:4318.")
+	apiAllowedCidrs := flag.String("api_allowed_cidrs", "", "Comma-separated CIDRs allowed to use the HTTP API.")
+	metricsSink := flag.String("metrics_sink", "", "Push metrics to statsd://host:port or graphite://host:port.")
 	flag.Parse()
 	opt := &cmdOptions{
 		DataKeyFilename: *dataKeyFname,
@@ -192,72 +795,164 @@ func parseFlags() (*cmdOptions, *cfg.NodeCfgUnparsed, string) {
 		Ntest:           *ntest,
 		Timeout:         *timeout,
 		PeerCount:       *npeer,
+		EncryptFor:      *encryptFor,
+		Template:        *templateFile,
+		Env:             *env,
+		Yes:             *yes,
+		JSON:            *jsonOut,
+		Namespace:       *namespace,
+		PubKey:          *pubKey,
+		FollowLinks:     *followLinks,
+		ProfileCPU:      *profileCPU,
+		Time:            parseDatTime(*timeFlag),
+		WorkCache:       *workCache,
+		Profile:         *profile,
+		CfgDir:          *cfgDir,
+		LoadRate:        *loadRate,
+		LoadSize:        loadSize,
+		LoadDuration:    *loadDuration,
 	}
+	// Only flags given on the command line are set, so they can set zero values
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
 	cfg := &cfg.NodeCfgUnparsed{
-		KeyFilename:    *nodeKeyFname,
-		UdpListenAddr:  *udpLaddr,
-		Edges:          strings.Split(*edges, ","),
-		BackupFilename: *backup,
-		ShardCapacity:  *shardCap,
-		LogLevel:       *logLevel,
-		LogUnbuffered:  *logUnbuffered,
+		KeyFilename:     flagValue(set, "key_filename", *nodeKeyFname),
+		UdpListenAddr:   flagValue(set, "udp_listen_addr", *udpLaddr),
+		ApiListenAddr:   flagValue(set, "api_listen_addr", *apiLaddr),
+		RespListenAddr:  flagValue(set, "resp_listen_addr", *respLaddr),
+		GRPCListenAddr:  flagValue(set, "grpc_listen_addr", *grpcLaddr),
+		Edges:           flagList(set, "edges", *edges),
+		Prefer:          flagValue(set, "prefer", *prefer),
+		BackupFilename:  flagValue(set, "backup_filename", *backup),
+		AuditFilename:   flagValue(set, "audit_filename", *auditFilename),
+		TokensFilename:  flagValue(set, "tokens_filename", *tokensFilename),
+		ShardCapacity:   flagValue(set, "shard_capacity", shardCap),
+		MaxMemory:       flagValue(set, "max_memory", maxMemory),
+		LogLevel:        flagValue(set, "log_level", *logLevel),
+		LogLevels:       flagMap(set, "log_levels", *logLevels),
+		LogUnbuffered:   flagValue(set, "log_unbuffered", *logUnbuffered),
+		HistoryPubKeys:  flagList(set, "history_pubkeys", *historyPubKeys),
+		HistoryDepth:    flagValue(set, "history_depth", *historyDepth),
+		HistoryFilename: flagValue(set, "history_filename", *historyFilename),
+		LinkDepth:       flagValue(set, "link_depth", *linkDepth),
+		Mode:            flagValue(set, "mode", *mode),
+		MetricsSink:     flagValue(set, "metrics_sink", *metricsSink),
+		ApiAllowedCidrs: flagList(set, "api_allowed_cidrs", *apiAllowedCidrs),
+		OtlpEndpoint:    flagValue(set, "otlp_endpoint", *otlpEndpoint),
 	}
 	return opt, cfg, *cfgFilename
 }
 
-func put(d *godave.Dave, key string, val []byte, privKey ed25519.PrivateKey, opt *cmdOptions) {
-	fmt.Printf("waiting for %d peers...\n", opt.PeerCount)
-	d.WaitForActivePeers(context.Background(), opt.PeerCount)
-	pubKey := privKey.Public().(ed25519.PublicKey)
-	datCh, errors, err := d.BatchWriter(pubKey)
+// Returns a pointer to v if the flag was given, otherwise nil.
+func flagValue[T any](set map[string]bool, name string, v T) *T {
+	if !set[name] {
+		return nil
+	}
+	return &v
+}
+
+// Parses a comma-separated list flag, which appends if it begins with +.
+// An empty list given explicitly clears the setting.
+func flagList(set map[string]bool, name, v string) cfg.List[string] {
+	if !set[name] {
+		return cfg.List[string]{}
+	}
+	v, appending := strings.CutPrefix(v, "+")
+	items := make([]string, 0)
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return cfg.List[string]{Items: items, Append: appending, Set: true}
+}
+
+// Parses a comma-separated flag of name=value pairs.
+func flagMap(set map[string]bool, name, v string) map[string]string {
+	if !set[name] {
+		return nil
+	}
+	m := make(map[string]string)
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			k, v, _ := strings.Cut(item, "=")
+			m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return m
+}
+
+type putResult struct {
+	Keys   []string `json:"keys"`
+	TookMs int64    `json:"took_ms"`
+}
+
+type getResult struct {
+	Key    string `json:"key"`
+	Val    string `json:"val"`
+	PubKey string `json:"pubkey"`
+	Time   int64  `json:"time"` // Unix milli
+	TookMs int64  `json:"took_ms"`
+}
+
+func put(d *godave.Dave, key string, val []byte, s signer.Signer, opt *cmdOptions) {
+	ctx, span := trace.Start(context.Background(), "put")
+	defer span.End()
+	span.SetAttr("count", opt.Ntest)
+	span.SetAttr("difficulty", int(opt.Difficulty))
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	info("waiting for %d peers...", opt.PeerCount)
+	_, wait := trace.Start(ctx, "wait_peers")
+	d.WaitForActivePeers(ctx, opt.PeerCount)
+	wait.End()
+	p, err := newSendPipeline(ctx, d, s, opt)
 	if err != nil {
-		exit(1, "failed to get batch writer: %s", err)
-	}
-	keyInc := key
-	work := make(chan dat.Dat, runtime.NumCPU())
-	wg := sync.WaitGroup{}
-	for i := 0; i < runtime.NumCPU(); i++ {
-		wg.Add(1)
-		go func() {
-			for w := range work {
-				(&w).Sign(privKey)
-				w.Work, w.Salt = dat.DoWork(w.Sig, opt.Difficulty)
-				datCh <- w
-			}
-			wg.Done()
-		}()
+		exit(errs.General, "%s", err)
 	}
+	pubKey := s.PublicKey()
 	start := time.Now()
+	keys := make([]string, 0, opt.Ntest)
+	if opt.Ntest == 1 {
+		info("computing proof...")
+	}
 	for i := 0; i < opt.Ntest; i++ {
+		keyInc := key
 		if i > 0 {
 			keyInc = fmt.Sprintf("%s_%d", key, i)
 		}
-		// 100ms margin, incase clocks are not well synchronised
-		new := &dat.Dat{Key: keyInc, Val: val, Time: time.Now().Add(-100 * time.Millisecond), PubKey: pubKey}
-		if opt.Ntest == 1 {
-			fmt.Println("computing proof...")
-		}
-		work <- *new
-		select {
-		case err := <-errors:
-			exit(1, "error: %s", err)
-		default:
+		if p.Submit(&dat.Dat{Key: keyInc, Val: val, Time: datTime(opt), PubKey: pubKey}) != nil {
+			break // reported by Close
 		}
-		fmt.Printf("put %s\n", new.Key)
+		info("put %s", keyInc)
+		keys = append(keys, keyInc)
 	}
-	close(work)
-	wg.Wait()
-	close(datCh)
-	fmt.Printf("took %s\n", time.Since(start))
-	time.Sleep(50 * time.Millisecond) // Let sending finish
+	sent, err := p.Close(opt.Timeout)
+	took := time.Since(start)
+	span.SetError(err)
+	if err != nil {
+		exit(errs.General, "put failed after %d of %d sent: %s", sent, len(keys), err)
+	}
+	result(&putResult{Keys: keys, TookMs: took.Milliseconds()}, "took %s", took)
 }
 
 func exit(code int, msg string, args ...any) {
 	time.Sleep(time.Millisecond) // wait for logs to flush
-	fmt.Printf(msg+"\n", args...)
+	flushTraces()
+	info(msg, args...)
+	if jsonOutput && code != 0 {
+		printJSON(&errorResult{Error: fmt.Sprintf(msg, args...), Code: code})
+	}
 	os.Exit(code)
 }
 
+// Exports pending spans, if tracing is enabled.
+func flushTraces() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	trace.Shutdown(ctx)
+}
+
 func cancelOnKillSig(sigs chan os.Signal, cancel context.CancelFunc) {
 	switch <-sigs {
 	case syscall.SIGINT:
@@ -0,0 +1,79 @@
+// Package memlimit finds the memory the node may use, from config or the
+// container's cgroup, and divides it between the store, caches and queues,
+// so a node in a small container stays within its limit.
+package memlimit
+
+import (
+	"os"
+	"runtime"
+	rmetrics "runtime/metrics"
+	"strconv"
+	"strings"
+
+	"github.com/intob/daved/metrics"
+)
+
+// Shares of the budget
+const (
+	StoreShare     = 1. / 2  // The store's capacity
+	ReadCacheShare = 1. / 16 // The read cache
+	WsShare        = 1. / 16 // Websocket connections
+	GoLimitShare   = 0.9     // Soft limit of the Go runtime, leaving headroom outside the heap
+)
+
+// Memory held per websocket connection, mostly its buffers and goroutines.
+const WsConnCost = 64 * 1024
+
+// cgroup v1 reports no limit as a huge number instead of "max".
+const noLimitV1 = 1 << 62
+
+var cgroupFiles = []string{
+	"/sys/fs/cgroup/memory.max",                   // cgroup v2
+	"/sys/fs/cgroup/memory/memory.limit_in_bytes", // cgroup v1
+}
+
+// Detect returns the memory limit of the process's cgroup, or zero if
+// there is none.
+func Detect() int64 {
+	for _, name := range cgroupFiles {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		s := strings.TrimSpace(string(b))
+		if s == "max" {
+			return 0
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n <= 0 || n >= noLimitV1 {
+			return 0
+		}
+		return n
+	}
+	return 0
+}
+
+// Register adds gauges of the budget and of the Go runtime's memory use.
+func Register(reg *metrics.Registry, budget int64) {
+	reg.GaugeFunc("daved_memory_budget_bytes", "Memory the node sizes itself to, zero if unlimited.", func() float64 {
+		return float64(budget)
+	})
+	reg.GaugeFunc("daved_memory_heap_bytes", "Bytes of live and unswept heap objects.", func() float64 {
+		return sample("/memory/classes/heap/objects:bytes")
+	})
+	reg.GaugeFunc("daved_memory_runtime_bytes", "Bytes mapped by the Go runtime.", func() float64 {
+		return sample("/memory/classes/total:bytes")
+	})
+	reg.GaugeFunc("daved_goroutines", "Goroutines running.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+}
+
+func sample(name string) float64 {
+	s := []rmetrics.Sample{{Name: name}}
+	rmetrics.Read(s)
+	if s[0].Value.Kind() != rmetrics.KindUint64 {
+		return 0
+	}
+	return float64(s[0].Value.Uint64())
+}
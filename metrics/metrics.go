@@ -0,0 +1,142 @@
+// Package metrics is a minimal registry of counters and gauges, exposed in
+// the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+type Registry struct {
+	mu      sync.RWMutex
+	metrics map[string]*metric
+}
+
+type metric struct {
+	help   string
+	kind   string // counter or gauge
+	handle any    // *Counter, *Gauge or func() float64
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]*metric)}
+}
+
+type Counter struct {
+	v atomic.Uint64
+}
+
+func (c *Counter) Inc()          { c.v.Add(1) }
+func (c *Counter) Add(n uint64)  { c.v.Add(n) }
+func (c *Counter) Value() uint64 { return c.v.Load() }
+
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+func (g *Gauge) Set(v float64)  { g.bits.Store(math.Float64bits(v)) }
+func (g *Gauge) Value() float64 { return math.Float64frombits(g.bits.Load()) }
+
+// Counter registers and returns a counter. Registering an existing name
+// returns the existing counter.
+func (r *Registry) Counter(name, help string) *Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.metrics[name]; ok {
+		if c, ok := m.handle.(*Counter); ok {
+			return c
+		}
+	}
+	c := &Counter{}
+	r.metrics[name] = &metric{help: help, kind: "counter", handle: c}
+	return c
+}
+
+// Gauge registers and returns a gauge. Registering an existing name returns
+// the existing gauge.
+func (r *Registry) Gauge(name, help string) *Gauge {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.metrics[name]; ok {
+		if g, ok := m.handle.(*Gauge); ok {
+			return g
+		}
+	}
+	g := &Gauge{}
+	r.metrics[name] = &metric{help: help, kind: "gauge", handle: g}
+	return g
+}
+
+// CounterFunc registers a counter whose value is read from fn when
+// collected. Fn must never decrease.
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics[name] = &metric{help: help, kind: "counter", handle: fn}
+}
+
+// GaugeFunc registers a gauge whose value is read from fn when collected.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics[name] = &metric{help: help, kind: "gauge", handle: fn}
+}
+
+// Snapshot returns the current value of each metric.
+func (r *Registry) Snapshot() map[string]float64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	snap := make(map[string]float64, len(r.metrics))
+	for name, m := range r.metrics {
+		snap[name] = m.value()
+	}
+	return snap
+}
+
+// Kind returns "counter" or "gauge", or an empty string if the metric is
+// not registered.
+func (r *Registry) Kind(name string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if m, ok := r.metrics[name]; ok {
+		return m.kind
+	}
+	return ""
+}
+
+// WritePrometheus writes all metrics in the Prometheus text format.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+	for _, name := range names {
+		r.mu.RLock()
+		m := r.metrics[name]
+		r.mu.RUnlock()
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, m.help, name, m.kind, name, m.value())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *metric) value() float64 {
+	switch h := m.handle.(type) {
+	case *Counter:
+		return float64(h.Value())
+	case *Gauge:
+		return h.Value()
+	case func() float64:
+		return h()
+	}
+	return 0
+}
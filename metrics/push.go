@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/intob/daved/logbuf"
+)
+
+// Sink protocols
+const (
+	SINK_STATSD   = "statsd"   // UDP, counters sent as deltas
+	SINK_GRAPHITE = "graphite" // TCP plaintext protocol
+)
+
+// Max statsd payload, fits a typical MTU.
+const statsdPacketSize = 1432
+
+// Push sends the registry's metrics to addr on each interval until ctx is
+// cancelled.
+func (r *Registry) Push(ctx context.Context, protocol, addr string, interval time.Duration, logs chan<- string) {
+	sent := make(map[string]float64)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		var err error
+		switch protocol {
+		case SINK_STATSD:
+			err = r.pushStatsd(addr, sent)
+		case SINK_GRAPHITE:
+			err = r.pushGraphite(addr)
+		default:
+			err = fmt.Errorf("unknown protocol %q", protocol)
+		}
+		if err != nil {
+			logbuf.For(logs, "metrics").Printf("push to %s failed: %s", addr, err)
+		}
+	}
+}
+
+func (r *Registry) sortedSnapshot() ([]string, map[string]float64) {
+	snap := r.Snapshot()
+	names := make([]string, 0, len(snap))
+	for name := range snap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, snap
+}
+
+func (r *Registry) pushStatsd(addr string, sent map[string]float64) error {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	names, snap := r.sortedSnapshot()
+	buf := &bytes.Buffer{}
+	for _, name := range names {
+		var line string
+		if r.Kind(name) == "counter" {
+			delta := snap[name] - sent[name]
+			sent[name] = snap[name]
+			if delta <= 0 {
+				continue
+			}
+			line = fmt.Sprintf("%s:%v|c\n", name, delta)
+		} else {
+			line = fmt.Sprintf("%s:%v|g\n", name, snap[name])
+		}
+		if buf.Len()+len(line) > statsdPacketSize {
+			if _, err := conn.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			buf.Reset()
+		}
+		buf.WriteString(line)
+	}
+	if buf.Len() > 0 {
+		_, err = conn.Write(buf.Bytes())
+	}
+	return err
+}
+
+func (r *Registry) pushGraphite(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	names, snap := r.sortedSnapshot()
+	now := time.Now().Unix()
+	buf := &bytes.Buffer{}
+	for _, name := range names {
+		fmt.Fprintf(buf, "%s %v %d\n", name, snap[name], now)
+	}
+	_, err = conn.Write(buf.Bytes())
+	return err
+}
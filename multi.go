@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/intob/daved/api"
+	"github.com/intob/daved/cfg"
+	"github.com/intob/daved/errs"
+	"github.com/intob/daved/logbuf"
+	"github.com/intob/daved/loglevel"
+	"github.com/intob/daved/logtail"
+	"github.com/intob/daved/node"
+	"github.com/intob/godave/logger"
+)
+
+type namedNode struct {
+	name string
+	*node.Node
+}
+
+// Runs a node for each config file in -cfg_dir until killed, named by the
+// file without its extension. The combined status is served at the API
+// listen address of the flags or -cfg, so it must differ from the nodes'.
+func multiCommand(opt *cmdOptions, nodeCfg *cfg.NodeCfg) {
+	if opt.CfgDir == "" {
+		exit(errs.Usage, "correct usage is multi -cfg_dir <DIR>")
+	}
+	entries, err := os.ReadDir(opt.CfgDir) // Sorted by name
+	if err != nil {
+		exit(errs.Config, "failed to read config dir: %s", err)
+	}
+	ctx := getCtx()
+	out := logTail.Tee(logger.StdOut(!nodeCfg.LogUnbuffered))
+	nodes := make([]*namedNode, 0, len(entries))
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		switch strings.ToLower(ext) {
+		case ".yaml", ".yml", ".toml", ".json":
+		default:
+			continue
+		}
+		if e.IsDir() {
+			continue
+		}
+		name := strings.TrimSuffix(e.Name(), ext)
+		c, err := readMultiNodeCfg(filepath.Join(opt.CfgDir, e.Name()), opt.Profile)
+		if err != nil {
+			exit(errs.Config, "node %s: %s", name, err)
+		}
+		records, s := workPool(opt, c)
+		levels := loglevel.New(c.LogLevel, c.LogLevels)
+		tail := logtail.New(1000)
+		buf := logbuf.New(logbuf.DefaultLines)
+		n, err := node.Run(ctx, &node.Cfg{
+			Node:            c,
+			Logs:            levels.Filter(buf.Pipe(tail.Tee(prefixLogs("["+name+"] ", out)))),
+			LogLevels:       levels,
+			LogBuffer:       buf,
+			Version:         commit,
+			LogTail:         tail,
+			Signer:          s,
+			WorkPoolRecords: records,
+		})
+		if err != nil {
+			exit(errs.Code(err), "failed to start node %s: %s", name, err)
+		}
+		info("started node %s, api on http://%s", name, n.Addr())
+		nodes = append(nodes, &namedNode{name: name, Node: n})
+	}
+	if len(nodes) == 0 {
+		exit(errs.Config, "no config files in %s", opt.CfgDir)
+	}
+	listener, err := net.Listen("tcp", nodeCfg.ApiListenAddr)
+	if err != nil {
+		exit(errs.General, "failed to start combined status server: %s", err)
+	}
+	server := &http.Server{Handler: api.MultiHandler(func() []api.NodeStatus {
+		statuses := make([]api.NodeStatus, 0, len(nodes))
+		for _, n := range nodes {
+			statuses = append(statuses, api.NodeStatus{
+				Name:    n.name,
+				ApiAddr: n.Addr(),
+				Status:  n.Status(),
+				Health:  n.Health(),
+			})
+		}
+		return statuses
+	})}
+	go server.Serve(listener)
+	info("combined status on http://%s/v1/status", listener.Addr())
+	<-ctx.Done()
+	server.Close()
+	for _, n := range nodes {
+		<-n.Done()
+	}
+	flushTraces()
+	fmt.Println("shutdown gracefully")
+}
+
+// Reads a node's config file, with the profile selected if set. Flags are
+// not merged, as addresses must differ between nodes.
+func readMultiNodeCfg(filename, profile string) (*cfg.NodeCfg, error) {
+	unparsed, err := cfg.ReadNodeCfgFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %s", err)
+	}
+	unparsed, err = cfg.SelectProfile(unparsed, profile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select profile: %s", err)
+	}
+	return cfg.ParseNodeCfg(unparsed)
+}
+
+// Prefixes each line with the name of its node.
+func prefixLogs(prefix string, out chan<- string) chan<- string {
+	in := make(chan string, cap(out))
+	go func() {
+		for line := range in {
+			out <- prefix + line
+		}
+	}()
+	return in
+}
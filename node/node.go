@@ -0,0 +1,502 @@
+// Package node runs a daved node: the godave instance, daved's components
+// and the HTTP API, as the daved binary does when run without a command.
+// Other Go programs can embed a node with Run instead of running the binary.
+package node
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/intob/daved/api"
+	"github.com/intob/daved/audit"
+	"github.com/intob/daved/cfg"
+	"github.com/intob/daved/clock"
+	"github.com/intob/daved/crash"
+	"github.com/intob/daved/dns"
+	"github.com/intob/daved/errs"
+	"github.com/intob/daved/events"
+	"github.com/intob/daved/history"
+	"github.com/intob/daved/logbuf"
+	"github.com/intob/daved/loglevel"
+	"github.com/intob/daved/logtail"
+	"github.com/intob/daved/memlimit"
+	"github.com/intob/daved/metrics"
+	"github.com/intob/daved/readcache"
+	"github.com/intob/daved/resp"
+	"github.com/intob/daved/schema"
+	"github.com/intob/daved/signer"
+	"github.com/intob/daved/status"
+	"github.com/intob/daved/tenant"
+	"github.com/intob/daved/upload"
+	"github.com/intob/daved/watchdog"
+	"github.com/intob/daved/workcache"
+	"github.com/intob/daved/workpool"
+	"github.com/intob/godave"
+	"github.com/intob/godave/dat"
+	"github.com/intob/godave/logger"
+	"github.com/intob/godave/network"
+	"github.com/intob/godave/types"
+)
+
+type Cfg struct {
+	Node            *cfg.NodeCfg
+	Logs            chan<- string    // Optional, defaults to stdout filtered by LogLevels
+	LogLevels       *loglevel.Levels // Optional, set to the levels of Node
+	LogTail         *logtail.Tail    // Optional, serves /logs
+	LogBuffer       *logbuf.Buffer   // Optional, the buffer of Logs, for its metrics
+	Signer          signer.Signer    // Signs work pool records, Redis protocol sets and uploads, required with any
+	WorkPoolRecords []workpool.Record
+	Version         string // Optional, the commit served at /v1/meta
+}
+
+// Node is a running node. It stops when the context passed to Run is
+// cancelled.
+type Node struct {
+	Dave      *godave.Dave
+	svc       *api.Service
+	readCache *readcache.Cache
+	readOnly  bool
+	dog       *watchdog.Watchdog
+	bus       *events.Bus
+	done      chan struct{}
+}
+
+// Run starts a node and its API, returning once the API is listening.
+func Run(ctx context.Context, c *Cfg) (*Node, error) {
+	nodeCfg := c.Node
+	levels := c.LogLevels
+	if levels == nil {
+		levels = loglevel.New(nodeCfg.LogLevel, nodeCfg.LogLevels)
+	} else {
+		levels.Set(nodeCfg.LogLevel, nodeCfg.LogLevels)
+	}
+	logs, buf := c.Logs, c.LogBuffer
+	if logs == nil {
+		out := logger.StdOut(!nodeCfg.LogUnbuffered)
+		if c.LogTail != nil {
+			out = c.LogTail.Tee(out)
+		}
+		buf = logbuf.New(logbuf.DefaultLines)
+		logs = levels.Filter(buf.Pipe(out))
+	}
+	d, err := NewDave(nodeCfg, logs, levels)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	started := false
+	defer func() {
+		if !started {
+			cancel()
+			d.Kill()
+		}
+	}()
+	bus := events.NewBus()
+	dog := watchdog.New(&watchdog.Cfg{
+		Dave:          d,
+		Bus:           bus,
+		Interval:      nodeCfg.Watchdog.Interval,
+		NoPeersAfter:  nodeCfg.Watchdog.NoPeersAfter,
+		MinPeers:      nodeCfg.Watchdog.MinPeers,
+		MinPeersAfter: nodeCfg.Watchdog.MinPeersAfter,
+		Logs:          logs,
+	})
+	crash.Go(func() { dog.Run(ctx) })
+	var hist *history.History
+	if len(nodeCfg.HistoryPubKeys) > 0 {
+		hist, err = history.NewHistory(&history.HistoryCfg{
+			PubKeys:  nodeCfg.HistoryPubKeys,
+			Depth:    nodeCfg.HistoryDepth,
+			Filename: nodeCfg.HistoryFilename,
+			Logs:     logs,
+		})
+		if err != nil {
+			return nil, errs.Wrap(errs.ErrConfig, fmt.Errorf("failed to init history: %w", err))
+		}
+		dog.Supervise(ctx, "history", func(ctx context.Context) { hist.Run(ctx, bus, 10*time.Second) })
+	}
+	watcherCfg := &events.WatcherCfg{
+		Bus:               bus,
+		Dave:              d,
+		BackupFilename:    nodeCfg.BackupFilename,
+		CapacityThreshold: nodeCfg.CapacityThreshold,
+		Interval:          5 * time.Second,
+	}
+	dog.Supervise(ctx, "events", func(ctx context.Context) { events.Watch(ctx, watcherCfg) })
+	alerterCfg := &events.AlerterCfg{
+		Bus:            bus,
+		Dave:           d,
+		BackupFilename: nodeCfg.BackupFilename,
+		MinPeers:       nodeCfg.Alerts.MinPeers,
+		MaxUsedPercent: nodeCfg.Alerts.MaxUsedPercent,
+		MaxBackupAge:   nodeCfg.Alerts.MaxBackupAge,
+		Interval:       nodeCfg.Alerts.Interval,
+		Logs:           logs,
+	}
+	dog.Supervise(ctx, "alerts", func(ctx context.Context) { events.Monitor(ctx, alerterCfg) })
+	for _, h := range nodeCfg.Webhooks {
+		err = events.Deliver(ctx, bus, &events.Webhook{
+			URL:     h.URL,
+			Events:  h.Events,
+			Secret:  h.Secret,
+			Retries: h.Retries,
+		}, logs)
+		if err != nil {
+			return nil, errs.Wrap(errs.ErrConfig, fmt.Errorf("invalid webhook %s: %w", h.URL, err))
+		}
+	}
+	for _, h := range nodeCfg.Hooks {
+		err = events.Exec(ctx, bus, &events.Hook{
+			Command:   h.Command,
+			Events:    h.Events,
+			KeyPrefix: h.KeyPrefix,
+			Timeout:   h.Timeout,
+		}, logs)
+		if err != nil {
+			return nil, errs.Wrap(errs.ErrConfig, fmt.Errorf("invalid hook %s: %w", h.Command[0], err))
+		}
+	}
+	statusHistory := status.NewRecorder(&status.RecorderCfg{
+		Interval:  10 * time.Second,
+		Retention: nodeCfg.StatusHistory,
+		Dave:      d,
+	})
+	dog.Supervise(ctx, "status", func(ctx context.Context) { statusHistory.Run(ctx) })
+	reg := metrics.NewRegistry()
+	memlimit.Register(reg, nodeCfg.MaxMemory)
+	if buf != nil {
+		reg.GaugeFunc("daved_log_queue_lines", "Log lines waiting to be written.", func() float64 {
+			return float64(buf.Len())
+		})
+		reg.CounterFunc("daved_log_dropped_total", "Log lines dropped because their consumer was too slow.", func() float64 {
+			return float64(buf.Dropped())
+		})
+	} else {
+		reg.GaugeFunc("daved_log_queue_lines", "Log lines waiting to be written.", func() float64 {
+			return float64(len(logs))
+		})
+	}
+	if nodeCfg.MaxMemory > 0 && os.Getenv("GOMEMLIMIT") == "" {
+		debug.SetMemoryLimit(int64(memlimit.GoLimitShare * float64(nodeCfg.MaxMemory)))
+	}
+	if nodeCfg.MetricsSink != nil {
+		dog.Supervise(ctx, "metrics", func(ctx context.Context) {
+			reg.Push(ctx, nodeCfg.MetricsSink.Protocol, nodeCfg.MetricsSink.Addr, nodeCfg.MetricsPushInterval, logs)
+		})
+	}
+	if p := nodeCfg.WorkPool; p != nil {
+		if c.Signer == nil {
+			return nil, errs.Wrap(errs.ErrConfig, errors.New("work pool requires a signer"))
+		}
+		cache, err := workcache.Open(p.WorkCache)
+		if err != nil {
+			return nil, errs.Wrap(errs.ErrConfig, fmt.Errorf("failed to open work cache: %w", err))
+		}
+		poolCfg := &workpool.PoolCfg{
+			Records:    c.WorkPoolRecords,
+			Signer:     c.Signer,
+			Cache:      cache,
+			Difficulty: network.MIN_WORK,
+			Slot:       p.Slot,
+			Ahead:      p.Ahead,
+			Workers:    p.Workers,
+			Logs:       logs,
+		}
+		dog.Supervise(ctx, "workpool", func(ctx context.Context) { workpool.Run(ctx, poolCfg) })
+	}
+	if c := nodeCfg.Clock; len(c.NTPServers) > 0 {
+		offset := reg.Gauge("daved_clock_offset_seconds", "Offset of the local clock from NTP servers, measured at startup.")
+		dog.Supervise(ctx, "clock", func(ctx context.Context) { measureClock(ctx, c, offset, logs) })
+	}
+	var readCache *readcache.Cache
+	if nodeCfg.ReadCacheSize > 0 {
+		readCache = readcache.New(nodeCfg.ReadCacheSize, nodeCfg.ReadCacheTTL, reg)
+		dog.Supervise(ctx, "readcache", func(ctx context.Context) { readCache.Watch(ctx, bus) })
+	}
+	var auditLog *audit.Log
+	if nodeCfg.AuditFilename != "" {
+		auditLog, err = audit.Open(nodeCfg.AuditFilename)
+		if err != nil {
+			return nil, errs.Wrap(errs.ErrConfig, fmt.Errorf("failed to open audit log: %w", err))
+		}
+	}
+	schemas, err := LoadSchemas(nodeCfg.Schemas)
+	if err != nil {
+		return nil, errs.Wrap(errs.ErrConfig, err)
+	}
+	var tenants *tenant.Tenants
+	if nodeCfg.TokensFilename != "" {
+		tenants, err = tenant.Open(nodeCfg.TokensFilename, logs)
+		if err != nil {
+			return nil, errs.Wrap(errs.ErrConfig, fmt.Errorf("failed to read tokens: %w", err))
+		}
+		dog.Supervise(ctx, "tenant", func(ctx context.Context) { tenants.Run(ctx, 10*time.Second) })
+	}
+	n := &Node{
+		Dave:      d,
+		readCache: readCache,
+		readOnly:  nodeCfg.Mode == cfg.MODE_READONLY,
+		dog:       dog,
+		bus:       bus,
+		done:      make(chan struct{}),
+	}
+	var uploads *upload.Uploads
+	if nodeCfg.ApiUploads {
+		if c.Signer == nil {
+			return nil, errs.Wrap(errs.ErrConfig, errors.New("uploads require a signer"))
+		}
+		uploads = upload.New(&upload.Cfg{Store: n, Signer: c.Signer, Difficulty: network.MIN_WORK, Logs: logs})
+		dog.Supervise(ctx, "upload", uploads.Run)
+	}
+	svc := api.NewService(&api.ServiceCfg{
+		ListenAddr:    nodeCfg.ApiListenAddr,
+		Logs:          logs,
+		Dave:          d,
+		History:       hist,
+		Schemas:       schemas,
+		ReadOnly:      nodeCfg.Mode == cfg.MODE_READONLY,
+		Metrics:       reg,
+		Events:        bus,
+		Debug:         nodeCfg.ApiDebug,
+		StatusHistory: statusHistory,
+		AccessLog:     nodeCfg.ApiAccessLog,
+		GraphQL:       nodeCfg.ApiGraphQL,
+		Gateway:       nodeCfg.ApiGateway,
+		Uploads:       uploads,
+		LinkDepth:     nodeCfg.LinkDepth,
+		GRPCAddr:      nodeCfg.GRPCListenAddr,
+		ProxyHeader:   nodeCfg.ApiProxyHeader,
+		Proxies:       nodeCfg.ApiTrustedProxies,
+		AllowedCidrs:  nodeCfg.ApiAllowedCidrs,
+		DeniedCidrs:   nodeCfg.ApiDeniedCidrs,
+		Websocket: &api.WebsocketCfg{
+			PingInterval:   nodeCfg.Websocket.PingInterval,
+			IdleTimeout:    nodeCfg.Websocket.IdleTimeout,
+			MaxConnsPerIP:  nodeCfg.Websocket.MaxConnsPerIP,
+			MaxConns:       int(memlimit.WsShare * float64(nodeCfg.MaxMemory) / memlimit.WsConnCost),
+			SharedBuffers:  nodeCfg.MaxMemory > 0,
+			AllowedOrigins: nodeCfg.Websocket.AllowedOrigins,
+			Tokens:         nodeCfg.Websocket.Tokens,
+			Bridge:         apiBridge(nodeCfg.Websocket.Bridge),
+		},
+		ReadCache: readCache,
+		LogTail:   c.LogTail,
+		LogLevels: levels,
+		Watchdog:  dog,
+		Audit:     auditLog,
+		Tenants:   tenants,
+		TLS:       apiTLS(nodeCfg.ApiTLS),
+		Version:   c.Version,
+	})
+	n.svc = svc
+	if nodeCfg.RespListenAddr != "" {
+		if c.Signer == nil {
+			return nil, errs.Wrap(errs.ErrConfig, errors.New("redis protocol requires a signer"))
+		}
+		var authorize func(string) bool
+		if svc.HasTokens() {
+			authorize = func(token string) bool { return svc.Permits(token, "write") }
+		}
+		server, err := resp.NewServer(&resp.ServerCfg{
+			Addr:       nodeCfg.RespListenAddr,
+			Store:      n,
+			Signer:     c.Signer,
+			Difficulty: network.MIN_WORK,
+			Timeout:    5 * time.Second,
+			Schemas:    schemas,
+			Authorize:  authorize,
+			Charge:     svc.ChargeSet,
+			Audit:      svc.AuditSet,
+			Logs:       logs,
+		})
+		if err != nil {
+			return nil, errs.Wrap(errs.ErrConfig, fmt.Errorf("failed to start redis protocol server: %w", err))
+		}
+		dog.Supervise(ctx, "resp", server.Run)
+	}
+	if nodeCfg.DNS != nil {
+		server, err := dns.NewServer(&dns.ServerCfg{
+			Addr:  nodeCfg.DNS.ListenAddr,
+			Zone:  nodeCfg.DNS.Zone,
+			TTL:   nodeCfg.DNS.TTL,
+			Store: n,
+			Logs:  logs,
+		})
+		if err != nil {
+			return nil, errs.Wrap(errs.ErrConfig, fmt.Errorf("failed to start dns server: %w", err))
+		}
+		dog.Supervise(ctx, "dns", server.Run)
+	}
+	err = svc.Start()
+	if errors.Is(err, api.ErrNoGRPC) {
+		return nil, errs.Wrap(errs.ErrConfig, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to start http server: %w", err)
+	}
+	started = true
+	go func() {
+		<-ctx.Done()
+		svc.Close()
+		if auditLog != nil {
+			auditLog.Close()
+		}
+		if hist != nil {
+			if err := hist.Flush(); err != nil {
+				logbuf.For(logs, "history").Printf("failed to write history: %s", err)
+			}
+		}
+		if tenants != nil {
+			if err := tenants.Flush(); err != nil {
+				logbuf.For(logs, "tenant").Printf("failed to write tokens: %s", err)
+			}
+		}
+		d.Kill()
+		close(n.done)
+	}()
+	return n, nil
+}
+
+// NewDave starts the godave instance of a node, without daved's components
+// or API. Commands use it to talk to the network.
+func NewDave(nodeCfg *cfg.NodeCfg, logs chan<- string, levels *loglevel.Levels) (*godave.Dave, error) {
+	key, err := cfg.ReadKeyFile(nodeCfg.KeyFilename)
+	if err != nil {
+		return nil, errs.Wrap(errs.ErrKey, fmt.Errorf("failed to load key file: %s", err))
+	}
+	daveLogger, err := logger.NewDaveLogger(&logger.DaveLoggerCfg{
+		Level:  logger.DEBUG, // Filtered by levels
+		Output: logs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create logger: %w", err)
+	}
+	daveCfg := &godave.DaveCfg{
+		UdpListenAddr:  nodeCfg.UdpListenAddr,
+		PrivateKey:     key,
+		Edges:          nodeCfg.Edges,
+		ShardCapacity:  nodeCfg.ShardCapacity,
+		BackupFilename: nodeCfg.BackupFilename,
+		Logger:         levels.Logger(daveLogger),
+	}
+	d, err := godave.NewDave(daveCfg)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Measures the offset of the local clock, applied to the time of dats the
+// node signs, and warns if it is skewed by more than max_skew, as peers
+// would refuse or misorder the dats of its clients too.
+func measureClock(ctx context.Context, c *cfg.Clock, offset *metrics.Gauge, logs chan<- string) {
+	log := logbuf.For(logs, "clock")
+	e, err := clock.Measure(ctx, c.NTPServers)
+	if err != nil {
+		log.Printf("failed to measure clock offset, dats are timed by the local clock: %s", err)
+		return
+	}
+	clock.Set(e)
+	offset.Set(e.Offset.Seconds())
+	if e.Offset.Abs() > c.MaxSkew {
+		log.Printf("WARNING: local clock is %s off %s, more than max_skew %s; synchronise it, dats are timed by %s until then", e.Offset.Round(time.Millisecond), e.Server, c.MaxSkew, e.Server)
+		return
+	}
+	log.Debugf("local clock is %s off %s, ±%s", e.Offset.Round(time.Millisecond), e.Server, e.Uncertainty().Round(time.Millisecond))
+}
+
+// LoadSchemas compiles the schemas of the config, or returns nil if there
+// are none.
+func LoadSchemas(schemas []cfg.Schema) (*schema.Set, error) {
+	if len(schemas) == 0 {
+		return nil, nil
+	}
+	rules := make([]schema.Rule, 0, len(schemas))
+	for _, s := range schemas {
+		sch, err := schema.Load(s.Filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load schema: %w", err)
+		}
+		rules = append(rules, schema.Rule{Prefix: s.Prefix, Schema: sch})
+	}
+	return schema.NewSet(rules), nil
+}
+
+// Returns nil if the API is served over plain HTTP.
+func apiTLS(t *cfg.ApiTLS) *api.TLSCfg {
+	if t == nil {
+		return nil
+	}
+	return &api.TLSCfg{
+		CertFile:     t.CertFile,
+		KeyFile:      t.KeyFile,
+		ClientCAFile: t.ClientCAFile,
+		ClientScopes: t.ClientScopes,
+	}
+}
+
+// Returns nil if the bridge is disabled.
+func apiBridge(b *cfg.Bridge) *api.BridgeCfg {
+	if b == nil {
+		return nil
+	}
+	return &api.BridgeCfg{
+		MaxKeys:  b.MaxKeys,
+		Rate:     b.Rate,
+		Burst:    b.Burst,
+		MaxPuts:  b.MaxPuts,
+		MaxBytes: b.MaxBytes,
+	}
+}
+
+// Done is closed when the node has stopped.
+func (n *Node) Done() <-chan struct{} {
+	return n.done
+}
+
+// Addr returns the address the API is listening on.
+func (n *Node) Addr() string {
+	return n.svc.Addr()
+}
+
+// Status returns the same status as the API at /status.
+func (n *Node) Status() *api.Status {
+	return n.svc.Status()
+}
+
+// Health returns the same state as the API at /healthz.
+func (n *Node) Health() *watchdog.State {
+	return n.dog.State()
+}
+
+// Put sends a dat to the network. It must be signed and have its proof of
+// work, as prepared by a signer and the API at /work.
+func (n *Node) Put(d dat.Dat) error {
+	if n.readOnly {
+		return errors.New("node is in readonly mode")
+	}
+	if err := n.Dave.Put(d); err != nil {
+		return err
+	}
+	n.bus.Publish(events.DAT_PUT, events.NewDatEvent(&d))
+	return nil
+}
+
+// Get returns the dat with key signed by pubKey, from the read cache if it
+// has it, or nil if the network does not.
+func (n *Node) Get(ctx context.Context, pubKey ed25519.PublicKey, key string) (*dat.Dat, error) {
+	if d, ok := n.readCache.Get(pubKey, key); ok {
+		return d, nil
+	}
+	entry, err := n.Dave.Get(ctx, &types.Get{PublicKey: pubKey, DatKey: key})
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	n.readCache.Put(&entry.Dat)
+	return &entry.Dat, nil
+}
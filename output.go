@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Set by the -json flag. Results are then written to stdout as JSON, and
+// human-readable text is moved to stderr.
+var jsonOutput bool
+
+// Returns where human-readable progress text should go.
+func humanOut() io.Writer {
+	if jsonOutput {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// Prints human-readable progress text.
+func info(msg string, args ...any) {
+	fmt.Fprintf(humanOut(), msg+"\n", args...)
+}
+
+// Prints the result of a command, as v in JSON mode, or as msg otherwise.
+func result(v any, msg string, args ...any) {
+	if !jsonOutput {
+		fmt.Printf(msg+"\n", args...)
+		return
+	}
+	printJSON(v)
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode json: %s\n", err)
+	}
+}
+
+type errorResult struct {
+	Error string `json:"error"`
+	Code  int    `json:"code"`
+}
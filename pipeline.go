@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/intob/daved/signer"
+	"github.com/intob/daved/trace"
+	"github.com/intob/daved/workcache"
+	"github.com/intob/godave"
+	"github.com/intob/godave/dat"
+	"github.com/intob/godave/types"
+)
+
+// sendPipeline signs, does work and sends dats through a godave BatchWriter.
+// Each stage holds at most one dat per CPU, so Submit blocks while workers
+// are busy. A signing failure cancels the pipeline, send errors reported by
+// the BatchWriter are collected and returned by Close.
+type sendPipeline struct {
+	ctx       context.Context
+	cancel    context.CancelCauseFunc
+	dave      *godave.Dave
+	signer    signer.Signer // Nil if dats are submitted signed, with work
+	cache     *workcache.Cache
+	opt       *cmdOptions
+	jobs      chan *dat.Dat
+	datCh     chan<- dat.Dat
+	confirmed chan struct{}
+	workers   sync.WaitGroup
+	sent      atomic.Int64
+	mu        sync.Mutex
+	pending   []*dat.Dat // Written to the BatchWriter, not yet got
+	errs      []error
+}
+
+func newSendPipeline(ctx context.Context, d *godave.Dave, s signer.Signer, opt *cmdOptions) (*sendPipeline, error) {
+	return startSendPipeline(ctx, d, s.PublicKey(), s, opt)
+}
+
+// Sends dats of pubKey that are already signed and have work, such as those
+// of a bundle.
+func newSignedSendPipeline(ctx context.Context, d *godave.Dave, pubKey ed25519.PublicKey, opt *cmdOptions) (*sendPipeline, error) {
+	return startSendPipeline(ctx, d, pubKey, nil, opt)
+}
+
+func startSendPipeline(ctx context.Context, d *godave.Dave, pubKey ed25519.PublicKey, s signer.Signer, opt *cmdOptions) (*sendPipeline, error) {
+	datCh, errCh, err := d.BatchWriter(pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get batch writer: %w", err)
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	p := &sendPipeline{
+		ctx:       ctx,
+		cancel:    cancel,
+		dave:      d,
+		signer:    s,
+		cache:     openWorkCache(opt),
+		opt:       opt,
+		jobs:      make(chan *dat.Dat, runtime.NumCPU()),
+		datCh:     datCh,
+		confirmed: make(chan struct{}),
+	}
+	// Godave does not promise to close the error channel once the dats
+	// written are sent, so Close also asks for each of them.
+	go func() {
+		defer close(p.confirmed)
+		for err := range errCh {
+			if err != nil {
+				p.addErr(err)
+			}
+		}
+	}()
+	for i := 0; i < runtime.NumCPU(); i++ {
+		p.workers.Add(1)
+		go p.work()
+	}
+	return p, nil
+}
+
+// Submit queues d, blocking while the pipeline is full. Returns the cause if
+// the pipeline was cancelled.
+func (p *sendPipeline) Submit(d *dat.Dat) error {
+	select {
+	case p.jobs <- d:
+		return nil
+	case <-p.ctx.Done():
+		return context.Cause(p.ctx)
+	}
+}
+
+func (p *sendPipeline) work() {
+	defer p.workers.Done()
+	for d := range p.jobs {
+		if p.ctx.Err() != nil {
+			continue // drain
+		}
+		if p.signer != nil && !pooled(d, p.signer, p.cache, p.opt) {
+			_, ss := trace.Start(p.ctx, "sign")
+			err := p.signer.Sign(d)
+			ss.SetError(err)
+			ss.End()
+			if err != nil {
+				p.cancel(fmt.Errorf("failed to sign %s: %w", d.Key, err))
+				continue
+			}
+			_, ws := trace.Start(p.ctx, "work")
+			d.Work, d.Salt, err = p.cache.DoWork(d.Sig, p.opt.Difficulty)
+			ws.SetError(err)
+			ws.End()
+			if err != nil {
+				p.cancel(fmt.Errorf("failed to cache work: %w", err))
+				continue
+			}
+		}
+		select {
+		case p.datCh <- *d:
+			p.sent.Add(1)
+			p.mu.Lock()
+			p.pending = append(p.pending, d)
+			p.mu.Unlock()
+		case <-p.ctx.Done():
+		}
+	}
+}
+
+// Sent returns the number of dats written to the BatchWriter so far.
+func (p *sendPipeline) Sent() int64 {
+	return p.sent.Load()
+}
+
+// Reports whether every dat written can be got, so has been sent, dropping
+// those got from pending. Workers write in any order, so the last written
+// says nothing of the rest. Get also finds dats in the node's own store, so
+// nothing counts as sent without active peers. Called by Close once the
+// workers are done, so pending no longer changes.
+func (p *sendPipeline) allStored() bool {
+	if p.dave.ActivePeerCount() == 0 {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(p.ctx, time.Second)
+	defer cancel()
+	for len(p.pending) > 0 {
+		d := p.pending[0]
+		entry, err := p.dave.Get(ctx, &types.Get{PublicKey: d.PubKey, DatKey: d.Key})
+		if err != nil || entry == nil || entry.Dat.Sig != d.Sig {
+			return false
+		}
+		p.pending[0] = nil
+		p.pending = p.pending[1:]
+	}
+	return true
+}
+
+func (p *sendPipeline) addErr(err error) {
+	p.mu.Lock()
+	p.errs = append(p.errs, err)
+	p.mu.Unlock()
+}
+
+// Close waits for workers to write every queued dat, then up to timeout for
+// the BatchWriter to close its error channel or for all dats written to be
+// got. Returns the number of dats sent and all errors.
+func (p *sendPipeline) Close(timeout time.Duration) (int64, error) {
+	close(p.jobs)
+	p.workers.Wait()
+	_, span := trace.Start(p.ctx, "send")
+	close(p.datCh)
+	deadline := time.After(timeout)
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+wait:
+	for {
+		select {
+		case <-p.confirmed:
+			break wait
+		case <-p.ctx.Done():
+			break wait
+		case <-deadline:
+			p.addErr(fmt.Errorf("timed out after %s waiting for send confirmation", timeout))
+			break wait
+		case <-tick.C:
+			if p.allStored() {
+				break wait
+			}
+		}
+	}
+	span.End()
+	if cause := context.Cause(p.ctx); cause != nil {
+		p.addErr(cause)
+	}
+	p.cancel(nil)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sent.Load(), errors.Join(p.errs...)
+}
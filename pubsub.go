@@ -0,0 +1,22 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/intob/daved/dats"
+	"github.com/intob/daved/errs"
+	"github.com/intob/daved/pubsub"
+)
+
+// Returns the namespaced key of a new message on the topic of the first
+// argument.
+func publishKey(opt *cmdOptions) string {
+	if err := pubsub.ValidateTopic(flag.Arg(1)); err != nil {
+		exit(errs.Usage, "invalid topic: %s", err)
+	}
+	key, err := dats.NamespacedKey(opt.Namespace, pubsub.Key(flag.Arg(1), datTime(opt)))
+	if err != nil {
+		exit(errs.Usage, "invalid topic: %s", err)
+	}
+	return key
+}
@@ -0,0 +1,52 @@
+// Package pubsub maps topics onto dat keys, so that a publisher's messages
+// on a topic are ordinary signed dats.
+//
+// A message is keyed <topic>/<bucket>/<time>, where bucket is the UTC hour
+// it was published in and time is unix milliseconds. Each message gets its
+// own key, rather than replacing the last.
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BucketLayout is the time layout of a key's bucket.
+const BucketLayout = "2006010215"
+
+// MaxTopicLen leaves room for the bucket and time within a 255-byte key.
+const MaxTopicLen = 200
+
+// ValidateTopic checks that topic can be used in a key: not empty, within
+// MaxTopicLen, and without leading, trailing or repeated slashes.
+func ValidateTopic(topic string) error {
+	if topic == "" {
+		return errors.New("topic is empty")
+	}
+	if len(topic) > MaxTopicLen {
+		return fmt.Errorf("topic is longer than %d bytes", MaxTopicLen)
+	}
+	if strings.HasPrefix(topic, "/") || strings.HasSuffix(topic, "/") || strings.Contains(topic, "//") {
+		return errors.New("topic must not start or end with a slash, or contain empty segments")
+	}
+	return nil
+}
+
+// Key returns the key of a message on topic published at t.
+func Key(topic string, t time.Time) string {
+	return BucketPrefix(topic, t) + strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+// Prefix returns the prefix of every message key on topic.
+func Prefix(topic string) string {
+	return topic + "/"
+}
+
+// BucketPrefix returns the prefix of the keys of messages on topic
+// published in the same hour as t.
+func BucketPrefix(topic string, t time.Time) string {
+	return Prefix(topic) + t.UTC().Format(BucketLayout) + "/"
+}
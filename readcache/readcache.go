@@ -0,0 +1,140 @@
+// Package readcache holds recently fetched remote dats, so hot keys served
+// by the API are not fetched from the network on every request.
+package readcache
+
+import (
+	"container/list"
+	"context"
+	"encoding/base64"
+	"sync"
+	"time"
+
+	"github.com/intob/daved/events"
+	"github.com/intob/daved/metrics"
+	"github.com/intob/godave/dat"
+)
+
+// Cache is an LRU of dats bounded by the total size of keys and values.
+// Entries older than ttl are refetched, so newer versions are picked up.
+// A nil Cache is valid and caches nothing.
+type Cache struct {
+	mu       sync.Mutex
+	capacity int64
+	ttl      time.Duration
+	size     int64
+	ll       *list.List // Front is most recently used
+	items    map[string]*list.Element
+	hits     *metrics.Counter
+	misses   *metrics.Counter
+}
+
+type entry struct {
+	dat     *dat.Dat
+	fetched time.Time
+}
+
+func New(capacity int64, ttl time.Duration, reg *metrics.Registry) *Cache {
+	return &Cache{
+		capacity: capacity,
+		ttl:      ttl,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+		hits:     reg.Counter("daved_read_cache_hits_total", "Remote gets served from the read cache."),
+		misses:   reg.Counter("daved_read_cache_misses_total", "Remote gets not in the read cache."),
+	}
+}
+
+func cacheKey(pubKey []byte, key string) string {
+	return string(pubKey) + "\x00" + key
+}
+
+func size(d *dat.Dat) int64 {
+	return int64(len(d.Key) + len(d.Val))
+}
+
+func (c *Cache) Get(pubKey []byte, key string) (*dat.Dat, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[cacheKey(pubKey, key)]
+	if ok && time.Since(el.Value.(*entry).fetched) > c.ttl {
+		c.remove(el)
+		ok = false
+	}
+	if !ok {
+		c.misses.Inc()
+		return nil, false
+	}
+	c.hits.Inc()
+	c.ll.MoveToFront(el)
+	return el.Value.(*entry).dat, true
+}
+
+// Put caches d, unless a newer version is already cached. Dats larger than
+// the whole cache are not cached.
+func (c *Cache) Put(d *dat.Dat) {
+	if c == nil || size(d) > c.capacity {
+		return
+	}
+	k := cacheKey(d.PubKey, d.Key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[k]; ok {
+		if el.Value.(*entry).dat.Time.After(d.Time) {
+			return
+		}
+		c.remove(el)
+	}
+	c.items[k] = c.ll.PushFront(&entry{dat: d, fetched: time.Now()})
+	c.size += size(d)
+	for c.size > c.capacity {
+		c.remove(c.ll.Back())
+	}
+}
+
+// Invalidate drops the cached version of the dat if it is older than t.
+func (c *Cache) Invalidate(pubKey []byte, key string, t time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[cacheKey(pubKey, key)]; ok && el.Value.(*entry).dat.Time.Before(t) {
+		c.remove(el)
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	d := c.ll.Remove(el).(*entry).dat
+	delete(c.items, cacheKey(d.PubKey, d.Key))
+	c.size -= size(d)
+}
+
+// Watch invalidates cached dats when a newer version is put through the node,
+// until ctx is cancelled. Versions arriving via gossip are not reported by
+// godave, so those are only picked up once the ttl expires.
+func (c *Cache) Watch(ctx context.Context, bus *events.Bus) {
+	if c == nil {
+		return
+	}
+	evs, cancel := bus.Subscribe(256, events.DAT_PUT)
+	defer cancel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-evs:
+			de, ok := e.Data.(*events.DatEvent)
+			if !ok {
+				continue
+			}
+			pubKey, err := base64.RawURLEncoding.DecodeString(de.PubKey)
+			if err != nil {
+				continue
+			}
+			c.Invalidate(pubKey, de.Key, de.Time)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+// Package resp serves a subset of the Redis protocol, so the many Redis
+// client libraries can read and write dats without a dave SDK. Keys are dat
+// keys under the node's own key: SET signs, does work and puts, GET looks up
+// the newest version. Dats never expire, so TTL is -1 for any that exists.
+// With Authorize, SET requires a connection to AUTH with a token first;
+// without it, the server only listens on loopback.
+package resp
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intob/daved/clock"
+	"github.com/intob/daved/logbuf"
+	"github.com/intob/daved/schema"
+	"github.com/intob/daved/signer"
+	"github.com/intob/godave/dat"
+)
+
+const (
+	maxArgs    = 1024
+	maxBulkLen = 64 * 1024 // Far larger than a dat, so only abuse is refused
+	maxConns   = 256
+	// A client must send the rest of a command this soon after its first
+	// byte, and read a reply this soon after it is written
+	ioTimeout          = 10 * time.Second
+	defaultIdleTimeout = 5 * time.Minute
+)
+
+var errProtocol = errors.New("protocol error")
+
+// Store is the node, which reads through its cache and refuses writes in
+// readonly mode.
+type Store interface {
+	Get(ctx context.Context, pubKey ed25519.PublicKey, key string) (*dat.Dat, error)
+	Put(d dat.Dat) error
+}
+
+type ServerCfg struct {
+	Addr       string
+	Store      Store
+	Signer     signer.Signer // Signs and owns every key
+	Difficulty uint8
+	Timeout    time.Duration           // Of a GET from the network
+	Schemas    *schema.Set             // Optional, SET values must match
+	Authorize  func(token string) bool // Reports whether token may SET, nil requires a loopback Addr
+	Logs       chan<- string
+	// Connections sending no command for this long are closed, so idle
+	// clients cannot hold all maxConns, 0 for 5m
+	IdleTimeout time.Duration
+	// Optional, called before each SET is signed with the token its
+	// connection authenticated with, if any, and the size of the key and
+	// value, refusing the SET on error
+	Charge func(token string, size int64) error
+	// Optional, called after each SET with the token its connection
+	// authenticated with, if any
+	Audit func(token, addr, key string, val []byte, err error)
+}
+
+// A connection's state between commands.
+type client struct {
+	addr   string
+	authed bool
+	token  string // Authenticated with, empty if none
+}
+
+type Server struct {
+	cfg   *ServerCfg
+	ln    net.Listener
+	conns chan struct{} // Semaphore
+	log   logbuf.Logger
+}
+
+// NewServer listens on cfg.Addr, so the address is in use once it returns.
+func NewServer(cfg *ServerCfg) (*Server, error) {
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	if addr, ok := ln.Addr().(*net.TCPAddr); cfg.Authorize == nil && (!ok || !addr.IP.IsLoopback()) {
+		ln.Close()
+		return nil, fmt.Errorf("%s is not a loopback address, and there are no tokens to AUTH with", cfg.Addr)
+	}
+	return &Server{
+		cfg:   cfg,
+		ln:    ln,
+		conns: make(chan struct{}, maxConns),
+		log:   logbuf.For(cfg.Logs, "resp"),
+	}, nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Run serves connections until ctx is cancelled, then closes them.
+func (s *Server) Run(ctx context.Context) {
+	s.log.Printf("listening on %s", s.Addr())
+	stop := context.AfterFunc(ctx, func() { s.ln.Close() })
+	defer stop()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				s.log.Printf("failed to accept: %s", err)
+			}
+			return
+		}
+		select {
+		case s.conns <- struct{}{}:
+		default:
+			conn.Write([]byte("-ERR max number of clients reached\r\n"))
+			conn.Close()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-s.conns }()
+			s.serve(ctx, conn)
+		}()
+	}
+}
+
+func (s *Server) serve(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	c := &client{addr: conn.RemoteAddr().String(), authed: s.cfg.Authorize == nil}
+	idle := s.cfg.IdleTimeout
+	if idle <= 0 {
+		idle = defaultIdleTimeout
+	}
+	for {
+		conn.SetReadDeadline(time.Now().Add(idle))
+		if _, err := r.Peek(1); err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(ioTimeout))
+		args, err := readCommand(r)
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				writeError(w, "ERR "+err.Error())
+				conn.SetWriteDeadline(time.Now().Add(ioTimeout))
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		quit := s.exec(ctx, w, args, c)
+		if r.Buffered() == 0 || quit { // Reply to pipelined commands together
+			conn.SetWriteDeadline(time.Now().Add(ioTimeout))
+			if w.Flush() != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// Runs a command, writing its reply. Returns true if the client quit.
+func (s *Server) exec(ctx context.Context, w *bufio.Writer, args []string, c *client) bool {
+	switch name := strings.ToUpper(args[0]); name {
+	case "PING":
+		if len(args) > 1 {
+			writeBulk(w, []byte(args[1]))
+		} else {
+			w.WriteString("+PONG\r\n")
+		}
+	case "ECHO":
+		if len(args) != 2 {
+			writeArity(w, name)
+			break
+		}
+		writeBulk(w, []byte(args[1]))
+	case "GET":
+		if len(args) != 2 {
+			writeArity(w, name)
+			break
+		}
+		d, err := s.get(ctx, args[1])
+		if err != nil {
+			writeError(w, "ERR "+err.Error())
+		} else if d == nil {
+			w.WriteString("$-1\r\n")
+		} else {
+			writeBulk(w, d.Val)
+		}
+	case "SET":
+		if len(args) != 3 {
+			if len(args) > 3 {
+				writeError(w, "ERR options are not supported, dats do not expire and are always overwritten")
+			} else {
+				writeArity(w, name)
+			}
+			break
+		}
+		if !c.authed {
+			writeError(w, "NOAUTH Authentication required.")
+			break
+		}
+		err := s.set(c.token, args[1], []byte(args[2]))
+		if s.cfg.Audit != nil {
+			s.cfg.Audit(c.token, c.addr, args[1], []byte(args[2]), err)
+		}
+		if err != nil {
+			writeError(w, "ERR "+err.Error())
+			break
+		}
+		w.WriteString("+OK\r\n")
+	case "EXISTS":
+		if len(args) < 2 {
+			writeArity(w, name)
+			break
+		}
+		n := 0
+		for _, key := range args[1:] {
+			d, err := s.get(ctx, key)
+			if err != nil {
+				writeError(w, "ERR "+err.Error())
+				return false
+			}
+			if d != nil {
+				n++
+			}
+		}
+		writeInt(w, n)
+	case "TTL", "PTTL":
+		if len(args) != 2 {
+			writeArity(w, name)
+			break
+		}
+		d, err := s.get(ctx, args[1])
+		if err != nil {
+			writeError(w, "ERR "+err.Error())
+		} else if d == nil {
+			writeInt(w, -2)
+		} else {
+			writeInt(w, -1)
+		}
+	case "AUTH":
+		if len(args) != 2 && len(args) != 3 { // AUTH [username] token
+			writeArity(w, name)
+			break
+		}
+		if s.cfg.Authorize == nil {
+			writeError(w, "ERR AUTH called without any tokens configured")
+			break
+		}
+		c.token = args[len(args)-1]
+		c.authed = s.cfg.Authorize(c.token)
+		if !c.authed {
+			c.token = ""
+			writeError(w, "WRONGPASS invalid token, or it lacks write scope")
+			break
+		}
+		w.WriteString("+OK\r\n")
+	case "SELECT":
+		if len(args) != 2 || args[1] != "0" {
+			writeError(w, "ERR DB index is out of range")
+			break
+		}
+		w.WriteString("+OK\r\n")
+	case "CLIENT":
+		w.WriteString("+OK\r\n") // SETNAME and SETINFO, sent by clients on connect
+	case "COMMAND":
+		w.WriteString("*0\r\n")
+	case "HELLO":
+		writeError(w, "NOPROTO only RESP2 is supported")
+	case "QUIT":
+		w.WriteString("+OK\r\n")
+		return true
+	default:
+		writeError(w, fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+	return false
+}
+
+func (s *Server) get(ctx context.Context, key string) (*dat.Dat, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+	return s.cfg.Store.Get(ctx, s.cfg.Signer.PublicKey(), key)
+}
+
+func (s *Server) set(token, key string, val []byte) error {
+	if err := s.cfg.Schemas.Validate(key, val); err != nil {
+		return err
+	}
+	if s.cfg.Charge != nil {
+		if err := s.cfg.Charge(token, int64(len(key)+len(val))); err != nil {
+			return err
+		}
+	}
+	d := &dat.Dat{Key: key, Val: val, Time: clock.Now(), PubKey: s.cfg.Signer.PublicKey()}
+	if err := s.cfg.Signer.Sign(d); err != nil {
+		return fmt.Errorf("failed to sign: %w", err)
+	}
+	d.Work, d.Salt = dat.DoWork(d.Sig, s.cfg.Difficulty)
+	return s.cfg.Store.Put(*d)
+}
+
+// Reads a command as an array of bulk strings, or an inline command as
+// sent by telnet.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+	}
+	args := make([]string, 0, max(n, 0))
+	for range n {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("%w: expected '$', got '%.1s'", errProtocol, line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulkLen {
+			return nil, fmt.Errorf("%w: invalid bulk length", errProtocol)
+		}
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		args = append(args, string(b[:size]))
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", fmt.Errorf("%w: line too long", errProtocol)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func writeBulk(w *bufio.Writer, b []byte) {
+	fmt.Fprintf(w, "$%d\r\n", len(b))
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func writeInt(w *bufio.Writer, n int) {
+	fmt.Fprintf(w, ":%d\r\n", n)
+}
+
+func writeError(w *bufio.Writer, msg string) {
+	w.WriteString("-" + msg + "\r\n")
+}
+
+func writeArity(w *bufio.Writer, name string) {
+	writeError(w, fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+}
@@ -0,0 +1,266 @@
+package resp
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/intob/daved/schema"
+	"github.com/intob/godave/dat"
+)
+
+func TestReadCommand(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []string
+		err  bool
+	}{
+		{"*2\r\n$3\r\nGET\r\n$1\r\na\r\n", []string{"GET", "a"}, false},
+		{"*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$4\r\na\r\nb\r\n", []string{"SET", "k", "a\r\nb"}, false},
+		{"*1\r\n$0\r\n\r\n", []string{""}, false},
+		{"*0\r\n", []string{}, false},
+		{"PING hello\r\n", []string{"PING", "hello"}, false},
+		{"  \r\n", []string{}, false},
+		{"*x\r\n", nil, true},
+		{"*2000\r\n", nil, true},
+		{"*1\r\n:1\r\n", nil, true},
+		{"*1\r\n$-1\r\n", nil, true},
+		{"*1\r\n$70000\r\n", nil, true},
+		{"*1\r\n$5\r\nab", nil, true},
+		{"*2\r\n$1\r\na\r\n", nil, true},
+	} {
+		got, err := readCommand(bufio.NewReader(strings.NewReader(tc.in)))
+		if tc.err {
+			if err == nil {
+				t.Errorf("readCommand(%q) = %q, want an error", tc.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("readCommand(%q): %s", tc.in, err)
+			continue
+		}
+		if len(got) != 0 || len(tc.want) != 0 {
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("readCommand(%q) = %q, want %q", tc.in, got, tc.want)
+			}
+		}
+	}
+}
+
+type testStore struct {
+	mu       sync.Mutex
+	dats     map[string]dat.Dat
+	readOnly bool
+}
+
+func (s *testStore) Get(ctx context.Context, pubKey ed25519.PublicKey, key string) (*dat.Dat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key == "broken" {
+		return nil, errors.New("timeout")
+	}
+	d, ok := s.dats[key]
+	if !ok {
+		return nil, nil
+	}
+	return &d, nil
+}
+
+func (s *testStore) Put(d dat.Dat) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.readOnly {
+		return errors.New("node is in readonly mode")
+	}
+	s.dats[d.Key] = d
+	return nil
+}
+
+type testSigner struct {
+	pub ed25519.PublicKey
+}
+
+func (s *testSigner) PublicKey() ed25519.PublicKey { return s.pub }
+func (s *testSigner) Sign(d *dat.Dat) error        { return nil }
+
+type audited struct {
+	token, key string
+	err        error
+}
+
+func startServer(t *testing.T, authorize func(string) bool, charge func(string, int64) error) (*bufio.ReadWriter, *testStore, *[]audited) {
+	t.Helper()
+	pub, _, _ := ed25519.GenerateKey(nil)
+	num, err := schema.Compile([]byte(`{"type": "number"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &testStore{dats: make(map[string]dat.Dat)}
+	var mu sync.Mutex
+	var log []audited
+	logs := make(chan string)
+	go func() {
+		for range logs {
+		}
+	}()
+	s, err := NewServer(&ServerCfg{
+		Addr:      "127.0.0.1:0",
+		Store:     store,
+		Signer:    &testSigner{pub: pub},
+		Timeout:   time.Second,
+		Schemas:   schema.NewSet([]schema.Rule{{Prefix: "num/", Schema: num}}),
+		Authorize: authorize,
+		Charge:    charge,
+		Audit: func(token, addr, key string, val []byte, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			log = append(log, audited{token, key, err})
+		},
+		Logs: logs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.Run(ctx)
+	conn, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		cancel()
+	})
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)), store, &log
+}
+
+// Sends each command and checks the reply.
+func exchange(t *testing.T, rw *bufio.ReadWriter, steps []struct{ cmd, want string }) {
+	t.Helper()
+	for _, step := range steps {
+		rw.WriteString(step.cmd)
+		if err := rw.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, len(step.want))
+		if _, err := io.ReadFull(rw, got); err != nil {
+			t.Fatalf("%q: %s", step.cmd, err)
+		}
+		if string(got) != step.want {
+			t.Errorf("%q: got %q, want %q", step.cmd, got, step.want)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	rw, store, _ := startServer(t, nil, nil)
+	exchange(t, rw, []struct{ cmd, want string }{
+		{"PING\r\n", "+PONG\r\n"},
+		{"*2\r\n$4\r\nPING\r\n$2\r\nhi\r\n", "$2\r\nhi\r\n"},
+		{"ECHO a\r\n", "$1\r\na\r\n"},
+		{"ECHO\r\n", "-ERR wrong number of arguments for 'echo' command\r\n"},
+		{"GET k\r\n", "$-1\r\n"},
+		{"TTL k\r\n", ":-2\r\n"},
+		{"*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$3\r\na b\r\n", "+OK\r\n"},
+		{"GET k\r\n", "$3\r\na b\r\n"},
+		{"TTL k\r\n", ":-1\r\n"},
+		{"PTTL k\r\n", ":-1\r\n"},
+		{"EXISTS k k missing\r\n", ":2\r\n"},
+		{"GET broken\r\n", "-ERR timeout\r\n"},
+		{"SET k v EX 10\r\n", "-ERR options are not supported, dats do not expire and are always overwritten\r\n"},
+		{"SET k\r\n", "-ERR wrong number of arguments for 'set' command\r\n"},
+		{"SET num/a 1\r\n", "+OK\r\n"},
+		{"SET num/a one\r\n", "-ERR value does not match the schema of \"num/\": value is not JSON: invalid character 'o' looking for beginning of value\r\n"},
+		{"AUTH secret\r\n", "-ERR AUTH called without any tokens configured\r\n"},
+		{"SELECT 0\r\n", "+OK\r\n"},
+		{"SELECT 1\r\n", "-ERR DB index is out of range\r\n"},
+		{"CLIENT SETNAME x\r\n", "+OK\r\n"},
+		{"COMMAND\r\n", "*0\r\n"},
+		{"HELLO 3\r\n", "-NOPROTO only RESP2 is supported\r\n"},
+		{"FLUSHALL\r\n", "-ERR unknown command 'FLUSHALL'\r\n"},
+		{"PING\r\nPING\r\n", "+PONG\r\n+PONG\r\n"},
+	})
+	store.readOnly = true
+	exchange(t, rw, []struct{ cmd, want string }{
+		{"SET k v\r\n", "-ERR node is in readonly mode\r\n"},
+		{"QUIT\r\n", "+OK\r\n"},
+	})
+	if _, err := rw.ReadByte(); err != io.EOF {
+		t.Errorf("connection open after QUIT, read %v", err)
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	charged := make(map[string]int64)
+	charge := func(token string, size int64) error {
+		if charged[token]+size > 4 {
+			return errors.New("quota exceeded")
+		}
+		charged[token] += size
+		return nil
+	}
+	rw, _, log := startServer(t, func(token string) bool { return token == "secret" }, charge)
+	exchange(t, rw, []struct{ cmd, want string }{
+		{"GET k\r\n", "$-1\r\n"},
+		{"SET k v\r\n", "-NOAUTH Authentication required.\r\n"},
+		{"AUTH wrong\r\n", "-WRONGPASS invalid token, or it lacks write scope\r\n"},
+		{"SET k v\r\n", "-NOAUTH Authentication required.\r\n"},
+		{"AUTH default secret\r\n", "+OK\r\n"},
+		{"SET k v\r\n", "+OK\r\n"},
+		{"SET k vvv\r\n", "-ERR quota exceeded\r\n"},
+		{"SET num/a x\r\n", "-ERR value does not match the schema of \"num/\": value is not JSON: invalid character 'x' looking for beginning of value\r\n"},
+		{"AUTH\r\n", "-ERR wrong number of arguments for 'auth' command\r\n"},
+	})
+	if charged["secret"] != 2 {
+		t.Errorf("charged %d bytes, want 2", charged["secret"])
+	}
+	want := []audited{
+		{"secret", "k", nil},
+		{"secret", "k", errors.New("quota exceeded")},
+		{"secret", "num/a", errors.New("schema")},
+	}
+	if len(*log) != len(want) {
+		t.Fatalf("audited %v, want %v", *log, want)
+	}
+	for i, a := range *log {
+		if a.token != want[i].token || a.key != want[i].key || (a.err == nil) != (want[i].err == nil) {
+			t.Errorf("audit %d is %v, want %v", i, a, want[i])
+		}
+	}
+}
+
+func TestNewServerNotLoopback(t *testing.T) {
+	if s, err := NewServer(&ServerCfg{Addr: "0.0.0.0:0"}); err == nil {
+		s.ln.Close()
+		t.Error("listening on all addresses without tokens")
+	}
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	s, err := NewServer(&ServerCfg{Addr: "127.0.0.1:0", IdleTimeout: 50 * time.Millisecond, Logs: make(chan string, 8)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+	conn, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("idle connection not closed, read %v", err)
+	}
+}
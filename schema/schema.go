@@ -0,0 +1,542 @@
+// Package schema validates values against JSON Schema, so applications
+// sharing a publisher key can agree on the structure of their keys. The
+// structural keywords of draft 2020-12 are supported: type, enum, const,
+// the numeric and string bounds, pattern, items, properties, required,
+// additionalProperties, patternProperties, allOf, anyOf, oneOf, not and $ref
+// within the document. Other keywords, such as format, are ignored.
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxDepth = 64 // Of nested schemas while validating, bounding $ref loops
+
+var ErrNotJSON = errors.New("value is not JSON")
+
+// ValidationError is the first place a value fails its schema.
+type ValidationError struct {
+	Path string // JSON pointer into the value
+	Msg  string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Msg
+	}
+	return fmt.Sprintf("at %s: %s", e.Path, e.Msg)
+}
+
+type Schema struct {
+	doc     any
+	regexps map[string]*regexp.Regexp
+}
+
+// Load reads and compiles the schema in filename.
+func Load(filename string) (*Schema, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Compile(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return s, nil
+}
+
+// Compile parses a schema, checking its keywords.
+func Compile(b []byte) (*Schema, error) {
+	s := &Schema{regexps: make(map[string]*regexp.Regexp)}
+	if err := json.Unmarshal(b, &s.doc); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if err := s.check(s.doc, ""); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return s, nil
+}
+
+var schemaKeywords = map[string]bool{"items": true, "additionalProperties": true, "not": true}
+var schemaListKeywords = map[string]bool{"allOf": true, "anyOf": true, "oneOf": true}
+var schemaMapKeywords = map[string]bool{"properties": true, "patternProperties": true, "$defs": true, "definitions": true}
+var numberKeywords = []string{"minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf"}
+var countKeywords = []string{"minLength", "maxLength", "minItems", "maxItems", "minProperties", "maxProperties"}
+var typeNames = map[string]bool{"null": true, "boolean": true, "object": true, "array": true, "number": true, "integer": true, "string": true}
+
+func (s *Schema) check(sch any, path string) error {
+	if _, ok := sch.(bool); ok {
+		return nil
+	}
+	obj, ok := sch.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%s: a schema is an object or a boolean", pointerOrRoot(path))
+	}
+	for kw, v := range obj {
+		p := path + "/" + escape(kw)
+		switch {
+		case kw == "type":
+			names, ok := v.([]any)
+			if !ok {
+				names = []any{v}
+			}
+			for _, n := range names {
+				if name, ok := n.(string); !ok || !typeNames[name] {
+					return fmt.Errorf("%s: unknown type %v", p, n)
+				}
+			}
+		case kw == "enum":
+			if _, ok := v.([]any); !ok {
+				return fmt.Errorf("%s: must be an array", p)
+			}
+		case kw == "required":
+			names, ok := v.([]any)
+			if !ok {
+				return fmt.Errorf("%s: must be an array of strings", p)
+			}
+			for _, n := range names {
+				if _, ok := n.(string); !ok {
+					return fmt.Errorf("%s: must be an array of strings", p)
+				}
+			}
+		case kw == "pattern":
+			if err := s.compileRegexp(v, p); err != nil {
+				return err
+			}
+		case kw == "uniqueItems":
+			if _, ok := v.(bool); !ok {
+				return fmt.Errorf("%s: must be a boolean", p)
+			}
+		case kw == "$ref":
+			ref, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("%s: must be a string", p)
+			}
+			target, err := s.resolve(ref)
+			if err != nil {
+				return fmt.Errorf("%s: %w", p, err)
+			}
+			if _, ok := target.(map[string]any); !ok && !isBool(target) {
+				return fmt.Errorf("%s: %q is not a schema", p, ref)
+			}
+		case kw == "items" && isList(v): // Tuples, as before draft 2020-12
+			for i, item := range v.([]any) {
+				if err := s.check(item, p+"/"+strconv.Itoa(i)); err != nil {
+					return err
+				}
+			}
+		case schemaKeywords[kw]:
+			if err := s.check(v, p); err != nil {
+				return err
+			}
+		case schemaListKeywords[kw]:
+			list, ok := v.([]any)
+			if !ok || len(list) == 0 {
+				return fmt.Errorf("%s: must be a non-empty array of schemas", p)
+			}
+			for i, item := range list {
+				if err := s.check(item, p+"/"+strconv.Itoa(i)); err != nil {
+					return err
+				}
+			}
+		case schemaMapKeywords[kw]:
+			m, ok := v.(map[string]any)
+			if !ok {
+				return fmt.Errorf("%s: must be an object of schemas", p)
+			}
+			for name, item := range m {
+				if kw == "patternProperties" {
+					if err := s.compileRegexp(name, p); err != nil {
+						return err
+					}
+				}
+				if err := s.check(item, p+"/"+escape(name)); err != nil {
+					return err
+				}
+			}
+		case slices.Contains(numberKeywords, kw):
+			n, ok := v.(float64)
+			if !ok || kw == "multipleOf" && n <= 0 {
+				return fmt.Errorf("%s: must be a number", p)
+			}
+		case slices.Contains(countKeywords, kw):
+			if n, ok := v.(float64); !ok || n < 0 || n != math.Trunc(n) {
+				return fmt.Errorf("%s: must be a non-negative integer", p)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) compileRegexp(v any, path string) error {
+	expr, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("%s: pattern must be a string", path)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	s.regexps[expr] = re
+	return nil
+}
+
+// Resolves a reference within the document, # or a JSON pointer after it.
+func (s *Schema) resolve(ref string) (any, error) {
+	ptr, ok := strings.CutPrefix(ref, "#")
+	if !ok {
+		return nil, fmt.Errorf("only references within the schema are supported, got %q", ref)
+	}
+	cur := s.doc
+	if ptr == "" {
+		return cur, nil
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, fmt.Errorf("invalid reference %q", ref)
+	}
+	for _, tok := range strings.Split(ptr[1:], "/") {
+		tok = strings.ReplaceAll(strings.ReplaceAll(tok, "~1", "/"), "~0", "~")
+		switch c := cur.(type) {
+		case map[string]any:
+			cur, ok = c[tok]
+		case []any:
+			i, err := strconv.Atoi(tok)
+			ok = err == nil && i >= 0 && i < len(c)
+			if ok {
+				cur = c[i]
+			}
+		default:
+			ok = false
+		}
+		if !ok {
+			return nil, fmt.Errorf("reference %q not found", ref)
+		}
+	}
+	return cur, nil
+}
+
+// Validate checks that val is a JSON document matching the schema.
+func (s *Schema) Validate(val []byte) error {
+	var v any
+	if err := json.Unmarshal(val, &v); err != nil {
+		return fmt.Errorf("%w: %w", ErrNotJSON, err)
+	}
+	return s.validate(s.doc, v, "", 0)
+}
+
+func (s *Schema) validate(sch, v any, path string, depth int) error {
+	if depth > maxDepth {
+		return &ValidationError{Path: path, Msg: "schema nests too deeply"}
+	}
+	depth++
+	if b, ok := sch.(bool); ok {
+		if !b {
+			return &ValidationError{Path: path, Msg: "no value is allowed"}
+		}
+		return nil
+	}
+	obj := sch.(map[string]any)
+	if ref, ok := obj["$ref"].(string); ok {
+		target, _ := s.resolve(ref) // Resolved by check
+		if err := s.validate(target, v, path, depth); err != nil {
+			return err
+		}
+	}
+	if t, ok := obj["type"]; ok && !matchesType(t, v) {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf("expected %s, got %s", typeList(t), typeOf(v))}
+	}
+	if enum, ok := obj["enum"].([]any); ok && !containsValue(enum, v) {
+		return &ValidationError{Path: path, Msg: "value is not one of the enum"}
+	}
+	if c, ok := obj["const"]; ok && !reflect.DeepEqual(c, v) {
+		return &ValidationError{Path: path, Msg: "value is not the const"}
+	}
+	var err error
+	switch val := v.(type) {
+	case float64:
+		err = s.validateNumber(obj, val, path)
+	case string:
+		err = s.validateString(obj, val, path)
+	case []any:
+		err = s.validateArray(obj, val, path, depth)
+	case map[string]any:
+		err = s.validateObject(obj, val, path, depth)
+	}
+	if err != nil {
+		return err
+	}
+	return s.validateCombinators(obj, v, path, depth)
+}
+
+func (s *Schema) validateNumber(obj map[string]any, n float64, path string) error {
+	if min, ok := obj["minimum"].(float64); ok && n < min {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf("%v is less than the minimum %v", n, min)}
+	}
+	if max, ok := obj["maximum"].(float64); ok && n > max {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf("%v is more than the maximum %v", n, max)}
+	}
+	if min, ok := obj["exclusiveMinimum"].(float64); ok && n <= min {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf("%v is not more than %v", n, min)}
+	}
+	if max, ok := obj["exclusiveMaximum"].(float64); ok && n >= max {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf("%v is not less than %v", n, max)}
+	}
+	if m, ok := obj["multipleOf"].(float64); ok {
+		if q := n / m; q != math.Trunc(q) {
+			return &ValidationError{Path: path, Msg: fmt.Sprintf("%v is not a multiple of %v", n, m)}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateString(obj map[string]any, str string, path string) error {
+	n := utf8.RuneCountInString(str)
+	if min, ok := obj["minLength"].(float64); ok && float64(n) < min {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf("shorter than %v characters", min)}
+	}
+	if max, ok := obj["maxLength"].(float64); ok && float64(n) > max {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf("longer than %v characters", max)}
+	}
+	if expr, ok := obj["pattern"].(string); ok && !s.regexps[expr].MatchString(str) {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf("does not match pattern %q", expr)}
+	}
+	return nil
+}
+
+func (s *Schema) validateArray(obj map[string]any, arr []any, path string, depth int) error {
+	if min, ok := obj["minItems"].(float64); ok && float64(len(arr)) < min {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf("fewer than %v items", min)}
+	}
+	if max, ok := obj["maxItems"].(float64); ok && float64(len(arr)) > max {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf("more than %v items", max)}
+	}
+	if unique, _ := obj["uniqueItems"].(bool); unique {
+		for i := range arr {
+			for j := i + 1; j < len(arr); j++ {
+				if reflect.DeepEqual(arr[i], arr[j]) {
+					return &ValidationError{Path: path, Msg: fmt.Sprintf("items %d and %d are equal", i, j)}
+				}
+			}
+		}
+	}
+	items, ok := obj["items"]
+	if !ok {
+		return nil
+	}
+	for i, item := range arr {
+		sch := items
+		if tuple, ok := items.([]any); ok {
+			if i >= len(tuple) {
+				break
+			}
+			sch = tuple[i]
+		}
+		if err := s.validate(sch, item, path+"/"+strconv.Itoa(i), depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateObject(obj map[string]any, m map[string]any, path string, depth int) error {
+	if min, ok := obj["minProperties"].(float64); ok && float64(len(m)) < min {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf("fewer than %v properties", min)}
+	}
+	if max, ok := obj["maxProperties"].(float64); ok && float64(len(m)) > max {
+		return &ValidationError{Path: path, Msg: fmt.Sprintf("more than %v properties", max)}
+	}
+	required, _ := obj["required"].([]any)
+	for _, name := range required {
+		if _, ok := m[name.(string)]; !ok {
+			return &ValidationError{Path: path, Msg: fmt.Sprintf("missing property %q", name)}
+		}
+	}
+	props, _ := obj["properties"].(map[string]any)
+	patterns, _ := obj["patternProperties"].(map[string]any)
+	additional, hasAdditional := obj["additionalProperties"]
+	// Sorted, so the same value always reports the same error
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := path + "/" + escape(name)
+		matched := false
+		if sch, ok := props[name]; ok {
+			matched = true
+			if err := s.validate(sch, m[name], p, depth); err != nil {
+				return err
+			}
+		}
+		for expr, sch := range patterns {
+			if s.regexps[expr].MatchString(name) {
+				matched = true
+				if err := s.validate(sch, m[name], p, depth); err != nil {
+					return err
+				}
+			}
+		}
+		if !matched && hasAdditional {
+			if b, ok := additional.(bool); ok && !b {
+				return &ValidationError{Path: path, Msg: fmt.Sprintf("property %q is not allowed", name)}
+			}
+			if err := s.validate(additional, m[name], p, depth); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateCombinators(obj map[string]any, v any, path string, depth int) error {
+	if all, ok := obj["allOf"].([]any); ok {
+		for _, sch := range all {
+			if err := s.validate(sch, v, path, depth); err != nil {
+				return err
+			}
+		}
+	}
+	if anyOf, ok := obj["anyOf"].([]any); ok {
+		if !slices.ContainsFunc(anyOf, func(sch any) bool { return s.validate(sch, v, path, depth) == nil }) {
+			return &ValidationError{Path: path, Msg: "matches none of anyOf"}
+		}
+	}
+	if oneOf, ok := obj["oneOf"].([]any); ok {
+		n := 0
+		for _, sch := range oneOf {
+			if s.validate(sch, v, path, depth) == nil {
+				n++
+			}
+		}
+		if n != 1 {
+			return &ValidationError{Path: path, Msg: fmt.Sprintf("matches %d of oneOf, not exactly one", n)}
+		}
+	}
+	if not, ok := obj["not"]; ok && s.validate(not, v, path, depth) == nil {
+		return &ValidationError{Path: path, Msg: "matches the schema of not"}
+	}
+	return nil
+}
+
+func matchesType(t, v any) bool {
+	names, ok := t.([]any)
+	if !ok {
+		names = []any{t}
+	}
+	for _, n := range names {
+		name := n.(string)
+		if name == typeOf(v) || name == "number" && typeOf(v) == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(v any) string {
+	switch n := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if n == math.Trunc(n) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func typeList(t any) string {
+	names, ok := t.([]any)
+	if !ok {
+		return t.(string)
+	}
+	strs := make([]string, len(names))
+	for i, n := range names {
+		strs[i] = n.(string)
+	}
+	return strings.Join(strs, " or ")
+}
+
+func containsValue(list []any, v any) bool {
+	for _, item := range list {
+		if reflect.DeepEqual(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func isBool(v any) bool {
+	_, ok := v.(bool)
+	return ok
+}
+
+func isList(v any) bool {
+	_, ok := v.([]any)
+	return ok
+}
+
+// Escapes a JSON pointer token.
+func escape(tok string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(tok, "~", "~0"), "/", "~1")
+}
+
+func pointerOrRoot(path string) string {
+	if path == "" {
+		return "root"
+	}
+	return path
+}
+
+// Rule applies a schema to the keys starting with a prefix.
+type Rule struct {
+	Prefix string
+	Schema *Schema
+}
+
+// Set validates values by the rule of the longest prefix of their key.
+// Keys matching no rule, and every key of a nil set, are not checked.
+type Set struct {
+	rules []Rule // Longest prefix first
+}
+
+func NewSet(rules []Rule) *Set {
+	rules = slices.Clone(rules)
+	sort.SliceStable(rules, func(i, j int) bool { return len(rules[i].Prefix) > len(rules[j].Prefix) })
+	return &Set{rules: rules}
+}
+
+// Validate checks val against the schema of key, if it has one.
+func (s *Set) Validate(key string, val []byte) error {
+	if s == nil {
+		return nil
+	}
+	for _, r := range s.rules {
+		if strings.HasPrefix(key, r.Prefix) {
+			if err := r.Schema.Validate(val); err != nil {
+				return fmt.Errorf("value does not match the schema of %q: %w", r.Prefix, err)
+			}
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package schema
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	for _, tc := range []struct {
+		schema string
+		ok     bool
+	}{
+		{`true`, true},
+		{`{}`, true},
+		{`{"type": ["string", "null"], "format": "email"}`, true},
+		{`{"$defs": {"n": {"type": "integer"}}, "properties": {"a": {"$ref": "#/$defs/n"}}}`, true},
+		{`{"items": [{"type": "string"}, {"type": "number"}]}`, true},
+		{`not json`, false},
+		{`[]`, false},
+		{`{"type": "text"}`, false},
+		{`{"enum": "a"}`, false},
+		{`{"required": [1]}`, false},
+		{`{"pattern": "("}`, false},
+		{`{"patternProperties": {"(": {}}}`, false},
+		{`{"$ref": "other.json"}`, false},
+		{`{"$ref": "#/$defs/missing"}`, false},
+		{`{"allOf": []}`, false},
+		{`{"properties": {"a": 1}}`, false},
+		{`{"multipleOf": 0}`, false},
+		{`{"minLength": -1}`, false},
+		{`{"maxItems": 1.5}`, false},
+		{`{"uniqueItems": "yes"}`, false},
+	} {
+		_, err := Compile([]byte(tc.schema))
+		if tc.ok && err != nil {
+			t.Errorf("Compile(%s): %s", tc.schema, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("Compile(%s) succeeded, want an error", tc.schema)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		schema string
+		val    string
+		path   string // Of the error, "-" if the value matches
+	}{
+		{`true`, `1`, "-"},
+		{`false`, `1`, ""},
+		{`{"type": "integer"}`, `3`, "-"},
+		{`{"type": "integer"}`, `3.5`, ""},
+		{`{"type": "number"}`, `3`, "-"},
+		{`{"type": ["string", "null"]}`, `null`, "-"},
+		{`{"type": "string"}`, `true`, ""},
+		{`{"enum": ["a", 1]}`, `1`, "-"},
+		{`{"enum": ["a", 1]}`, `"b"`, ""},
+		{`{"const": {"a": [1]}}`, `{"a": [1]}`, "-"},
+		{`{"minimum": 1, "exclusiveMaximum": 3}`, `3`, ""},
+		{`{"multipleOf": 0.5}`, `2.5`, "-"},
+		{`{"multipleOf": 2}`, `3`, ""},
+		{`{"minLength": 2, "maxLength": 3}`, `"héé"`, "-"},
+		{`{"maxLength": 2}`, `"abc"`, ""},
+		{`{"pattern": "^[a-z]+$"}`, `"abc"`, "-"},
+		{`{"pattern": "^[a-z]+$"}`, `"ABC"`, ""},
+		{`{"items": {"type": "string"}, "maxItems": 2}`, `["a", 1]`, "/1"},
+		{`{"items": [{"type": "string"}]}`, `["a", 1]`, "-"},
+		{`{"uniqueItems": true}`, `[1, {"a": 2}, {"a": 2}]`, ""},
+		{`{"required": ["id"]}`, `{"name": "x"}`, ""},
+		{`{"properties": {"a/b": {"type": "string"}}}`, `{"a/b": 1}`, "/a~1b"},
+		{`{"properties": {"a": {"properties": {"b": {"type": "string"}}}}}`, `{"a": {"b": 1}}`, "/a/b"},
+		{`{"patternProperties": {"^x-": {"type": "string"}}, "additionalProperties": false}`, `{"x-a": "1"}`, "-"},
+		{`{"patternProperties": {"^x-": {"type": "string"}}, "additionalProperties": false}`, `{"y": "1"}`, ""},
+		{`{"additionalProperties": {"type": "integer"}}`, `{"a": "1"}`, "/a"},
+		{`{"allOf": [{"minimum": 1}, {"maximum": 2}]}`, `3`, ""},
+		{`{"anyOf": [{"type": "string"}, {"type": "integer"}]}`, `1`, "-"},
+		{`{"anyOf": [{"type": "string"}, {"type": "integer"}]}`, `1.5`, ""},
+		{`{"oneOf": [{"type": "integer"}, {"type": "number"}]}`, `1`, ""},
+		{`{"oneOf": [{"type": "integer"}, {"type": "string"}]}`, `1`, "-"},
+		{`{"not": {"type": "null"}}`, `null`, ""},
+		{`{"$defs": {"n": {"type": "integer"}}, "items": {"$ref": "#/$defs/n"}}`, `[1, "2"]`, "/1"},
+		{`{"properties": {"next": {"$ref": "#"}}, "type": "object"}`, `{"next": {"next": 1}}`, "/next/next"},
+	} {
+		s, err := Compile([]byte(tc.schema))
+		if err != nil {
+			t.Errorf("Compile(%s): %s", tc.schema, err)
+			continue
+		}
+		err = s.Validate([]byte(tc.val))
+		if tc.path == "-" {
+			if err != nil {
+				t.Errorf("%s against %s: %s", tc.val, tc.schema, err)
+			}
+			continue
+		}
+		var ve *ValidationError
+		if !errors.As(err, &ve) {
+			t.Errorf("%s against %s: got %v, want a validation error", tc.val, tc.schema, err)
+			continue
+		}
+		if ve.Path != tc.path {
+			t.Errorf("%s against %s: error at %q, want %q", tc.val, tc.schema, ve.Path, tc.path)
+		}
+	}
+}
+
+func TestValidateNotJSON(t *testing.T) {
+	s, err := Compile([]byte(`true`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Validate([]byte("{")); !errors.Is(err, ErrNotJSON) {
+		t.Errorf("got %v, want ErrNotJSON", err)
+	}
+}
+
+func TestValidateRefLoop(t *testing.T) {
+	s, err := Compile([]byte(`{"$ref": "#"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Validate([]byte(`1`)); err == nil {
+		t.Error("a schema referring to itself matched")
+	}
+}
+
+func TestSet(t *testing.T) {
+	str, _ := Compile([]byte(`{"type": "string"}`))
+	num, _ := Compile([]byte(`{"type": "number"}`))
+	set := NewSet([]Rule{{Prefix: "app/", Schema: str}, {Prefix: "app/count/", Schema: num}})
+	for _, tc := range []struct {
+		set *Set
+		key string
+		val string
+		ok  bool
+	}{
+		{set, "app/name", `"x"`, true},
+		{set, "app/name", `1`, false},
+		{set, "app/count/a", `1`, true},
+		{set, "app/count/a", `"x"`, false},
+		{set, "other", `not json`, true},
+		{nil, "app/name", `1`, true},
+	} {
+		err := tc.set.Validate(tc.key, []byte(tc.val))
+		if tc.ok != (err == nil) {
+			t.Errorf("Validate(%q, %s) = %v, want ok %v", tc.key, tc.val, err, tc.ok)
+		}
+	}
+}
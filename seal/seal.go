@@ -0,0 +1,147 @@
+package seal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Magic prefix of a sealed value, followed by the ephemeral X25519 public key
+// and the AES-GCM ciphertext.
+var magic = []byte("DSB1")
+
+const (
+	keyLen   = 32
+	Overhead = 4 + keyLen + 16 // magic + ephemeral key + GCM tag
+)
+
+var curve25519P, _ = new(big.Int).SetString("7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffed", 16)
+
+// Seal encrypts msg so that only the holder of the ed25519 private key
+// matching recipient can open it. An ephemeral X25519 key is used for each
+// call, so the result is anonymous with respect to the sender.
+func Seal(recipient ed25519.PublicKey, msg []byte) ([]byte, error) {
+	recipientX, err := publicToX25519(recipient)
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+	shared, err := ephemeral.ECDH(recipientX)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute shared secret: %w", err)
+	}
+	aead, err := newAEAD(shared, ephemeral.PublicKey().Bytes(), recipientX.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, Overhead+len(msg))
+	out = append(out, magic...)
+	out = append(out, ephemeral.PublicKey().Bytes()...)
+	// The key is unique per message, so a zero nonce is safe.
+	return aead.Seal(out, make([]byte, aead.NonceSize()), msg, nil), nil
+}
+
+// Open decrypts a value produced by Seal using the recipient's private key.
+func Open(priv ed25519.PrivateKey, box []byte) ([]byte, error) {
+	if !IsSealed(box) {
+		return nil, errors.New("value is not sealed")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(box[len(magic) : len(magic)+keyLen])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key: %w", err)
+	}
+	privX, err := privateToX25519(priv)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := privX.ECDH(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute shared secret: %w", err)
+	}
+	aead, err := newAEAD(shared, ephemeral.Bytes(), privX.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	msg, err := aead.Open(nil, make([]byte, aead.NonceSize()), box[len(magic)+keyLen:], nil)
+	if err != nil {
+		return nil, errors.New("failed to open sealed value, wrong key?")
+	}
+	return msg, nil
+}
+
+// IsSealed reports whether b looks like a value produced by Seal.
+func IsSealed(b []byte) bool {
+	return len(b) >= Overhead && bytes.HasPrefix(b, magic)
+}
+
+func newAEAD(shared, ephemeralPub, recipientPub []byte) (cipher.AEAD, error) {
+	// HKDF-SHA256 with a single output block
+	salt := append(append([]byte{}, ephemeralPub...), recipientPub...)
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(shared)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte("dave sealed box"))
+	expand.Write([]byte{1})
+	block, err := aes.NewCipher(expand.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func privateToX25519(priv ed25519.PrivateKey) (*ecdh.PrivateKey, error) {
+	if len(priv) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid private key length %d", len(priv))
+	}
+	h := sha512.Sum512(priv.Seed())
+	h[0] &= 248
+	h[31] &= 127
+	h[31] |= 64
+	return ecdh.X25519().NewPrivateKey(h[:keyLen])
+}
+
+// Converts an Edwards point to its Montgomery u-coordinate, u = (1+y)/(1-y).
+func publicToX25519(pub ed25519.PublicKey) (*ecdh.PublicKey, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key length %d", len(pub))
+	}
+	le := make([]byte, keyLen)
+	copy(le, pub)
+	le[31] &= 127
+	y := new(big.Int).SetBytes(reverse(le))
+	if y.Cmp(curve25519P) >= 0 {
+		return nil, errors.New("invalid public key")
+	}
+	one := big.NewInt(1)
+	num := new(big.Int).Add(one, y)
+	den := new(big.Int).Sub(one, y)
+	den.Mod(den, curve25519P)
+	if den.Sign() == 0 {
+		return nil, errors.New("invalid public key")
+	}
+	den.ModInverse(den, curve25519P)
+	u := num.Mul(num, den)
+	u.Mod(u, curve25519P)
+	ub := make([]byte, keyLen)
+	u.FillBytes(ub)
+	return ecdh.X25519().NewPublicKey(reverse(ub))
+}
+
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
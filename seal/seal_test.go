@@ -0,0 +1,112 @@
+package seal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range [][]byte{{}, []byte("hello"), bytes.Repeat([]byte{0xab}, 1024)} {
+		box, err := Seal(pub, msg)
+		if err != nil {
+			t.Fatalf("seal %d bytes: %s", len(msg), err)
+		}
+		if len(box) != Overhead+len(msg) {
+			t.Errorf("sealed %d bytes to %d, want %d", len(msg), len(box), Overhead+len(msg))
+		}
+		if !IsSealed(box) {
+			t.Errorf("sealed %d bytes, IsSealed is false", len(msg))
+		}
+		got, err := Open(priv, box)
+		if err != nil {
+			t.Fatalf("open %d bytes: %s", len(msg), err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Errorf("opened %x, want %x", got, msg)
+		}
+	}
+}
+
+func TestSealIsRandomised(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := Seal(pub, []byte("same"))
+	b, _ := Seal(pub, []byte("same"))
+	if bytes.Equal(a, b) {
+		t.Error("sealing the same message twice gave the same box")
+	}
+}
+
+func TestOpenWrongKey(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(nil)
+	_, other, _ := ed25519.GenerateKey(nil)
+	box, err := Seal(pub, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(other, box); err == nil {
+		t.Error("opened with the wrong key")
+	}
+}
+
+func TestOpenTampered(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	box, err := Seal(pub, []byte("secret message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range box {
+		tampered := bytes.Clone(box)
+		tampered[i] ^= 1
+		if _, err := Open(priv, tampered); err == nil {
+			t.Errorf("opened with byte %d flipped", i)
+		}
+	}
+	if _, err := Open(priv, box[:len(box)-1]); err == nil {
+		t.Error("opened truncated box")
+	}
+	if _, err := Open(priv, append(bytes.Clone(box), 0)); err == nil {
+		t.Error("opened extended box")
+	}
+}
+
+// The X25519 key converted from an ed25519 public key must match the one
+// derived from its private key, or nothing sealed could be opened.
+func TestX25519Conversion(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		pub, priv, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fromPub, err := publicToX25519(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fromPriv, err := privateToX25519(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fromPub.Equal(fromPriv.PublicKey()) {
+			t.Fatalf("key %x: public conversion %x, private %x", pub, fromPub.Bytes(), fromPriv.PublicKey().Bytes())
+		}
+	}
+}
+
+func TestInvalidKeys(t *testing.T) {
+	if _, err := Seal(make([]byte, 31), []byte("x")); err == nil {
+		t.Error("sealed for a short public key")
+	}
+	if _, err := Open(make([]byte, 10), make([]byte, Overhead)); err == nil {
+		t.Error("opened with a short private key")
+	}
+	if _, err := Open(ed25519.NewKeyFromSeed(make([]byte, 32)), []byte("not sealed")); err == nil {
+		t.Error("opened a value that is not sealed")
+	}
+}
@@ -0,0 +1,140 @@
+// Package signer signs dats, either with a local private key or by
+// delegating to a remote signing service, so publishing keys can be kept in
+// a central HSM-backed service while nodes do the work and sending.
+package signer
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/intob/godave/dat"
+)
+
+type Signer interface {
+	PublicKey() ed25519.PublicKey
+	Sign(d *dat.Dat) error // Sets d.Sig
+}
+
+type local struct {
+	priv ed25519.PrivateKey
+}
+
+// Local signs with a private key held by this process.
+func Local(priv ed25519.PrivateKey) Signer {
+	return &local{priv: priv}
+}
+
+func (l *local) PublicKey() ed25519.PublicKey {
+	return l.priv.Public().(ed25519.PublicKey)
+}
+
+func (l *local) Sign(d *dat.Dat) error {
+	d.Sign(l.priv)
+	return nil
+}
+
+// Remote asks a signing service to sign each dat. The service receives the
+// dat's fields and must sign them as godave's (*dat.Dat).Sign does.
+type Remote struct {
+	url    string
+	pubKey ed25519.PublicKey
+	http   *http.Client
+}
+
+type RemoteCfg struct {
+	URL      string
+	PubKey   ed25519.PublicKey // Key the service signs with
+	CertFile string            // Client certificate for mTLS, optional
+	KeyFile  string
+	CAFile   string // Verifies the service, system roots if empty
+}
+
+type signReq struct {
+	PubKey string `json:"pubkey"`
+	Key    string `json:"key"`
+	Val    string `json:"val"`  // Base64
+	Time   int64  `json:"time"` // Unix milli
+}
+
+type signResp struct {
+	Sig string `json:"sig"` // Base64
+}
+
+func NewRemote(cfg *RemoteCfg) (*Remote, error) {
+	if len(cfg.PubKey) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid public key")
+	}
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in ca file")
+		}
+		tlsCfg.RootCAs = pool
+	}
+	return &Remote{
+		url:    cfg.URL,
+		pubKey: cfg.PubKey,
+		http: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsCfg},
+		},
+	}, nil
+}
+
+func (r *Remote) PublicKey() ed25519.PublicKey {
+	return r.pubKey
+}
+
+func (r *Remote) Sign(d *dat.Dat) error {
+	body, err := json.Marshal(&signReq{
+		PubKey: base64.RawURLEncoding.EncodeToString(r.pubKey),
+		Key:    d.Key,
+		Val:    base64.RawURLEncoding.EncodeToString(d.Val),
+		Time:   d.Time.UnixMilli(),
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := r.http.Post(r.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("remote signer: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("remote signer: %s: %s", resp.Status, msg)
+	}
+	sr := &signResp{}
+	err = json.NewDecoder(resp.Body).Decode(sr)
+	if err != nil {
+		return fmt.Errorf("remote signer: failed to decode response: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sr.Sig)
+	if err != nil || len(sig) != len(d.Sig) {
+		return errors.New("remote signer: invalid signature")
+	}
+	copy(d.Sig[:], sig)
+	return nil
+}
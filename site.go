@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/intob/daved/api"
+	"github.com/intob/daved/cfg"
+	"github.com/intob/daved/dats"
+	"github.com/intob/daved/errs"
+	"github.com/intob/daved/signer"
+	"github.com/intob/daved/site"
+	"github.com/intob/godave"
+	"github.com/intob/godave/dat"
+)
+
+type siteResult struct {
+	Name   string `json:"name"`
+	Files  int    `json:"files"`
+	Dats   int    `json:"dats"`
+	URL    string `json:"url"`
+	TookMs int64  `json:"took_ms"`
+}
+
+// Parses the arguments of site publish, which takes its key after the
+// directory, as in site publish ./dist --key blog.
+func sitePublishArgs(args []string) (string, string) {
+	fs := flag.NewFlagSet("site publish", flag.ContinueOnError)
+	fs.SetOutput(humanOut())
+	key := fs.String("key", "site", "Key of the site.")
+	if len(args) == 0 {
+		exit(errs.Usage, "correct usage is site publish <DIR> [--key NAME]")
+	}
+	if err := fs.Parse(args[1:]); err != nil || fs.NArg() > 0 {
+		exit(errs.Usage, "correct usage is site publish <DIR> [--key NAME]")
+	}
+	return args[0], *key
+}
+
+// Puts the files of dir as a site, the chunks first, then once they are
+// sent, the dat of the manifest, so the site never refers to missing files.
+func sitePublish(d *godave.Dave, dir, name string, s signer.Signer, opt *cmdOptions, nodeCfg *cfg.NodeCfg) {
+	name, err := dats.NamespacedKey(opt.Namespace, name)
+	if err != nil {
+		exit(errs.Usage, "invalid key: %s", err)
+	}
+	m, puts, err := site.Build(name, os.DirFS(dir))
+	if err != nil {
+		exit(errs.Usage, "failed to read site: %s", err)
+	}
+	for _, p := range m.Paths() {
+		info("%s %s %d", p, m.Files[p].Type, m.Files[p].Size)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	info("waiting for %d peers...", opt.PeerCount)
+	d.WaitForActivePeers(ctx, opt.PeerCount)
+	start := time.Now()
+	chunks, root := puts[:len(puts)-1], puts[len(puts)-1:]
+	for i, batch := range [][]site.Dat{chunks, root} {
+		if i == 0 {
+			info("sending %d chunks...", len(batch))
+		} else {
+			info("sending manifest...")
+		}
+		p, err := newSendPipeline(ctx, d, s, opt)
+		if err != nil {
+			exit(errs.General, "%s", err)
+		}
+		for _, put := range batch {
+			if p.Submit(&dat.Dat{Key: put.Key, Val: put.Val, Time: datTime(opt), PubKey: s.PublicKey()}) != nil {
+				break // reported by Close
+			}
+		}
+		sent, err := p.Close(opt.Timeout)
+		if err != nil {
+			exit(errs.General, "publish failed after %d of %d sent: %s", sent, len(batch), err)
+		}
+	}
+	took := time.Since(start)
+	res := &siteResult{Name: name, Files: len(m.Files), Dats: len(puts), URL: siteURL(nodeCfg, s, name), TookMs: took.Milliseconds()}
+	result(res, "published %d files in %d dats, took %s\n%s", res.Files, res.Dats, took, res.URL)
+}
+
+// Returns the URL of a site at the gateway of the node at api_listen_addr.
+func siteURL(nodeCfg *cfg.NodeCfg, s signer.Signer, name string) string {
+	scheme := "http"
+	if nodeCfg.ApiTLS != nil {
+		scheme = "https"
+	}
+	host := nodeCfg.ApiListenAddr
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if ip := net.ParseIP(h); h == "" || ip != nil && ip.IsUnspecified() {
+			host = net.JoinHostPort("localhost", port)
+		}
+	}
+	pubKey := base64.RawURLEncoding.EncodeToString(s.PublicKey())
+	return fmt.Sprintf("%s://%s/%s/site/%s/%s/", scheme, host, api.APIVersion, pubKey, url.PathEscape(name))
+}
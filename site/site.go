@@ -0,0 +1,381 @@
+// Package site stores static sites as dats, and reads them back for the
+// gateway. A value fits in one packet, so files are split into chunks
+// keyed by their content, and a manifest maps paths to files:
+//
+//	<name>                     the manifest's File, as JSON
+//	<name>/<sha256>/<n>        chunk n of a file, or of the manifest, by the first 16 hex digits of its hash
+//
+// Chunks of identical files are stored once, and a republished site only
+// changes the dat <name>, so readers see either the old or the new site,
+// never a mix, while old chunks remain.
+package site
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/intob/godave/dat"
+)
+
+const (
+	ChunkSize   = 1024
+	MaxFileSize = 8 << 20 // Files are read into memory by the gateway
+	maxNameLen  = 200     // Leaves room in the 255 byte key for the chunk suffix
+	fetchers    = 16      // Chunks fetched concurrently per file
+)
+
+var (
+	ErrNotFound = errors.New("site not found")
+	ErrCorrupt  = errors.New("file does not match its hash")
+)
+
+// Manifest maps the paths of a site to its files.
+type Manifest struct {
+	Files    map[string]*File  `json:"files"`               // By path, e.g. /about/index.html
+	Routes   map[string]string `json:"routes,omitempty"`    // Request path to file path, e.g. /about/ to /about/index.html
+	NotFound string            `json:"not_found,omitempty"` // File served with 404, if the site has /404.html
+}
+
+type File struct {
+	Type   string `json:"type"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"` // Hex
+}
+
+// Dat is a key and value to put.
+type Dat struct {
+	Key string
+	Val []byte
+}
+
+// GetFunc returns the newest version of a dat, or nil if it was not found.
+type GetFunc func(ctx context.Context, pubKey ed25519.PublicKey, key string) (*dat.Dat, error)
+
+// ValidateName checks that name can key a site.
+func ValidateName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("name is empty")
+	case len(name) > maxNameLen:
+		return fmt.Errorf("name is longer than %d bytes", maxNameLen)
+	}
+	return nil
+}
+
+// ValidatePath checks that p can be the path of a file in a site, being
+// absolute and clean, such as /report.pdf.
+func ValidatePath(p string) error {
+	if p == "/" || path.Clean(p) != p || !strings.HasPrefix(p, "/") {
+		return fmt.Errorf("invalid path %q", p)
+	}
+	return nil
+}
+
+// Build reads the files of fsys, skipping those whose name starts with a
+// dot, and returns the site's manifest and the dats to put, the dat of
+// the manifest last.
+func Build(name string, fsys fs.FS) (*Manifest, []Dat, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, nil, err
+	}
+	m := &Manifest{Files: make(map[string]*File), Routes: make(map[string]string)}
+	dats := make([]Dat, 0)
+	seen := make(map[string]bool)
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != "." && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		body, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		if len(body) > MaxFileSize {
+			return fmt.Errorf("%s is larger than %d bytes", p, MaxFileSize)
+		}
+		f := newFile(body, contentType(p, body))
+		if !seen[f.SHA256] {
+			seen[f.SHA256] = true
+			dats = appendChunks(dats, name, f, body)
+		}
+		filePath := "/" + p
+		m.Files[filePath] = f
+		if path.Base(filePath) == "index.html" {
+			m.Routes[strings.TrimSuffix(filePath, "index.html")] = filePath
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(m.Files) == 0 {
+		return nil, nil, errors.New("no files to publish")
+	}
+	if m.Files["/404.html"] != nil {
+		m.NotFound = "/404.html"
+	}
+	dats, err = appendManifest(dats, name, m)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, dats, nil
+}
+
+// BuildFile returns the manifest of a site of the single file body at
+// filePath, such as /report.pdf, and the dats to put, the dat of the
+// manifest last.
+func BuildFile(name, filePath string, body []byte) (*Manifest, []Dat, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, nil, err
+	}
+	if err := ValidatePath(filePath); err != nil {
+		return nil, nil, err
+	}
+	if len(body) > MaxFileSize {
+		return nil, nil, fmt.Errorf("file is larger than %d bytes", MaxFileSize)
+	}
+	f := newFile(body, contentType(filePath, body))
+	m := &Manifest{Files: map[string]*File{filePath: f}}
+	if path.Base(filePath) == "index.html" {
+		m.Routes = map[string]string{strings.TrimSuffix(filePath, "index.html"): filePath}
+	}
+	dats, err := appendManifest(appendChunks(nil, name, f, body), name, m)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, dats, nil
+}
+
+// Appends the chunks of the manifest m, then the dat of name referring to
+// it.
+func appendManifest(dats []Dat, name string, m *Manifest) ([]Dat, error) {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	f := newFile(body, "application/json")
+	dats = appendChunks(dats, name, f, body)
+	root, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	return append(dats, Dat{Key: name, Val: root}), nil
+}
+
+func newFile(body []byte, typ string) *File {
+	sum := sha256.Sum256(body)
+	return &File{Type: typ, Size: int64(len(body)), SHA256: hex.EncodeToString(sum[:])}
+}
+
+func contentType(p string, body []byte) string {
+	if typ := mime.TypeByExtension(path.Ext(p)); typ != "" {
+		return typ
+	}
+	return http.DetectContentType(body)
+}
+
+func appendChunks(dats []Dat, name string, f *File, body []byte) []Dat {
+	for n := 0; n < f.chunks(); n++ {
+		end := min((n+1)*ChunkSize, len(body))
+		dats = append(dats, Dat{Key: chunkKey(name, f, n), Val: body[n*ChunkSize : end]})
+	}
+	return dats
+}
+
+func (f *File) chunks() int {
+	return int((f.Size + ChunkSize - 1) / ChunkSize)
+}
+
+func chunkKey(name string, f *File, n int) string {
+	return fmt.Sprintf("%s/%s/%d", name, f.SHA256[:16], n)
+}
+
+// Lookup returns the path of the file for a request path: a route, a file,
+// or the index.html of a directory.
+func (m *Manifest) Lookup(p string) (string, bool) {
+	if filePath, ok := m.Routes[p]; ok && m.Files[filePath] != nil {
+		return filePath, true
+	}
+	if strings.HasSuffix(p, "/") {
+		p += "index.html"
+	}
+	if m.Files[p] != nil {
+		return p, true
+	}
+	return "", false
+}
+
+// ReadManifest fetches the manifest of site name.
+func ReadManifest(ctx context.Context, get GetFunc, pubKey ed25519.PublicKey, name string) (*Manifest, error) {
+	root, err := get(ctx, pubKey, name)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, ErrNotFound
+	}
+	f := &File{}
+	if err := json.Unmarshal(root.Val, f); err != nil || len(f.SHA256) != sha256.Size*2 {
+		return nil, fmt.Errorf("invalid manifest reference: %s is not a site", name)
+	}
+	body, err := ReadFile(ctx, get, pubKey, name, f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(body, m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	return m, nil
+}
+
+// ReadFile fetches the chunks of f concurrently, and checks them against
+// its hash.
+func ReadFile(ctx context.Context, get GetFunc, pubKey ed25519.PublicKey, name string, f *File) ([]byte, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	chunks, err := readChunks(ctx, get, pubKey, name, f, 0, f.chunks())
+	if err != nil {
+		return nil, err
+	}
+	body := bytes.Join(chunks, nil)
+	sum := sha256.Sum256(body)
+	if int64(len(body)) != f.Size || hex.EncodeToString(sum[:]) != f.SHA256 {
+		return nil, ErrCorrupt
+	}
+	return body, nil
+}
+
+// FileReader reads f from the network, fetching only the chunks covering
+// each read, so a Range request costs the chunks it asks for. A partial
+// read cannot be checked against the file's hash, only against the chunk
+// sizes, so it relies on the chunks being signed by the site's key.
+type FileReader struct {
+	ctx    context.Context
+	get    GetFunc
+	pubKey ed25519.PublicKey
+	name   string
+	f      *File
+	off    int64
+}
+
+// NewFileReader returns a reader of f, for serving ranges of it.
+func NewFileReader(ctx context.Context, get GetFunc, pubKey ed25519.PublicKey, name string, f *File) (*FileReader, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	return &FileReader{ctx: ctx, get: get, pubKey: pubKey, name: name, f: f}, nil
+}
+
+func (r *FileReader) Read(p []byte) (int, error) {
+	if r.off >= r.f.Size {
+		return 0, io.EOF
+	}
+	end := min(r.off+int64(len(p)), r.f.Size)
+	first := int(r.off / ChunkSize)
+	chunks, err := readChunks(r.ctx, r.get, r.pubKey, r.name, r.f, first, int((end-1)/ChunkSize)+1)
+	if err != nil {
+		return 0, err
+	}
+	body := bytes.Join(chunks, nil)
+	base := int64(first) * ChunkSize
+	n := copy(p, body[r.off-base:end-base])
+	r.off += int64(n)
+	return n, nil
+}
+
+func (r *FileReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.f.Size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	r.off = offset
+	return offset, nil
+}
+
+func (f *File) validate() error {
+	if f.Size < 0 || f.Size > MaxFileSize || len(f.SHA256) != sha256.Size*2 {
+		return fmt.Errorf("invalid file of %d bytes", f.Size)
+	}
+	return nil
+}
+
+// Fetches chunks first to last, exclusive, of f concurrently, checking
+// that each is the size its position in the file implies.
+func readChunks(ctx context.Context, get GetFunc, pubKey ed25519.PublicKey, name string, f *File, first, last int) ([][]byte, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	chunks := make([][]byte, last-first)
+	next := make(chan int)
+	wg := sync.WaitGroup{}
+	for i := 0; i < min(fetchers, len(chunks)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range next {
+				d, err := get(ctx, pubKey, chunkKey(name, f, n))
+				if err == nil && d == nil {
+					err = fmt.Errorf("chunk %d not found", n)
+				}
+				if err == nil && int64(len(d.Val)) != min(f.Size-int64(n)*ChunkSize, ChunkSize) {
+					err = ErrCorrupt
+				}
+				if err != nil {
+					cancel(err)
+					continue
+				}
+				chunks[n-first] = d.Val
+			}
+		}()
+	}
+	for n := first; n < last; n++ {
+		select {
+		case next <- n:
+		case <-ctx.Done():
+		}
+	}
+	close(next)
+	wg.Wait()
+	if err := context.Cause(ctx); err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// Paths returns the paths of the manifest's files, sorted.
+func (m *Manifest) Paths() []string {
+	paths := make([]string, 0, len(m.Files))
+	for p := range m.Files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,109 @@
+// Package status samples the node's status periodically and keeps the
+// samples in a ring buffer, so trends can be shown without an external
+// metrics stack.
+package status
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/intob/godave"
+)
+
+type Sample struct {
+	Time        time.Time `json:"time"`
+	ActivePeers int       `json:"active_peers"`
+	UsedSpace   int64     `json:"used_space"`
+	Capacity    int64     `json:"capacity"`
+	PacketsIn   *float64  `json:"packets_in,omitempty"` // Per second, if supported
+	PacketsOut  *float64  `json:"packets_out,omitempty"`
+}
+
+// Implemented by godave builds that count UDP messages.
+type packetCounter interface {
+	PacketCounts() (in, out uint64)
+}
+
+type Recorder struct {
+	mu       sync.RWMutex
+	samples  []Sample
+	next     int
+	full     bool
+	interval time.Duration
+	dave     *godave.Dave
+}
+
+type RecorderCfg struct {
+	Interval  time.Duration
+	Retention time.Duration // Samples older than this are overwritten
+	Dave      *godave.Dave
+}
+
+func NewRecorder(cfg *RecorderCfg) *Recorder {
+	size := max(int(cfg.Retention/cfg.Interval), 1)
+	return &Recorder{
+		samples:  make([]Sample, size),
+		interval: cfg.Interval,
+		dave:     cfg.Dave,
+	}
+}
+
+// Run takes a sample on each interval until ctx is cancelled.
+func (r *Recorder) Run(ctx context.Context) {
+	pc, countsPackets := any(r.dave).(packetCounter)
+	var lastIn, lastOut uint64
+	if countsPackets {
+		lastIn, lastOut = pc.PacketCounts()
+	}
+	tick := time.NewTicker(r.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		s := Sample{
+			Time:        time.Now(),
+			ActivePeers: r.dave.ActivePeerCount(),
+			UsedSpace:   r.dave.UsedSpace(),
+			Capacity:    r.dave.Capacity(),
+		}
+		if countsPackets {
+			in, out := pc.PacketCounts()
+			inRate := float64(in-lastIn) / r.interval.Seconds()
+			outRate := float64(out-lastOut) / r.interval.Seconds()
+			s.PacketsIn, s.PacketsOut = &inRate, &outRate
+			lastIn, lastOut = in, out
+		}
+		r.mu.Lock()
+		r.samples[r.next] = s
+		r.next = (r.next + 1) % len(r.samples)
+		if r.next == 0 {
+			r.full = true
+		}
+		r.mu.Unlock()
+	}
+}
+
+// Window returns the samples taken within d, oldest first.
+func (r *Recorder) Window(d time.Duration) []Sample {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	since := time.Now().Add(-d)
+	n := r.next
+	start := 0
+	if r.full {
+		n = len(r.samples)
+		start = r.next
+	}
+	window := make([]Sample, 0, n)
+	for i := 0; i < n; i++ {
+		s := r.samples[(start+i)%len(r.samples)]
+		if s.Time.After(since) {
+			window = append(window, s)
+		}
+	}
+	return window
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/intob/daved/errs"
+	"github.com/intob/daved/seal"
+	"github.com/intob/godave"
+	"github.com/intob/godave/types"
+)
+
+// Names of environment variables whose values are redacted from reviews.
+var secretName = regexp.MustCompile(`(?i)secret|token|passw|private|credential|api_?key|_key$`)
+
+// Renders the template of put -template. Environment variables are only
+// available with -env, as {{env "NAME"}}, and an unset one fails the render
+// rather than publishing an empty value. Returns the value and the
+// variables it used.
+func renderTemplate(filename string, withEnv bool) ([]byte, map[string]string, error) {
+	used := make(map[string]string)
+	funcs := template.FuncMap{
+		"env": func(name string) (string, error) {
+			if !withEnv {
+				return "", fmt.Errorf("environment variables are only available with -env")
+			}
+			v, ok := os.LookupEnv(name)
+			if !ok {
+				return "", fmt.Errorf("environment variable %s is not set", name)
+			}
+			used[name] = v
+			return v, nil
+		},
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl, err := template.New(filename).Funcs(funcs).Parse(string(b))
+	if err != nil {
+		return nil, nil, err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, nil); err != nil {
+		return nil, nil, err
+	}
+	return out.Bytes(), used, nil
+}
+
+// Shows how a rendered value changes the published version of key, with
+// the values of secret-looking variables redacted, then asks to continue
+// unless -yes is set. Without a terminal to ask on, -yes is required.
+func reviewPut(d *godave.Dave, pubKey ed25519.PublicKey, key string, val []byte, used map[string]string, opt *cmdOptions) {
+	secrets := make([]string, 0)
+	for name := range used {
+		if secretName.MatchString(name) {
+			secrets = append(secrets, name)
+		}
+	}
+	sort.Strings(secrets)
+	redact := func(s string) string {
+		for _, name := range secrets {
+			if used[name] != "" {
+				s = strings.ReplaceAll(s, used[name], "<"+name+">")
+			}
+		}
+		return s
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), opt.Timeout)
+	defer cancel()
+	d.WaitForActivePeers(ctx, opt.PeerCount)
+	var current string
+	entry, err := d.Get(ctx, &types.Get{PublicKey: pubKey, DatKey: key})
+	switch {
+	case err != nil || entry == nil:
+		info("%s is not published yet, or was not found:", key)
+	case seal.IsSealed(entry.Dat.Val):
+		info("%s is published encrypted, so cannot be compared:", key)
+	default:
+		current = string(entry.Dat.Val)
+		info("changes to %s:", key)
+	}
+	for _, line := range diffLines(splitLines(redact(current)), splitLines(redact(string(val)))) {
+		info("%s", line)
+	}
+	if current == string(val) {
+		info("the value is unchanged")
+	}
+	if len(secrets) > 0 && opt.EncryptFor == "" {
+		info("warning: the value embeds %s, and will be readable by anyone; consider -encrypt_for", strings.Join(secrets, ", "))
+	}
+	if opt.Yes {
+		return
+	}
+	if stat, err := os.Stdin.Stat(); err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		exit(errs.Usage, "review the changes and pass -yes to put without a terminal")
+	}
+	fmt.Fprintf(humanOut(), "put %s? [y/N] ", key)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+		exit(errs.General, "put cancelled")
+	}
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// Returns the lines of a and b, prefixed "-" if only in a, "+" if only in
+// b, and " " if in both, by their longest common subsequence. Values fit in
+// a packet, so the quadratic table is small.
+func diffLines(a, b []string) []string {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	lines := make([]string, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, "  "+a[i])
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, "- "+a[i])
+			i++
+		default:
+			lines = append(lines, "+ "+b[j])
+			j++
+		}
+	}
+	return lines
+}
@@ -0,0 +1,290 @@
+// Package tenant keeps the API tokens of the applications a node serves,
+// each with a scope, a request rate and quotas on what it puts, so that
+// one node can be shared without one application starving the others.
+//
+// Only a hash of each token is kept. Tokens and their usage are written to
+// a file, so quotas hold across restarts.
+package tenant
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/intob/daved/logbuf"
+)
+
+var (
+	ErrNotFound = errors.New("token not found")
+	ErrQuota    = errors.New("quota exceeded")
+)
+
+type Limits struct {
+	Rate     float64 `json:"rate,omitempty"`      // Requests per second, 0 for no limit
+	Burst    int     `json:"burst,omitempty"`     // Requests above the rate allowed at once, at least 1
+	MaxPuts  int64   `json:"max_puts,omitempty"`  // 0 for no limit
+	MaxBytes int64   `json:"max_bytes,omitempty"` // Of keys and values put, 0 for no limit
+}
+
+type Usage struct {
+	Puts  int64 `json:"puts"`
+	Bytes int64 `json:"bytes"`
+}
+
+type Token struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Scope   string    `json:"scope"`
+	Hash    string    `json:"hash,omitempty"` // Hex SHA-256 of the token, only in the file
+	Created time.Time `json:"created"`
+	Limits  Limits    `json:"limits"`
+	Usage   Usage     `json:"usage"`
+}
+
+type tokensFile struct {
+	Tokens []*Token `json:"tokens"`
+}
+
+// Tenants is the set of tokens of a node.
+type Tenants struct {
+	mu       sync.Mutex
+	byHash   map[string]*Token
+	buckets  map[string]*bucket // By id
+	filename string
+	dirty    bool // Usage changed since the file was written
+	logs     chan<- string
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// ID returns the id of a token, being a prefix of its hash, by which it is
+// listed, revoked and recorded in the audit log.
+func ID(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:6])
+}
+
+func hashOf(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// Open reads the tokens of a file. A missing file has none.
+func Open(filename string, logs chan<- string) (*Tenants, error) {
+	t := &Tenants{
+		byHash:   make(map[string]*Token),
+		buckets:  make(map[string]*bucket),
+		filename: filename,
+		logs:     logs,
+	}
+	b, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f tokensFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("invalid tokens file %s: %w", filename, err)
+	}
+	for _, tok := range f.Tokens {
+		t.byHash[tok.Hash] = tok
+	}
+	return t, nil
+}
+
+// Create adds a token, returning it. The token is not kept, only its hash,
+// so it cannot be shown again.
+func (t *Tenants) Create(name, scope string, limits Limits) (string, *Token, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+	tok := &Token{
+		ID:      ID(secret),
+		Name:    name,
+		Scope:   scope,
+		Hash:    hashOf(secret),
+		Created: time.Now(),
+		Limits:  limits,
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.byHash[tok.Hash] = tok
+	if err := t.write(); err != nil {
+		delete(t.byHash, tok.Hash)
+		return "", nil, err
+	}
+	return secret, public(tok), nil
+}
+
+// Revoke removes a token by id.
+func (t *Tenants) Revoke(id string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for hash, tok := range t.byHash {
+		if tok.ID != id {
+			continue
+		}
+		delete(t.byHash, hash)
+		delete(t.buckets, id)
+		if err := t.write(); err != nil {
+			t.byHash[hash] = tok
+			return err
+		}
+		return nil
+	}
+	return ErrNotFound
+}
+
+// Get returns a copy of the token with id, or nil if there is none.
+func (t *Tenants) Get(id string) *Token {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, tok := range t.byHash {
+		if tok.ID == id {
+			return public(tok)
+		}
+	}
+	return nil
+}
+
+// List returns the tokens with their usage, oldest first, without hashes.
+func (t *Tenants) List() []*Token {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := make([]*Token, 0, len(t.byHash))
+	for _, tok := range t.byHash {
+		list = append(list, public(tok))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list
+}
+
+// Authenticate returns a copy of the token, or nil if it is not known.
+func (t *Tenants) Authenticate(secret string) *Token {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tok, ok := t.byHash[hashOf(secret)]
+	if !ok {
+		return nil
+	}
+	return public(tok)
+}
+
+// Allow reports whether the token may make another request now, taking one
+// from its bucket if so.
+func (t *Tenants) Allow(tok *Token) bool {
+	if tok.Limits.Rate <= 0 {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	burst := float64(max(tok.Limits.Burst, 1))
+	now := time.Now()
+	b, ok := t.buckets[tok.ID]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		t.buckets[tok.ID] = b
+	}
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*tok.Limits.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Charge counts a put of size bytes against the token's quotas, returning
+// ErrQuota without counting it if either would be exceeded.
+func (t *Tenants) Charge(id string, size int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, tok := range t.byHash {
+		if tok.ID != id {
+			continue
+		}
+		l, u := tok.Limits, tok.Usage
+		if l.MaxPuts > 0 && u.Puts+1 > l.MaxPuts {
+			return fmt.Errorf("%w: %d of %d puts", ErrQuota, u.Puts, l.MaxPuts)
+		}
+		if l.MaxBytes > 0 && u.Bytes+size > l.MaxBytes {
+			return fmt.Errorf("%w: %d of %d bytes", ErrQuota, u.Bytes, l.MaxBytes)
+		}
+		tok.Usage.Puts++
+		tok.Usage.Bytes += size
+		t.dirty = true
+		return nil
+	}
+	return ErrNotFound
+}
+
+// Run writes usage to the file on each interval until ctx is cancelled.
+func (t *Tenants) Run(ctx context.Context, interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		if err := t.Flush(); err != nil {
+			logbuf.For(t.logs, "tenant").Printf("failed to write tokens: %s", err)
+		}
+	}
+}
+
+// Flush writes the file if usage changed since it was last written.
+func (t *Tenants) Flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.dirty {
+		return nil
+	}
+	return t.write()
+}
+
+func (t *Tenants) write() error {
+	f := tokensFile{Tokens: make([]*Token, 0, len(t.byHash))}
+	for _, tok := range t.byHash {
+		f.Tokens = append(f.Tokens, tok)
+	}
+	sort.Slice(f.Tokens, func(i, j int) bool { return f.Tokens[i].Created.Before(f.Tokens[j].Created) })
+	b, err := json.MarshalIndent(&f, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := t.filename + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, t.filename); err != nil {
+		return err
+	}
+	t.dirty = false
+	return nil
+}
+
+// Returns a copy of the token without its hash.
+func public(tok *Token) *Token {
+	cpy := *tok
+	cpy.Hash = ""
+	return &cpy
+}
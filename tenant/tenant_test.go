@@ -0,0 +1,51 @@
+package tenant
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestCharge(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "tokens.json")
+	tenants, err := Open(filename, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, tok, err := tenants.Create("app", "write", Limits{MaxPuts: 3, MaxBytes: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		size  int64
+		quota bool
+	}{
+		{4, false},
+		{4, false},
+		{3, true}, // 11 of 10 bytes
+		{2, false},
+		{0, true}, // 4th put
+	} {
+		err := tenants.Charge(tok.ID, tc.size)
+		if tc.quota != errors.Is(err, ErrQuota) {
+			t.Errorf("charge of %d bytes: got %v, want quota %v", tc.size, err, tc.quota)
+		}
+	}
+	if err := tenants.Charge("unknown", 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("charge of an unknown token: got %v, want ErrNotFound", err)
+	}
+	if err := tenants.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := Open(filename, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Usage{Puts: 3, Bytes: 10}
+	if got := reopened.Get(tok.ID); got == nil || got.Usage != want {
+		t.Errorf("usage after reopening is %+v, want %+v", got, want)
+	}
+	if err := reopened.Charge(tok.ID, 0); !errors.Is(err, ErrQuota) {
+		t.Errorf("charge after reopening: got %v, want ErrQuota", err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/intob/daved/api"
+	"github.com/intob/daved/cfg"
+	"github.com/intob/daved/errs"
+	"github.com/intob/daved/tenant"
+)
+
+// Lists, creates or revokes the running node's tenant tokens.
+func tokensCommand(client *api.Client) {
+	switch flag.Arg(1) {
+	case "":
+		tokens, err := client.Tokens()
+		if err != nil {
+			exit(errs.General, "failed to list tokens: %s", err)
+		}
+		if jsonOutput {
+			printJSON(tokens)
+			return
+		}
+		for _, t := range tokens {
+			fmt.Printf("%s %-16s %-5s puts=%d bytes=%s%s\n", t.ID, t.Name, t.Scope,
+				t.Usage.Puts, cfg.Size(t.Usage.Bytes), formatLimits(t.Limits))
+		}
+	case "create":
+		if flag.NArg() < 4 {
+			exit(errs.Usage, "correct usage is tokens create <NAME> <read|write|admin> [rate=N] [burst=N] [max_puts=N] [max_bytes=SIZE]")
+		}
+		req := &api.TokenReq{Name: flag.Arg(2), Scope: flag.Arg(3)}
+		for _, arg := range flag.Args()[4:] {
+			if err := parseLimit(&req.Limits, arg); err != nil {
+				exit(errs.Usage, "invalid limit %q: %s", arg, err)
+			}
+		}
+		created, err := client.CreateToken(req)
+		if err != nil {
+			exit(errs.General, "failed to create token: %s", err)
+		}
+		if jsonOutput {
+			printJSON(created)
+			return
+		}
+		info("created token %s, it will not be shown again", created.ID)
+		fmt.Println(created.Secret)
+	case "revoke":
+		if flag.NArg() < 3 {
+			exit(errs.Usage, "correct usage is tokens revoke <ID>")
+		}
+		if err := client.RevokeToken(flag.Arg(2)); err != nil {
+			exit(errs.General, "failed to revoke token: %s", err)
+		}
+		info("revoked token %s", flag.Arg(2))
+	default:
+		exit(errs.Usage, "correct usage is tokens [create|revoke]")
+	}
+}
+
+func parseLimit(l *tenant.Limits, arg string) error {
+	name, v, ok := strings.Cut(arg, "=")
+	if !ok {
+		return fmt.Errorf("expected NAME=VALUE")
+	}
+	var err error
+	switch name {
+	case "rate":
+		l.Rate, err = strconv.ParseFloat(v, 64)
+	case "burst":
+		l.Burst, err = strconv.Atoi(v)
+	case "max_puts":
+		l.MaxPuts, err = strconv.ParseInt(v, 10, 64)
+	case "max_bytes":
+		l.MaxBytes, err = cfg.ParseSize(v)
+	default:
+		return fmt.Errorf("expected rate, burst, max_puts or max_bytes")
+	}
+	return err
+}
+
+func formatLimits(l tenant.Limits) string {
+	var b strings.Builder
+	if l.Rate > 0 {
+		fmt.Fprintf(&b, " rate=%g burst=%d", l.Rate, max(l.Burst, 1))
+	}
+	if l.MaxPuts > 0 {
+		fmt.Fprintf(&b, " max_puts=%d", l.MaxPuts)
+	}
+	if l.MaxBytes > 0 {
+		fmt.Fprintf(&b, " max_bytes=%s", cfg.Size(l.MaxBytes))
+	}
+	return b.String()
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/intob/daved/api"
+	"github.com/intob/daved/events"
+)
+
+const (
+	topInterval   = time.Second
+	topRecentDats = 8
+)
+
+// Runs a full-screen monitor of the running node until interrupted: status,
+// recent puts through the node from the event stream and the log tail.
+// Drawn with plain ANSI escapes, so any terminal will do.
+func top(c *api.Client) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var mu sync.Mutex
+	recent := make([]*events.DatEvent, 0, topRecentDats)
+	var streamErr error
+	go func() {
+		err := c.Events(ctx, func(e *api.StreamedEvent) {
+			de := &events.DatEvent{}
+			if json.Unmarshal(e.Data, de) != nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(recent) == topRecentDats {
+				recent = recent[1:]
+			}
+			recent = append(recent, de)
+		}, events.DAT_PUT)
+		mu.Lock()
+		streamErr = err
+		mu.Unlock()
+	}()
+	fmt.Print("\x1b[?1049h\x1b[?25l") // Alternate screen, hide cursor
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+	tick := time.NewTicker(topInterval)
+	defer tick.Stop()
+	for {
+		width, height := terminalSize()
+		mu.Lock()
+		dats := append([]*events.DatEvent(nil), recent...)
+		err := streamErr
+		mu.Unlock()
+		lines := topLines(c, dats, err, height)
+		var b strings.Builder
+		b.WriteString("\x1b[H")
+		for i, line := range lines {
+			if i == height {
+				break
+			}
+			if r := []rune(line); len(r) > width {
+				line = string(r[:width])
+			}
+			b.WriteString(line)
+			b.WriteString("\x1b[K\r\n")
+		}
+		b.WriteString("\x1b[J")
+		fmt.Print(b.String())
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+func topLines(c *api.Client, dats []*events.DatEvent, streamErr error, height int) []string {
+	lines := make([]string, 0, height)
+	stat, err := c.Status()
+	if err != nil {
+		return append(lines, fmt.Sprintf("daved top  %s  failed to get status: %s", time.Now().Format(time.TimeOnly), err))
+	}
+	lines = append(lines, fmt.Sprintf("daved top  %s  peers %d  used %d/%d bytes  network %d/%d bytes",
+		time.Now().Format(time.TimeOnly), stat.ActivePeers, stat.UsedSpace, stat.Capacity, stat.Network.UsedSpace, stat.Network.Capacity), "")
+
+	lines = append(lines, "RECENT PUTS")
+	if streamErr != nil {
+		lines = append(lines, "  event stream ended: "+streamErr.Error())
+	}
+	for i := len(dats) - 1; i >= 0; i-- {
+		d := dats[i]
+		lines = append(lines, fmt.Sprintf("  %s %.8s %s (%d bytes)", d.Time.Format(time.TimeOnly), d.PubKey, d.Key, d.Size))
+	}
+	lines = append(lines, "")
+
+	lines = append(lines, "LOG")
+	n := height - len(lines)
+	if n < 1 {
+		return lines
+	}
+	logs, err := c.Logs(n)
+	if err != nil {
+		return append(lines, "  "+err.Error())
+	}
+	for _, l := range logs {
+		lines = append(lines, "  "+l)
+	}
+	return lines
+}
+
+// Asks stty for the terminal size, falling back to $COLUMNS and $LINES, then
+// to 100x40.
+func terminalSize() (width, height int) {
+	width, height = 100, 40
+	cmd := exec.Command("stty", "size")
+	cmd.Stdin = os.Stdin
+	if out, err := cmd.Output(); err == nil {
+		if _, err := fmt.Sscan(string(out), &height, &width); err == nil && width > 0 && height > 0 {
+			return width, height
+		}
+		width, height = 100, 40
+	}
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		width = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("LINES")); err == nil && n > 0 {
+		height = n
+	}
+	return width, height
+}
@@ -0,0 +1,191 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	batchSize     = 512
+	batchInterval = 5 * time.Second
+)
+
+// Exporter batches ended spans and posts them to an OTLP/HTTP endpoint.
+// Spans are dropped if the queue is full.
+type Exporter struct {
+	url     string
+	service string
+	spans   chan *Span
+	flush   chan chan struct{}
+	http    *http.Client
+}
+
+func newExporter(url, service string) *Exporter {
+	e := &Exporter{
+		url:     url,
+		service: service,
+		spans:   make(chan *Span, 4*batchSize),
+		flush:   make(chan chan struct{}),
+		http:    &http.Client{Timeout: 10 * time.Second},
+	}
+	go e.run()
+	return e
+}
+
+func (e *Exporter) queue(s *Span) {
+	select {
+	case e.spans <- s:
+	default:
+	}
+}
+
+func (e *Exporter) shutdown(ctx context.Context) {
+	done := make(chan struct{})
+	select {
+	case e.flush <- done:
+	case <-ctx.Done():
+		return
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+func (e *Exporter) run() {
+	batch := make([]*Span, 0, batchSize)
+	tick := time.NewTicker(batchInterval)
+	defer tick.Stop()
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		e.post(batch) // Errors are dropped, tracing must not affect the node
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case s := <-e.spans:
+			batch = append(batch, s)
+			if len(batch) >= batchSize {
+				send()
+			}
+		case <-tick.C:
+			send()
+		case done := <-e.flush:
+			for len(e.spans) > 0 {
+				batch = append(batch, <-e.spans)
+			}
+			send()
+			close(done)
+			return
+		}
+	}
+}
+
+func (e *Exporter) post(batch []*Span) error {
+	body, err := json.Marshal(e.encode(batch))
+	if err != nil {
+		return err
+	}
+	resp, err := e.http.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP JSON encoding, see opentelemetry-proto's trace.proto.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttr `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID      string     `json:"traceId"`
+		SpanID       string     `json:"spanId"`
+		ParentSpanID string     `json:"parentSpanId,omitempty"`
+		Name         string     `json:"name"`
+		Kind         int        `json:"kind"`
+		Start        string     `json:"startTimeUnixNano"`
+		End          string     `json:"endTimeUnixNano"`
+		Attributes   []otlpAttr `json:"attributes,omitempty"`
+		Status       otlpStatus `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"` // 0 unset, 2 error
+		Message string `json:"message,omitempty"`
+	}
+	otlpAttr struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	}
+)
+
+func (e *Exporter) encode(batch []*Span) *otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		s.mu.Lock()
+		span := otlpSpan{
+			TraceID: fmt.Sprintf("%x", s.traceID),
+			SpanID:  fmt.Sprintf("%x", s.spanID),
+			Name:    s.name,
+			Kind:    s.kind,
+			Start:   strconv.FormatInt(s.start.UnixNano(), 10),
+			End:     strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parentID != [8]byte{} {
+			span.ParentSpanID = fmt.Sprintf("%x", s.parentID)
+		}
+		for k, v := range s.attrs {
+			span.Attributes = append(span.Attributes, attr(k, v))
+		}
+		if s.err != "" {
+			span.Status = otlpStatus{Code: 2, Message: s.err}
+		}
+		s.mu.Unlock()
+		spans = append(spans, span)
+	}
+	return &otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttr{attr("service.name", e.service)}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "github.com/intob/daved"}, Spans: spans}},
+	}}}
+}
+
+func attr(key string, val any) otlpAttr {
+	switch v := val.(type) {
+	case string:
+		return otlpAttr{key, map[string]any{"stringValue": v}}
+	case bool:
+		return otlpAttr{key, map[string]any{"boolValue": v}}
+	case int:
+		return otlpAttr{key, map[string]any{"intValue": strconv.Itoa(v)}}
+	case int64:
+		return otlpAttr{key, map[string]any{"intValue": strconv.FormatInt(v, 10)}}
+	case float64:
+		return otlpAttr{key, map[string]any{"doubleValue": v}}
+	}
+	return otlpAttr{key, map[string]any{"stringValue": fmt.Sprint(val)}}
+}
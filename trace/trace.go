@@ -0,0 +1,159 @@
+// Package trace records spans and exports them to an OpenTelemetry
+// collector using OTLP over HTTP with JSON encoding, so latency can be
+// broken down by stage. Tracing is disabled until Init is called, and all
+// span methods are no-ops on a nil span.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span kinds, as defined by OTLP
+const (
+	KIND_INTERNAL = 1
+	KIND_SERVER   = 2
+	KIND_CLIENT   = 3
+)
+
+type Span struct {
+	mu       sync.Mutex
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    map[string]any
+	err      string
+	ended    bool
+}
+
+type ctxKey struct{}
+
+var (
+	mu       sync.RWMutex
+	exporter *Exporter
+)
+
+// Init enables tracing, exporting spans to the collector at endpoint,
+// e.g. http://localhost:4318.
+func Init(endpoint, service string) {
+	mu.Lock()
+	defer mu.Unlock()
+	exporter = newExporter(strings.TrimSuffix(endpoint, "/")+"/v1/traces", service)
+}
+
+// Shutdown exports pending spans and disables tracing.
+func Shutdown(ctx context.Context) {
+	mu.Lock()
+	e := exporter
+	exporter = nil
+	mu.Unlock()
+	if e != nil {
+		e.shutdown(ctx)
+	}
+}
+
+func enabled() *Exporter {
+	mu.RLock()
+	defer mu.RUnlock()
+	return exporter
+}
+
+// Start begins a span as a child of the span in ctx, if any. Returns nil
+// if tracing is disabled.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return StartKind(ctx, name, KIND_INTERNAL)
+}
+
+func StartKind(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	if enabled() == nil {
+		return ctx, nil
+	}
+	s := &Span{name: name, kind: kind, start: time.Now(), attrs: make(map[string]any)}
+	if parent := FromContext(ctx); parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, ctxKey{}, s), s
+}
+
+// FromContext returns the span in ctx, or nil.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(ctxKey{}).(*Span)
+	return s
+}
+
+// ContextWithRemoteParent returns ctx carrying the parent described by a W3C
+// traceparent header, so spans continue a caller's trace. Invalid headers
+// are ignored.
+func ContextWithRemoteParent(ctx context.Context, traceparent string) context.Context {
+	parts := strings.Split(traceparent, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return ctx
+	}
+	s := &Span{}
+	if _, err := hex.Decode(s.traceID[:], []byte(parts[1])); err != nil {
+		return ctx
+	}
+	if _, err := hex.Decode(s.spanID[:], []byte(parts[2])); err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxKey{}, s)
+}
+
+// TraceParent returns the W3C traceparent header value for s.
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%x-%x-01", s.traceID, s.spanID)
+}
+
+func (s *Span) SetAttr(key string, val any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs[key] = val
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed if err is not nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = err.Error()
+	s.mu.Unlock()
+}
+
+// End completes the span and queues it for export. Only the first call has
+// an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+	if e := enabled(); e != nil {
+		e.queue(s)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/intob/daved/status"
+)
+
+var sparkChars = []rune("▁▂▃▄▅▆▇█")
+
+// Prints a sparkline with the latest value for each status series.
+func printStatusHistory(samples []status.Sample, window time.Duration) {
+	if len(samples) == 0 {
+		fmt.Println("no samples yet")
+		return
+	}
+	peers := make([]float64, len(samples))
+	used := make([]float64, len(samples))
+	var in, out []float64
+	for i, s := range samples {
+		peers[i] = float64(s.ActivePeers)
+		used[i] = float64(s.UsedSpace)
+		if s.PacketsIn != nil && s.PacketsOut != nil {
+			in = append(in, *s.PacketsIn)
+			out = append(out, *s.PacketsOut)
+		}
+	}
+	last := samples[len(samples)-1]
+	fmt.Printf("last %s, %d samples\n", window, len(samples))
+	fmt.Printf("%-12s %s %d\n", "peers", sparkline(peers, 60), last.ActivePeers)
+	fmt.Printf("%-12s %s %d/%d bytes\n", "used space", sparkline(used, 60), last.UsedSpace, last.Capacity)
+	if len(in) > 0 {
+		fmt.Printf("%-12s %s %.1f/s\n", "packets in", sparkline(in, 60), in[len(in)-1])
+		fmt.Printf("%-12s %s %.1f/s\n", "packets out", sparkline(out, 60), out[len(out)-1])
+	}
+}
+
+// Renders values as at most width characters, averaging into buckets.
+func sparkline(values []float64, width int) string {
+	buckets := min(width, len(values))
+	avg := make([]float64, buckets)
+	lo, hi := 0.0, 0.0
+	for b := range avg {
+		from, to := b*len(values)/buckets, (b+1)*len(values)/buckets
+		for _, v := range values[from:to] {
+			avg[b] += v
+		}
+		avg[b] /= float64(to - from)
+		if b == 0 || avg[b] < lo {
+			lo = avg[b]
+		}
+		if b == 0 || avg[b] > hi {
+			hi = avg[b]
+		}
+	}
+	line := make([]rune, buckets)
+	for b, v := range avg {
+		i := 0
+		if hi > lo {
+			i = int((v - lo) / (hi - lo) * float64(len(sparkChars)-1))
+		}
+		line[b] = sparkChars[i]
+	}
+	return string(line)
+}
@@ -0,0 +1,306 @@
+// Package upload keeps the state of resumable file uploads, offset-based as
+// in tus: a client creates an upload of a known size, appends to it at the
+// offset the node has, and after an interruption asks for that offset and
+// carries on, rather than starting over. A complete upload is put under the
+// node's key as a site of the single file, so the gateway serves it.
+//
+// Uploads are held in memory until complete, so at most maxUploads are in
+// progress at once, each of at most site.MaxFileSize. Uploads expire after
+// expiry without a request.
+package upload
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/intob/daved/clock"
+	"github.com/intob/daved/logbuf"
+	"github.com/intob/daved/signer"
+	"github.com/intob/daved/site"
+	"github.com/intob/godave/dat"
+)
+
+const (
+	maxUploads = 8
+	expiry     = 24 * time.Hour
+)
+
+// States of an upload.
+const (
+	Uploading  = "uploading"
+	Publishing = "publishing"
+	Published  = "published"
+	Failed     = "failed"
+)
+
+var (
+	ErrNotFound = errors.New("upload not found")
+	ErrOffset   = errors.New("offset does not match the upload")
+	ErrBusy     = errors.New("upload is being written by another request")
+	ErrComplete = errors.New("upload is complete")
+	ErrTooLong  = errors.New("body is longer than the rest of the upload")
+	ErrTooMany  = fmt.Errorf("%d uploads are in progress", maxUploads)
+)
+
+// Store is the node, which refuses writes in readonly mode.
+type Store interface {
+	Put(d dat.Dat) error
+}
+
+type Cfg struct {
+	Store      Store
+	Signer     signer.Signer // Signs and owns every upload
+	Difficulty uint8
+	Logs       chan<- string
+}
+
+type Status struct {
+	ID      string    `json:"id"`
+	Key     string    `json:"key"`  // Of the site
+	Path    string    `json:"path"` // Of the file within the site, e.g. /report.pdf
+	Size    int64     `json:"size"`
+	Offset  int64     `json:"offset"` // Bytes received
+	State   string    `json:"state"`
+	Error   string    `json:"error,omitempty"` // Why publishing failed
+	Expires time.Time `json:"expires"`
+}
+
+type upload struct {
+	Status
+	owner  string // Token id the upload was created with, empty if none
+	charge func(size int64) error
+	body   []byte // Nil once complete
+	busy   bool   // A request is appending
+}
+
+// Uploads is the set of uploads of a node.
+type Uploads struct {
+	cfg     *Cfg
+	mu      sync.Mutex
+	uploads map[string]*upload
+	done    chan struct{} // Closed when Run returns, stopping publishing
+	log     logbuf.Logger
+}
+
+func New(cfg *Cfg) *Uploads {
+	return &Uploads{
+		cfg:     cfg,
+		uploads: make(map[string]*upload),
+		done:    make(chan struct{}),
+		log:     logbuf.For(cfg.Logs, "upload"),
+	}
+}
+
+// Create starts an upload of size bytes to be put as the file at path of
+// site key, owned by the token id owner. Charge, if not nil, is called
+// with the size of each dat before it is signed, failing the upload on
+// error.
+func (u *Uploads) Create(key, path string, size int64, owner string, charge func(size int64) error) (*Status, error) {
+	if err := site.ValidateName(key); err != nil {
+		return nil, err
+	}
+	if err := site.ValidatePath(path); err != nil {
+		return nil, err
+	}
+	if size < 0 || size > site.MaxFileSize {
+		return nil, fmt.Errorf("size must be from 0 to %d bytes", site.MaxFileSize)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	up := &upload{
+		Status: Status{ID: hex.EncodeToString(id), Key: key, Path: path, Size: size, State: Uploading},
+		owner:  owner,
+		charge: charge,
+		body:   make([]byte, 0, size),
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	n := 0
+	for _, other := range u.uploads {
+		if other.State == Uploading {
+			n++
+		}
+	}
+	if n >= maxUploads {
+		return nil, ErrTooMany
+	}
+	u.uploads[up.ID] = up
+	up.Expires = time.Now().Add(expiry)
+	if size == 0 {
+		u.complete(up)
+	}
+	return up.status(), nil
+}
+
+// Get returns the status of upload id, if owner created it.
+func (u *Uploads) Get(id, owner string) (*Status, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	up, err := u.find(id, owner)
+	if err != nil {
+		return nil, err
+	}
+	return up.status(), nil
+}
+
+// Append reads r to the end of upload id, which must have offset bytes.
+// What is read before an error is kept, so the client can resume from the
+// new offset, but a body longer than the rest of the upload is refused.
+// Once the upload has all its bytes, it is published in the background.
+func (u *Uploads) Append(id, owner string, offset int64, r io.Reader) (*Status, error) {
+	u.mu.Lock()
+	up, err := u.find(id, owner)
+	switch {
+	case err != nil:
+	case up.State != Uploading:
+		err = ErrComplete
+	case up.busy:
+		err = ErrBusy
+	case offset != up.Offset:
+		err = fmt.Errorf("%w, which has %d bytes", ErrOffset, up.Offset)
+	}
+	if err != nil {
+		u.mu.Unlock()
+		return nil, err
+	}
+	up.busy = true
+	body := up.body
+	u.mu.Unlock()
+	n, readErr := io.ReadFull(r, body[len(body):cap(body)])
+	if readErr == io.EOF || readErr == io.ErrUnexpectedEOF { // A shorter append
+		readErr = nil
+	}
+	tooLong := false
+	if readErr == nil && len(body)+n == cap(body) {
+		m, _ := r.Read(make([]byte, 1))
+		tooLong = m > 0
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	up.busy = false
+	if tooLong {
+		return nil, ErrTooLong
+	}
+	up.body = body[:len(body)+n]
+	up.Offset += int64(n)
+	up.Expires = time.Now().Add(expiry)
+	if readErr != nil {
+		return nil, fmt.Errorf("failed to read body after %d bytes: %w", n, readErr)
+	}
+	if up.Offset == up.Size {
+		u.complete(up)
+	}
+	return up.status(), nil
+}
+
+// Delete cancels upload id, or forgets one that is complete.
+func (u *Uploads) Delete(id, owner string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	up, err := u.find(id, owner)
+	if err != nil {
+		return err
+	}
+	if up.busy || up.State == Publishing {
+		return ErrBusy
+	}
+	delete(u.uploads, id)
+	return nil
+}
+
+// Run expires uploads until ctx is cancelled, then stops publishing.
+func (u *Uploads) Run(ctx context.Context) {
+	defer close(u.done)
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-tick.C:
+			u.expire(now)
+		}
+	}
+}
+
+func (u *Uploads) expire(now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for id, up := range u.uploads {
+		if now.After(up.Expires) && !up.busy && up.State != Publishing {
+			delete(u.uploads, id)
+		}
+	}
+}
+
+// Returns upload id, hiding the uploads of other owners.
+func (u *Uploads) find(id, owner string) (*upload, error) {
+	up, ok := u.uploads[id]
+	if !ok || up.owner != owner {
+		return nil, ErrNotFound
+	}
+	return up, nil
+}
+
+func (up *upload) status() *Status {
+	s := up.Status
+	return &s
+}
+
+// Marks up as publishing and puts it in the background.
+func (u *Uploads) complete(up *upload) {
+	up.State = Publishing
+	body := up.body
+	up.body = nil
+	go func() {
+		err := u.publish(up, body)
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		up.Expires = time.Now().Add(expiry)
+		if err != nil {
+			up.State, up.Error = Failed, err.Error()
+			u.log.Printf("failed to publish %s%s: %s", up.Key, up.Path, err)
+			return
+		}
+		up.State = Published
+		u.log.Printf("published %s%s, %d bytes", up.Key, up.Path, up.Size)
+	}()
+}
+
+// Puts the chunks of the file, then the manifest, so the site never refers
+// to missing chunks.
+func (u *Uploads) publish(up *upload, body []byte) error {
+	_, puts, err := site.BuildFile(up.Key, up.Path, body)
+	if err != nil {
+		return err
+	}
+	for _, put := range puts {
+		select {
+		case <-u.done:
+			return errors.New("node stopped")
+		default:
+		}
+		if up.charge != nil {
+			if err := up.charge(int64(len(put.Key) + len(put.Val))); err != nil {
+				return err
+			}
+		}
+		d := &dat.Dat{Key: put.Key, Val: put.Val, Time: clock.Now(), PubKey: u.cfg.Signer.PublicKey()}
+		if err := u.cfg.Signer.Sign(d); err != nil {
+			return fmt.Errorf("failed to sign: %w", err)
+		}
+		d.Work, d.Salt = dat.DoWork(d.Sig, u.cfg.Difficulty)
+		if err := u.cfg.Store.Put(*d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/intob/godave/dat"
+)
+
+type testStore struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+func (s *testStore) Put(d dat.Dat) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, d.Key)
+	return nil
+}
+
+type testSigner struct {
+	pub ed25519.PublicKey
+}
+
+func (s *testSigner) PublicKey() ed25519.PublicKey { return s.pub }
+func (s *testSigner) Sign(d *dat.Dat) error        { return nil }
+
+func newUploads() (*Uploads, *testStore) {
+	pub, _, _ := ed25519.GenerateKey(nil)
+	store := &testStore{}
+	logs := make(chan string, 8)
+	return New(&Cfg{Store: store, Signer: &testSigner{pub: pub}, Logs: logs}), store
+}
+
+// Polls upload id until it is no longer publishing.
+func waitPublished(t *testing.T, u *Uploads, id string) *Status {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		stat, err := u.Get(id, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.State != Publishing {
+			return stat
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("upload still publishing")
+	return nil
+}
+
+func TestResume(t *testing.T) {
+	u, store := newUploads()
+	body := bytes.Repeat([]byte("dave"), 700) // 3 chunks
+	stat, err := u.Create("files", "/notes.txt", int64(len(body)), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An interrupted append keeps what was read
+	_, err = u.Append(stat.ID, "", 0, &failingReader{body: body[:1000]})
+	if err == nil {
+		t.Fatal("append of an interrupted body did not fail")
+	}
+	stat, err = u.Get(stat.ID, "")
+	if err != nil || stat.Offset != 1000 || stat.State != Uploading {
+		t.Fatalf("after interruption got %+v, %v, want offset 1000", stat, err)
+	}
+	if _, err := u.Append(stat.ID, "", 0, bytes.NewReader(body)); !errors.Is(err, ErrOffset) {
+		t.Errorf("append at a stale offset: got %v, want ErrOffset", err)
+	}
+	if _, err := u.Append(stat.ID, "", 1000, bytes.NewReader(append(body[1000:], 'x'))); !errors.Is(err, ErrTooLong) {
+		t.Errorf("append past the size: got %v, want ErrTooLong", err)
+	}
+	if _, err := u.Get(stat.ID, "other"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("get by another owner: got %v, want ErrNotFound", err)
+	}
+	stat, err = u.Append(stat.ID, "", 1000, bytes.NewReader(body[1000:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Offset != stat.Size {
+		t.Errorf("offset %d after the last append, want %d", stat.Offset, stat.Size)
+	}
+	if stat = waitPublished(t, u, stat.ID); stat.State != Published {
+		t.Fatalf("state %s (%s), want published", stat.State, stat.Error)
+	}
+	if _, err := u.Append(stat.ID, "", stat.Size, bytes.NewReader(nil)); !errors.Is(err, ErrComplete) {
+		t.Errorf("append to a published upload: got %v, want ErrComplete", err)
+	}
+	// 3 chunks of the file, 1 of the manifest, then the manifest reference
+	if len(store.keys) != 5 || store.keys[4] != "files" || !strings.HasPrefix(store.keys[0], "files/") {
+		t.Errorf("put %q, want chunks then files", store.keys)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	u, _ := newUploads()
+	for _, tc := range []struct {
+		key, path string
+		size      int64
+	}{
+		{"", "/a", 1},
+		{"k", "a", 1},
+		{"k", "/a/../b", 1},
+		{"k", "/a", -1},
+		{"k", "/a", 9 << 20},
+	} {
+		if _, err := u.Create(tc.key, tc.path, tc.size, "", nil); err == nil {
+			t.Errorf("created upload of %q %q, %d bytes", tc.key, tc.path, tc.size)
+		}
+	}
+	for i := 0; i < maxUploads; i++ {
+		if _, err := u.Create("k", "/a", 1, "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := u.Create("k", "/a", 1, "", nil); !errors.Is(err, ErrTooMany) {
+		t.Errorf("upload beyond the limit: got %v, want ErrTooMany", err)
+	}
+	u.expire(time.Now().Add(expiry + time.Minute))
+	if _, err := u.Create("k", "/a", 1, "", nil); err != nil {
+		t.Errorf("upload after expiry: %s", err)
+	}
+}
+
+func TestCharge(t *testing.T) {
+	u, store := newUploads()
+	quota := errors.New("quota")
+	stat, err := u.Create("k", "/a", 1, "", func(size int64) error { return quota })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.Append(stat.ID, "", 0, bytes.NewReader([]byte("a"))); err != nil {
+		t.Fatal(err)
+	}
+	if stat = waitPublished(t, u, stat.ID); stat.State != Failed || stat.Error != "quota" {
+		t.Errorf("got %s (%s), want failed for quota", stat.State, stat.Error)
+	}
+	if len(store.keys) != 0 {
+		t.Errorf("put %q over quota", store.keys)
+	}
+}
+
+// Returns body, then an error other than EOF, as a dropped connection.
+type failingReader struct {
+	body []byte
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.body) == 0 {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, r.body)
+	r.body = r.body[n:]
+	return n, nil
+}
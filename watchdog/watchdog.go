@@ -0,0 +1,207 @@
+// Package watchdog monitors the peer count of the godave instance, and
+// supervises daved's own components, restarting those that panic. Its state
+// is served by the API at /healthz.
+package watchdog
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/intob/daved/events"
+	"github.com/intob/daved/logbuf"
+	"github.com/intob/godave"
+)
+
+// Check names
+const (
+	CHECK_PEERS     = "peers"
+	CHECK_MIN_PEERS = "min_peers"
+)
+
+// Restarts of a component after which the watchdog gives up on it.
+const maxRestarts = 5
+
+type Cfg struct {
+	Dave          *godave.Dave
+	Bus           *events.Bus
+	Interval      time.Duration
+	NoPeersAfter  time.Duration // Zero disables the peers check
+	MinPeers      int           // Zero disables the min_peers check
+	MinPeersAfter time.Duration // How long below MinPeers fails the check
+	Logs          chan<- string
+}
+
+type Check struct {
+	Name    string    `json:"name"`
+	Healthy bool      `json:"healthy"`
+	Since   time.Time `json:"since"` // When it last changed
+	Message string    `json:"message,omitempty"`
+}
+
+type Component struct {
+	Name      string `json:"name"`
+	Restarts  int    `json:"restarts"`
+	LastPanic string `json:"last_panic,omitempty"`
+	Failed    bool   `json:"failed"` // Gave up restarting it
+}
+
+type State struct {
+	Healthy    bool        `json:"healthy"`
+	Checks     []Check     `json:"checks"`
+	Components []Component `json:"components"`
+}
+
+// Action is the data of watchdog.action events.
+type Action struct {
+	Action string `json:"action"` // restart
+	Target string `json:"target"`
+	Reason string `json:"reason"`
+}
+
+type Watchdog struct {
+	cfg        *Cfg
+	mu         sync.Mutex
+	checks     map[string]*Check
+	components map[string]*Component
+}
+
+func New(cfg *Cfg) *Watchdog {
+	return &Watchdog{
+		cfg:        cfg,
+		checks:     make(map[string]*Check),
+		components: make(map[string]*Component),
+	}
+}
+
+// Run checks the node on each interval until ctx is cancelled.
+func (w *Watchdog) Run(ctx context.Context) {
+	lastPeer, lastEnough := time.Now(), time.Now()
+	tick := time.NewTicker(w.cfg.Interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		now := time.Now()
+		peers := w.cfg.Dave.ActivePeerCount()
+		if peers > 0 {
+			lastPeer = now
+		}
+		if peers >= w.cfg.MinPeers {
+			lastEnough = now
+		}
+		if w.cfg.NoPeersAfter > 0 {
+			alone := now.Sub(lastPeer)
+			w.set(CHECK_PEERS, alone < w.cfg.NoPeersAfter, "no peers for %s", alone.Round(time.Second))
+		}
+		if w.cfg.MinPeers > 0 {
+			below := now.Sub(lastEnough)
+			w.set(CHECK_MIN_PEERS, below < w.cfg.MinPeersAfter, "%d of %d peers for %s", peers, w.cfg.MinPeers, below.Round(time.Second))
+		}
+	}
+}
+
+// Records the result of a check, publishing and logging changes.
+func (w *Watchdog) set(name string, healthy bool, msg string, args ...any) {
+	w.mu.Lock()
+	c, ok := w.checks[name]
+	if ok && c.Healthy == healthy {
+		if !healthy {
+			c.Message = fmt.Sprintf(msg, args...)
+		}
+		w.mu.Unlock()
+		return
+	}
+	c = &Check{Name: name, Healthy: healthy, Since: time.Now()}
+	if !healthy {
+		c.Message = fmt.Sprintf(msg, args...)
+	}
+	w.checks[name] = c
+	w.mu.Unlock()
+	if !ok && healthy {
+		return // Healthy from the start
+	}
+	typ := events.WATCHDOG_RECOVERED
+	if !healthy {
+		typ = events.WATCHDOG_UNHEALTHY
+	}
+	w.log("%s %s %s", typ, name, c.Message)
+	w.cfg.Bus.Publish(typ, *c)
+}
+
+// Supervise runs a component in a new goroutine, restarting it with backoff
+// if it panics, up to maxRestarts times. A component that returns is done.
+func (w *Watchdog) Supervise(ctx context.Context, name string, run func(ctx context.Context)) {
+	w.mu.Lock()
+	c := &Component{Name: name}
+	w.components[name] = c
+	w.mu.Unlock()
+	go func() {
+		backoff := time.Second
+		for {
+			p, stack := runRecovered(ctx, run)
+			if p == nil || ctx.Err() != nil {
+				return
+			}
+			w.mu.Lock()
+			c.Restarts++
+			c.LastPanic = fmt.Sprint(p)
+			c.Failed = c.Restarts > maxRestarts
+			failed := c.Failed
+			w.mu.Unlock()
+			w.log("%s panicked: %v\n%s", name, p, stack)
+			if failed {
+				w.log("%s panicked %d times, not restarting", name, maxRestarts+1)
+				w.cfg.Bus.Publish(events.WATCHDOG_UNHEALTHY, Check{Name: name, Since: time.Now(), Message: "gave up restarting after repeated panics"})
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, time.Minute)
+			w.log("restarting %s", name)
+			w.cfg.Bus.Publish(events.WATCHDOG_ACTION, &Action{Action: "restart", Target: name, Reason: fmt.Sprintf("panic: %v", p)})
+		}
+	}()
+}
+
+func runRecovered(ctx context.Context, run func(ctx context.Context)) (p any, stack []byte) {
+	defer func() {
+		if p = recover(); p != nil {
+			stack = debug.Stack()
+		}
+	}()
+	run(ctx)
+	return nil, nil
+}
+
+// State returns the result of each check and the state of each component.
+// The node is healthy if every check passes and no component has failed.
+func (w *Watchdog) State() *State {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := &State{Healthy: true, Checks: make([]Check, 0, len(w.checks)), Components: make([]Component, 0, len(w.components))}
+	for _, c := range w.checks {
+		s.Checks = append(s.Checks, *c)
+		s.Healthy = s.Healthy && c.Healthy
+	}
+	for _, c := range w.components {
+		s.Components = append(s.Components, *c)
+		s.Healthy = s.Healthy && !c.Failed
+	}
+	sort.Slice(s.Checks, func(i, j int) bool { return s.Checks[i].Name < s.Checks[j].Name })
+	sort.Slice(s.Components, func(i, j int) bool { return s.Components[i].Name < s.Components[j].Name })
+	return s
+}
+
+func (w *Watchdog) log(msg string, args ...any) {
+	logbuf.For(w.cfg.Logs, "watchdog").Printf(msg, args...)
+}
@@ -0,0 +1,22 @@
+// Package wire reads captures of godave UDP traffic.
+package wire
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Print writes a human-readable description of pkt to w, with its payload
+// as a hex dump. The message format belongs to godave, so it is not parsed.
+func Print(w io.Writer, pkt *Packet) {
+	if !pkt.Time.IsZero() {
+		fmt.Fprintf(w, "%s ", pkt.Time.Format(time.RFC3339Nano))
+	}
+	if pkt.Src.IsValid() {
+		fmt.Fprintf(w, "%s -> %s ", pkt.Src, pkt.Dst)
+	}
+	fmt.Fprintf(w, "%d bytes\n", len(pkt.Payload))
+	fmt.Fprint(w, hex.Dump(pkt.Payload))
+}
@@ -0,0 +1,35 @@
+package wire
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ReadHex reads one message per line of hex. Whitespace within a line and
+// a leading "offset:" column are ignored.
+func ReadHex(r io.Reader) ([]*Packet, error) {
+	packets := make([]*Packet, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if _, rest, ok := strings.Cut(text, ":"); ok {
+			text = rest
+		}
+		text = strings.Join(strings.Fields(text), "")
+		payload, err := hex.DecodeString(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		packets = append(packets, &Packet{Payload: payload})
+	}
+	return packets, scanner.Err()
+}
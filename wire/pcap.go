@@ -0,0 +1,169 @@
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+)
+
+// Link types
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLinuxSLL = 113
+)
+
+const (
+	pcapMagic     = 0xa1b2c3d4
+	pcapMagicNano = 0xa1b23c4d
+	protoUDP      = 17
+)
+
+// Packet is a UDP datagram read from a capture.
+type Packet struct {
+	Time    time.Time
+	Src     netip.AddrPort
+	Dst     netip.AddrPort
+	Payload []byte
+}
+
+type PcapReader struct {
+	r        io.Reader
+	order    binary.ByteOrder
+	nano     bool
+	linkType uint32
+}
+
+func NewPcapReader(r io.Reader) (*PcapReader, error) {
+	hdr := make([]byte, 24)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("failed to read pcap header: %w", err)
+	}
+	p := &PcapReader{r: r}
+	switch {
+	case binary.LittleEndian.Uint32(hdr) == pcapMagic:
+		p.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(hdr) == pcapMagic:
+		p.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(hdr) == pcapMagicNano:
+		p.order, p.nano = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(hdr) == pcapMagicNano:
+		p.order, p.nano = binary.BigEndian, true
+	default:
+		return nil, errors.New("not a pcap file")
+	}
+	p.linkType = p.order.Uint32(hdr[20:])
+	switch p.linkType {
+	case linkNull, linkEthernet, linkRaw, linkLinuxSLL:
+	default:
+		return nil, fmt.Errorf("unsupported link type %d", p.linkType)
+	}
+	return p, nil
+}
+
+// Next returns the next UDP packet, skipping other traffic. Returns io.EOF
+// at the end of the capture.
+func (p *PcapReader) Next() (*Packet, error) {
+	hdr := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(p.r, hdr); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, errors.New("truncated record header")
+			}
+			return nil, err
+		}
+		sec := p.order.Uint32(hdr)
+		frac := p.order.Uint32(hdr[4:])
+		inclLen := p.order.Uint32(hdr[8:])
+		if inclLen > 1<<20 {
+			return nil, fmt.Errorf("record too large: %d bytes", inclLen)
+		}
+		frame := make([]byte, inclLen)
+		if _, err := io.ReadFull(p.r, frame); err != nil {
+			return nil, errors.New("truncated record")
+		}
+		nsec := int64(frac) * 1000
+		if p.nano {
+			nsec = int64(frac)
+		}
+		pkt, ok := p.parseFrame(frame)
+		if !ok {
+			continue
+		}
+		pkt.Time = time.Unix(int64(sec), nsec)
+		return pkt, nil
+	}
+}
+
+func (p *PcapReader) parseFrame(frame []byte) (*Packet, bool) {
+	switch p.linkType {
+	case linkNull:
+		if len(frame) < 4 {
+			return nil, false
+		}
+		return parseIP(frame[4:])
+	case linkEthernet:
+		if len(frame) < 14 {
+			return nil, false
+		}
+		etherType := binary.BigEndian.Uint16(frame[12:])
+		payload := frame[14:]
+		if etherType == 0x8100 && len(frame) >= 18 { // VLAN tag
+			payload = frame[18:]
+		}
+		return parseIP(payload)
+	case linkLinuxSLL:
+		if len(frame) < 16 {
+			return nil, false
+		}
+		return parseIP(frame[16:])
+	default:
+		return parseIP(frame)
+	}
+}
+
+func parseIP(b []byte) (*Packet, bool) {
+	if len(b) < 1 {
+		return nil, false
+	}
+	var src, dst netip.Addr
+	var udp []byte
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) < 20 || b[9] != protoUDP {
+			return nil, false
+		}
+		ihl := int(b[0]&0x0f) * 4
+		if ihl < 20 || len(b) < ihl {
+			return nil, false
+		}
+		src = netip.AddrFrom4([4]byte(b[12:16]))
+		dst = netip.AddrFrom4([4]byte(b[16:20]))
+		udp = b[ihl:]
+	case 6:
+		if len(b) < 40 || b[6] != protoUDP { // extension headers are not followed
+			return nil, false
+		}
+		src = netip.AddrFrom16([16]byte(b[8:24]))
+		dst = netip.AddrFrom16([16]byte(b[24:40]))
+		udp = b[40:]
+	default:
+		return nil, false
+	}
+	if len(udp) < 8 {
+		return nil, false
+	}
+	length := int(binary.BigEndian.Uint16(udp[4:]))
+	if length < 8 || length > len(udp) {
+		length = len(udp)
+	}
+	return &Packet{
+		Src:     netip.AddrPortFrom(src, binary.BigEndian.Uint16(udp)),
+		Dst:     netip.AddrPortFrom(dst, binary.BigEndian.Uint16(udp[2:])),
+		Payload: udp[8:length],
+	}, true
+}
@@ -0,0 +1,125 @@
+// Package workcache remembers proof-of-work by signature. Ed25519
+// signatures are deterministic, so republishing an identical dat (same key,
+// value, time and signer) can reuse the work instead of redoing it.
+package workcache
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/intob/godave/dat"
+)
+
+type entry struct {
+	difficulty uint8
+	work       dat.Work
+	salt       dat.Salt
+}
+
+// Cache is an append-only file of "<sig> <difficulty> <work> <salt>" lines,
+// hex encoded.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[dat.Signature]entry
+	file    *os.File
+}
+
+func Open(filename string) (*Cache, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cache{entries: make(map[dat.Signature]entry), file: f}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if err := c.parse(scanner.Text()); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Cache) parse(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) != 4 {
+		return fmt.Errorf("expected 4 fields, got %d", len(fields))
+	}
+	var sig dat.Signature
+	var e entry
+	if err := decodeHex(fields[0], sig[:]); err != nil {
+		return err
+	}
+	var diff int
+	if _, err := fmt.Sscan(fields[1], &diff); err != nil || diff < 0 || diff > 255 {
+		return fmt.Errorf("invalid difficulty %q", fields[1])
+	}
+	e.difficulty = uint8(diff)
+	if err := decodeHex(fields[2], e.work[:]); err != nil {
+		return err
+	}
+	if err := decodeHex(fields[3], e.salt[:]); err != nil {
+		return err
+	}
+	if prev, ok := c.entries[sig]; !ok || e.difficulty > prev.difficulty {
+		c.entries[sig] = e
+	}
+	return nil
+}
+
+func decodeHex(s string, dst []byte) error {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(dst) {
+		return fmt.Errorf("invalid hex field %q", s)
+	}
+	copy(dst, b)
+	return nil
+}
+
+// Get returns cached work for sig of at least the given difficulty.
+func (c *Cache) Get(sig dat.Signature, difficulty uint8) (dat.Work, dat.Salt, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[sig]
+	if !ok || e.difficulty < difficulty {
+		return dat.Work{}, dat.Salt{}, false
+	}
+	return e.work, e.salt, true
+}
+
+func (c *Cache) Put(sig dat.Signature, difficulty uint8, work dat.Work, salt dat.Salt) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if prev, ok := c.entries[sig]; ok && prev.difficulty >= difficulty {
+		return nil
+	}
+	c.entries[sig] = entry{difficulty: difficulty, work: work, salt: salt}
+	_, err := fmt.Fprintf(c.file, "%x %d %x %x\n", sig, difficulty, work, salt)
+	return err
+}
+
+// DoWork returns cached work for sig, or computes and caches it. A nil
+// cache always computes.
+func (c *Cache) DoWork(sig dat.Signature, difficulty uint8) (dat.Work, dat.Salt, error) {
+	if c == nil {
+		work, salt := dat.DoWork(sig, difficulty)
+		return work, salt, nil
+	}
+	if work, salt, ok := c.Get(sig, difficulty); ok {
+		return work, salt, nil
+	}
+	work, salt := dat.DoWork(sig, difficulty)
+	return work, salt, c.Put(sig, difficulty, work, salt)
+}
+
+func (c *Cache) Close() error {
+	return c.file.Close()
+}
@@ -0,0 +1,136 @@
+// Package workpool precomputes proof-of-work for the node's own records at
+// future slot times, while the CPU is otherwise idle. When a record is put
+// during a prepared slot, its work is already in the work cache.
+package workpool
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intob/daved/logbuf"
+	"github.com/intob/daved/signer"
+	"github.com/intob/daved/workcache"
+	"github.com/intob/godave/dat"
+)
+
+type Record struct {
+	Key string
+	Val []byte
+}
+
+type PoolCfg struct {
+	Records    []Record
+	Signer     signer.Signer
+	Cache      *workcache.Cache
+	Difficulty uint8
+	Slot       time.Duration
+	Ahead      int // Slots prepared in advance
+	Workers    int
+	Logs       chan<- string
+}
+
+// SlotTime is the dat time used for records put during the slot containing t.
+func SlotTime(t time.Time, slot time.Duration) time.Time {
+	return t.Truncate(slot)
+}
+
+// Run prepares the current and next Ahead slots, then sleeps until the next
+// slot begins, until ctx is cancelled.
+func Run(ctx context.Context, cfg *PoolCfg) {
+	for {
+		start := time.Now()
+		n := prepare(ctx, cfg, SlotTime(start, cfg.Slot))
+		if n > 0 {
+			logbuf.For(cfg.Logs, "workpool").Printf("prepared %d dats in %s", n, time.Since(start))
+		}
+		next := SlotTime(time.Now(), cfg.Slot).Add(cfg.Slot)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
+
+func prepare(ctx context.Context, cfg *PoolCfg, from time.Time) int {
+	pubKey := cfg.Signer.PublicKey()
+	jobs := make(chan dat.Dat)
+	var mu sync.Mutex
+	var n int
+	wg := sync.WaitGroup{}
+	for i := 0; i < cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range jobs {
+				if _, _, ok := cfg.Cache.Get(d.Sig, cfg.Difficulty); ok {
+					continue
+				}
+				if !waitIdle(ctx) {
+					continue
+				}
+				_, _, err := cfg.Cache.DoWork(d.Sig, cfg.Difficulty)
+				if err != nil {
+					logbuf.For(cfg.Logs, "workpool").Printf("failed to cache work for %s: %s", d.Key, err)
+					continue
+				}
+				mu.Lock()
+				n++
+				mu.Unlock()
+			}
+		}()
+	}
+send:
+	for i := 0; i <= cfg.Ahead; i++ {
+		t := from.Add(time.Duration(i) * cfg.Slot)
+		for _, rec := range cfg.Records {
+			d := dat.Dat{Key: rec.Key, Val: rec.Val, Time: t, PubKey: pubKey}
+			if err := cfg.Signer.Sign(&d); err != nil {
+				logbuf.For(cfg.Logs, "workpool").Printf("failed to sign %s: %s", rec.Key, err)
+				continue
+			}
+			select {
+			case jobs <- d:
+			case <-ctx.Done():
+				break send
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return n
+}
+
+// Blocks while the 1 minute load average is at or above the number of CPUs.
+// Where the load average is unavailable, the CPU is assumed idle.
+func waitIdle(ctx context.Context) bool {
+	for {
+		load, ok := loadAvg()
+		if !ok || load < float64(runtime.NumCPU()) {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+func loadAvg() (float64, bool) {
+	b, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return 0, false
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	return load, err == nil
+}
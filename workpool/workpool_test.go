@@ -0,0 +1,40 @@
+package workpool
+
+import (
+	"context"
+	"crypto/ed25519"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/intob/daved/signer"
+	"github.com/intob/daved/workcache"
+)
+
+func TestPrepare(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := workcache.Open(filepath.Join(t.TempDir(), "work"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	cfg := &PoolCfg{
+		Records:    []Record{{Key: "a", Val: []byte("1")}, {Key: "b", Val: []byte("2")}},
+		Signer:     signer.Local(priv),
+		Cache:      cache,
+		Difficulty: 4,
+		Slot:       time.Minute,
+		Ahead:      2,
+		Workers:    4,
+	}
+	from := SlotTime(time.Now(), cfg.Slot)
+	if n := prepare(context.Background(), cfg, from); n != 6 {
+		t.Errorf("prepared %d dats, want 6", n)
+	}
+	if n := prepare(context.Background(), cfg, from); n != 0 {
+		t.Errorf("prepared %d dats again, want 0 as they are cached", n)
+	}
+}